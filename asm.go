@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gilmae/chip8/asm"
+	"github.com/gilmae/chip8/symbols"
+)
+
+// assemble builds a rom from assembly source.
+func assemble(args []string) {
+	flags := flag.NewFlagSet("asm", flag.ExitOnError)
+	outPath := flags.String("o", "", "write the rom here (default: the source file with a .ch8 extension)")
+	listingPath := flags.String("listing", "", "write a listing of addresses, bytes and source here")
+	symPath := flags.String("sym", "", "write the symbol file here, as JSON if it ends .json (default: next to the rom, with a .sym extension)")
+	origin := flags.String("origin", "200", "hex address the rom is loaded at")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s asm [flags] source\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() < 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	src := flags.Arg(0)
+
+	a := &asm.Assembler{}
+	var err error
+	if a.Origin, err = parseHexAddr(*origin); err != nil {
+		fmt.Fprintf(os.Stderr, "error: -origin: %s\n", err)
+		os.Exit(2)
+	}
+
+	program, err := a.AssembleFile(src)
+	if err != nil {
+		printError(err)
+		os.Exit(3)
+	}
+
+	if *outPath == "" {
+		*outPath = strings.TrimSuffix(src, filepath.Ext(src)) + ".ch8"
+	}
+	if *symPath == "" {
+		*symPath = symbols.PathFor(*outPath)
+	}
+	if err := ioutil.WriteFile(*outPath, program.Code, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(3)
+	}
+	writeSymbols := program.Symbols.Write
+	if strings.EqualFold(filepath.Ext(*symPath), ".json") {
+		writeSymbols = program.Symbols.WriteJSON
+	}
+	if err := writeFile(*symPath, writeSymbols); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(3)
+	}
+	if *listingPath != "" {
+		if err := writeFile(*listingPath, program.WriteListing); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			os.Exit(3)
+		}
+	}
+}
+
+// writeFile creates path and writes to it with write.
+func writeFile(path string, write func(io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,706 @@
+// Package asm assembles CHIP-8 programs written in the Cowgod syntax the
+// disassembler writes, so a disassembled rom assembles back to the same
+// bytes.
+//
+// A line holds an optional label, an instruction or directive, and an
+// optional comment:
+//
+//	loop:	LD V0, K	; wait for a key
+//
+// Mnemonics, registers and directives may be written in either case; names
+// are case sensitive. The directives are
+//
+//	NAME EQU expr	defines a constant; NAME = expr does the same
+//	DB expr, "text"	bytes and strings
+//	DW expr	big-endian 16 bit words
+//	ORG expr	carries on at expr, padding with zeros
+//	INCLUDE "file"	assembles file, found relative to this one, here
+//
+// Expressions are numbers (42, 0x2A, #2A, $2A, 0b101010, %101010 or 'A'),
+// labels, constants and $, the address of the line, combined with C's
+// operators + - * / % & | ^ << >> ~ and parentheses.
+package asm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gilmae/chip8/chip8"
+	"github.com/gilmae/chip8/symbols"
+)
+
+const (
+	// DefaultOrigin is where roms are loaded.
+	DefaultOrigin uint16 = 0x200
+
+	max_include_depth = 16
+	listing_bytes     = 4 // bytes shown on a line of the listing
+)
+
+// Error is a problem with the source, at a line and column (both from 1).
+type Error struct {
+	File   string
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *Error) Error() string {
+	if e.Column > 0 {
+		return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Msg)
+	}
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+}
+
+// ErrorList is every problem found in the source.
+type ErrorList []*Error
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", l[0], len(l)-1)
+}
+
+// Assembler assembles source files.
+type Assembler struct {
+	Origin   uint16                            // where the rom is loaded; DefaultOrigin if 0
+	ReadFile func(path string) ([]byte, error) // reads included files; ioutil.ReadFile if nil
+}
+
+// Program is an assembled rom.
+type Program struct {
+	Origin  uint16
+	Code    []byte
+	Symbols *symbols.Table // the labels, and the line each piece of code came from
+
+	statements []*statement
+}
+
+const (
+	statement_empty = iota
+	statement_instruction
+	statement_db
+	statement_dw
+	statement_org
+	statement_equ
+	statement_include
+)
+
+type statement struct {
+	file   string
+	line   int
+	text   string // the line as written, for the listing
+	label  string
+	kind   int
+	name   string // the mnemonic, directive or constant
+	column int    // of name
+	args   []*operand
+	form   form
+
+	addr  int
+	size  int
+	bytes []byte
+}
+
+const (
+	operand_register = iota
+	operand_range
+	operand_keyword
+	operand_expr
+	operand_long
+	operand_string
+)
+
+type operand struct {
+	kind    int
+	column  int
+	keyword string // the operand in upper case, without spaces
+	x, y    int    // registers
+	value   expr
+	text    []byte // strings
+}
+
+type symbol struct {
+	label  bool
+	value  int
+	expr   expr
+	pc     int // the address a constant was defined at, for $
+	file   string
+	line   int
+	column int
+	placed bool // a label has been given its address
+	state  int  // of a constant: 0 unworked, 1 being worked out, 2 known
+}
+
+type assembly struct {
+	a          *Assembler
+	statements []*statement
+	symbols    map[string]*symbol
+	labels     []string // in order of definition
+	errors     ErrorList
+	including  map[string]bool
+}
+
+var (
+	label_re    = regexp.MustCompile(`^\s*([A-Za-z_.][A-Za-z0-9_.]*):`)
+	register_re = regexp.MustCompile(`^V([0-9A-F])$`)
+	range_re    = regexp.MustCompile(`^V([0-9A-F])-V([0-9A-F])$`)
+	name_re     = regexp.MustCompile(`^[A-Za-z_.][A-Za-z0-9_.]*$`)
+)
+
+// AssembleFile assembles the source file at path.
+func (a *Assembler) AssembleFile(path string) (*Program, error) {
+	src, err := a.readFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return a.Assemble(path, src)
+}
+
+// Assemble assembles src, naming it name in errors and the line table.
+// Errors in the source are returned as an ErrorList.
+func (a *Assembler) Assemble(name string, src []byte) (*Program, error) {
+	as := &assembly{a: a, symbols: make(map[string]*symbol), including: map[string]bool{name: true}}
+	as.parse(name, src, 0)
+	if len(as.errors) == 0 {
+		as.layout()
+	}
+	if len(as.errors) > 0 {
+		return nil, as.errors
+	}
+	p := as.emit()
+	if len(as.errors) > 0 {
+		return nil, as.errors
+	}
+	return p, nil
+}
+
+func (a *Assembler) readFile(path string) ([]byte, error) {
+	if a.ReadFile != nil {
+		return a.ReadFile(path)
+	}
+	return ioutil.ReadFile(path)
+}
+
+func (a *Assembler) origin() int {
+	if a.Origin == 0 {
+		return int(DefaultOrigin)
+	}
+	return int(a.Origin)
+}
+
+func (as *assembly) errorf(file string, line, column int, format string, args ...interface{}) {
+	as.errors = append(as.errors, &Error{File: file, Line: line, Column: column, Msg: fmt.Sprintf(format, args...)})
+}
+
+// fail records err, which came from working out part of s.
+func (as *assembly) fail(s *statement, err error) {
+	if e, ok := err.(*Error); ok {
+		if e.File == "" {
+			e.File, e.Line = s.file, s.line
+		}
+		as.errors = append(as.errors, e)
+		return
+	}
+	as.errorf(s.file, s.line, s.column, "%s", err)
+}
+
+// parse reads the statements of file, and of the files it includes.
+func (as *assembly) parse(file string, src []byte, depth int) {
+	scanner := bufio.NewScanner(strings.NewReader(string(src)))
+	for n := 1; scanner.Scan(); n++ {
+		s := &statement{file: file, line: n, text: strings.TrimRight(scanner.Text(), " \t\r")}
+		if err := as.parseLine(s); err != nil {
+			as.fail(s, err)
+			continue
+		}
+		as.statements = append(as.statements, s)
+		if s.kind == statement_include {
+			as.include(s, depth)
+		}
+	}
+}
+
+func (as *assembly) include(s *statement, depth int) {
+	path := filepath.Join(filepath.Dir(s.file), string(s.args[0].text))
+	if depth >= max_include_depth || as.including[path] {
+		as.errorf(s.file, s.line, s.args[0].column, "%s includes itself", path)
+		return
+	}
+	src, err := as.a.readFile(path)
+	if err != nil {
+		as.errorf(s.file, s.line, s.args[0].column, "%s", err)
+		return
+	}
+	as.including[path] = true
+	as.parse(path, src, depth+1)
+	delete(as.including, path)
+}
+
+func (as *assembly) parseLine(s *statement) error {
+	line := stripComment(s.text)
+	rest, column := line, 1
+	if m := label_re.FindStringSubmatchIndex(line); m != nil {
+		s.label = line[m[2]:m[3]]
+		if err := as.define(s.label, &symbol{label: true, file: s.file, line: s.line, column: m[2] + 1}); err != nil {
+			return err
+		}
+		rest, column = line[m[1]:], m[1]+1
+	}
+
+	trimmed := strings.TrimLeft(rest, " \t")
+	column += len(rest) - len(trimmed)
+	if trimmed == "" {
+		return nil
+	}
+	end := strings.IndexAny(trimmed, " \t=")
+	if end < 0 {
+		end = len(trimmed)
+	}
+	s.name, s.column = trimmed[:end], column
+	args, argsColumn := trimmed[end:], column+end
+
+	// NAME EQU expr, or NAME = expr
+	after := strings.TrimLeft(args, " \t")
+	argsColumn += len(args) - len(after)
+	switch {
+	case strings.HasPrefix(after, "="):
+		return as.parseConstant(s, after[1:], argsColumn+1)
+	case len(after) >= 3 && strings.EqualFold(after[:3], "EQU") && (len(after) == 3 || after[3] == ' ' || after[3] == '\t'):
+		return as.parseConstant(s, after[3:], argsColumn+3)
+	}
+
+	operands, err := splitOperands(args, column+end)
+	if err != nil {
+		return err
+	}
+	mnemonic := strings.ToUpper(s.name)
+	switch mnemonic {
+	case "DB", "DW":
+		s.kind = statement_db
+		if mnemonic == "DW" {
+			s.kind = statement_dw
+		}
+		if len(operands) == 0 {
+			return &Error{Column: s.column, Msg: mnemonic + " needs at least one value"}
+		}
+		for _, o := range operands {
+			arg, err := parseData(o, s.kind == statement_db)
+			if err != nil {
+				return err
+			}
+			s.args = append(s.args, arg)
+		}
+		return nil
+	case "ORG":
+		s.kind = statement_org
+		if len(operands) != 1 {
+			return &Error{Column: s.column, Msg: "ORG takes an address"}
+		}
+		x, err := parseExpr(operands[0].text, operands[0].column)
+		if err != nil {
+			return err
+		}
+		s.args = []*operand{{kind: operand_expr, column: operands[0].column, value: x}}
+		return nil
+	case "INCLUDE":
+		s.kind = statement_include
+		if len(operands) != 1 {
+			return &Error{Column: s.column, Msg: "INCLUDE takes a file name"}
+		}
+		arg, err := parseData(operands[0], true)
+		if err != nil || arg.kind != operand_string {
+			return &Error{Column: operands[0].column, Msg: "INCLUDE takes a quoted file name"}
+		}
+		s.args = []*operand{arg}
+		return nil
+	}
+
+	if _, ok := forms[mnemonic]; !ok {
+		return &Error{Column: s.column, Msg: fmt.Sprintf("unknown instruction %q", s.name)}
+	}
+	s.kind = statement_instruction
+	for _, o := range operands {
+		arg, err := parseOperand(o)
+		if err != nil {
+			return err
+		}
+		s.args = append(s.args, arg)
+	}
+	f, ok := findForm(mnemonic, s.args)
+	if !ok {
+		return &Error{Column: s.column, Msg: fmt.Sprintf("%s cannot take these operands", mnemonic)}
+	}
+	s.form = f
+	return nil
+}
+
+func (as *assembly) parseConstant(s *statement, value string, column int) error {
+	if !name_re.MatchString(s.name) {
+		return &Error{Column: s.column, Msg: fmt.Sprintf("bad constant name %q", s.name)}
+	}
+	if strings.TrimSpace(value) == "" {
+		return &Error{Column: column, Msg: "constant needs a value"}
+	}
+	x, err := parseExpr(value, column)
+	if err != nil {
+		return err
+	}
+	s.kind = statement_equ
+	return as.define(s.name, &symbol{expr: x, file: s.file, line: s.line, column: s.column})
+}
+
+func (as *assembly) define(name string, sym *symbol) error {
+	upper := strings.ToUpper(name)
+	if keywords[upper] || register_re.MatchString(upper) {
+		return &Error{Column: sym.column, Msg: fmt.Sprintf("%s is a register, and cannot be a name", name)}
+	}
+	if old, ok := as.symbols[name]; ok {
+		return &Error{Column: sym.column, Msg: fmt.Sprintf("%s is already defined at %s:%d", name, old.file, old.line)}
+	}
+	as.symbols[name] = sym
+	if sym.label {
+		as.labels = append(as.labels, name)
+	}
+	return nil
+}
+
+// stripComment cuts the comment from line, minding quotes.
+func stripComment(line string) string {
+	quote := byte(0)
+	for i := 0; i < len(line); i++ {
+		switch ch := line[i]; {
+		case quote != 0 && ch == '\\':
+			i++
+		case quote != 0 && ch == quote:
+			quote = 0
+		case quote == 0 && (ch == '"' || ch == '\''):
+			quote = ch
+		case quote == 0 && ch == ';':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+type rawOperand struct {
+	text   string
+	column int
+}
+
+// splitOperands splits s, which starts at column, at the commas that are not
+// quoted or in parentheses.
+func splitOperands(s string, column int) ([]rawOperand, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var operands []rawOperand
+	start, depth, quote, quoted := 0, 0, byte(0), 0
+	add := func(end int) error {
+		text := s[start:end]
+		trimmed := strings.TrimLeft(text, " \t")
+		c := column + start + len(text) - len(trimmed)
+		trimmed = strings.TrimRight(trimmed, " \t")
+		if trimmed == "" {
+			return &Error{Column: c, Msg: "missing operand"}
+		}
+		operands = append(operands, rawOperand{trimmed, c})
+		return nil
+	}
+	for i := 0; i < len(s); i++ {
+		switch ch := s[i]; {
+		case quote != 0 && ch == '\\':
+			i++
+		case quote != 0 && ch == quote:
+			quote = 0
+		case quote != 0:
+		case ch == '"' || ch == '\'':
+			quote, quoted = ch, i
+		case ch == '(':
+			depth++
+		case ch == ')':
+			depth--
+		case ch == ',' && depth == 0:
+			if err := add(i); err != nil {
+				return nil, err
+			}
+			start = i + 1
+		}
+	}
+	if quote != 0 {
+		return nil, &Error{Column: column + quoted, Msg: "unterminated quote"}
+	}
+	if err := add(len(s)); err != nil {
+		return nil, err
+	}
+	return operands, nil
+}
+
+// parseOperand reads an instruction operand.
+func parseOperand(o rawOperand) (*operand, error) {
+	compact := strings.ToUpper(strings.Join(strings.Fields(o.text), ""))
+	arg := &operand{column: o.column, keyword: compact}
+	if m := register_re.FindStringSubmatch(compact); m != nil {
+		arg.kind = operand_register
+		arg.x = hexDigit(m[1])
+		return arg, nil
+	}
+	if m := range_re.FindStringSubmatch(compact); m != nil {
+		arg.kind = operand_range
+		arg.x, arg.y = hexDigit(m[1]), hexDigit(m[2])
+		return arg, nil
+	}
+	if keywords[compact] {
+		arg.kind = operand_keyword
+		return arg, nil
+	}
+
+	text, column := o.text, o.column
+	if fields := strings.Fields(text); len(fields) > 1 && strings.EqualFold(fields[0], "LONG") {
+		arg.kind = operand_long
+		i := strings.Index(text, fields[0]) + len(fields[0])
+		text, column = text[i:], column+i
+	} else {
+		arg.kind = operand_expr
+	}
+	x, err := parseExpr(text, column)
+	if err != nil {
+		return nil, err
+	}
+	arg.value = x
+	return arg, nil
+}
+
+// parseData reads a DB or DW value, which for DB may be a string.
+func parseData(o rawOperand, allowStrings bool) (*operand, error) {
+	if o.text[0] == '"' {
+		if !allowStrings {
+			return nil, &Error{Column: o.column, Msg: "strings are only allowed in DB"}
+		}
+		text, err := strconv.Unquote(o.text)
+		if err != nil {
+			return nil, &Error{Column: o.column, Msg: fmt.Sprintf("bad string %s", o.text)}
+		}
+		return &operand{kind: operand_string, column: o.column, text: []byte(text)}, nil
+	}
+	x, err := parseExpr(o.text, o.column)
+	if err != nil {
+		return nil, err
+	}
+	return &operand{kind: operand_expr, column: o.column, value: x}, nil
+}
+
+func hexDigit(s string) int {
+	n, _ := strconv.ParseUint(s, 16, 8)
+	return int(n)
+}
+
+// layout gives each statement its address and size, and each label its
+// address.
+func (as *assembly) layout() {
+	pc := as.a.origin()
+	for _, s := range as.statements {
+		if s.kind == statement_org {
+			to, err := as.eval(s.args[0], pc)
+			switch {
+			case err != nil:
+				as.fail(s, err)
+			case to < pc:
+				as.errorf(s.file, s.line, s.args[0].column, "ORG %03X is before %03X, where the code has got to", to, pc)
+			default:
+				s.size = to - pc
+			}
+		}
+		s.addr = pc
+		if s.label != "" {
+			sym := as.symbols[s.label]
+			sym.value, sym.placed = pc+s.size, true
+		}
+		if sym, ok := as.symbols[s.name]; ok && s.kind == statement_equ {
+			sym.pc = pc
+		}
+
+		switch s.kind {
+		case statement_instruction:
+			def, _ := chip8.Lookup(byte(s.form.op))
+			s.size = def.Size()
+		case statement_db:
+			for _, arg := range s.args {
+				if arg.kind == operand_string {
+					s.size += len(arg.text)
+				} else {
+					s.size++
+				}
+			}
+		case statement_dw:
+			s.size = 2 * len(s.args)
+		}
+		pc += s.size
+		if pc > 0x10000 {
+			as.errorf(s.file, s.line, 0, "the program runs past FFFF")
+			return
+		}
+	}
+}
+
+// eval works out the value of an expression operand of the line at pc.
+func (as *assembly) eval(arg *operand, pc int) (int, error) {
+	return eval(arg.value, pc, as.resolve)
+}
+
+func (as *assembly) resolve(id *ident) (int, error) {
+	sym, ok := as.symbols[id.name]
+	if !ok || (sym.label && !sym.placed) {
+		return 0, &Error{Column: id.column, Msg: fmt.Sprintf("undefined: %s", id.name)}
+	}
+	if sym.label {
+		return sym.value, nil
+	}
+	switch sym.state {
+	case 1:
+		return 0, &Error{Column: id.column, Msg: fmt.Sprintf("%s is defined in terms of itself", id.name)}
+	case 2:
+		return sym.value, nil
+	}
+	sym.state = 1
+	v, err := eval(sym.expr, sym.pc, as.resolve)
+	if err != nil {
+		sym.state = 0
+		if e, ok := err.(*Error); ok && e.File == "" {
+			e.File, e.Line = sym.file, sym.line
+		}
+		return 0, err
+	}
+	sym.value, sym.state = v, 2
+	return v, nil
+}
+
+// emit assembles the laid out statements.
+func (as *assembly) emit() *Program {
+	origin := as.a.origin()
+	p := &Program{Origin: uint16(origin), Symbols: &symbols.Table{}, statements: as.statements}
+	for _, s := range as.statements {
+		for _, err := range as.assemble(s) {
+			as.fail(s, err)
+		}
+	}
+	for _, s := range as.statements {
+		if s.kind == statement_org {
+			p.Code = append(p.Code, make([]byte, s.size)...)
+			continue
+		}
+		p.Code = append(p.Code, s.bytes...)
+		if s.size > 0 {
+			p.Symbols.Lines = append(p.Symbols.Lines, symbols.Line{Addr: uint16(s.addr), File: s.file, Line: s.line})
+		}
+	}
+	for _, name := range as.labels {
+		p.Symbols.Symbols = append(p.Symbols.Symbols, symbols.Symbol{Name: name, Addr: uint16(as.symbols[name].value)})
+	}
+	return p
+}
+
+// assemble works out the bytes of s.
+func (as *assembly) assemble(s *statement) []error {
+	var errs []error
+	switch s.kind {
+	case statement_instruction:
+		var operands []int
+		for i, arg := range s.args {
+			switch {
+			case s.form.keyword(i):
+				// Written out, like the V0 of JP V0, so not encoded.
+			case arg.kind == operand_register:
+				operands = append(operands, arg.x)
+			case arg.kind == operand_range:
+				operands = append(operands, arg.x, arg.y)
+			case arg.kind == operand_expr || arg.kind == operand_long:
+				v, err := as.eval(arg, s.addr)
+				if err != nil {
+					errs = append(errs, err)
+					continue
+				}
+				operands = append(operands, v)
+			}
+		}
+		if len(errs) > 0 {
+			return errs
+		}
+		ins, err := chip8.Encode(s.form.op, operands...)
+		if err != nil {
+			return []error{&Error{Column: s.column, Msg: err.Error()}}
+		}
+		s.bytes = ins
+	case statement_db, statement_dw:
+		for _, arg := range s.args {
+			if arg.kind == operand_string {
+				s.bytes = append(s.bytes, arg.text...)
+				continue
+			}
+			v, err := as.eval(arg, s.addr)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if s.kind == statement_db {
+				if v < -0x80 || v > 0xFF {
+					errs = append(errs, &Error{Column: arg.column, Msg: fmt.Sprintf("%d does not fit in a byte", v)})
+				}
+				s.bytes = append(s.bytes, byte(v))
+			} else {
+				if v < -0x8000 || v > 0xFFFF {
+					errs = append(errs, &Error{Column: arg.column, Msg: fmt.Sprintf("%d does not fit in a word", v)})
+				}
+				s.bytes = append(s.bytes, byte(v>>8), byte(v))
+			}
+		}
+	}
+	return errs
+}
+
+// WriteListing writes the source beside the address and bytes of each line.
+func (p *Program) WriteListing(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	file := ""
+	for _, s := range p.statements {
+		if s.file != file {
+			if file != "" {
+				bw.WriteString("\n")
+			}
+			file = s.file
+			fmt.Fprintf(bw, "; %s\n", file)
+		}
+		code := s.bytes
+		if s.kind == statement_org {
+			code = nil
+		}
+		addr := "    "
+		if len(code) > 0 || s.label != "" || s.kind == statement_org {
+			addr = fmt.Sprintf("%03X ", s.addr)
+		}
+		first := code
+		if len(first) > listing_bytes {
+			first = first[:listing_bytes]
+		}
+		line := fmt.Sprintf("%s %-*X %5d  %s", addr, 2*listing_bytes, first, s.line, s.text)
+		fmt.Fprintln(bw, strings.TrimRight(line, " "))
+		for i := listing_bytes; i < len(code); i += listing_bytes {
+			end := i + listing_bytes
+			if end > len(code) {
+				end = len(code)
+			}
+			fmt.Fprintf(bw, "%03X  %X\n", s.addr+i, code[i:end])
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,170 @@
+package asm
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gilmae/chip8/disasm"
+)
+
+func TestAssemble(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []byte
+	}{
+		{"CLS\nRET", []byte{0x00, 0xE0, 0x00, 0xEE}},
+		{"ld v1, 0x2a", []byte{0x61, 0x2A}},
+		{"LD V1, -1", []byte{0x61, 0xFF}},
+		{"LD VA, VB", []byte{0x8A, 0xB0}},
+		{"SE V1, V2\nSNE V1, 3", []byte{0x51, 0x20, 0x41, 0x03}},
+		{"JP V0, 0x300", []byte{0xB3, 0x00}},
+		{"DRW V0, V1, 5", []byte{0xD0, 0x15}},
+		{"LD [I], V3\nLD V3, [ I ]", []byte{0xF3, 0x55, 0xF3, 0x65}},
+		{"LD [I], V1-V3\nLD V1-V3, [I]", []byte{0x51, 0x32, 0x51, 0x33}},
+		{"LD I, LONG 0x1234", []byte{0xF0, 0x00, 0x12, 0x34}},
+		{"LD V1, K\nLD DT, V1\nLD HF, V1", []byte{0xF1, 0x0A, 0xF1, 0x15, 0xF1, 0x30}},
+		{"PLANE 3\nSCD 4", []byte{0xF3, 0x01, 0x00, 0xC4}},
+		{"start: JP start", []byte{0x12, 0x00}},
+		{"JP end\nend:\n\tEXIT", []byte{0x12, 0x02, 0x00, 0xFD}},
+		{"LD I, sprite\nsprite: DB %11110000, $90", []byte{0xA2, 0x02, 0xF0, 0x90}},
+		{"SPEED EQU 3\nsize = SPEED * 2 + 1\nLD V0, size", []byte{0x60, 0x07}},
+		{"LD V0, (1 << 4 | 2) & ~1", []byte{0x60, 0x12}},
+		{"LD V0, 7 - 2 - 1\nLD V1, 2 + 3 * 4", []byte{0x60, 0x04, 0x61, 0x0E}},
+		{"LD V0, 'A'\nLD V1, #1F", []byte{0x60, 0x41, 0x61, 0x1F}},
+		{"JP $\nJP $ + 4", []byte{0x12, 0x00, 0x12, 0x06}},
+		{`DB "Hi;", 0, -1`, []byte{'H', 'i', ';', 0x00, 0xFF}},
+		{"DW 0x1234, end\nend:", []byte{0x12, 0x34, 0x02, 0x04}},
+		{"CLS\nORG 0x206\nhere: JP here", []byte{0x00, 0xE0, 0x00, 0x00, 0x00, 0x00, 0x12, 0x06}},
+		{"SYS 0x123 ; comment", []byte{0x01, 0x23}},
+	}
+
+	for _, tt := range tests {
+		p, err := (&Assembler{}).Assemble("test.asm", []byte(tt.src))
+		if err != nil {
+			t.Errorf("%q: %s", tt.src, err)
+			continue
+		}
+		if !bytes.Equal(p.Code, tt.want) {
+			t.Errorf("%q, want=% X, got=% X", tt.src, tt.want, p.Code)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"CLS\n  FOO V1", "test.asm:2:3: unknown instruction \"FOO\""},
+		{"LD V1, missing", "test.asm:1:8: undefined: missing"},
+		{"LD V1, 0x100", "test.asm:1:1: LD operand 256 does not fit in 8 bits"},
+		{"LD DT, 3", "test.asm:1:1: LD cannot take these operands"},
+		{"a:\na:", "test.asm:2:1: a is already defined at test.asm:1"},
+		{"x = y\ny = x\nLD V0, x", "test.asm:2:5: x is defined in terms of itself"},
+		{"DB 256", "test.asm:1:4: 256 does not fit in a byte"},
+		{"LD V0, (1", "test.asm:1:10: missing )"},
+		{"ORG 0x100", "test.asm:1:5: ORG 100 is before 200, where the code has got to"},
+		{"ORG later\nlater:", "test.asm:1:5: undefined: later"},
+		{"vf: CLS", "test.asm:1:1: vf is a register, and cannot be a name"},
+		{`DB "open`, "test.asm:1:4: unterminated quote"},
+	}
+
+	for _, tt := range tests {
+		_, err := (&Assembler{}).Assemble("test.asm", []byte(tt.src))
+		if err == nil {
+			t.Errorf("%q, want error %q", tt.src, tt.want)
+			continue
+		}
+		list, ok := err.(ErrorList)
+		if !ok {
+			t.Errorf("%q, want an ErrorList, got=%T", tt.src, err)
+			continue
+		}
+		if list[0].Error() != tt.want {
+			t.Errorf("%q, want=%q, got=%q", tt.src, tt.want, list[0])
+		}
+	}
+}
+
+func TestInclude(t *testing.T) {
+	files := map[string]string{
+		"game/main.asm":        "INCLUDE \"lib/sprites.asm\"\nLD I, smile\n",
+		"game/lib/sprites.asm": "JP skip\nsmile: DB 0x24, 0x00, 0x42, 0x3C\nskip:\n",
+		"loop.asm":             "INCLUDE \"loop.asm\"\n",
+	}
+	a := &Assembler{ReadFile: func(path string) ([]byte, error) {
+		src, ok := files[path]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return []byte(src), nil
+	}}
+
+	p, err := a.AssembleFile("game/main.asm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0x12, 0x06, 0x24, 0x00, 0x42, 0x3C, 0xA2, 0x02}
+	if !bytes.Equal(p.Code, want) {
+		t.Errorf("code, want=% X, got=% X", want, p.Code)
+	}
+	if line, ok := p.Symbols.Source(0x206); !ok || line.File != "game/main.asm" || line.Line != 2 {
+		t.Errorf("source of 206, want=game/main.asm:2, got=%s:%d", line.File, line.Line)
+	}
+	if line, ok := p.Symbols.Source(0x202); !ok || line.File != "game/lib/sprites.asm" || line.Line != 2 {
+		t.Errorf("source of 202, want=game/lib/sprites.asm:2, got=%s:%d", line.File, line.Line)
+	}
+	if addr, ok := p.Symbols.Lookup("smile"); !ok || addr != 0x202 {
+		t.Errorf("smile, want=202, got=%03X", addr)
+	}
+
+	if _, err := a.AssembleFile("loop.asm"); err == nil || !strings.Contains(err.Error(), "includes itself") {
+		t.Errorf("loop.asm, want error about including itself, got=%v", err)
+	}
+}
+
+func TestListing(t *testing.T) {
+	src := "start:\tLD V0, 1 ; one\n\tDB 1, 2, 3, 4, 5\n"
+	p, err := (&Assembler{}).Assemble("test.asm", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	if err := p.WriteListing(&out); err != nil {
+		t.Fatal(err)
+	}
+	want := `; test.asm
+200  6001         1  start:	LD V0, 1 ; one
+202  01020304     2  	DB 1, 2, 3, 4, 5
+206  05
+`
+	if out.String() != want {
+		t.Errorf("listing, want=\n%s\ngot=\n%s", want, out.String())
+	}
+}
+
+// Disassembling a rom and assembling the listing must give the rom back.
+func TestRoundTrip(t *testing.T) {
+	random := rand.New(rand.NewSource(8))
+	for i := 0; i < 200; i++ {
+		rom := make([]byte, 2+random.Intn(200))
+		random.Read(rom)
+		rom[0], rom[1] = 0x00, 0xE0
+
+		var listing bytes.Buffer
+		if err := disasm.Disassemble(rom, disasm.Options{}).Write(&listing); err != nil {
+			t.Fatal(err)
+		}
+		p, err := (&Assembler{}).Assemble(fmt.Sprintf("rom%d.asm", i), listing.Bytes())
+		if err != nil {
+			t.Fatalf("rom %d: %s\n%s", i, err, listing.String())
+		}
+		if !bytes.Equal(p.Code, rom) {
+			t.Fatalf("rom %d, want=% X\ngot=% X\n%s", i, rom, p.Code, listing.String())
+		}
+	}
+}
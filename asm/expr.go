@@ -0,0 +1,283 @@
+package asm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// expr is a parsed expression: number, here, *ident, *unary or *binary.
+type expr interface{}
+
+type number int
+
+// here is $, the address of the line the expression is on.
+type here struct{}
+
+type ident struct {
+	name   string
+	column int
+}
+
+type unary struct {
+	op byte
+	x  expr
+}
+
+type binary struct {
+	op     string
+	x, y   expr
+	column int
+}
+
+var binary_precedence = map[string]int{
+	"|":  1,
+	"^":  2,
+	"&":  3,
+	"<<": 4,
+	">>": 4,
+	"+":  5,
+	"-":  5,
+	"*":  6,
+	"/":  6,
+	"%":  6,
+}
+
+type exprParser struct {
+	s      string
+	pos    int
+	column int // of s[0] in the line
+}
+
+// parseExpr parses s, which starts at column of its line. Errors are *Error
+// with only the column set.
+func parseExpr(s string, column int) (expr, error) {
+	p := &exprParser{s: s, column: column}
+	x, err := p.binary(1)
+	if err != nil {
+		return nil, err
+	}
+	p.space()
+	if p.pos < len(p.s) {
+		return nil, p.errorf("unexpected %q in expression", p.s[p.pos:])
+	}
+	return x, nil
+}
+
+func (p *exprParser) errorf(format string, args ...interface{}) error {
+	return &Error{Column: p.column + p.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *exprParser) space() {
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *exprParser) operator() string {
+	p.space()
+	rest := p.s[p.pos:]
+	if strings.HasPrefix(rest, "<<") || strings.HasPrefix(rest, ">>") {
+		return rest[:2]
+	}
+	if rest != "" {
+		return rest[:1]
+	}
+	return ""
+}
+
+// binary parses operators of at least precedence min, by precedence climbing.
+func (p *exprParser) binary(min int) (expr, error) {
+	x, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.operator()
+		prec, ok := binary_precedence[op]
+		if !ok || prec < min {
+			return x, nil
+		}
+		column := p.column + p.pos
+		p.pos += len(op)
+		y, err := p.binary(prec + 1)
+		if err != nil {
+			return nil, err
+		}
+		x = &binary{op: op, x: x, y: y, column: column}
+	}
+}
+
+func (p *exprParser) unary() (expr, error) {
+	p.space()
+	if p.pos >= len(p.s) {
+		return nil, p.errorf("expression cut short")
+	}
+	switch ch := p.s[p.pos]; ch {
+	case '-', '~', '+':
+		p.pos++
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &unary{op: ch, x: x}, nil
+	case '(':
+		p.pos++
+		x, err := p.binary(1)
+		if err != nil {
+			return nil, err
+		}
+		p.space()
+		if p.pos >= len(p.s) || p.s[p.pos] != ')' {
+			return nil, p.errorf("missing )")
+		}
+		p.pos++
+		return x, nil
+	}
+	return p.primary()
+}
+
+func (p *exprParser) primary() (expr, error) {
+	start := p.pos
+	ch := p.s[p.pos]
+	switch {
+	case isDigit(ch):
+		word := p.word()
+		lower := strings.ToLower(word)
+		switch {
+		case strings.HasPrefix(lower, "0x"):
+			return p.number(word, lower[2:], 16, start)
+		case strings.HasPrefix(lower, "0b"):
+			return p.number(word, lower[2:], 2, start)
+		}
+		return p.number(word, word, 10, start)
+	case ch == '#' || ch == '$':
+		p.pos++
+		if p.pos < len(p.s) && isHexDigit(p.s[p.pos]) {
+			word := p.word()
+			return p.number(string(ch)+word, word, 16, start)
+		}
+		if ch == '$' {
+			return here{}, nil
+		}
+	case ch == '%':
+		p.pos++
+		if p.pos < len(p.s) && (p.s[p.pos] == '0' || p.s[p.pos] == '1') {
+			word := p.word()
+			return p.number("%"+word, word, 2, start)
+		}
+	case ch == '\'':
+		end := strings.IndexByte(p.s[p.pos+1:], '\'')
+		if end < 0 {
+			return nil, p.errorf("missing '")
+		}
+		lit := p.s[p.pos : p.pos+end+2]
+		r, _, tail, err := strconv.UnquoteChar(lit[1:len(lit)-1], '\'')
+		if err != nil || tail != "" || r > 0xFF {
+			return nil, p.errorf("bad character %s", lit)
+		}
+		p.pos += len(lit)
+		return number(r), nil
+	case isIdentStart(ch):
+		return &ident{name: p.word(), column: p.column + start}, nil
+	}
+	p.pos = start
+	return nil, p.errorf("unexpected %q in expression", p.s[p.pos:p.pos+1])
+}
+
+func (p *exprParser) word() string {
+	start := p.pos
+	for p.pos < len(p.s) && isIdentPart(p.s[p.pos]) {
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+func (p *exprParser) number(word, digits string, base int, start int) (expr, error) {
+	n, err := strconv.ParseInt(digits, base, 32)
+	if err != nil {
+		p.pos = start
+		return nil, p.errorf("bad number %q", word)
+	}
+	return number(n), nil
+}
+
+func isDigit(ch byte) bool {
+	return '0' <= ch && ch <= '9'
+}
+
+func isHexDigit(ch byte) bool {
+	return isDigit(ch) || ('a' <= ch && ch <= 'f') || ('A' <= ch && ch <= 'F')
+}
+
+func isIdentStart(ch byte) bool {
+	return ch == '_' || ch == '.' || ('a' <= ch && ch <= 'z') || ('A' <= ch && ch <= 'Z')
+}
+
+func isIdentPart(ch byte) bool {
+	return isIdentStart(ch) || isDigit(ch)
+}
+
+// eval works x out, looking identifiers up with resolve. pc is the value of $.
+func eval(x expr, pc int, resolve func(*ident) (int, error)) (int, error) {
+	switch x := x.(type) {
+	case number:
+		return int(x), nil
+	case here:
+		return pc, nil
+	case *ident:
+		return resolve(x)
+	case *unary:
+		v, err := eval(x.x, pc, resolve)
+		if err != nil {
+			return 0, err
+		}
+		switch x.op {
+		case '-':
+			return -v, nil
+		case '~':
+			return ^v, nil
+		}
+		return v, nil
+	case *binary:
+		a, err := eval(x.x, pc, resolve)
+		if err != nil {
+			return 0, err
+		}
+		b, err := eval(x.y, pc, resolve)
+		if err != nil {
+			return 0, err
+		}
+		switch x.op {
+		case "|":
+			return a | b, nil
+		case "^":
+			return a ^ b, nil
+		case "&":
+			return a & b, nil
+		case "<<", ">>":
+			if b < 0 || b > 31 {
+				return 0, &Error{Column: x.column, Msg: fmt.Sprintf("bad shift %d", b)}
+			}
+			if x.op == "<<" {
+				return a << uint(b), nil
+			}
+			return a >> uint(b), nil
+		case "+":
+			return a + b, nil
+		case "-":
+			return a - b, nil
+		case "*":
+			return a * b, nil
+		case "/", "%":
+			if b == 0 {
+				return 0, &Error{Column: x.column, Msg: "division by zero"}
+			}
+			if x.op == "/" {
+				return a / b, nil
+			}
+			return a % b, nil
+		}
+	}
+	panic(fmt.Sprintf("asm: bad expression %#v", x))
+}
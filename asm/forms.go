@@ -0,0 +1,158 @@
+package asm
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/gilmae/chip8/chip8"
+)
+
+// A form is one way of writing an instruction: the operands it takes and the
+// opcode it assembles to. Operands are v for a register, r for a range of
+// registers, e for an expression, long for LONG and an expression, or a
+// keyword that must be written as it is.
+type form struct {
+	operands string
+	op       chip8.Opcode
+}
+
+var forms = map[string][]form{
+	"SYS":   {{"e", chip8.SYS}},
+	"CLS":   {{"", chip8.CLS}},
+	"RET":   {{"", chip8.RET}},
+	"JP":    {{"e", chip8.JP}, {"V0,e", chip8.JPV0}},
+	"CALL":  {{"e", chip8.CALL}},
+	"SE":    {{"v,e", chip8.SE}, {"v,v", chip8.SRE}},
+	"SNE":   {{"v,e", chip8.SNE}, {"v,v", chip8.SRNE}},
+	"ADD":   {{"v,e", chip8.ADD}, {"v,v", chip8.ADDVxVy}, {"I,v", chip8.ADDIVx}},
+	"OR":    {{"v,v", chip8.OR}},
+	"AND":   {{"v,v", chip8.AND}},
+	"XOR":   {{"v,v", chip8.XOR}},
+	"SUB":   {{"v,v", chip8.SUB}},
+	"SHR":   {{"v,v", chip8.SHR}},
+	"SUBN":  {{"v,v", chip8.SUBN}},
+	"SHL":   {{"v,v", chip8.SHL}},
+	"RND":   {{"v,e", chip8.RND}},
+	"DRW":   {{"v,v,e", chip8.DRW}},
+	"SKP":   {{"v", chip8.SKP}},
+	"SKNP":  {{"v", chip8.SKNP}},
+	"SCD":   {{"e", chip8.SCD}},
+	"SCU":   {{"e", chip8.SCU}},
+	"SCR":   {{"", chip8.SCR}},
+	"SCL":   {{"", chip8.SCL}},
+	"EXIT":  {{"", chip8.EXIT}},
+	"LOW":   {{"", chip8.LOW}},
+	"HIGH":  {{"", chip8.HIGH}},
+	"PLANE": {{"e", chip8.PLANE}},
+	"AUDIO": {{"", chip8.AUDIO}},
+	"PITCH": {{"v", chip8.PITCH}},
+	"LD": {
+		{"v,e", chip8.LD},
+		{"v,v", chip8.LDVxVy},
+		{"I,e", chip8.LDI},
+		{"I,long", chip8.LDIL},
+		{"v,DT", chip8.LDVxDT},
+		{"v,K", chip8.LDK},
+		{"DT,v", chip8.LDDTVx},
+		{"ST,v", chip8.LDSTVx},
+		{"F,v", chip8.LDF},
+		{"HF,v", chip8.LDHF},
+		{"B,v", chip8.LDB},
+		{"[I],v", chip8.LDIVx},
+		{"v,[I]", chip8.LDVxI},
+		{"[I],r", chip8.LDIVxVy},
+		{"r,[I]", chip8.LDVxVyI},
+		{"R,v", chip8.LDRVx},
+		{"v,R", chip8.LDVxR},
+	},
+}
+
+var directives = []string{"DB", "DW", "EQU", "INCLUDE", "ORG"}
+
+// Mnemonics lists the instructions and directives, sorted.
+func Mnemonics() []string {
+	words := append([]string(nil), directives...)
+	for mnemonic := range forms {
+		words = append(words, mnemonic)
+	}
+	sort.Strings(words)
+	return words
+}
+
+// Keywords lists the operands that are written as they are, such as DT and
+// [I], sorted.
+func Keywords() []string {
+	var words []string
+	for k := range keywords {
+		words = append(words, k)
+	}
+	sort.Strings(words)
+	return words
+}
+
+// keywords are the operands that are neither registers nor expressions, so
+// cannot be used as names.
+var keywords = map[string]bool{
+	"I": true, "[I]": true, "DT": true, "ST": true, "K": true,
+	"F": true, "HF": true, "B": true, "R": true,
+}
+
+func (f form) want() []string {
+	if f.operands == "" {
+		return nil
+	}
+	return strings.Split(f.operands, ",")
+}
+
+// keyword reports whether operand i of f is a keyword rather than a value.
+func (f form) keyword(i int) bool {
+	switch f.want()[i] {
+	case "v", "r", "e", "long":
+		return false
+	}
+	return true
+}
+
+// matches reports whether operands can be written as f.
+func (f form) matches(operands []*operand) bool {
+	want := f.want()
+	if len(want) != len(operands) {
+		return false
+	}
+	for i, w := range want {
+		o := operands[i]
+		switch w {
+		case "v":
+			if o.kind != operand_register {
+				return false
+			}
+		case "r":
+			if o.kind != operand_range {
+				return false
+			}
+		case "e":
+			if o.kind != operand_expr {
+				return false
+			}
+		case "long":
+			if o.kind != operand_long {
+				return false
+			}
+		default:
+			if o.keyword != w {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// findForm finds how mnemonic is written with operands.
+func findForm(mnemonic string, operands []*operand) (form, bool) {
+	for _, f := range forms[mnemonic] {
+		if f.matches(operands) {
+			return f, true
+		}
+	}
+	return form{}, false
+}
@@ -0,0 +1,170 @@
+package chip8
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Audio sounds the buzzer. The machine calls Play once per frame with what
+// the buzzer should be doing for that frame.
+type Audio interface {
+	Play(t Tone)
+	Close()
+}
+
+// Tone is the state of the buzzer for one frame.
+type Tone struct {
+	On bool
+
+	// Pattern is the XO-CHIP audio pattern buffer, or nil for the plain
+	// beeper, played at a rate set by Pitch.
+	Pattern *[16]byte
+	Pitch   byte
+}
+
+type Waveform int
+
+const (
+	Square Waveform = iota
+	Sine
+	Triangle
+	Sawtooth
+)
+
+var waveformNames = map[Waveform]string{
+	Square:   "square",
+	Sine:     "sine",
+	Triangle: "triangle",
+	Sawtooth: "sawtooth",
+}
+
+func (w Waveform) String() string {
+	return waveformNames[w]
+}
+
+func WaveformByName(name string) (Waveform, error) {
+	for w, n := range waveformNames {
+		if n == strings.ToLower(name) {
+			return w, nil
+		}
+	}
+	return Square, fmt.Errorf("unknown waveform %q, want square, sine, triangle or sawtooth", name)
+}
+
+const (
+	DefaultSampleRate = 44100
+	DefaultToneHz     = 440.0
+	DefaultVolume     = 0.25
+
+	ramp_seconds = 0.005 // fade in and out over 5ms so starting and stopping doesn't click
+)
+
+// Beeper synthesises the buzzer. It is independent of any audio device, so
+// the samples it generates can be inspected directly.
+type Beeper struct {
+	SampleRate int
+	Frequency  float64 // Hz of the plain beeper
+	Waveform   Waveform
+	Volume     float64 // 0 to 1
+	Muted      bool
+
+	phase        float64 // position through the current cycle, 0 to 1
+	patternPhase float64 // position through the pattern buffer, in bits
+	gain         float64 // current envelope level, chasing 0 or 1
+}
+
+func NewBeeper(sampleRate int) *Beeper {
+	return &Beeper{
+		SampleRate: sampleRate,
+		Frequency:  DefaultToneHz,
+		Waveform:   Square,
+		Volume:     DefaultVolume,
+	}
+}
+
+// Generate fills buf with the next len(buf) samples, in the range -1 to 1,
+// carrying phase and envelope over from the previous call.
+func (b *Beeper) Generate(t Tone, buf []float32) {
+	target := 0.0
+	if t.On && !b.Muted {
+		target = 1
+	}
+	step := 1 / (ramp_seconds * float64(b.SampleRate))
+
+	for i := range buf {
+		if b.gain < target {
+			b.gain = math.Min(b.gain+step, target)
+		} else if b.gain > target {
+			b.gain = math.Max(b.gain-step, target)
+		}
+
+		var v float64
+		if t.Pattern != nil {
+			v = b.patternSample(t)
+		} else {
+			v = b.waveSample()
+		}
+		buf[i] = float32(v * b.gain * b.Volume)
+	}
+}
+
+func (b *Beeper) waveSample() float64 {
+	p := b.phase
+	b.phase = math.Mod(b.phase+b.Frequency/float64(b.SampleRate), 1)
+
+	switch b.Waveform {
+	case Sine:
+		return math.Sin(2 * math.Pi * p)
+	case Triangle:
+		return 1 - 4*math.Abs(p-0.5)
+	case Sawtooth:
+		return 2*p - 1
+	}
+	if p < 0.5 {
+		return 1
+	}
+	return -1
+}
+
+// patternSample plays the 128 bit XO-CHIP pattern buffer at
+// 4000*2^((pitch-64)/48) bits per second.
+func (b *Beeper) patternSample(t Tone) float64 {
+	rate := 4000 * math.Pow(2, (float64(t.Pitch)-64)/48)
+	bit := int(b.patternPhase) % 128
+	b.patternPhase = math.Mod(b.patternPhase+rate/float64(b.SampleRate), 128)
+
+	if t.Pattern[bit/8]&(0x80>>uint(bit%8)) != 0 {
+		return 1
+	}
+	return -1
+}
+
+type nullAudio struct{}
+
+func NewNullAudio() *nullAudio {
+	return &nullAudio{}
+}
+
+func (n *nullAudio) Play(t Tone) {}
+
+func (n *nullAudio) Close() {}
+
+// BufferAudio is an Audio that keeps everything it generates in Samples, for
+// tests and tools that want the sound without a device.
+type BufferAudio struct {
+	Beeper  *Beeper
+	Samples []float32
+}
+
+func NewBufferAudio(sampleRate int) *BufferAudio {
+	return &BufferAudio{Beeper: NewBeeper(sampleRate)}
+}
+
+func (a *BufferAudio) Play(t Tone) {
+	buf := make([]float32, a.Beeper.SampleRate/FrameRate)
+	a.Beeper.Generate(t, buf)
+	a.Samples = append(a.Samples, buf...)
+}
+
+func (a *BufferAudio) Close() {}
@@ -0,0 +1,115 @@
+package chip8
+
+import (
+	"math"
+	"testing"
+)
+
+func peak(samples []float32) float64 {
+	p := 0.0
+	for _, s := range samples {
+		p = math.Max(p, math.Abs(float64(s)))
+	}
+	return p
+}
+
+func TestBeeper(t *testing.T) {
+	tests := []struct {
+		name         string
+		waveform     Waveform
+		muted        bool
+		tone         Tone
+		expectedPeak float64
+	}{
+		{"off", Square, false, Tone{}, 0},
+		{"square", Square, false, Tone{On: true}, DefaultVolume},
+		{"sine", Sine, false, Tone{On: true}, DefaultVolume},
+		{"triangle", Triangle, false, Tone{On: true}, DefaultVolume},
+		{"sawtooth", Sawtooth, false, Tone{On: true}, DefaultVolume},
+		{"muted", Square, true, Tone{On: true}, 0},
+		{"pattern", Square, false, Tone{On: true, Pattern: &[16]byte{0xf0, 0xf0}, Pitch: 64}, DefaultVolume},
+	}
+
+	for _, tt := range tests {
+		b := NewBeeper(DefaultSampleRate)
+		b.Waveform = tt.waveform
+		b.Muted = tt.muted
+
+		buf := make([]float32, DefaultSampleRate/FrameRate)
+		b.Generate(tt.tone, buf)
+
+		if p := peak(buf); math.Abs(p-tt.expectedPeak) > 0.01 {
+			t.Errorf("%s: wrong peak level, want=%.2f, got=%.2f", tt.name, tt.expectedPeak, p)
+		}
+	}
+}
+
+func TestBeeperDoesNotClick(t *testing.T) {
+	b := NewBeeper(DefaultSampleRate)
+	buf := make([]float32, DefaultSampleRate/FrameRate)
+
+	b.Generate(Tone{On: true}, buf)
+	if math.Abs(float64(buf[0])) > 0.01 {
+		t.Errorf("tone started at full volume, first sample=%.3f", buf[0])
+	}
+
+	b.Generate(Tone{}, buf)
+	if math.Abs(float64(buf[0])) < 0.2 {
+		t.Errorf("tone stopped dead, first sample after stop=%.3f", buf[0])
+	}
+	if last := buf[len(buf)-1]; last != 0 {
+		t.Errorf("tone did not fade out, last sample=%.3f", last)
+	}
+}
+
+func TestMachineSoundTimerPlaysAudio(t *testing.T) {
+	audio := NewBufferAudio(DefaultSampleRate)
+	// ST := 3, then loop
+	c := newTestCpu([]byte{0x61, 0x03, 0xf1, 0x18, 0x12, 0x04}, WithAudio(audio))
+
+	if err := c.RunFrames(10); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	frame := DefaultSampleRate / FrameRate
+	if len(audio.Samples) != 10*frame {
+		t.Fatalf("wrong number of samples, want=%d, got=%d", 10*frame, len(audio.Samples))
+	}
+	if peak(audio.Samples[:frame]) == 0 {
+		t.Errorf("expected sound while the sound timer runs")
+	}
+	if peak(audio.Samples[5*frame:]) != 0 {
+		t.Errorf("expected silence once the sound timer has run out")
+	}
+}
+
+func TestPausedMachineFadesOut(t *testing.T) {
+	for _, pause := range []func(c *Machine){
+		func(c *Machine) { c.Pause() },
+		func(c *Machine) { c.SetRewinding(true) },
+	} {
+		audio := NewBufferAudio(DefaultSampleRate)
+		// ST := 30, then loop
+		c := newTestCpu([]byte{0x61, 0x1e, 0xf1, 0x18, 0x12, 0x04}, WithAudio(audio), WithRewind(DefaultRewindBudget))
+		if err := c.RunFrames(2); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		pause(c)
+		if err := c.Tick(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		frame := DefaultSampleRate / FrameRate
+		if len(audio.Samples) != 3*frame {
+			t.Fatalf("wrong number of samples, want=%d, got=%d", 3*frame, len(audio.Samples))
+		}
+		stopped := audio.Samples[2*frame:]
+		if math.Abs(float64(stopped[0])) < 0.2 {
+			t.Errorf("tone stopped dead, first sample after stop=%.3f", stopped[0])
+		}
+		if last := stopped[len(stopped)-1]; last != 0 {
+			t.Errorf("tone did not fade out, last sample=%.3f", last)
+		}
+	}
+}
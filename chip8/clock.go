@@ -0,0 +1,87 @@
+package chip8
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock paces a running Machine, which runs one frame per tick received from
+// C. Supplying a Clock lets the host, rather than the machine, decide when
+// frames happen.
+type Clock interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+type realClock struct {
+	ticker *time.Ticker
+}
+
+// NewRealClock returns a Clock ticking every d of wall time.
+func NewRealClock(d time.Duration) *realClock {
+	return &realClock{ticker: time.NewTicker(d)}
+}
+
+func (r *realClock) C() <-chan time.Time {
+	return r.ticker.C
+}
+
+func (r *realClock) Stop() {
+	r.ticker.Stop()
+}
+
+// ManualClock ticks only when Advance is called, so a Run loop can be driven
+// frame by frame without waiting on wall time. It drives a single Run: once
+// that returns, or Stop is called, the clock ticks no more.
+type ManualClock struct {
+	c        chan time.Time
+	done     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+	now      time.Time
+}
+
+func NewManualClock() *ManualClock {
+	return &ManualClock{c: make(chan time.Time), done: make(chan struct{}), stopped: make(chan struct{})}
+}
+
+func (m *ManualClock) C() <-chan time.Time {
+	return m.c
+}
+
+// Advance delivers n ticks, each DefaultClockSpeed after the last. It blocks
+// until a running machine has finished the frame for every one of them, and
+// reports false if the clock stopped, or the machine's Run returned, first.
+func (m *ManualClock) Advance(n int) bool {
+	for i := 0; i < n; i++ {
+		m.now = m.now.Add(DefaultClockSpeed)
+		select {
+		case m.c <- m.now:
+		case <-m.stopped:
+			return false
+		}
+		<-m.done
+	}
+	return true
+}
+
+func (m *ManualClock) tickDone() {
+	m.done <- struct{}{}
+}
+
+func (m *ManualClock) runReturned() {
+	m.Stop()
+}
+
+// tickAcknowledger is implemented by clocks that need to know when the frame
+// for a tick has finished, and when Run has returned.
+type tickAcknowledger interface {
+	tickDone()
+	runReturned()
+}
+
+func (m *ManualClock) Stop() {
+	m.stopOnce.Do(func() {
+		close(m.stopped)
+	})
+}
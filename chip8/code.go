@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"strconv"
 )
 
 type Instructions []byte
@@ -12,7 +13,9 @@ type Opcode byte
 
 type Definition struct {
 	Name          string
-	OperandWidths []int // bits
+	OperandWidths []int  // bits
+	Pattern       string // encoding in hex digits, with operands as runs of x, y, n or k
+	Description   string // what the instruction does
 }
 
 const (
@@ -52,44 +55,85 @@ const (
 	LDB    // Load BCD to I, I+1, I+2
 	LDIVx  // Load registers to memory starting at I
 	LDVxI  // Read memory into registers, starting at I
+
+	// SUPER-CHIP 1.1
+	SCD   // Scroll down N lines
+	SCR   // Scroll right 4 pixels
+	SCL   // Scroll left 4 pixels
+	EXIT  // Exit the interpreter
+	LOW   // Switch to 64x32 mode
+	HIGH  // Switch to 128x64 mode
+	LDHF  // Set I to location of big hex sprite
+	LDRVx // Save registers to RPL flags
+	LDVxR // Load registers from RPL flags
+
+	// XO-CHIP
+	SCU     // Scroll up N lines
+	LDIVxVy // Save Vx to Vy to memory starting at I
+	LDVxVyI // Load Vx to Vy from memory starting at I
+	LDIL    // Load a 16 bit address to I, from the following two bytes
+	PLANE   // Select the drawing planes
+	AUDIO   // Load the audio pattern buffer from I
+	PITCH   // Set the audio pattern playback pitch
 )
 
 var definitions = map[Opcode]*Definition{
-	SYS:     {"SYS", []int{12}},
-	CLS:     {"CLS", []int{}},
-	RET:     {"RET", []int{}},
-	JP:      {"JP", []int{12}},
-	CALL:    {"CALL", []int{12}},
-	SE:      {"SE", []int{4, 8}},
-	SNE:     {"SNE", []int{4, 8}},
-	SRE:     {"SRE", []int{4, 4}},
-	SRNE:    {"SRNE", []int{4, 4}},
-	LD:      {"LD", []int{4, 8}},
-	LDVxVy:  {"LDVxVy", []int{4, 4}},
-	LDI:     {"LDI", []int{12}},
-	LDVxDT:  {"LDVxDT", []int{4}},
-	LDDTVx:  {"LDDTVx", []int{4}},
-	LDSTVx:  {"LDSTVx", []int{4}},
-	LDB:     {"LDB", []int{4}},
-	LDIVx:   {"LDIVx", []int{4}},
-	LDVxI:   {"LDVxI", []int{4}},
-	ADD:     {"ADD", []int{4, 8}},
-	ADDVxVy: {"ADDVxVy", []int{4, 4}},
-	ADDIVx:  {"ADDIVx", []int{4}},
-	OR:      {"OR", []int{4, 4}},
-	AND:     {"AND", []int{4, 4}},
-	XOR:     {"XOR", []int{4, 4}},
-	SUB:     {"SUB", []int{4, 4}},
-	SUBN:    {"SUBN", []int{4, 4}},
-	SHR:     {"SHR", []int{4}},
-	SHL:     {"SHL", []int{4}},
-	JPV0:    {"JPV0", []int{12}},
-	RND:     {"RND", []int{4, 8}},
-	DRW:     {"DRW", []int{4, 4, 4}},
-	LDF:     {"LDF", []int{4}},
-	LDK:     {"LDK", []int{4}},
-	SKP:     {"SKP", []int{4}},
-	SKNP:    {"SKNP", []int{4}},
+	SYS:     {"SYS", []int{12}, "0nnn", "Call machine code at nnn; ignored"},
+	CLS:     {"CLS", []int{}, "00E0", "Clear the display"},
+	RET:     {"RET", []int{}, "00EE", "Return from a subroutine"},
+	JP:      {"JP", []int{12}, "1nnn", "Jump to nnn"},
+	CALL:    {"CALL", []int{12}, "2nnn", "Call the subroutine at nnn"},
+	SE:      {"SE", []int{4, 8}, "3xkk", "Skip the next instruction if Vx = kk"},
+	SNE:     {"SNE", []int{4, 8}, "4xkk", "Skip the next instruction if Vx != kk"},
+	SRE:     {"SRE", []int{4, 4}, "5xy0", "Skip the next instruction if Vx = Vy"},
+	SRNE:    {"SRNE", []int{4, 4}, "9xy0", "Skip the next instruction if Vx != Vy"},
+	LD:      {"LD", []int{4, 8}, "6xkk", "Set Vx = kk"},
+	LDVxVy:  {"LDVxVy", []int{4, 4}, "8xy0", "Set Vx = Vy"},
+	LDI:     {"LDI", []int{12}, "Annn", "Set I = nnn"},
+	LDVxDT:  {"LDVxDT", []int{4}, "Fx07", "Set Vx = the delay timer"},
+	LDDTVx:  {"LDDTVx", []int{4}, "Fx15", "Set the delay timer = Vx"},
+	LDSTVx:  {"LDSTVx", []int{4}, "Fx18", "Set the sound timer = Vx"},
+	LDB:     {"LDB", []int{4}, "Fx33", "Store the decimal digits of Vx at I, I+1 and I+2"},
+	LDIVx:   {"LDIVx", []int{4}, "Fx55", "Store V0 to Vx in memory from I"},
+	LDVxI:   {"LDVxI", []int{4}, "Fx65", "Read V0 to Vx from memory from I"},
+	ADD:     {"ADD", []int{4, 8}, "7xkk", "Set Vx = Vx + kk"},
+	ADDVxVy: {"ADDVxVy", []int{4, 4}, "8xy4", "Set Vx = Vx + Vy, VF = carry"},
+	ADDIVx:  {"ADDIVx", []int{4}, "Fx1E", "Set I = I + Vx"},
+	OR:      {"OR", []int{4, 4}, "8xy1", "Set Vx = Vx OR Vy"},
+	AND:     {"AND", []int{4, 4}, "8xy2", "Set Vx = Vx AND Vy"},
+	XOR:     {"XOR", []int{4, 4}, "8xy3", "Set Vx = Vx XOR Vy"},
+	SUB:     {"SUB", []int{4, 4}, "8xy5", "Set Vx = Vx - Vy, VF = NOT borrow"},
+	SUBN:    {"SUBN", []int{4, 4}, "8xy7", "Set Vx = Vy - Vx, VF = NOT borrow"},
+	SHR:     {"SHR", []int{4, 4}, "8xy6", "Set Vx = Vx >> 1 (Vy >> 1 with ShiftUsesVy), VF = the bit shifted out"},
+	SHL:     {"SHL", []int{4, 4}, "8xyE", "Set Vx = Vx << 1 (Vy << 1 with ShiftUsesVy), VF = the bit shifted out"},
+	JPV0:    {"JPV0", []int{12}, "Bnnn", "Jump to nnn + V0 (BXNN jumps to nnn + Vx with JumpUsesVx)"},
+	RND:     {"RND", []int{4, 8}, "Cxkk", "Set Vx = a random byte AND kk"},
+	DRW:     {"DRW", []int{4, 4, 4}, "Dxyn", "Draw the n byte sprite at I at (Vx, Vy), VF = collision"},
+	LDF:     {"LDF", []int{4}, "Fx29", "Set I = the small font sprite for digit Vx"},
+	LDK:     {"LDK", []int{4}, "Fx0A", "Wait for a key press and store it in Vx"},
+	SKP:     {"SKP", []int{4}, "Ex9E", "Skip the next instruction if the key in Vx is down"},
+	SKNP:    {"SKNP", []int{4}, "ExA1", "Skip the next instruction if the key in Vx is up"},
+	SCD:     {"SCD", []int{4}, "00Cn", "Scroll the display down n lines"},
+	SCR:     {"SCR", []int{}, "00FB", "Scroll the display right 4 pixels"},
+	SCL:     {"SCL", []int{}, "00FC", "Scroll the display left 4 pixels"},
+	EXIT:    {"EXIT", []int{}, "00FD", "Exit the interpreter"},
+	LOW:     {"LOW", []int{}, "00FE", "Switch to 64x32 low resolution"},
+	HIGH:    {"HIGH", []int{}, "00FF", "Switch to 128x64 high resolution"},
+	LDHF:    {"LDHF", []int{4}, "Fx30", "Set I = the big font sprite for digit Vx"},
+	LDRVx:   {"LDRVx", []int{4}, "Fx75", "Save V0 to Vx to the RPL flags"},
+	LDVxR:   {"LDVxR", []int{4}, "Fx85", "Load V0 to Vx from the RPL flags"},
+	SCU:     {"SCU", []int{4}, "00Dn", "Scroll the display up n lines"},
+	LDIVxVy: {"LDIVxVy", []int{4, 4}, "5xy2", "Store Vx to Vy in memory from I, leaving I alone"},
+	LDVxVyI: {"LDVxVyI", []int{4, 4}, "5xy3", "Read Vx to Vy from memory from I, leaving I alone"},
+	LDIL:    {"LDIL", []int{16}, "F000nnnn", "Set I = nnnn, the 16 bit address after the instruction"},
+	PLANE:   {"PLANE", []int{4}, "Fn01", "Select drawing planes n"},
+	AUDIO:   {"AUDIO", []int{}, "F002", "Load the 16 byte audio pattern at I"},
+	PITCH:   {"PITCH", []int{4}, "Fx3A", "Set the audio pattern's pitch = Vx"},
+}
+
+// Size returns the length of the instruction in bytes.
+func (d *Definition) Size() int {
+	return len(d.Pattern) / 2
 }
 
 func Lookup(op byte) (*Definition, error) {
@@ -110,6 +154,22 @@ func ParseOpcode(ins Instructions) Opcode {
 			return CLS
 		case 0xEE: // 00EE - RET
 			return RET
+		case 0xFB: // 00FB - SCR
+			return SCR
+		case 0xFC: // 00FC - SCL
+			return SCL
+		case 0xFD: // 00FD - EXIT
+			return EXIT
+		case 0xFE: // 00FE - LOW
+			return LOW
+		case 0xFF: // 00FF - HIGH
+			return HIGH
+		}
+		switch tribble & 0xff0 {
+		case 0xC0: // 00Cn - SCD nibble
+			return SCD
+		case 0xD0: // 00Dn - SCU nibble
+			return SCU
 		default: // 0nnn - SYS addr. Presumably will be unuused
 			return SYS
 		}
@@ -122,7 +182,15 @@ func ParseOpcode(ins Instructions) Opcode {
 	case 0x4:
 		return SNE
 	case 0x5:
-		return SRE
+		nibble := ReadNibble(ins)
+		switch nibble {
+		case 0x0:
+			return SRE
+		case 0x2:
+			return LDIVxVy
+		case 0x3:
+			return LDVxVyI
+		}
 	case 0x6:
 		return LD
 	case 0x7:
@@ -168,8 +236,18 @@ func ParseOpcode(ins Instructions) Opcode {
 			return SKNP
 		}
 	case 0xf:
+		switch ReadUint16(ins) {
+		case 0xf000:
+			return LDIL
+		case 0xf002:
+			return AUDIO
+		}
 		lowbyte := ReadUint8(ins)
 		switch lowbyte {
+		case 0x01:
+			return PLANE
+		case 0x3a:
+			return PITCH
 		case 0x07:
 			return LDVxDT
 		case 0x0a:
@@ -188,36 +266,95 @@ func ParseOpcode(ins Instructions) Opcode {
 			return LDIVx
 		case 0x65:
 			return LDVxI
+		case 0x30:
+			return LDHF
+		case 0x75:
+			return LDRVx
+		case 0x85:
+			return LDVxR
 		}
 	}
 	return UNKNOWN
 }
 
+// Encode builds the instruction for op from its operands, in the order they
+// appear in the definition's Pattern. It is the inverse of ReadOperands.
+// Operands may be negative, down to minus half their range, and are stored
+// in two's complement.
+func Encode(op Opcode, operands ...int) (Instructions, error) {
+	def, err := Lookup(byte(op))
+	if err != nil {
+		return nil, err
+	}
+	if len(operands) != len(def.OperandWidths) {
+		return nil, fmt.Errorf("%s takes %d operands, got %d", def.Name, len(def.OperandWidths), len(operands))
+	}
+
+	word := uint64(0)
+	operand := 0
+	for i := 0; i < len(def.Pattern); {
+		ch := def.Pattern[i]
+		if !isOperandDigit(ch) {
+			digit, _ := strconv.ParseUint(def.Pattern[i:i+1], 16, 8)
+			word = word<<4 | digit
+			i++
+			continue
+		}
+
+		end := i
+		for end < len(def.Pattern) && def.Pattern[end] == ch {
+			end++
+		}
+		bits := uint(4 * (end - i))
+		value := operands[operand]
+		if value >= 1<<bits || value < -(1<<(bits-1)) {
+			return nil, fmt.Errorf("%s operand %d does not fit in %d bits", def.Name, value, bits)
+		}
+		word = word<<bits | uint64(value)&(1<<bits-1)
+		operand++
+		i = end
+	}
+
+	ins := make(Instructions, def.Size())
+	for i := range ins {
+		ins[i] = byte(word >> uint(8*(len(ins)-1-i)))
+	}
+	return ins, nil
+}
+
+// ReadOperands extracts the operands of ins, in the order they appear in the
+// definition's Pattern.
 func ReadOperands(def *Definition, ins Instructions) []int {
-	operands := make([]int, len(def.OperandWidths))
-	offset := 0
-	for i, width := range def.OperandWidths {
-		switch width {
-		case 12:
-			operands[i] = int(ReadUint12((ins)))
-		case 8:
-			operands[i] = int(ReadUint8(ins))
-		case 4:
-			switch offset {
-			case 0:
-				operands[i] = int(ReadHighByteNibble(ins))
-			case 1:
-				operands[i] = int(ReadLowByteHighNibble(ins))
-			case 2:
-				operands[i] = int(ReadNibble(ins))
-			}
+	operands := make([]int, 0, len(def.OperandWidths))
+	word := uint64(0)
+	for i := 0; i < len(def.Pattern)/2 && i < len(ins); i++ {
+		word = word<<8 | uint64(ins[i])
+	}
+
+	for i := 0; i < len(def.Pattern); {
+		ch := def.Pattern[i]
+		if !isOperandDigit(ch) {
+			i++
+			continue
+		}
+
+		end := i
+		for end < len(def.Pattern) && def.Pattern[end] == ch {
+			end++
 		}
-		offset++
+		shift := uint(4 * (len(def.Pattern) - end))
+		mask := uint64(1)<<uint(4*(end-i)) - 1
+		operands = append(operands, int(word>>shift&mask))
+		i = end
 	}
 
 	return operands
 }
 
+func isOperandDigit(ch byte) bool {
+	return ch == 'x' || ch == 'y' || ch == 'n' || ch == 'k'
+}
+
 func ReadUint16(ins Instructions) uint16 {
 	return binary.BigEndian.Uint16(ins[0:2])
 }
@@ -253,25 +390,36 @@ func ReadHighestByte(ins Instructions) uint8 {
 	return uint8(ins[0])
 }
 
+// String describes each instruction with its offset in ins, both in hex.
+// Bytes that are not an instruction are described as data. For listings in
+// assembler syntax, see the disasm package.
 func (ins Instructions) String() string {
 	var out bytes.Buffer
 
 	i := 0
 	for i < len(ins) {
-		op := ParseOpcode(ins[i:])
-		def, err := Lookup(byte(op))
-
-		if err != nil {
-			i += 2
-			fmt.Fprintf(&out, "ERROR: %s\n", err)
+		var def *Definition
+		err := fmt.Errorf("instruction cut short")
+		if i+2 <= len(ins) {
+			def, err = Lookup(byte(ParseOpcode(ins[i:])))
+		}
+		if err != nil || i+def.Size() > len(ins) {
+			end := i + 2
+			if end > len(ins) {
+				end = len(ins)
+			}
+			fmt.Fprintf(&out, "%04X DB", i)
+			for _, b := range ins[i:end] {
+				fmt.Fprintf(&out, " %02X", b)
+			}
+			out.WriteString("\n")
+			i = end
 			continue
-		} else {
-
-			operands := ReadOperands(def, ins)
-
-			fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
 		}
-		i += 2
+
+		operands := ReadOperands(def, ins[i:])
+		fmt.Fprintf(&out, "%04X %s\n", i, ins.fmtInstruction(def, operands))
+		i += def.Size()
 	}
 
 	return out.String()
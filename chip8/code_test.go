@@ -1,6 +1,7 @@
 package chip8
 
 import (
+	"bytes"
 	"testing"
 )
 
@@ -16,7 +17,7 @@ func TestParseOpcode(t *testing.T) {
 		{[]byte{0x21, 0x23}, CALL},
 		{[]byte{0x31, 0x23}, SE},
 		{[]byte{0x41, 0x23}, SNE},
-		{[]byte{0x51, 0x23}, SRE},
+		{[]byte{0x51, 0x20}, SRE},
 		{[]byte{0x61, 0x23}, LD},
 		{[]byte{0x71, 0x23}, ADD},
 		{[]byte{0x81, 0x20}, LDVxVy},
@@ -44,6 +45,23 @@ func TestParseOpcode(t *testing.T) {
 		{[]byte{0xf1, 0x33}, LDB},
 		{[]byte{0xf2, 0x55}, LDIVx},
 		{[]byte{0xf2, 0x65}, LDVxI},
+		{[]byte{0x00, 0xc4}, SCD},
+		{[]byte{0x00, 0xfb}, SCR},
+		{[]byte{0x00, 0xfc}, SCL},
+		{[]byte{0x00, 0xfd}, EXIT},
+		{[]byte{0x00, 0xfe}, LOW},
+		{[]byte{0x00, 0xff}, HIGH},
+		{[]byte{0xf1, 0x30}, LDHF},
+		{[]byte{0xf7, 0x75}, LDRVx},
+		{[]byte{0xf7, 0x85}, LDVxR},
+		{[]byte{0x00, 0xd4}, SCU},
+		{[]byte{0x51, 0x22}, LDIVxVy},
+		{[]byte{0x51, 0x23}, LDVxVyI},
+		{[]byte{0xf0, 0x00, 0x12, 0x34}, LDIL},
+		{[]byte{0xf3, 0x01}, PLANE},
+		{[]byte{0xf0, 0x02}, AUDIO},
+		{[]byte{0xf1, 0x3a}, PITCH},
+		{[]byte{0x51, 0x21}, UNKNOWN},
 	}
 
 	for _, tt := range tests {
@@ -67,7 +85,7 @@ func TestInstructionString(t *testing.T) {
 		{[]byte{0x21, 0x23}, "0000 CALL 291\n"},
 		{[]byte{0x31, 0x23}, "0000 SE 1 35\n"},
 		{[]byte{0x41, 0x23}, "0000 SNE 1 35\n"},
-		{[]byte{0x51, 0x23}, "0000 SRE 1 2\n"},
+		{[]byte{0x51, 0x20}, "0000 SRE 1 2\n"},
 		{[]byte{0x61, 0x23}, "0000 LD 1 35\n"},
 		{[]byte{0x71, 0x23}, "0000 ADD 1 35\n"},
 		{[]byte{0x81, 0x20}, "0000 LDVxVy 1 2\n"},
@@ -76,9 +94,9 @@ func TestInstructionString(t *testing.T) {
 		{[]byte{0x81, 0x23}, "0000 XOR 1 2\n"},
 		{[]byte{0x81, 0x24}, "0000 ADDVxVy 1 2\n"},
 		{[]byte{0x81, 0x25}, "0000 SUB 1 2\n"},
-		{[]byte{0x81, 0x26}, "0000 SHR 1\n"},
+		{[]byte{0x81, 0x26}, "0000 SHR 1 2\n"},
 		{[]byte{0x81, 0x27}, "0000 SUBN 1 2\n"},
-		{[]byte{0x81, 0x2e}, "0000 SHL 1\n"},
+		{[]byte{0x81, 0x2e}, "0000 SHL 1 2\n"},
 		{[]byte{0x91, 0x23}, "0000 SRNE 1 2\n"},
 		{[]byte{0xA1, 0x23}, "0000 LDI 291\n"},
 		{[]byte{0xb1, 0x23}, "0000 JPV0 291\n"},
@@ -95,6 +113,24 @@ func TestInstructionString(t *testing.T) {
 		{[]byte{0xf1, 0x33}, "0000 LDB 1\n"},
 		{[]byte{0xf2, 0x55}, "0000 LDIVx 2\n"},
 		{[]byte{0xf2, 0x65}, "0000 LDVxI 2\n"},
+		{[]byte{0x00, 0xc4}, "0000 SCD 4\n"},
+		{[]byte{0x00, 0xfb}, "0000 SCR\n"},
+		{[]byte{0x00, 0xfc}, "0000 SCL\n"},
+		{[]byte{0x00, 0xfd}, "0000 EXIT\n"},
+		{[]byte{0x00, 0xfe}, "0000 LOW\n"},
+		{[]byte{0x00, 0xff}, "0000 HIGH\n"},
+		{[]byte{0xf1, 0x30}, "0000 LDHF 1\n"},
+		{[]byte{0xf7, 0x75}, "0000 LDRVx 7\n"},
+		{[]byte{0xf7, 0x85}, "0000 LDVxR 7\n"},
+		{[]byte{0x00, 0xd4}, "0000 SCU 4\n"},
+		{[]byte{0x51, 0x22}, "0000 LDIVxVy 1 2\n"},
+		{[]byte{0x51, 0x23}, "0000 LDVxVyI 1 2\n"},
+		{[]byte{0xf0, 0x00, 0x12, 0x34}, "0000 LDIL 4660\n"},
+		{[]byte{0xf3, 0x01}, "0000 PLANE 3\n"},
+		{[]byte{0xf0, 0x02}, "0000 AUDIO\n"},
+		{[]byte{0xf1, 0x3a}, "0000 PITCH 1\n"},
+		{[]byte{0x61, 0x23, 0x22, 0x0c, 0xf0, 0x00, 0x12, 0x34, 0x00, 0xee}, "0000 LD 1 35\n0002 CALL 524\n0004 LDIL 4660\n0008 RET\n"},
+		{[]byte{0x00, 0xe0, 0x51, 0x21, 0xf0}, "0000 CLS\n0002 DB 51 21\n0004 DB F0\n"},
 	}
 
 	for _, tt := range tests {
@@ -278,3 +314,51 @@ func TestHighestByte(t *testing.T) {
 		}
 	}
 }
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		op       Opcode
+		operands []int
+		expected Instructions
+	}{
+		{CLS, nil, Instructions{0x00, 0xe0}},
+		{JP, []int{0x123}, Instructions{0x11, 0x23}},
+		{SE, []int{1, 0x23}, Instructions{0x31, 0x23}},
+		{ADD, []int{1, -1}, Instructions{0x71, 0xff}},
+		{SHL, []int{1, 2}, Instructions{0x81, 0x2e}},
+		{DRW, []int{1, 2, 3}, Instructions{0xd1, 0x23}},
+		{LDVxR, []int{7}, Instructions{0xf7, 0x85}},
+		{LDIL, []int{0x1234}, Instructions{0xf0, 0x00, 0x12, 0x34}},
+		{PLANE, []int{3}, Instructions{0xf3, 0x01}},
+	}
+
+	for _, tt := range tests {
+		ins, err := Encode(tt.op, tt.operands...)
+		if err != nil {
+			t.Errorf("Encode(%d, %v): %s", tt.op, tt.operands, err)
+			continue
+		}
+		if !bytes.Equal(ins, tt.expected) {
+			t.Errorf("wrong encoding, want=%X, got=%X", tt.expected, ins)
+		}
+		def, _ := Lookup(byte(tt.op))
+		if operands := ReadOperands(def, ins); len(tt.operands) > 0 && operands[len(operands)-1] != tt.operands[len(tt.operands)-1]&(1<<uint(def.OperandWidths[len(operands)-1])-1) {
+			t.Errorf("ReadOperands(Encode(%v)), got=%v", tt.operands, operands)
+		}
+	}
+
+	for _, bad := range []struct {
+		op       Opcode
+		operands []int
+	}{
+		{SE, []int{16, 0}},
+		{LD, []int{1, 0x100}},
+		{LD, []int{1, -129}},
+		{CLS, []int{1}},
+		{UNKNOWN, nil},
+	} {
+		if _, err := Encode(bad.op, bad.operands...); err == nil {
+			t.Errorf("Encode(%d, %v), want error", bad.op, bad.operands)
+		}
+	}
+}
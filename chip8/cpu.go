@@ -2,31 +2,45 @@ package chip8
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha1"
 	"fmt"
 	"io"
 	"log"
 	"math/rand"
 	"os"
+	"sync"
 	"time"
-
-	"github.com/veandco/go-sdl2/sdl"
 )
 
 const (
-	font_start_addr    uint16 = 0x50
-	program_start_addr uint16 = 0x200
+	font_start_addr     uint16 = 0x50
+	big_font_start_addr uint16 = 0xA0
+	program_start_addr  uint16 = 0x200
+
+	default_pitch byte = 64 // plays the XO-CHIP pattern at 4000 bits per second
 )
 
+// FrameRate is the rate, in Hz, at which the timers count down and the
+// display is presented. It is fixed by the hardware; the instruction rate is
+// not.
+const FrameRate = 60
+
 var (
 	DefaultLogger     = log.New(os.Stdout, "", 0)
-	DefaultClockSpeed = time.Duration(time.Second / 60) // 60 Hz
+	DefaultClockSpeed = time.Duration(time.Second / FrameRate) // 60 Hz
+	DefaultCPUHz      = 600                                    // 10 instructions per frame
 )
 
-type cpu struct {
-	memory    [4096]byte
+// Machine is a CHIP-8 interpreter: memory, registers, timers and stack, plus
+// the display and keyboard it drives and the Host it runs inside.
+type Machine struct {
+	mu sync.Mutex // guards everything below against concurrent callers
+
+	memory    []byte
 	registers [16]byte
 
-	I uint16 // Instruction Register
+	index uint16 // Index register, I
 
 	delay byte // delay timer register
 	sound byte //sound timer register
@@ -36,81 +50,325 @@ type cpu struct {
 	pc uint16 // Program counter
 	sp uint8  // stack pointer
 
-	d        *display
-	keyboard *keyboard
+	d        *Display
+	keyboard *Keyboard
 	logger   *log.Logger
-	clock    <-chan time.Time
+	tracer   *Tracer // nil unless tracing
+	clock    Clock
 	stop     chan struct{}
-	r        renderer
+	stopOnce sync.Once
+	paused   bool
+	host     Host
+	audio    Audio
+	quirks   Quirks
+	rng      *rand.Rand
+
+	hz     int // instructions per second
+	cycles int // instructions owed but not yet run, in 1/FrameRate units
+
+	vblankWait bool // a sprite was drawn and the rest of the frame is skipped
+	exited     bool // 00FD has run
+
+	rpl [16]byte // SUPER-CHIP RPL user flags, kept across resets
+
+	platform     Platform
+	audioPattern [16]byte // XO-CHIP audio pattern buffer
+	hasPattern   bool     // F002 has loaded audioPattern
+	pitch        byte     // XO-CHIP playback pitch
+
+	rom [sha1.Size]byte // SHA-1 of the loaded program, tying save states to it
+
+	history   *rewindBuffer // recent frames, when rewinding is enabled
+	rewinding bool
+
+	debug *Debugger
 }
 
-func NewCpu(k *keyboard, r renderer) *cpu {
+// NewMachine returns a Machine with the font loaded and the program counter at
+// the start of program memory. Anything not set by an Option gets a default: a
+// headless host, silent audio, a fresh keyboard, DefaultLogger and a
+// time-seeded RNG. Without WithClock, Run paces itself with a DefaultClockSpeed
+// real-time clock.
+func NewMachine(opts ...Option) *Machine {
 	d := NewDisplay()
-	c := &cpu{
-		pc:       program_start_addr, // First 512 bytes are "reserved" for the Chip-8 "interpreter"
-		d:        &d,
-		keyboard: k,
-		logger:   DefaultLogger,
-		clock:    time.Tick(DefaultClockSpeed),
-		stop:     make(chan struct{}),
-		r:        r,
+	c := &Machine{
+		pc:     program_start_addr, // First 512 bytes are "reserved" for the Chip-8 "interpreter"
+		d:      &d,
+		logger: DefaultLogger,
+		stop:   make(chan struct{}),
+		hz:     DefaultCPUHz,
+		pitch:  default_pitch,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.host == nil {
+		c.host = NewNullHost()
+	}
+	if c.keyboard == nil {
+		c.keyboard = NewKeyboard()
+	}
+	if c.audio == nil {
+		c.audio = NewNullAudio()
+	}
+	if c.rng == nil {
+		c.rng = rand.New(rand.NewSource(time.Now().UnixNano()))
 	}
+	c.d.clip = c.quirks.ClipSprites
+	c.memory = make([]byte, c.platform.memorySize())
+
 	c.loadFont()
 
 	return c
 }
 
-func (c *cpu) LoadBytes(program []byte) (int, error) {
+// NewCpu returns a Machine reading from k and running inside h.
+func NewCpu(k *Keyboard, h Host, opts ...Option) *Machine {
+	return NewMachine(append([]Option{WithKeyboard(k), WithHost(h)}, opts...)...)
+}
+
+func (c *Machine) LoadBytes(program []byte) (int, error) {
 	reader := bytes.NewReader(program)
 	return c.Load(reader)
 }
 
-func (c *cpu) Load(reader io.Reader) (int, error) {
-	return c.load(reader, program_start_addr)
+func (c *Machine) Load(reader io.Reader) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n, err := c.load(reader, program_start_addr)
+	c.rom = sha1.Sum(c.memory[program_start_addr : int(program_start_addr)+n])
+	c.clearHistory()
+	return n, err
 }
 
-func (c *cpu) Run() error {
+// Run ticks the machine once per clock tick until ctx is cancelled, Stop is
+// called, the host asks to quit, the program runs 00FD or an instruction
+// fails. Cancellation returns
+// ctx.Err(); the other ways of stopping cleanly return nil.
+func (c *Machine) Run(ctx context.Context) error {
+	clock := c.clock
+	if clock == nil {
+		clock = NewRealClock(DefaultClockSpeed)
+		defer clock.Stop()
+	}
+	ack, acks := clock.(tickAcknowledger)
+	if acks {
+		defer ack.runReturned()
+	}
+
 	for {
 		select {
+		case <-ctx.Done():
+			return ctx.Err()
 		case <-c.stop:
 			return nil
-		case <-c.clock:
+		case <-clock.C():
 			err := c.Tick()
+			if acks {
+				ack.tickDone()
+			}
 			if err != nil {
 				return err
 			}
+			if c.hasExited() {
+				return nil
+			}
 		}
 	}
 }
 
-func (c *cpu) Stop() {
-	close(c.stop)
+// RunFrames runs n frames back to back, without waiting on any clock. It
+// returns early if the machine is stopped or the program exits.
+func (c *Machine) RunFrames(n int) error {
+	for i := 0; i < n; i++ {
+		if c.stopped() || c.hasExited() {
+			return nil
+		}
+		if err := c.Tick(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (c *cpu) Tick() error {
-	if c.delay > 0 {
-		c.delay--
+// StepInstructions executes n instructions. Timers, display and input are
+// left alone, as they only advance with frames.
+func (c *Machine) StepInstructions(n int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i := 0; i < n; i++ {
+		if err := c.step(); err != nil {
+			return err
+		}
 	}
-	if c.sound > 0 {
-		c.sound--
+	return nil
+}
+
+// Stop makes Run return. A stopped machine stays stopped, even through a
+// reset; calling Stop more than once is harmless.
+func (c *Machine) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.stop)
+	})
+}
+
+func (c *Machine) stopped() bool {
+	select {
+	case <-c.stop:
+		return true
+	default:
+		return false
 	}
+}
 
-	ins := c.memory[c.pc : c.pc+2]
-	c.logger.Println(Instructions(ins).String())
-	c.pc += 2
-	op := ParseOpcode(ins)
+// hasExited reports whether the program has run 00FD since the last reset.
+func (c *Machine) hasExited() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.exited
+}
+
+// Tick polls the host for input, then runs one frame unless the machine is
+// paused or rewinding. A paused machine still polls and redraws, so the host
+// stays responsive; a rewinding one steps back a frame instead. Either way
+// the audio gets a frame of silence, so a tone fades out rather than cutting
+// off.
+func (c *Machine) Tick() error {
+	events, quit := c.host.Poll()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, ev := range events {
+		c.keyboard.Handle(ev)
+		if key, ok := c.keyboard.mapping.lookup(ev); ok {
+			c.tracer.key(key, ev.Down)
+		}
+	}
+	if quit {
+		c.Stop()
+	}
+
+	if c.rewinding {
+		c.stepBack()
+		c.present()
+		c.audio.Play(c.silence())
+		return nil
+	}
+	if c.paused {
+		c.present()
+		c.audio.Play(c.silence())
+		return nil
+	}
+	return c.frame()
+}
+
+// frame runs however many instructions are due at the configured CPU rate,
+// then the timer countdown, redraw and sound that happen at FrameRate
+// regardless of CPU speed.
+func (c *Machine) frame() error {
+	if c.history != nil {
+		c.history.push(c.snapshot())
+	}
+
+	c.cycles += c.hz
+	n := c.cycles / FrameRate
+	c.cycles %= FrameRate
+
+	c.vblankWait = false
+	for i := 0; i < n && !c.vblankWait && !c.exited; i++ {
+		if c.debug != nil && c.debug.before(false) {
+			break
+		}
+		if err := c.step(); err != nil {
+			return err
+		}
+		if c.debug != nil && c.debug.after() {
+			break
+		}
+	}
+
+	if c.delay > 0 || c.sound > 0 {
+		if c.delay > 0 {
+			c.delay--
+		}
+		if c.sound > 0 {
+			c.sound--
+		}
+		c.tracer.timer(c.delay, c.sound)
+	}
+
+	c.present()
+	c.audio.Play(c.tone())
+
+	return nil
+}
+
+func (c *Machine) tone() Tone {
+	t := Tone{On: c.sound > 0, Pitch: c.pitch}
+	if c.hasPattern {
+		pattern := c.audioPattern
+		t.Pattern = &pattern
+	}
+	return t
+}
+
+// silence is the current tone switched off, keeping its pitch and pattern
+// so the fade out carries on the same wave.
+func (c *Machine) silence() Tone {
+	t := c.tone()
+	t.On = false
+	return t
+}
+
+func (c *Machine) present() {
+	if c.d.isDirty {
+		c.drawScreen()
+		c.d.isDirty = false
+	}
+}
+
+// step fetches, decodes and executes a single instruction.
+func (c *Machine) step() error {
+	ins := Instructions{c.peek(c.pc), c.peek(c.pc + 1)}
+	op := c.decode(ins)
+	if op == LDIL {
+		ins = append(ins, c.peek(c.pc+2), c.peek(c.pc+3))
+	}
+	at := c.pc
+	c.tracer.instruction(c, at, ins)
+	c.pc += uint16(len(ins))
 
 	switch op {
 	case SYS:
 		// nothing, ignore
 	case CLS:
 		c.d.Clear()
+	case SCD:
+		c.d.ScrollDown(int(ReadNibble(ins)))
+	case SCR:
+		c.d.ScrollRight(4)
+	case SCL:
+		c.d.ScrollLeft(4)
+	case EXIT:
+		c.pc -= 2
+		c.exited = true
+	case SCU:
+		c.d.ScrollUp(int(ReadNibble(ins)))
+	case LOW:
+		c.d.SetHighRes(false)
+	case HIGH:
+		c.d.SetHighRes(true)
 	case RET:
 		val, err := c.pop()
 		if err != nil {
 			return err
 		}
 		c.pc = val
+		c.tracer.stack("ret", at, val, c.sp)
 	case JP:
 		addr := ReadUint12(ins)
 		c.pc = addr
@@ -121,31 +379,32 @@ func (c *cpu) Tick() error {
 			return err
 		}
 		c.pc = addr
+		c.tracer.stack("call", at, addr, c.sp)
 	case SE:
 		val := ReadUint8(ins)
 		register := ReadHighByteNibble(ins)
 		if c.registers[register] == val {
-			c.pc += 2
+			c.skip()
 		}
 	case SNE:
 		val := ReadUint8(ins)
 		register := ReadHighByteNibble(ins)
 		if c.registers[register] != val {
-			c.pc += 2
+			c.skip()
 		}
 	case SRE:
 		xregister := ReadHighByteNibble(ins)
 		yregister := ReadLowByteHighNibble(ins)
 
 		if c.registers[xregister] == c.registers[yregister] {
-			c.pc += 2
+			c.skip()
 		}
 	case SRNE:
 		xregister := ReadHighByteNibble(ins)
 		yregister := ReadLowByteHighNibble(ins)
 
 		if c.registers[xregister] != c.registers[yregister] {
-			c.pc += 2
+			c.skip()
 		}
 	case LD:
 		register := ReadHighByteNibble(ins)
@@ -158,7 +417,7 @@ func (c *cpu) Tick() error {
 		c.registers[xregister] = c.registers[yregister]
 	case LDI:
 		addr := ReadUint12(ins)
-		c.I = addr
+		c.index = addr
 	case LDVxDT:
 		register := ReadHighByteNibble(ins)
 		c.registers[register] = c.delay
@@ -171,19 +430,21 @@ func (c *cpu) Tick() error {
 	case LDB:
 		register := ReadHighByteNibble(ins)
 		value := int(c.registers[register])
-		c.memory[c.I] = byte(value / 100)
-		c.memory[c.I+1] = byte((value % 100) / 10)
-		c.memory[c.I+2] = byte(value % 10)
+		c.write(c.index, byte(value/100))
+		c.write(c.index+1, byte((value%100)/10))
+		c.write(c.index+2, byte(value%10))
 	case LDIVx:
 		register := ReadHighByteNibble(ins)
 		for idx := 0; idx <= int(register); idx++ {
-			c.memory[int(c.I)+idx] = c.registers[idx]
+			c.write(c.index+uint16(idx), c.registers[idx])
 		}
+		c.index += c.quirks.LoadStore.increment(register)
 	case LDVxI:
 		register := ReadHighByteNibble(ins)
 		for idx := 0; idx <= int(register); idx++ {
-			c.registers[idx] = c.memory[int(c.I)+idx]
+			c.registers[idx] = c.read(c.index + uint16(idx))
 		}
+		c.index += c.quirks.LoadStore.increment(register)
 	case ADD:
 		register := ReadHighByteNibble(ins)
 		val := ReadUint8(ins)
@@ -191,89 +452,131 @@ func (c *cpu) Tick() error {
 	case ADDVxVy:
 		registerx := ReadHighByteNibble(ins)
 		registery := ReadLowByteHighNibble(ins)
-		value := c.registers[registerx] + c.registers[registery]
+		value := int(c.registers[registerx]) + int(c.registers[registery])
 		overflow := 0
 		if value > 255 {
 			overflow = 1
 			value = value & 0xff
 		}
 
+		c.registers[registerx] = byte(value)
 		c.registers[0xf] = byte(overflow)
-		c.registers[registerx] = value
 	case ADDIVx:
 		register := ReadHighByteNibble(ins)
-		c.I += uint16(c.registers[register])
+		c.index += uint16(c.registers[register])
 	case OR:
 		registerx := ReadHighByteNibble(ins)
 		registery := ReadLowByteHighNibble(ins)
 		c.registers[registerx] |= c.registers[registery]
+		c.logicQuirk()
 	case AND:
 		registerx := ReadHighByteNibble(ins)
 		registery := ReadLowByteHighNibble(ins)
 		c.registers[registerx] &= c.registers[registery]
+		c.logicQuirk()
 	case XOR:
 		registerx := ReadHighByteNibble(ins)
 		registery := ReadLowByteHighNibble(ins)
 		c.registers[registerx] ^= c.registers[registery]
+		c.logicQuirk()
 	case SUB:
 		registerx := ReadHighByteNibble(ins)
 		registery := ReadLowByteHighNibble(ins)
 		vx := c.registers[registerx]
 		vy := c.registers[registery]
-		if vx > vy {
-			c.registers[0xf] = 1
-		}
 		c.registers[registerx] = byte(vx - vy)
+		c.registers[0xf] = flag(vx >= vy)
 	case SUBN:
 		registerx := ReadHighByteNibble(ins)
 		registery := ReadLowByteHighNibble(ins)
 		vx := c.registers[registerx]
 		vy := c.registers[registery]
-		if vy > vx {
-			c.registers[0xf] = 1
-		}
 		c.registers[registerx] = byte(vy - vx)
+		c.registers[0xf] = flag(vy >= vx)
 	case SHR:
 		register := ReadHighByteNibble(ins)
-		c.registers[0xf] = c.registers[register] & 0x1
-		c.registers[register] = c.registers[register] >> 1
+		value := c.shiftSource(ins)
+		c.registers[register] = value >> 1
+		c.registers[0xf] = value & 0x1
 	case SHL:
 		register := ReadHighByteNibble(ins)
-		if c.registers[register] >= 0x8 {
-			c.registers[0xf] = 1
-		} else {
-			c.registers[0xf] = 0
-		}
-
-		c.registers[register] = byte(c.registers[register] << 1)
+		value := c.shiftSource(ins)
+		c.registers[register] = value << 1
+		c.registers[0xf] = value >> 7
 	case JPV0:
 		addr := ReadUint12(ins)
-		c.pc = uint16(c.registers[0]) + addr
+		register := uint8(0)
+		if c.quirks.JumpUsesVx {
+			register = ReadHighByteNibble(ins)
+		}
+		c.pc = uint16(c.registers[register]) + addr
 	case RND:
 		register := ReadHighByteNibble(ins)
 		val := ReadUint8(ins)
-		random := uint8(rand.Intn(255))
+		random := uint8(c.rng.Intn(256))
 		c.registers[register] = random & val
 	case DRW:
 		x := int(ReadHighByteNibble(ins))
 		y := int(ReadLowByteHighNibble(ins))
-		sprite_size := ReadNibble(ins)
+		sprite_size := int(ReadNibble(ins))
+		wide := sprite_size == 0 && c.platform != PlatformCHIP8
+		if wide {
+			sprite_size = 32 // DXY0 draws a 16x16 SUPER-CHIP sprite
+		}
+		sprite_size *= c.d.PlaneCount() // XO-CHIP draws one sprite per selected plane, one after the other
 		sprite := make([]byte, sprite_size)
 
-		for idx := 0; idx < int(sprite_size); idx++ {
-			sprite[idx] = c.memory[int(c.I)+idx]
+		for idx := 0; idx < sprite_size; idx++ {
+			sprite[idx] = c.read(c.index + uint16(idx))
 		}
 
-		collision := c.d.DrawSprite(sprite, int(c.registers[x]), int(c.registers[y]))
-		if collision {
-			c.registers[0xf] = 1
+		vx, vy := c.registers[x], c.registers[y]
+		var collision bool
+		if wide {
+			collision = c.d.DrawSprite16(sprite, int(vx), int(vy))
+		} else {
+			collision = c.d.DrawSprite(sprite, int(vx), int(vy))
+		}
+		c.registers[0xf] = flag(collision)
+		c.tracer.draw(at, vx, vy, int(ReadNibble(ins)), c.index, collision)
+		if c.quirks.DisplayWait {
+			c.vblankWait = true
 		}
 	case LDF:
 		register := ReadHighByteNibble(ins)
-		c.I = uint16(font_start_addr + uint16(fontwidth)*uint16(c.registers[register]))
+		c.index = uint16(font_start_addr + uint16(fontwidth)*uint16(c.registers[register]))
+	case LDHF:
+		register := ReadHighByteNibble(ins)
+		c.index = uint16(big_font_start_addr + uint16(bigfontwidth)*uint16(c.registers[register]&0xf))
+	case LDRVx:
+		register := ReadHighByteNibble(ins)
+		copy(c.rpl[:register+1], c.registers[:register+1])
+	case LDVxR:
+		register := ReadHighByteNibble(ins)
+		copy(c.registers[:register+1], c.rpl[:register+1])
+	case LDIL:
+		c.index = uint16(ReadOperands(definitions[LDIL], ins)[0])
+	case LDIVxVy:
+		for idx, register := range registerRange(ins) {
+			c.write(c.index+uint16(idx), c.registers[register])
+		}
+	case LDVxVyI:
+		for idx, register := range registerRange(ins) {
+			c.registers[register] = c.read(c.index + uint16(idx))
+		}
+	case PLANE:
+		c.d.SelectPlanes(ReadHighByteNibble(ins))
+	case AUDIO:
+		for idx := range c.audioPattern {
+			c.audioPattern[idx] = c.read(c.index + uint16(idx))
+		}
+		c.hasPattern = true
+	case PITCH:
+		register := ReadHighByteNibble(ins)
+		c.pitch = c.registers[register]
 	case LDK:
 		register := ReadHighByteNibble(ins)
-		key, ok := c.keyboard.pop()
+		key, ok := c.keyboard.awaitKey()
 		if !ok {
 			c.pc -= 2
 		} else {
@@ -281,48 +584,46 @@ func (c *cpu) Tick() error {
 		}
 	case SKP:
 		register := ReadHighByteNibble(ins)
-		key, ok := c.keyboard.popIfIs(c.registers[register])
-		if ok && key == c.registers[register] {
-			c.pc += 2
+		if c.keyboard.IsPressed(c.registers[register]) {
+			c.skip()
 		}
 	case SKNP:
 		register := ReadHighByteNibble(ins)
-		key, ok := c.keyboard.popIfIs(c.registers[register])
-		if !ok || key != c.registers[register] {
-			c.pc += 2
+		if !c.keyboard.IsPressed(c.registers[register]) {
+			c.skip()
 		}
 	}
 
-	if c.d.isDirty {
-		c.drawScreen()
-		c.d.isDirty = false
-	}
+	return nil
+}
 
-	if c.sound > 0 {
-		c.buzz()
+// shiftSource returns the value 8XY6 and 8XYE shift: Vy on machines with the
+// ShiftUsesVy quirk, Vx otherwise.
+func (c *Machine) shiftSource(ins Instructions) byte {
+	if c.quirks.ShiftUsesVy {
+		return c.registers[ReadLowByteHighNibble(ins)]
 	}
-	event := sdl.PollEvent()
-	switch t := event.(type) {
-	case *sdl.QuitEvent:
-		println("Quit")
-		c.Stop()
-	case *sdl.KeyboardEvent:
-		keyCode := rune(t.Keysym.Sym)
-		c.keyboard.push([]byte{byte(keyCode)})
-		c.logger.Println(keyCode)
-	}
-	return nil
+	return c.registers[ReadHighByteNibble(ins)]
 }
 
-func (c *cpu) buzz() {
+func (c *Machine) logicQuirk() {
+	if c.quirks.LogicResetsVF {
+		c.registers[0xf] = 0
+	}
+}
 
+func flag(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
 }
 
-func (c *cpu) drawScreen() {
-	_ = c.r.Render(c.d)
+func (c *Machine) drawScreen() {
+	_ = c.host.Render(c.d)
 }
 
-func (c *cpu) loadFont() {
+func (c *Machine) loadFont() {
 	reader := bytes.NewReader(fontset)
 	n, err := c.load(reader, font_start_addr)
 	if err != nil {
@@ -331,13 +632,80 @@ func (c *cpu) loadFont() {
 	if n != len(fontset) {
 		panic("fontset loaded incorrectly")
 	}
+
+	reader = bytes.NewReader(bigfontset)
+	n, err = c.load(reader, big_font_start_addr)
+	if err != nil {
+		panic(err)
+	}
+	if n != len(bigfontset) {
+		panic("big fontset loaded incorrectly")
+	}
 }
 
-func (c *cpu) load(reader io.Reader, offset uint16) (int, error) {
+func (c *Machine) load(reader io.Reader, offset uint16) (int, error) {
 	return reader.Read(c.memory[offset:])
 }
 
-func (c *cpu) pop() (uint16, error) {
+// read and write are an instruction's data accesses to memory, wrapping
+// addresses beyond the end of it. The debugger sees them; it does not see
+// peek, which fetches instructions.
+func (c *Machine) read(addr uint16) byte {
+	value := c.peek(addr)
+	if c.debug != nil {
+		c.debug.record(AccessRead, addr, value)
+	}
+	return value
+}
+
+func (c *Machine) write(addr uint16, value byte) {
+	c.memory[int(addr)&(len(c.memory)-1)] = value
+	c.tracer.memory(uint16(int(addr)&(len(c.memory)-1)), value)
+	if c.debug != nil {
+		c.debug.record(AccessWrite, addr, value)
+	}
+}
+
+func (c *Machine) peek(addr uint16) byte {
+	return c.memory[int(addr)&(len(c.memory)-1)]
+}
+
+// decode is ParseOpcode for the machine's platform: the SUPER-CHIP and
+// XO-CHIP instructions are unknown on platforms without them, so F000 NNNN,
+// for one, is two bytes long off XO-CHIP.
+func (c *Machine) decode(ins Instructions) Opcode {
+	op := ParseOpcode(ins)
+	if !c.platform.has(op) {
+		return UNKNOWN
+	}
+	return op
+}
+
+// skip steps over the next instruction, which may be the four byte F000 NNNN.
+func (c *Machine) skip() {
+	if c.decode(Instructions{c.peek(c.pc), c.peek(c.pc + 1)}) == LDIL {
+		c.pc += 2
+	}
+	c.pc += 2
+}
+
+// registerRange lists the registers named by the XO-CHIP 5XYN range
+// instructions, from X to Y in whichever direction that runs.
+func registerRange(ins Instructions) []uint8 {
+	x, y := ReadHighByteNibble(ins), ReadLowByteHighNibble(ins)
+	registers := []uint8{x}
+	for x != y {
+		if x < y {
+			x++
+		} else {
+			x--
+		}
+		registers = append(registers, x)
+	}
+	return registers
+}
+
+func (c *Machine) pop() (uint16, error) {
 	if c.sp == 0 {
 		return 0, fmt.Errorf("cannot pop from stack empty")
 	}
@@ -346,7 +714,7 @@ func (c *cpu) pop() (uint16, error) {
 	return v, nil
 }
 
-func (c *cpu) push(value uint16) error {
+func (c *Machine) push(value uint16) error {
 	if int(c.sp) >= len(c.stack) {
 		return fmt.Errorf("stack overflow")
 	}
@@ -0,0 +1,466 @@
+package chip8
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"testing"
+)
+
+func newTestCpu(program []byte, opts ...Option) *Machine {
+	opts = append([]Option{WithLogger(log.New(ioutil.Discard, "", 0))}, opts...)
+	c := NewMachine(opts...)
+	if _, err := c.LoadBytes(program); err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func TestTick(t *testing.T) {
+	tests := []struct {
+		program           []byte
+		ticks             int
+		expectedRegisters map[int]byte
+		expectedPc        uint16
+	}{
+		{[]byte{0x61, 0x23}, 1, map[int]byte{1: 0x23}, 0x202},
+		{[]byte{0x61, 0x23, 0x71, 0x01}, 2, map[int]byte{1: 0x24}, 0x204},
+		{[]byte{0x61, 0x23, 0x82, 0x10}, 2, map[int]byte{1: 0x23, 2: 0x23}, 0x204},
+		{[]byte{0x61, 0x23, 0x31, 0x23}, 2, map[int]byte{1: 0x23}, 0x206},
+		{[]byte{0x61, 0x23, 0x41, 0x23}, 2, map[int]byte{1: 0x23}, 0x204},
+		{[]byte{0x12, 0x08}, 1, map[int]byte{}, 0x208},
+		// VF is cleared on a borrow, takes bit 7 on a left shift, and is
+		// cleared by a sprite drawn without colliding.
+		{[]byte{0x6f, 0x01, 0x61, 0x01, 0x62, 0x02, 0x81, 0x25}, 4, map[int]byte{1: 0xff, 0xf: 0}, 0x208},
+		{[]byte{0x6f, 0x01, 0x61, 0x40, 0x81, 0x1e}, 3, map[int]byte{1: 0x80, 0xf: 0}, 0x206},
+		{[]byte{0xa2, 0x0a, 0xd0, 0x01, 0xd0, 0x01, 0x61, 0x08, 0xd1, 0x01, 0xff}, 5, map[int]byte{0xf: 0}, 0x20a},
+	}
+
+	for _, tt := range tests {
+		c := newTestCpu(tt.program)
+		for i := 0; i < tt.ticks; i++ {
+			if err := c.step(); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		}
+
+		for register, expected := range tt.expectedRegisters {
+			if c.registers[register] != expected {
+				t.Errorf("wrong value in V%X, want=%d, got=%d", register, expected, c.registers[register])
+			}
+		}
+
+		if c.pc != tt.expectedPc {
+			t.Errorf("wrong pc, want=%#x, got=%#x", tt.expectedPc, c.pc)
+		}
+	}
+}
+
+func TestCallAndReturn(t *testing.T) {
+	c := newTestCpu([]byte{0x22, 0x04, 0x00, 0x00, 0x00, 0xee})
+
+	if err := c.step(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if c.pc != 0x204 || c.sp != 1 {
+		t.Errorf("wrong state after CALL, pc=%#x, sp=%d", c.pc, c.sp)
+	}
+
+	if err := c.step(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if c.pc != 0x202 || c.sp != 0 {
+		t.Errorf("wrong state after RET, pc=%#x, sp=%d", c.pc, c.sp)
+	}
+}
+
+func TestAccessors(t *testing.T) {
+	c := newTestCpu([]byte{0xa3, 0x00, 0x61, 0x05, 0xf1, 0x15, 0xf1, 0x18, 0x22, 0x0c})
+	for i := 0; i < 5; i++ {
+		if err := c.step(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	if c.I() != 0x300 {
+		t.Errorf("wrong I, want=%#x, got=%#x", 0x300, c.I())
+	}
+	if c.Registers()[1] != 5 {
+		t.Errorf("wrong V1, want=%d, got=%d", 5, c.Registers()[1])
+	}
+	if c.Delay() != 5 || c.Sound() != 5 {
+		t.Errorf("wrong timers, want=5,5, got=%d,%d", c.Delay(), c.Sound())
+	}
+	if c.PC() != 0x20c || c.SP() != 1 {
+		t.Errorf("wrong pc/sp, want=0x20c/1, got=%#x/%d", c.PC(), c.SP())
+	}
+	if stack := c.Stack(); len(stack) != 1 || stack[0] != 0x20a {
+		t.Errorf("wrong stack, got=%v", stack)
+	}
+	if mem := c.Memory(); mem[0x200] != 0xa3 || mem[font_start_addr] != fontset[0] {
+		t.Errorf("memory does not hold program and font")
+	}
+
+	s := c.State()
+	if s.Registers != c.Registers() || s.I != c.I() || s.PC != c.PC() || s.SP != c.SP() || s.Delay != c.Delay() || s.Sound != c.Sound() {
+		t.Errorf("State disagrees with the accessors, registers=%v, I=%#x, pc=%#x, sp=%d", s.Registers, s.I, s.PC, s.SP)
+	}
+	if len(s.Stack) != 1 || s.Stack[0] != 0x20a || s.Memory[0x200] != 0xa3 || s.Paused {
+		t.Errorf("wrong stack, memory or paused in State")
+	}
+}
+
+func TestRandIsInjectable(t *testing.T) {
+	program := []byte{0xc1, 0xff, 0xc2, 0xff, 0xc3, 0xff}
+	a := newTestCpu(program, WithRand(rand.New(rand.NewSource(1))))
+	b := newTestCpu(program, WithRand(rand.New(rand.NewSource(1))))
+
+	for i := 0; i < 3; i++ {
+		a.step()
+		b.step()
+	}
+
+	if a.Registers() != b.Registers() {
+		t.Errorf("same seed gave different registers, %v and %v", a.Registers(), b.Registers())
+	}
+}
+
+func TestTickRunsInstructionsAtCPURate(t *testing.T) {
+	tests := []struct {
+		opt           Option
+		frames        int
+		expectedV1    byte
+		expectedDelay byte
+	}{
+		{WithInstructionsPerFrame(3), 1, 2, 9},
+		{WithInstructionsPerFrame(4), 2, 4, 8},
+		{WithCPUHz(90), 1, 1, 9},
+		{WithCPUHz(90), 2, 2, 8},
+		{WithCPUHz(30), 1, 0, 9},
+	}
+
+	for _, tt := range tests {
+		c := newTestCpu([]byte{0x71, 0x01, 0x12, 0x00}, tt.opt)
+		c.delay = 10
+		for i := 0; i < tt.frames; i++ {
+			if err := c.Tick(); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		}
+
+		if c.registers[1] != tt.expectedV1 {
+			t.Errorf("wrong V1, want=%d, got=%d", tt.expectedV1, c.registers[1])
+		}
+		if c.delay != tt.expectedDelay {
+			t.Errorf("wrong delay timer, want=%d, got=%d", tt.expectedDelay, c.delay)
+		}
+	}
+}
+
+func TestRunWithManualClock(t *testing.T) {
+	clock := NewManualClock()
+	c := newTestCpu([]byte{0x71, 0x01, 0x12, 0x00}, WithClock(clock), WithInstructionsPerFrame(2))
+
+	done := make(chan error)
+	go func() {
+		done <- c.Run(context.Background())
+	}()
+
+	clock.Advance(3)
+	c.Stop()
+
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if c.registers[1] != 3 {
+		t.Errorf("wrong V1 after 3 frames, want=%d, got=%d", 3, c.registers[1])
+	}
+}
+
+func TestAdvanceAfterRunReturns(t *testing.T) {
+	clock := NewManualClock()
+	// V0 := 1; EXIT
+	c := newTestCpu([]byte{0x60, 0x01, 0x00, 0xfd}, WithClock(clock), WithPlatform(PlatformSCHIP))
+
+	done := make(chan error)
+	go func() {
+		done <- c.Run(context.Background())
+	}()
+
+	if !clock.Advance(1) {
+		t.Fatalf("first tick was not delivered")
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if clock.Advance(1) {
+		t.Errorf("Advance delivered a tick after Run returned")
+	}
+}
+
+func TestRunFramesAndStepInstructions(t *testing.T) {
+	c := newTestCpu([]byte{0x71, 0x01, 0x12, 0x00}, WithInstructionsPerFrame(4))
+	c.delay = 10
+
+	if err := c.RunFrames(2); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if c.registers[1] != 4 || c.delay != 8 {
+		t.Errorf("wrong state after RunFrames, V1=%d, delay=%d", c.registers[1], c.delay)
+	}
+
+	if err := c.StepInstructions(3); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if c.registers[1] != 6 || c.delay != 8 {
+		t.Errorf("wrong state after StepInstructions, V1=%d, delay=%d", c.registers[1], c.delay)
+	}
+}
+
+func TestSuperChip(t *testing.T) {
+	// HIGH; V0 := 5; FX30; RPL save V0-V1; V0 := 0; RPL load V0-V0; EXIT
+	program := []byte{0x00, 0xff, 0x60, 0x05, 0xf0, 0x30, 0xf1, 0x75, 0x60, 0x00, 0xf0, 0x85, 0x00, 0xfd, 0x12, 0x00}
+	c := newTestCpu(program, WithPlatform(PlatformSCHIP), WithInstructionsPerFrame(20))
+
+	if err := c.RunFrames(2); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if !c.d.HighRes() {
+		t.Errorf("expected hires display")
+	}
+	if c.index != big_font_start_addr+5*uint16(bigfontwidth) {
+		t.Errorf("wrong I for big 5, want=%#x, got=%#x", big_font_start_addr+5*uint16(bigfontwidth), c.index)
+	}
+	if c.registers[0] != 5 || c.rpl[0] != 5 {
+		t.Errorf("RPL flags not round tripped, V0=%d, flag0=%d", c.registers[0], c.rpl[0])
+	}
+	if !c.exited || c.pc != 0x20c {
+		t.Errorf("expected EXIT to stop the machine at 0x20c, pc=%#x", c.pc)
+	}
+}
+
+func TestExitThenReset(t *testing.T) {
+	c := newTestCpu([]byte{0x60, 0x01, 0x00, 0xfd}, WithPlatform(PlatformSCHIP))
+	if err := c.RunFrames(5); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !c.exited || c.stopped() {
+		t.Errorf("EXIT should end the program without stopping the machine, exited=%t, stopped=%t", c.exited, c.stopped())
+	}
+
+	c.Reset()
+	if c.registers[0] != 0 {
+		t.Fatalf("reset did not clear V0")
+	}
+	if err := c.RunFrames(5); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if c.registers[0] != 1 {
+		t.Errorf("machine did not run after reset, want V0=%d, got=%d", 1, c.registers[0])
+	}
+}
+
+func TestWideSprite(t *testing.T) {
+	// I := 0x206; sprite V0 V0 0; loop
+	program := []byte{0xa2, 0x06, 0xd0, 0x00, 0x12, 0x04}
+	for i := 0; i < 32; i++ {
+		program = append(program, 0xff)
+	}
+	tests := []struct {
+		platform Platform
+		lit      bool
+	}{
+		{PlatformCHIP8, false},
+		{PlatformSCHIP, true},
+		{PlatformXOCHIP, true},
+	}
+
+	for _, tt := range tests {
+		c := newTestCpu(program, WithPlatform(tt.platform))
+		if err := c.StepInstructions(2); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		for _, p := range [][2]int{{0, 0}, {15, 15}} {
+			if lit, _ := c.d.GetPixel(p[0], p[1]); lit != tt.lit {
+				t.Errorf("%s: wrong pixel at %d,%d after DXY0, want=%t, got=%t", tt.platform, p[0], p[1], tt.lit, lit)
+			}
+		}
+		if lit, _ := c.d.GetPixel(16, 0); lit {
+			t.Errorf("%s: DXY0 drew past 16 pixels wide", tt.platform)
+		}
+	}
+}
+
+func TestPlatformOpcodes(t *testing.T) {
+	tests := []struct {
+		platform Platform
+		schip    bool
+		xochip   bool
+	}{
+		{PlatformCHIP8, false, false},
+		{PlatformSCHIP, true, false},
+		{PlatformXOCHIP, true, true},
+	}
+
+	for _, tt := range tests {
+		c := NewMachine(WithPlatform(tt.platform))
+		for _, ext := range []struct {
+			ops  []Opcode
+			want bool
+		}{{schip_opcodes, tt.schip}, {xochip_opcodes, tt.xochip}} {
+			for _, op := range ext.ops {
+				def, _ := Lookup(byte(op))
+				ins, err := Encode(op, make([]int, len(def.OperandWidths))...)
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				want := UNKNOWN
+				if ext.want {
+					want = op
+				}
+				if got := c.decode(ins); got != want {
+					t.Errorf("%s: wrong opcode for %X, want=%d, got=%d", tt.platform, []byte(ins), want, got)
+				}
+			}
+		}
+	}
+
+	// HIGH does nothing on CHIP-8.
+	c := newTestCpu([]byte{0x00, 0xff})
+	if err := c.Step(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if c.d.HighRes() || c.pc != 0x202 {
+		t.Errorf("00FF ran on CHIP-8, hires=%t, pc=%#x", c.d.HighRes(), c.pc)
+	}
+}
+
+func TestLongLoadIsXOChipOnly(t *testing.T) {
+	// skip next if V0 == 0; I := long 0x6105, or V1 := 5 off XO-CHIP
+	program := []byte{0x30, 0x00, 0xf0, 0x00, 0x61, 0x05}
+	tests := []struct {
+		platform Platform
+		steps    int
+		v1       byte
+		index    uint16
+		pc       uint16
+	}{
+		{PlatformCHIP8, 1, 0, 0, 0x204},
+		{PlatformCHIP8, 2, 5, 0, 0x206},
+		{PlatformSCHIP, 2, 5, 0, 0x206},
+		{PlatformXOCHIP, 1, 0, 0, 0x206},
+	}
+
+	for _, tt := range tests {
+		c := newTestCpu(program, WithPlatform(tt.platform))
+		if err := c.StepInstructions(tt.steps); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if c.registers[1] != tt.v1 || c.index != tt.index || c.pc != tt.pc {
+			t.Errorf("%s after %d steps: want V1=%d, I=%#x, pc=%#x, got V1=%d, I=%#x, pc=%#x",
+				tt.platform, tt.steps, tt.v1, tt.index, tt.pc, c.registers[1], c.index, c.pc)
+		}
+	}
+}
+
+func TestXOChip(t *testing.T) {
+	program := []byte{
+		0x61, 0x0a, // V1 := 10
+		0x62, 0x0b, // V2 := 11
+		0xf0, 0x00, 0x80, 0x00, // I := long 0x8000
+		0x51, 0x22, // save V1 - V2
+		0x53, 0x43, // load V3 - V4
+		0x31, 0x0a, // skip next if V1 == 10
+		0xf0, 0x00, 0x12, 0x34, // I := long 0x1234, skipped
+		0xf3, 0x01, // plane 3
+		0xf0, 0x02, // audio
+		0xf1, 0x3a, // pitch := V1
+	}
+	c := newTestCpu(program, WithPlatform(PlatformXOCHIP))
+	if err := c.StepInstructions(9); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(c.memory) != 0x10000 {
+		t.Errorf("wrong memory size, want=%#x, got=%#x", 0x10000, len(c.memory))
+	}
+	if c.index != 0x8000 {
+		t.Errorf("wrong I, want=%#x, got=%#x", 0x8000, c.index)
+	}
+	if c.memory[0x8000] != 10 || c.memory[0x8001] != 11 {
+		t.Errorf("registers not saved, got %v", c.memory[0x8000:0x8002])
+	}
+	if c.registers[3] != 10 || c.registers[4] != 11 {
+		t.Errorf("registers not loaded, V3=%d, V4=%d", c.registers[3], c.registers[4])
+	}
+	if c.d.Planes() != 3 {
+		t.Errorf("wrong planes, want=%d, got=%d", 3, c.d.Planes())
+	}
+	if _, pitch, ok := c.AudioPattern(); !ok || pitch != 10 {
+		t.Errorf("wrong audio state, pitch=%d, loaded=%t", pitch, ok)
+	}
+	if c.pc != 0x200+uint16(len(program)) {
+		t.Errorf("wrong pc, want=%#x, got=%#x", 0x200+len(program), c.pc)
+	}
+}
+
+type scriptedHost struct {
+	nullRenderer
+	events [][]KeyEvent
+}
+
+func (s *scriptedHost) Poll() ([]KeyEvent, bool) {
+	if len(s.events) == 0 {
+		return nil, false
+	}
+	events := s.events[0]
+	s.events = s.events[1:]
+	return events, false
+}
+
+func TestKeysHeldAcrossFrames(t *testing.T) {
+	// V0 := 5; loop: if key V0 is held, V1 += 1
+	program := []byte{0x60, 0x05, 0xe0, 0xa1, 0x71, 0x01, 0x12, 0x02}
+	host := &scriptedHost{events: [][]KeyEvent{
+		{{Code: 'w', Down: true}},
+		nil,
+		nil,
+		{{Code: 'w', Down: false}},
+	}}
+	c := newTestCpu(program, WithHost(host), WithInstructionsPerFrame(3))
+
+	if err := c.RunFrames(6); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if c.registers[1] != 3 {
+		t.Errorf("expected the held key to be seen on 3 frames, got %d", c.registers[1])
+	}
+}
+
+func TestWaitForKey(t *testing.T) {
+	program := []byte{0xf3, 0x0a, 0x12, 0x02}
+	host := &scriptedHost{events: [][]KeyEvent{
+		nil,
+		{{Code: 'e', Down: true}},
+		nil,
+		{{Code: 'e', Down: false}},
+	}}
+	c := newTestCpu(program, WithHost(host))
+
+	if err := c.RunFrames(3); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if c.pc != 0x200 {
+		t.Errorf("expected FX0A to wait until the key is released, pc=%#x", c.pc)
+	}
+
+	if err := c.RunFrames(1); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if c.registers[3] != 6 || c.pc == 0x200 {
+		t.Errorf("expected key 6 in V3 once released, V3=%d, pc=%#x", c.registers[3], c.pc)
+	}
+}
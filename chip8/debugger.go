@@ -0,0 +1,653 @@
+package chip8
+
+import (
+	"fmt"
+	"log"
+	"sort"
+)
+
+// StopReason says why the debugger stopped the machine.
+type StopReason int
+
+const (
+	StopPause StopReason = iota
+	StopBreakpoint
+	StopWatchpoint
+	StopOpcode
+	StopStep
+)
+
+func (r StopReason) String() string {
+	switch r {
+	case StopPause:
+		return "pause"
+	case StopBreakpoint:
+		return "breakpoint"
+	case StopWatchpoint:
+		return "watchpoint"
+	case StopOpcode:
+		return "opcode"
+	case StopStep:
+		return "step"
+	}
+	return fmt.Sprintf("StopReason(%d)", int(r))
+}
+
+// Stop describes the machine coming to a halt under the debugger. PC is where
+// it will carry on from. For a watchpoint Access is what set it off, made by
+// the instruction at Access.PC.
+type Stop struct {
+	Reason StopReason
+	PC     uint16
+	ID     int // the breakpoint or watchpoint, if one was hit
+	Opcode Opcode
+	Access Access
+}
+
+// AccessKind is a watchpoint's trigger: reads, writes or both.
+type AccessKind int
+
+const (
+	AccessRead AccessKind = 1 << iota
+	AccessWrite
+)
+
+func (k AccessKind) String() string {
+	switch k {
+	case AccessRead:
+		return "read"
+	case AccessWrite:
+		return "write"
+	case AccessRead | AccessWrite:
+		return "access"
+	}
+	return fmt.Sprintf("AccessKind(%d)", int(k))
+}
+
+// Access is an instruction reading or writing a memory address or, when
+// Memory is false, a register.
+type Access struct {
+	Kind     AccessKind
+	Memory   bool
+	Addr     uint16
+	Register Register
+	Value    uint16 // the value read, or written
+	PC       uint16 // the instruction making the access
+}
+
+// Breakpoint stops the machine before it executes the instruction at Addr.
+// Hits counts the times it has been reached. With a Condition it only stops
+// when that is true; with a Log message it is a log point, printing the
+// message rather than stopping.
+type Breakpoint struct {
+	ID        int
+	Addr      uint16
+	Hits      int
+	Condition string
+	Log       string
+
+	cond *Expr
+	log  *LogMessage
+}
+
+// Watchpoint stops the machine after an instruction reads or writes the
+// memory from Start to End inclusive or, if Memory is false, Register. Hits,
+// Condition and Log work as they do for a Breakpoint.
+type Watchpoint struct {
+	ID         int
+	Kind       AccessKind
+	Memory     bool
+	Start, End uint16
+	Register   Register
+	Hits       int
+	Condition  string
+	Log        string
+
+	cond *Expr
+	log  *LogMessage
+}
+
+func (w *Watchpoint) matches(a Access) bool {
+	if w.Kind&a.Kind == 0 || w.Memory != a.Memory {
+		return false
+	}
+	if w.Memory {
+		return a.Addr >= w.Start && a.Addr <= w.End
+	}
+	return a.Register == w.Register
+}
+
+// Frame is one level of the call stack: the subroutine at Function, currently
+// at PC. Return is where its RET will go, or 0 in the outermost frame.
+type Frame struct {
+	Function uint16
+	PC       uint16
+	Return   uint16
+}
+
+// Debugger stops a Machine at breakpoints, watchpoints and chosen opcodes and
+// steps it through a program. Its methods are safe to call while the machine
+// runs on another goroutine; stops are announced on the Stops channel.
+//
+// Breakpoints and opcodes are checked only while the machine runs frames, so
+// under Run, RunFrames or StepFrame, and not by Machine.Step.
+type Debugger struct {
+	// Logger prints log point messages and errors evaluating conditions. It
+	// starts as the machine's logger.
+	Logger *log.Logger
+
+	m *Machine
+
+	nextID      int
+	breakpoints map[int]*Breakpoint
+	watchpoints map[int]*Watchpoint
+	opcodes     map[Opcode]bool
+
+	stops chan Stop
+
+	resuming bool // skip breakpoints on the first instruction after a resume
+	until    func() bool
+
+	// Set by before for after.
+	pc        uint16
+	registers [16]byte
+	index     uint16
+	delay     byte
+	sound     byte
+	reads     []Register
+	accesses  []Access
+}
+
+// NewDebugger attaches a debugger to m, replacing any already attached.
+func NewDebugger(m *Machine) *Debugger {
+	d := &Debugger{
+		Logger:      m.logger,
+		m:           m,
+		breakpoints: make(map[int]*Breakpoint),
+		watchpoints: make(map[int]*Watchpoint),
+		opcodes:     make(map[Opcode]bool),
+		stops:       make(chan Stop, 1),
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.debug = d
+	return d
+}
+
+// Detach removes the debugger from its machine, which runs on unhindered.
+func (d *Debugger) Detach() {
+	d.m.mu.Lock()
+	defer d.m.mu.Unlock()
+	if d.m.debug == d {
+		d.m.debug = nil
+	}
+}
+
+// Machine is the machine being debugged.
+func (d *Debugger) Machine() *Machine {
+	return d.m
+}
+
+// Stops delivers the machine's stops. Only the latest is kept, so a slow
+// reader sees where the machine is now rather than a backlog.
+func (d *Debugger) Stops() <-chan Stop {
+	return d.stops
+}
+
+// SetBreakpoint stops the machine before it executes addr, returning the
+// breakpoint's id.
+func (d *Debugger) SetBreakpoint(addr uint16) int {
+	d.m.mu.Lock()
+	defer d.m.mu.Unlock()
+	d.nextID++
+	d.breakpoints[d.nextID] = &Breakpoint{ID: d.nextID, Addr: addr}
+	return d.nextID
+}
+
+// Watch sets a watchpoint on the memory from start to end inclusive,
+// returning its id.
+func (d *Debugger) Watch(kind AccessKind, start, end uint16) int {
+	return d.addWatchpoint(&Watchpoint{Kind: kind, Memory: true, Start: start, End: end})
+}
+
+// WatchRegister sets a watchpoint on a register, returning its id. PC and SP
+// change with every jump and call, so watching them is not supported.
+func (d *Debugger) WatchRegister(kind AccessKind, r Register) (int, error) {
+	if r < V0 || r > RegST {
+		return 0, fmt.Errorf("cannot watch %s", r)
+	}
+	return d.addWatchpoint(&Watchpoint{Kind: kind, Register: r}), nil
+}
+
+func (d *Debugger) addWatchpoint(w *Watchpoint) int {
+	d.m.mu.Lock()
+	defer d.m.mu.Unlock()
+	d.nextID++
+	w.ID = d.nextID
+	d.watchpoints[w.ID] = w
+	return w.ID
+}
+
+// Clear removes the breakpoint or watchpoint with the given id.
+func (d *Debugger) Clear(id int) {
+	d.m.mu.Lock()
+	defer d.m.mu.Unlock()
+	delete(d.breakpoints, id)
+	delete(d.watchpoints, id)
+}
+
+// SetCondition makes the breakpoint or watchpoint with the given id stop only
+// when the expression cond is true. An empty cond removes the condition.
+func (d *Debugger) SetCondition(id int, cond string) error {
+	var expr *Expr
+	if cond != "" {
+		var err error
+		if expr, err = ParseExpr(cond); err != nil {
+			return err
+		}
+	}
+
+	d.m.mu.Lock()
+	defer d.m.mu.Unlock()
+	if b, ok := d.breakpoints[id]; ok {
+		b.Condition, b.cond = cond, expr
+		return nil
+	}
+	if w, ok := d.watchpoints[id]; ok {
+		w.Condition, w.cond = cond, expr
+		return nil
+	}
+	return fmt.Errorf("no breakpoint or watchpoint %d", id)
+}
+
+// SetLog turns the breakpoint or watchpoint with the given id into a log
+// point, printing msg, a LogMessage, each time it would have stopped. An empty
+// msg makes it stop again.
+func (d *Debugger) SetLog(id int, msg string) error {
+	var message *LogMessage
+	if msg != "" {
+		var err error
+		if message, err = ParseLogMessage(msg); err != nil {
+			return err
+		}
+	}
+
+	d.m.mu.Lock()
+	defer d.m.mu.Unlock()
+	if b, ok := d.breakpoints[id]; ok {
+		b.Log, b.log = msg, message
+		return nil
+	}
+	if w, ok := d.watchpoints[id]; ok {
+		w.Log, w.log = msg, message
+		return nil
+	}
+	return fmt.Errorf("no breakpoint or watchpoint %d", id)
+}
+
+// Evaluate works out an expression against the machine as it is now, with
+// hits as zero.
+func (d *Debugger) Evaluate(src string) (int64, error) {
+	expr, err := ParseExpr(src)
+	if err != nil {
+		return 0, err
+	}
+	return expr.Eval(d.m, 0)
+}
+
+// ClearAll removes every breakpoint, watchpoint and opcode break.
+func (d *Debugger) ClearAll() {
+	d.m.mu.Lock()
+	defer d.m.mu.Unlock()
+	d.breakpoints = make(map[int]*Breakpoint)
+	d.watchpoints = make(map[int]*Watchpoint)
+	d.opcodes = make(map[Opcode]bool)
+}
+
+// Breakpoints lists the breakpoints by id.
+func (d *Debugger) Breakpoints() []Breakpoint {
+	d.m.mu.Lock()
+	defer d.m.mu.Unlock()
+	var list []Breakpoint
+	for _, b := range d.breakpoints {
+		list = append(list, *b)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Watchpoints lists the watchpoints by id.
+func (d *Debugger) Watchpoints() []Watchpoint {
+	d.m.mu.Lock()
+	defer d.m.mu.Unlock()
+	var list []Watchpoint
+	for _, w := range d.watchpoints {
+		list = append(list, *w)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// BreakOnOpcode stops the machine before every instruction that decodes to op,
+// or stops doing so when on is false.
+func (d *Debugger) BreakOnOpcode(op Opcode, on bool) {
+	d.m.mu.Lock()
+	defer d.m.mu.Unlock()
+	if on {
+		d.opcodes[op] = true
+	} else {
+		delete(d.opcodes, op)
+	}
+}
+
+// Pause stops the machine where it is.
+func (d *Debugger) Pause() {
+	d.m.mu.Lock()
+	defer d.m.mu.Unlock()
+	d.stop(Stop{Reason: StopPause, PC: d.m.pc})
+}
+
+// Continue resumes a stopped machine.
+func (d *Debugger) Continue() {
+	d.m.mu.Lock()
+	defer d.m.mu.Unlock()
+	d.resume(nil)
+}
+
+// StepIn executes the next instruction, following calls, and stops again.
+// Watchpoints it sets off are reported rather than the step.
+func (d *Debugger) StepIn() error {
+	d.m.mu.Lock()
+	defer d.m.mu.Unlock()
+
+	d.m.paused = true
+	d.until = nil
+	d.before(true)
+	if err := d.m.step(); err != nil {
+		return err
+	}
+	if !d.after() {
+		d.stop(Stop{Reason: StopStep, PC: d.m.pc})
+	}
+	return nil
+}
+
+// StepOver is StepIn, except that a CALL runs the whole subroutine, stopping
+// once it returns. The machine runs to get there, so breakpoints inside the
+// subroutine still stop it.
+func (d *Debugger) StepOver() error {
+	d.m.mu.Lock()
+	op := ParseOpcode(Instructions{d.m.peek(d.m.pc), d.m.peek(d.m.pc + 1)})
+	if op != CALL {
+		d.m.mu.Unlock()
+		return d.StepIn()
+	}
+	defer d.m.mu.Unlock()
+
+	m := d.m
+	ret, sp := m.pc+2, m.sp
+	d.resume(func() bool {
+		return m.pc == ret && m.sp == sp
+	})
+	return nil
+}
+
+// StepOut runs until the current subroutine returns, stopping at the
+// instruction after its CALL.
+func (d *Debugger) StepOut() error {
+	d.m.mu.Lock()
+	defer d.m.mu.Unlock()
+
+	m := d.m
+	sp := m.sp
+	if sp == 0 {
+		return fmt.Errorf("not in a subroutine")
+	}
+	d.resume(func() bool {
+		return m.sp < sp
+	})
+	return nil
+}
+
+// CallStack returns the stack frames, innermost first. Each subroutine's
+// address is read from the CALL before its return address; a frame whose
+// caller cannot be decoded reports Function 0.
+func (d *Debugger) CallStack() []Frame {
+	d.m.mu.Lock()
+	defer d.m.mu.Unlock()
+	return d.m.callStack()
+}
+
+func (c *Machine) callStack() []Frame {
+	frames := make([]Frame, 0, c.sp+1)
+	pc := c.pc
+	for i := int(c.sp) - 1; i >= 0; i-- {
+		ret := c.stack[i]
+		frames = append(frames, Frame{Function: c.callTarget(ret - 2), PC: pc, Return: ret})
+		pc = ret - 2
+	}
+	return append(frames, Frame{Function: program_start_addr, PC: pc})
+}
+
+// callTarget is the address called by the instruction at addr, or 0 if it is
+// not a CALL.
+func (c *Machine) callTarget(addr uint16) uint16 {
+	ins := Instructions{c.peek(addr), c.peek(addr + 1)}
+	if ParseOpcode(ins) != CALL {
+		return 0
+	}
+	return ReadUint12(ins)
+}
+
+// stop pauses the machine and announces it. The machine's lock is held.
+func (d *Debugger) stop(s Stop) {
+	d.m.paused = true
+	d.until = nil
+	select {
+	case <-d.stops:
+	default:
+	}
+	d.stops <- s
+}
+
+func (d *Debugger) resume(until func() bool) {
+	d.until = until
+	d.resuming = true
+	d.m.paused = false
+}
+
+// before runs ahead of each instruction in a frame, reporting whether the
+// machine should stop instead. When stepping, breakpoints are ignored and the
+// instruction always runs. The machine's lock is held.
+func (d *Debugger) before(stepping bool) bool {
+	m := d.m
+	resuming := d.resuming
+	d.resuming = false
+
+	ins := Instructions{m.peek(m.pc), m.peek(m.pc + 1)}
+	op := ParseOpcode(ins)
+
+	if !stepping {
+		if d.until != nil && d.until() {
+			d.stop(Stop{Reason: StopStep, PC: m.pc})
+			return true
+		}
+		if !resuming {
+			for _, id := range d.sortedBreakpoints() {
+				b := d.breakpoints[id]
+				if b.Addr != m.pc {
+					continue
+				}
+				b.Hits++
+				if d.trigger(b.cond, b.log, b.Hits) {
+					d.stop(Stop{Reason: StopBreakpoint, PC: m.pc, ID: b.ID})
+					return true
+				}
+			}
+			if d.opcodes[op] {
+				d.stop(Stop{Reason: StopOpcode, PC: m.pc, Opcode: op})
+				return true
+			}
+		}
+	}
+
+	d.pc = m.pc
+	d.registers = m.registers
+	d.index = m.index
+	d.delay = m.delay
+	d.sound = m.sound
+	d.reads = m.registersRead(op, ins)
+	d.accesses = d.accesses[:0]
+	return false
+}
+
+// after runs once an instruction has executed, stopping the machine if it
+// set off a watchpoint. The machine's lock is held.
+func (d *Debugger) after() bool {
+	if len(d.watchpoints) == 0 {
+		return false
+	}
+	m := d.m
+
+	for _, r := range d.reads {
+		d.accesses = append(d.accesses, Access{Kind: AccessRead, Register: r, Value: d.registerBefore(r)})
+	}
+	for r := V0; r <= RegST; r++ {
+		if value := m.register(r); value != d.registerBefore(r) {
+			d.accesses = append(d.accesses, Access{Kind: AccessWrite, Register: r, Value: value})
+		}
+	}
+
+	for _, a := range d.accesses {
+		for _, id := range d.sortedWatchpoints() {
+			w := d.watchpoints[id]
+			if !w.matches(a) {
+				continue
+			}
+			w.Hits++
+			if d.trigger(w.cond, w.log, w.Hits) {
+				a.PC = d.pc
+				d.stop(Stop{Reason: StopWatchpoint, PC: m.pc, ID: w.ID, Access: a})
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// trigger decides whether a breakpoint or watchpoint that has been reached
+// stops the machine, printing its message instead if it is a log point. A
+// condition that cannot be evaluated stops the machine, so the problem gets
+// noticed. The machine's lock is held.
+func (d *Debugger) trigger(cond *Expr, msg *LogMessage, hits int) bool {
+	if cond != nil {
+		v, err := cond.eval(d.m, hits)
+		if err != nil {
+			d.Logger.Printf("condition %s: %s", cond, err)
+			return true
+		}
+		if v == 0 {
+			return false
+		}
+	}
+	if msg != nil {
+		text, err := msg.format(d.m, hits)
+		if err != nil {
+			d.Logger.Printf("log point %s: %s", msg, err)
+			return true
+		}
+		d.Logger.Print(text)
+		return false
+	}
+	return true
+}
+
+func (d *Debugger) registerBefore(r Register) uint16 {
+	switch {
+	case r <= VF:
+		return uint16(d.registers[r])
+	case r == RegI:
+		return d.index
+	case r == RegDT:
+		return uint16(d.delay)
+	case r == RegST:
+		return uint16(d.sound)
+	}
+	return 0
+}
+
+// record notes a memory access by the current instruction.
+func (d *Debugger) record(kind AccessKind, addr uint16, value byte) {
+	d.accesses = append(d.accesses, Access{Kind: kind, Memory: true, Addr: addr, Value: uint16(value)})
+}
+
+func (d *Debugger) sortedBreakpoints() []int {
+	ids := make([]int, 0, len(d.breakpoints))
+	for id := range d.breakpoints {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+func (d *Debugger) sortedWatchpoints() []int {
+	ids := make([]int, 0, len(d.watchpoints))
+	for id := range d.watchpoints {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// registersRead lists the registers an instruction reads, besides the
+// program counter and stack pointer.
+func (c *Machine) registersRead(op Opcode, ins Instructions) []Register {
+	x := Register(ReadHighByteNibble(ins))
+	y := Register(ReadLowByteHighNibble(ins))
+	upTo := func(last Register, extra ...Register) []Register {
+		var regs []Register
+		for r := V0; r <= last; r++ {
+			regs = append(regs, r)
+		}
+		return append(regs, extra...)
+	}
+
+	switch op {
+	case SE, SNE, SKP, SKNP, LDDTVx, LDSTVx, LDF, LDHF, ADD, PITCH:
+		return []Register{x}
+	case LDB, ADDIVx:
+		return []Register{x, RegI}
+	case SRE, SRNE, ADDVxVy, OR, AND, XOR, SUB, SUBN:
+		return []Register{x, y}
+	case LDVxVy:
+		return []Register{y}
+	case SHR, SHL:
+		if c.quirks.ShiftUsesVy {
+			return []Register{y}
+		}
+		return []Register{x}
+	case JPV0:
+		if c.quirks.JumpUsesVx {
+			return []Register{x}
+		}
+		return []Register{V0}
+	case DRW:
+		return []Register{x, y, RegI}
+	case LDIVx:
+		return upTo(x, RegI)
+	case LDRVx:
+		return upTo(x)
+	case LDIVxVy:
+		regs := []Register{RegI}
+		for _, r := range registerRange(ins) {
+			regs = append(regs, Register(r))
+		}
+		return regs
+	case LDVxI, LDVxVyI, AUDIO:
+		return []Register{RegI}
+	case LDVxDT:
+		return []Register{RegDT}
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package chip8
+
+import "testing"
+
+var subroutine = []byte{
+	0x60, 0x05, // 200: LD V0, 5
+	0xA3, 0x00, // 202: LD I, 300
+	0x22, 0x0C, // 204: CALL 20C
+	0x81, 0x00, // 206: LD V1, V0
+	0xD0, 0x01, // 208: DRW V0, V0, 1
+	0x12, 0x0A, // 20A: JP 20A
+	0xF0, 0x55, // 20C: LD [I], V0
+	0x70, 0x01, // 20E: ADD V0, 1
+	0x00, 0xEE, // 210: RET
+}
+
+func newTestDebugger() (*Machine, *Debugger) {
+	c := newTestCpu(subroutine)
+	return c, NewDebugger(c)
+}
+
+// nextStop runs frames until the debugger stops the machine.
+func nextStop(t *testing.T, c *Machine, d *Debugger) Stop {
+	t.Helper()
+	for i := 0; i < 10; i++ {
+		select {
+		case s := <-d.Stops():
+			if !c.Paused() {
+				t.Errorf("machine still running after %s stop", s.Reason)
+			}
+			return s
+		default:
+		}
+		if err := c.RunFrames(1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Fatal("machine did not stop")
+	return Stop{}
+}
+
+func TestBreakpointAndCallStack(t *testing.T) {
+	c, d := newTestDebugger()
+	id := d.SetBreakpoint(0x20C)
+
+	s := nextStop(t, c, d)
+	if s.Reason != StopBreakpoint || s.ID != id || s.PC != 0x20C {
+		t.Fatalf("stop, want=breakpoint %d at 0x20C, got=%s %d at %#x", id, s.Reason, s.ID, s.PC)
+	}
+	if hits := d.Breakpoints()[0].Hits; hits != 1 {
+		t.Errorf("hits, want=%d, got=%d", 1, hits)
+	}
+
+	want := []Frame{
+		{Function: 0x20C, PC: 0x20C, Return: 0x206},
+		{Function: 0x200, PC: 0x204},
+	}
+	got := d.CallStack()
+	if len(got) != len(want) {
+		t.Fatalf("call stack, want=%v, got=%v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("frame %d, want=%v, got=%v", i, want[i], got[i])
+		}
+	}
+
+	// Paused machines stay put.
+	if err := c.RunFrames(3); err != nil {
+		t.Fatal(err)
+	}
+	if c.PC() != 0x20C {
+		t.Errorf("paused machine moved to %#x", c.PC())
+	}
+
+	if err := d.StepOut(); err != nil {
+		t.Fatal(err)
+	}
+	s = nextStop(t, c, d)
+	if s.Reason != StopStep || s.PC != 0x206 || c.Registers()[0] != 6 {
+		t.Errorf("step out, want=step at 0x206 with V0=6, got=%s at %#x with V0=%d", s.Reason, s.PC, c.Registers()[0])
+	}
+	if err := d.StepOut(); err == nil {
+		t.Errorf("stepped out of the outermost frame")
+	}
+}
+
+func TestStepInAndOver(t *testing.T) {
+	c, d := newTestDebugger()
+	d.SetBreakpoint(0x204)
+	nextStop(t, c, d)
+
+	if err := d.StepIn(); err != nil {
+		t.Fatal(err)
+	}
+	if s := <-d.Stops(); s.Reason != StopStep || s.PC != 0x20C {
+		t.Errorf("step in, want=step at 0x20C, got=%s at %#x", s.Reason, s.PC)
+	}
+
+	c.Reset()
+	d.Continue()
+	nextStop(t, c, d)
+	if err := d.StepOver(); err != nil {
+		t.Fatal(err)
+	}
+	s := nextStop(t, c, d)
+	if s.Reason != StopStep || s.PC != 0x206 || c.SP() != 0 {
+		t.Errorf("step over, want=step at 0x206, got=%s at %#x with sp=%d", s.Reason, s.PC, c.SP())
+	}
+
+	// Not a CALL, so stepping over is stepping in.
+	if err := d.StepOver(); err != nil {
+		t.Fatal(err)
+	}
+	if s := <-d.Stops(); s.PC != 0x208 {
+		t.Errorf("step over, want=0x208, got=%#x", s.PC)
+	}
+}
+
+func TestWatchpoints(t *testing.T) {
+	tests := []struct {
+		name  string
+		watch func(d *Debugger)
+		want  Access
+		pc    uint16
+	}{
+		{
+			"memory write",
+			func(d *Debugger) { d.Watch(AccessWrite, 0x2FF, 0x301) },
+			Access{Kind: AccessWrite, Memory: true, Addr: 0x300, Value: 5, PC: 0x20C},
+			0x20E,
+		},
+		{
+			"memory read",
+			func(d *Debugger) { d.Watch(AccessRead, 0x300, 0x300) },
+			Access{Kind: AccessRead, Memory: true, Addr: 0x300, Value: 5, PC: 0x208},
+			0x20A,
+		},
+		{
+			"register write",
+			func(d *Debugger) { d.WatchRegister(AccessWrite, V1) },
+			Access{Kind: AccessWrite, Register: V1, Value: 6, PC: 0x206},
+			0x208,
+		},
+		{
+			"register read",
+			func(d *Debugger) { d.WatchRegister(AccessRead, RegI) },
+			Access{Kind: AccessRead, Register: RegI, Value: 0x300, PC: 0x20C},
+			0x20E,
+		},
+	}
+
+	for _, test := range tests {
+		c, d := newTestDebugger()
+		test.watch(d)
+
+		s := nextStop(t, c, d)
+		if s.Reason != StopWatchpoint || s.PC != test.pc {
+			t.Errorf("%s: want=watchpoint at %#x, got=%s at %#x", test.name, test.pc, s.Reason, s.PC)
+		}
+		if s.Access != test.want {
+			t.Errorf("%s: access, want=%+v, got=%+v", test.name, test.want, s.Access)
+		}
+	}
+}
+
+func TestBreakOnOpcode(t *testing.T) {
+	c, d := newTestDebugger()
+	d.BreakOnOpcode(DRW, true)
+
+	s := nextStop(t, c, d)
+	if s.Reason != StopOpcode || s.Opcode != DRW || s.PC != 0x208 {
+		t.Errorf("want=DRW at 0x208, got=%s %d at %#x", s.Reason, s.Opcode, s.PC)
+	}
+
+	// Continuing runs the instruction it stopped at.
+	d.Continue()
+	if err := c.RunFrames(1); err != nil {
+		t.Fatal(err)
+	}
+	if c.PC() != 0x20A || c.Paused() {
+		t.Errorf("continue, want=running at 0x20A, got=%#x paused=%t", c.PC(), c.Paused())
+	}
+}
+
+func TestDetach(t *testing.T) {
+	c, d := newTestDebugger()
+	d.SetBreakpoint(0x202)
+	d.Detach()
+
+	if err := c.RunFrames(1); err != nil {
+		t.Fatal(err)
+	}
+	if c.Paused() || c.PC() != 0x20A {
+		t.Errorf("detached debugger still stopped the machine at %#x", c.PC())
+	}
+}
@@ -5,79 +5,228 @@ import "fmt"
 const (
 	width  int = 64
 	height int = 32
+
+	hires_width  int = 128
+	hires_height int = 64
 )
 
-type display struct {
-	pixels        []bool
+// Display is the screen: one or, for XO-CHIP, two bitplanes. Each pixel holds
+// a bit per plane, so its value is a colour from 0 to 3.
+type Display struct {
+	pixels        []byte
 	isDirty       bool
 	width, height int
+	clip          bool // sprites are cut off at the edges rather than wrapped
+	hires         bool
+	planes        byte // bitmask of the planes drawing and clearing affect
 }
 
-func NewDisplay() display {
-	d := display{width: width, height: height}
-	d.Clear()
+func NewDisplay() Display {
+	d := Display{width: width, height: height, planes: 1}
+	d.pixels = make([]byte, d.width*d.height)
 	return d
 }
 
-func (d *display) Clear() {
-	d.pixels = make([]bool, width*height)
+// Clear blanks the selected planes.
+func (d *Display) Clear() {
+	for i := range d.pixels {
+		d.pixels[i] &^= d.planes
+	}
+	d.isDirty = true
+}
+
+// SelectPlanes chooses which planes later draws, clears and scrolls affect.
+// Plane 1 is bit 0 and plane 2 bit 1; the default is plane 1 alone.
+func (d *Display) SelectPlanes(mask byte) {
+	d.planes = mask & 0x3
+}
+
+func (d *Display) Planes() byte {
+	return d.planes
+}
+
+// PlaneCount returns the number of selected planes, which is how many sprites
+// a draw consumes.
+func (d *Display) PlaneCount() int {
+	count := 0
+	for mask := d.planes; mask > 0; mask >>= 1 {
+		count += int(mask & 1)
+	}
+	return count
+}
+
+// SetHighRes switches between the 64x32 and SUPER-CHIP 128x64 modes, clearing
+// the screen.
+func (d *Display) SetHighRes(hires bool) {
+	d.hires = hires
+	if hires {
+		d.width, d.height = hires_width, hires_height
+	} else {
+		d.width, d.height = width, height
+	}
+	d.pixels = make([]byte, d.width*d.height)
+	d.isDirty = true
+}
+
+func (d *Display) HighRes() bool {
+	return d.hires
+}
+
+// DrawSprite XORs the sprite onto the display with its top left corner at x,y
+// and reports whether any lit pixel was turned off. Pixels falling off the
+// edge wrap round, unless the display clips. With more than one plane
+// selected, pixel holds a sprite for each plane in turn.
+func (d *Display) DrawSprite(pixel []byte, x int, y int) bool {
+	return d.drawPlanes(pixel, 1, x, y)
+}
+
+// DrawSprite16 is DrawSprite for SUPER-CHIP 16x16 sprites, given two bytes
+// per row.
+func (d *Display) DrawSprite16(pixel []byte, x int, y int) bool {
+	return d.drawPlanes(pixel, 2, x, y)
+}
+
+func (d *Display) drawPlanes(pixel []byte, bytes_per_row int, x int, y int) bool {
+	collision_detected := false
+	count := d.PlaneCount()
+	if count == 0 {
+		return false
+	}
+
+	size := len(pixel) / count
+	sprite := 0
+	for plane := byte(1); plane <= 2; plane <<= 1 {
+		if d.planes&plane == 0 {
+			continue
+		}
+
+		data := pixel[sprite*size : (sprite+1)*size]
+		rows := make([]uint16, len(data)/bytes_per_row)
+		for i := range rows {
+			for b := 0; b < bytes_per_row; b++ {
+				rows[i] = rows[i]<<8 | uint16(data[i*bytes_per_row+b])
+			}
+		}
+
+		collision_detected = d.draw(rows, 8*bytes_per_row, plane, x, y) || collision_detected
+		sprite++
+	}
+
 	d.isDirty = true
+	return collision_detected
 }
 
-func (d *display) DrawSprite(pixel []byte, x int, y int) bool {
+func (d *Display) draw(rows []uint16, sprite_width int, plane byte, x int, y int) bool {
 	collision_detected := false
+	x, y = d.normalisePixelCoords(x, y)
 
-	for row_offset, sprite_row := range pixel {
+	for row_offset, sprite_row := range rows {
 
-		bit := 7
+		bit := sprite_width - 1
 		for sprite_row > 0 {
-			if sprite_row%2 == 1 {
+			if sprite_row%2 == 1 && d.visible(x+bit, y+row_offset) {
 				px := d.addrOf(d.normalisePixelCoords(x+bit, y+row_offset))
 
-				collision_detected = collision_detected || d.pixels[px]
+				collision_detected = collision_detected || d.pixels[px]&plane != 0
 
-				d.pixels[px] = !d.pixels[px]
+				d.pixels[px] ^= plane
 			}
 			sprite_row = sprite_row >> 1
 			bit--
 		}
 
 	}
-	d.isDirty = true
 	return collision_detected
 }
 
-func (d *display) EachPixel(fn func(x, y uint16, addr int)) {
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
+func (d *Display) ScrollDown(n int) {
+	d.scroll(0, n)
+}
+
+func (d *Display) ScrollRight(n int) {
+	d.scroll(n, 0)
+}
+
+func (d *Display) ScrollLeft(n int) {
+	d.scroll(-n, 0)
+}
+
+func (d *Display) ScrollUp(n int) {
+	d.scroll(0, -n)
+}
+
+// scroll moves the selected planes by dx,dy. Pixels scrolled off the edge are
+// lost and the space scrolled in is blank.
+func (d *Display) scroll(dx, dy int) {
+	scrolled := make([]byte, len(d.pixels))
+	d.EachPixel(func(x, y uint16, addr int) {
+		scrolled[addr] = d.pixels[addr] &^ d.planes
+		sx, sy := int(x)-dx, int(y)-dy
+		if sx >= 0 && sx < d.width && sy >= 0 && sy < d.height {
+			scrolled[addr] |= d.pixels[d.addrOf(sx, sy)] & d.planes
+		}
+	})
+	d.pixels = scrolled
+	d.isDirty = true
+}
+
+func (d *Display) EachPixel(fn func(x, y uint16, addr int)) {
+	for y := 0; y < d.height; y++ {
+		for x := 0; x < d.width; x++ {
 			a := d.addrOf(x, y)
 			fn(uint16(x), uint16(y), a)
 		}
 	}
 }
 
-func (d *display) GetPixel(x int, y int) (bool, error) {
+func (d *Display) Width() int {
+	return d.width
+}
+
+func (d *Display) Height() int {
+	return d.height
+}
+
+// Lit reports whether the pixel at addr, as passed to EachPixel, is on in any
+// plane.
+func (d *Display) Lit(addr int) bool {
+	return d.pixels[addr] != 0
+}
+
+// Colour returns the palette index, 0 to 3, of the pixel at addr: one bit for
+// each plane it is lit in.
+func (d *Display) Colour(addr int) byte {
+	return d.pixels[addr]
+}
+
+func (d *Display) GetPixel(x int, y int) (bool, error) {
 	px := d.addrOf(d.normalisePixelCoords(x, y))
 
-	if px > height*width {
+	if px > d.height*d.width {
 		return false, fmt.Errorf("out of bounds")
 	}
-	return d.pixels[px], nil
+	return d.pixels[px] != 0, nil
+}
+
+func (d *Display) addrOf(x int, y int) int {
+	return y*d.width + x
 }
 
-func (d *display) addrOf(x int, y int) int {
-	return y*width + x
+// visible reports whether x,y is on screen once wrapping or clipping is
+// applied.
+func (d *Display) visible(x, y int) bool {
+	return !d.clip || (x < d.width && y < d.height)
 }
 
-func (d *display) normalisePixelCoords(x, y int) (dx, dy int) {
-	dx = x % width
+func (d *Display) normalisePixelCoords(x, y int) (dx, dy int) {
+	dx = x % d.width
 	if dx < 0 {
-		dx += width
+		dx += d.width
 	}
 
-	dy = y % height
+	dy = y % d.height
 	if dy < 0 {
-		dy += height
+		dy += d.height
 	}
 
 	return
@@ -53,7 +53,7 @@ func TestDrawPixel(t *testing.T) {
 		}
 
 		d.EachPixel(func(x, y uint16, addr int) {
-			if d.pixels[addr] {
+			if d.Lit(addr) {
 				total_actual_pixel_count++
 			}
 		})
@@ -92,7 +92,7 @@ func TestPixelsXored(t *testing.T) {
 	total_actual_pixel_count := 0
 
 	d.EachPixel(func(x, y uint16, addr int) {
-		if d.pixels[addr] {
+		if d.Lit(addr) {
 			total_actual_pixel_count++
 		}
 	})
@@ -110,7 +110,7 @@ func TestClear(t *testing.T) {
 
 	total_actual_pixel_count := 0
 	for _, px := range d.pixels {
-		if px {
+		if px != 0 {
 			total_actual_pixel_count++
 		}
 	}
@@ -119,3 +119,130 @@ func TestClear(t *testing.T) {
 		t.Errorf("expected %d pixels on, got %d", 0, total_actual_pixel_count)
 	}
 }
+
+func TestClip(t *testing.T) {
+	input := []byte{0xF0, 0x90, 0x90, 0x90, 0xF0}
+	d := NewDisplay()
+	d.clip = true
+	d.DrawSprite(input, 62, 30)
+
+	total_actual_pixel_count := 0
+	d.EachPixel(func(x, y uint16, addr int) {
+		if d.Lit(addr) {
+			total_actual_pixel_count++
+		}
+	})
+
+	if total_actual_pixel_count != 3 {
+		t.Errorf("expected %d pixels on, got %d", 3, total_actual_pixel_count)
+	}
+
+	d.Clear()
+	d.DrawSprite(input, 64+62, 0)
+	if px, _ := d.GetPixel(62, 0); !px {
+		t.Errorf("expected start coordinate to wrap")
+	}
+}
+
+func TestHighRes(t *testing.T) {
+	d := NewDisplay()
+	d.DrawSprite([]byte{0x80}, 0, 0)
+	d.SetHighRes(true)
+
+	if d.Width() != 128 || d.Height() != 64 || len(d.pixels) != 128*64 {
+		t.Errorf("wrong hires size, got %dx%d with %d pixels", d.Width(), d.Height(), len(d.pixels))
+	}
+	if px, _ := d.GetPixel(0, 0); px {
+		t.Errorf("expected mode switch to clear the screen")
+	}
+
+	d.DrawSprite([]byte{0x80}, 100, 60)
+	if px, _ := d.GetPixel(100, 60); !px {
+		t.Errorf("expected pixel 100,60 to be on")
+	}
+}
+
+func TestDrawSprite16(t *testing.T) {
+	sprite := make([]byte, 32)
+	for i := range sprite {
+		sprite[i] = 0xff
+	}
+	d := NewDisplay()
+	d.SetHighRes(true)
+	d.DrawSprite16(sprite, 10, 10)
+
+	total_actual_pixel_count := 0
+	d.EachPixel(func(x, y uint16, addr int) {
+		if d.Lit(addr) {
+			total_actual_pixel_count++
+			if x < 10 || x > 25 || y < 10 || y > 25 {
+				t.Errorf("pixel %d,%d outside the sprite is on", x, y)
+			}
+		}
+	})
+
+	if total_actual_pixel_count != 256 {
+		t.Errorf("expected %d pixels on, got %d", 256, total_actual_pixel_count)
+	}
+}
+
+func TestScroll(t *testing.T) {
+	tests := []struct {
+		scroll    func(d *Display)
+		expectedX int
+		expectedY int
+		expectOn  bool
+	}{
+		{func(d *Display) { d.ScrollDown(3) }, 10, 13, true},
+		{func(d *Display) { d.ScrollRight(4) }, 14, 10, true},
+		{func(d *Display) { d.ScrollLeft(4) }, 6, 10, true},
+		{func(d *Display) { d.ScrollDown(30) }, 10, 8, false},
+	}
+
+	for _, tt := range tests {
+		d := NewDisplay()
+		d.DrawSprite([]byte{0x80}, 10, 10)
+		tt.scroll(&d)
+
+		px, _ := d.GetPixel(tt.expectedX, tt.expectedY)
+		if px != tt.expectOn {
+			t.Errorf("wrong pixel at %d,%d after scroll, want=%t, got=%t", tt.expectedX, tt.expectedY, tt.expectOn, px)
+		}
+		if px, _ := d.GetPixel(10, 10); px {
+			t.Errorf("expected original pixel to have scrolled away")
+		}
+	}
+}
+
+func TestPlanes(t *testing.T) {
+	d := NewDisplay()
+	d.SelectPlanes(3)
+	collision := d.DrawSprite([]byte{0xc0, 0x80}, 0, 0)
+	if collision {
+		t.Errorf("expected no collision")
+	}
+
+	tests := []struct {
+		x              int
+		expectedColour byte
+	}{
+		{0, 3},
+		{1, 1},
+		{2, 0},
+	}
+	for _, tt := range tests {
+		if colour := d.Colour(d.addrOf(tt.x, 0)); colour != tt.expectedColour {
+			t.Errorf("wrong colour at %d,0, want=%d, got=%d", tt.x, tt.expectedColour, colour)
+		}
+	}
+
+	d.SelectPlanes(2)
+	d.Clear()
+	if colour := d.Colour(d.addrOf(0, 0)); colour != 1 {
+		t.Errorf("clearing plane 2 touched plane 1, got colour %d", colour)
+	}
+
+	if d.DrawSprite([]byte{0x80}, 1, 0) {
+		t.Errorf("expected no collision with a pixel lit only in another plane")
+	}
+}
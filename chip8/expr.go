@@ -0,0 +1,472 @@
+package chip8
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expr is a debugger expression over the machine's state, such as
+//
+//	V3 == 0x10 && I > 0x300
+//	mem[0x3F0] != 0
+//	hits > 5
+//
+// Names are the registers V0 to VF, I, DT, ST, PC and SP, and hits, the
+// number of times the breakpoint or watchpoint being tested has been reached.
+// mem[addr] is the byte at addr. Numbers are decimal, 0x hex or 0b binary.
+// The operators are C's, with the same precedence: || && | ^ & == != < <= >
+// >= << >> + - * / % and the unary ! - ~. Values are 64 bit signed integers
+// and anything but zero is true.
+type Expr struct {
+	src  string
+	root exprNode
+}
+
+// ParseExpr compiles an expression.
+func ParseExpr(src string) (*Expr, error) {
+	p := &exprParser{src: src}
+	p.next()
+	root, err := p.parse(1)
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tok_end {
+		return nil, p.errorf("unexpected %q", p.tok.text)
+	}
+	return &Expr{src: src, root: root}, nil
+}
+
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Eval evaluates the expression against m, with hits as given.
+func (e *Expr) Eval(m *Machine, hits int) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return e.eval(m, hits)
+}
+
+// eval is Eval with the machine's lock already held.
+func (e *Expr) eval(m *Machine, hits int) (int64, error) {
+	return e.root.eval(&exprEnv{m: m, hits: hits})
+}
+
+type exprEnv struct {
+	m    *Machine
+	hits int
+}
+
+type exprNode interface {
+	eval(env *exprEnv) (int64, error)
+}
+
+type numberNode int64
+
+func (n numberNode) eval(env *exprEnv) (int64, error) {
+	return int64(n), nil
+}
+
+type registerNode Register
+
+func (n registerNode) eval(env *exprEnv) (int64, error) {
+	return int64(env.m.register(Register(n))), nil
+}
+
+type hitsNode struct{}
+
+func (hitsNode) eval(env *exprEnv) (int64, error) {
+	return int64(env.hits), nil
+}
+
+type memNode struct{ addr exprNode }
+
+func (n memNode) eval(env *exprEnv) (int64, error) {
+	addr, err := n.addr.eval(env)
+	if err != nil {
+		return 0, err
+	}
+	return int64(env.m.peek(uint16(addr))), nil
+}
+
+type unaryNode struct {
+	op      string
+	operand exprNode
+}
+
+func (n unaryNode) eval(env *exprEnv) (int64, error) {
+	v, err := n.operand.eval(env)
+	if err != nil {
+		return 0, err
+	}
+	switch n.op {
+	case "!":
+		return truth(v == 0), nil
+	case "-":
+		return -v, nil
+	default: // "~"
+		return ^v, nil
+	}
+}
+
+type binaryNode struct {
+	op          string
+	left, right exprNode
+}
+
+func (n binaryNode) eval(env *exprEnv) (int64, error) {
+	l, err := n.left.eval(env)
+	if err != nil {
+		return 0, err
+	}
+	// && and || short-circuit, so hits > 5 && mem[V0] ... only reads
+	// memory when it has to.
+	switch {
+	case n.op == "&&" && l == 0:
+		return 0, nil
+	case n.op == "||" && l != 0:
+		return 1, nil
+	}
+	r, err := n.right.eval(env)
+	if err != nil {
+		return 0, err
+	}
+
+	switch n.op {
+	case "&&", "||":
+		return truth(r != 0), nil
+	case "|":
+		return l | r, nil
+	case "^":
+		return l ^ r, nil
+	case "&":
+		return l & r, nil
+	case "==":
+		return truth(l == r), nil
+	case "!=":
+		return truth(l != r), nil
+	case "<":
+		return truth(l < r), nil
+	case "<=":
+		return truth(l <= r), nil
+	case ">":
+		return truth(l > r), nil
+	case ">=":
+		return truth(l >= r), nil
+	case "<<":
+		return l << uint64(r&63), nil
+	case ">>":
+		return l >> uint64(r&63), nil
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/", "%":
+		if r == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		if n.op == "/" {
+			return l / r, nil
+		}
+		return l % r, nil
+	}
+	return 0, fmt.Errorf("unknown operator %s", n.op)
+}
+
+func truth(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// binary_precedence ranks the binary operators, loosest first.
+var binary_precedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"|":  3,
+	"^":  4,
+	"&":  5,
+	"==": 6, "!=": 6,
+	"<": 7, "<=": 7, ">": 7, ">=": 7,
+	"<<": 8, ">>": 8,
+	"+": 9, "-": 9,
+	"*": 10, "/": 10, "%": 10,
+}
+
+type tokenKind int
+
+const (
+	tok_end tokenKind = iota
+	tok_number
+	tok_name
+	tok_op
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+type exprParser struct {
+	src string
+	pos int
+	tok token
+}
+
+func (p *exprParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s: column %d: %s", p.src, p.tok.pos+1, fmt.Sprintf(format, args...))
+}
+
+// next reads the next token into p.tok.
+func (p *exprParser) next() {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+	start := p.pos
+	if p.pos >= len(p.src) {
+		p.tok = token{kind: tok_end, pos: start}
+		return
+	}
+
+	ch := p.src[p.pos]
+	if isNameByte(ch) {
+		for p.pos < len(p.src) && isNameByte(p.src[p.pos]) {
+			p.pos++
+		}
+		kind := tok_name
+		if ch >= '0' && ch <= '9' {
+			kind = tok_number
+		}
+		p.tok = token{kind: kind, text: p.src[start:p.pos], pos: start}
+		return
+	}
+
+	for _, op := range []string{"||", "&&", "==", "!=", "<=", ">=", "<<", ">>"} {
+		if strings.HasPrefix(p.src[p.pos:], op) {
+			p.pos += len(op)
+			p.tok = token{kind: tok_op, text: op, pos: start}
+			return
+		}
+	}
+	p.pos++
+	p.tok = token{kind: tok_op, text: p.src[start:p.pos], pos: start}
+}
+
+func isNameByte(ch byte) bool {
+	return ch == '_' || ch >= '0' && ch <= '9' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z'
+}
+
+// parse reads a binary expression whose operators bind at least as tightly as
+// min, by precedence climbing.
+func (p *exprParser) parse(min int) (exprNode, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		prec, ok := binary_precedence[p.tok.text]
+		if p.tok.kind != tok_op || !ok || prec < min {
+			return left, nil
+		}
+		op := p.tok.text
+		p.next()
+		right, err := p.parse(prec + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{op: op, left: left, right: right}
+	}
+}
+
+func (p *exprParser) unary() (exprNode, error) {
+	if p.tok.kind == tok_op && strings.Contains("!-~", p.tok.text) {
+		op := p.tok.text
+		p.next()
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return unaryNode{op: op, operand: operand}, nil
+	}
+	return p.primary()
+}
+
+// parseNumber reads a decimal, 0x hex or 0b binary number. A leading zero
+// is just a zero, not the start of an octal number.
+func parseNumber(text string) (int64, error) {
+	lower := strings.ToLower(text)
+	switch {
+	case strings.HasPrefix(lower, "0x"):
+		return strconv.ParseInt(lower[2:], 16, 64)
+	case strings.HasPrefix(lower, "0b"):
+		return strconv.ParseInt(lower[2:], 2, 64)
+	}
+	return strconv.ParseInt(text, 10, 64)
+}
+
+func (p *exprParser) primary() (exprNode, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tok_end:
+		return nil, p.errorf("unexpected end of expression")
+	case tok_number:
+		n, err := parseNumber(tok.text)
+		if err != nil {
+			return nil, p.errorf("bad number %q", tok.text)
+		}
+		p.next()
+		return numberNode(n), nil
+	case tok_name:
+		p.next()
+		switch strings.ToLower(tok.text) {
+		case "hits":
+			return hitsNode{}, nil
+		case "mem":
+			if err := p.expect("["); err != nil {
+				return nil, err
+			}
+			addr, err := p.parse(1)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			return memNode{addr: addr}, nil
+		}
+		r, err := RegisterByName(tok.text)
+		if err != nil {
+			return nil, fmt.Errorf("%s: column %d: unknown name %q", p.src, tok.pos+1, tok.text)
+		}
+		return registerNode(r), nil
+	}
+
+	if tok.text == "(" {
+		p.next()
+		inner, err := p.parse(1)
+		if err != nil {
+			return nil, err
+		}
+		return inner, p.expect(")")
+	}
+	return nil, p.errorf("unexpected %q", tok.text)
+}
+
+func (p *exprParser) expect(text string) error {
+	if p.tok.kind != tok_op || p.tok.text != text {
+		if p.tok.kind == tok_end {
+			return p.errorf("missing %q", text)
+		}
+		return p.errorf("want %q, got %q", text, p.tok.text)
+	}
+	p.next()
+	return nil
+}
+
+// LogMessage is the message a log point prints: text with expressions in
+// braces, each optionally followed by a colon and a Printf verb with its
+// flags, as in "V0={V0} at {PC:03x}". The default verb is d; {{ and }} stand
+// for literal braces.
+type LogMessage struct {
+	src   string
+	parts []logPart
+}
+
+type logPart struct {
+	text   string
+	expr   *Expr
+	format string
+}
+
+// ParseLogMessage compiles a log point message.
+func ParseLogMessage(src string) (*LogMessage, error) {
+	msg := &LogMessage{src: src}
+	var text strings.Builder
+	for i := 0; i < len(src); i++ {
+		ch := src[i]
+		switch {
+		case (ch == '{' || ch == '}') && i+1 < len(src) && src[i+1] == ch:
+			text.WriteByte(ch)
+			i++
+		case ch == '}':
+			return nil, fmt.Errorf("%s: column %d: unmatched }", src, i+1)
+		case ch == '{':
+			end := strings.IndexByte(src[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("%s: column %d: unclosed {", src, i+1)
+			}
+			inner := src[i+1 : i+end]
+			format := "%d"
+			if colon := strings.LastIndexByte(inner, ':'); colon >= 0 {
+				format = "%" + inner[colon+1:]
+				inner = inner[:colon]
+				if !validVerb(format) {
+					return nil, fmt.Errorf("%s: column %d: bad format %q", src, i+colon+2, format)
+				}
+			}
+			expr, err := ParseExpr(inner)
+			if err != nil {
+				return nil, err
+			}
+			if text.Len() > 0 {
+				msg.parts = append(msg.parts, logPart{text: text.String()})
+				text.Reset()
+			}
+			msg.parts = append(msg.parts, logPart{expr: expr, format: format})
+			i += end
+		default:
+			text.WriteByte(ch)
+		}
+	}
+	if text.Len() > 0 {
+		msg.parts = append(msg.parts, logPart{text: text.String()})
+	}
+	return msg, nil
+}
+
+// validVerb accepts integer Printf formats: flags and a width, then one of
+// d, x, X, o, b or c.
+func validVerb(format string) bool {
+	if len(format) < 2 || !strings.ContainsRune("dxXobc", rune(format[len(format)-1])) {
+		return false
+	}
+	for _, ch := range format[1 : len(format)-1] {
+		if !strings.ContainsRune("0123456789-+# ", ch) {
+			return false
+		}
+	}
+	return true
+}
+
+func (l *LogMessage) String() string {
+	return l.src
+}
+
+// Format renders the message against m, with hits as given.
+func (l *LogMessage) Format(m *Machine, hits int) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return l.format(m, hits)
+}
+
+func (l *LogMessage) format(m *Machine, hits int) (string, error) {
+	var out strings.Builder
+	for _, part := range l.parts {
+		if part.expr == nil {
+			out.WriteString(part.text)
+			continue
+		}
+		v, err := part.expr.eval(m, hits)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&out, part.format, v)
+	}
+	return out.String(), nil
+}
@@ -0,0 +1,175 @@
+package chip8
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestExpr(t *testing.T) {
+	c := newTestCpu([]byte{0x12, 0x00})
+	c.registers[3] = 0x10
+	c.index = 0x310
+	c.memory[0x3F0] = 7
+	c.delay = 4
+
+	tests := []struct {
+		src  string
+		hits int
+		want int64
+	}{
+		{"V3 == 0x10 && I > 0x300", 0, 1},
+		{"v3 == 16 && i > 0x310", 0, 0},
+		{"mem[0x3F0] != 0", 0, 1},
+		{"mem[0x3E0 + V3]", 0, 7},
+		{"hits > 5", 5, 0},
+		{"hits > 5", 6, 1},
+		{"1 + 2 * 3", 0, 7},
+		{"(1 + 2) * 3", 0, 9},
+		{"1 << 4 | 1", 0, 17},
+		{"-DT + ~0", 0, -5},
+		{"!V0 && !!V3", 0, 1},
+		{"0b101 ^ 7 % 4", 0, 6},
+		{"010 + 0x010", 0, 26},
+		{"0 && 1 / 0", 0, 0},
+		{"PC == 0x200 || SP", 0, 1},
+	}
+
+	for _, test := range tests {
+		expr, err := ParseExpr(test.src)
+		if err != nil {
+			t.Errorf("%s: %s", test.src, err)
+			continue
+		}
+		got, err := expr.Eval(c, test.hits)
+		if err != nil {
+			t.Errorf("%s: %s", test.src, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s, want=%d, got=%d", test.src, test.want, got)
+		}
+	}
+}
+
+func TestExprErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"", "unexpected end"},
+		{"V3 ==", "unexpected end"},
+		{"VG == 1", `unknown name "VG"`},
+		{"mem[1", `missing "]"`},
+		{"(1 + 2", `missing ")"`},
+		{"1 2", `unexpected "2"`},
+		{"0xZZ", "bad number"},
+		{"0o17", "bad number"},
+		{"V1 @ 2", `unexpected "@"`},
+	}
+
+	for _, test := range tests {
+		_, err := ParseExpr(test.src)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%q, want error containing %q, got=%v", test.src, test.want, err)
+		}
+	}
+
+	expr, _ := ParseExpr("1 / V0")
+	if _, err := expr.Eval(newTestCpu([]byte{0x12, 0x00}), 0); err == nil {
+		t.Errorf("division by zero was not an error")
+	}
+}
+
+func TestLogMessage(t *testing.T) {
+	c := newTestCpu([]byte{0x12, 0x00})
+	c.registers[0] = 42
+
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"V0={V0}", "V0=42"},
+		{"at {PC:03x}, hit {hits}", "at 200, hit 3"},
+		{"{{literal}} {V0:#x}", "{literal} 0x2a"},
+		{"plain", "plain"},
+	}
+
+	for _, test := range tests {
+		msg, err := ParseLogMessage(test.src)
+		if err != nil {
+			t.Errorf("%s: %s", test.src, err)
+			continue
+		}
+		got, err := msg.Format(c, 3)
+		if err != nil || got != test.want {
+			t.Errorf("%s, want=%q, got=%q (%v)", test.src, test.want, got, err)
+		}
+	}
+
+	for _, bad := range []string{"{V0", "V0}", "{V0:s}", "{V0 +}"} {
+		if _, err := ParseLogMessage(bad); err == nil {
+			t.Errorf("%q parsed", bad)
+		}
+	}
+}
+
+func TestConditionalBreakpoint(t *testing.T) {
+	c := newTestCpu([]byte{
+		0x70, 0x01, // 200: ADD V0, 1
+		0x12, 0x00, // 202: JP 200
+	})
+	d := NewDebugger(c)
+	id := d.SetBreakpoint(0x202)
+	if err := d.SetCondition(id, "V0 == 3 && hits == 3"); err != nil {
+		t.Fatal(err)
+	}
+
+	s := nextStop(t, c, d)
+	if s.ID != id || c.Registers()[0] != 3 {
+		t.Errorf("want=stop with V0=3, got=stop %d with V0=%d", s.ID, c.Registers()[0])
+	}
+	if err := d.SetCondition(id, "V0 =="); err == nil {
+		t.Errorf("bad condition was accepted")
+	}
+	if err := d.SetCondition(99, "1"); err == nil {
+		t.Errorf("condition on a missing breakpoint was accepted")
+	}
+}
+
+func TestLogPoint(t *testing.T) {
+	c := newTestCpu([]byte{
+		0x70, 0x01, // 200: ADD V0, 1
+		0x12, 0x00, // 202: JP 200
+	})
+	d := NewDebugger(c)
+	var out bytes.Buffer
+	d.Logger = log.New(&out, "", 0)
+
+	id := d.SetBreakpoint(0x202)
+	if err := d.SetLog(id, "V0={V0}"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetCondition(id, "V0 % 2 == 0"); err != nil {
+		t.Fatal(err)
+	}
+	watch := d.Watch(AccessWrite, 0x300, 0x300)
+	if err := d.SetLog(watch, "never"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.StepFrame(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Paused() {
+		t.Errorf("log point stopped the machine")
+	}
+	want := "V0=2\nV0=4\n"
+	if out.String() != want {
+		t.Errorf("log, want=%q, got=%q", want, out.String())
+	}
+	if hits := d.Breakpoints()[0].Hits; hits != 5 {
+		t.Errorf("hits, want=%d, got=%d", 5, hits)
+	}
+}
@@ -0,0 +1,36 @@
+package chip8
+
+// Host is the frontend a cpu runs inside. It draws the display and supplies
+// keypresses and the quit signal; sound goes to a separate Audio. Keeping
+// these behind interfaces leaves the core free of cgo and windowing code.
+type Host interface {
+	Renderer
+
+	// Poll drains pending input, returning the keys that have gone down or
+	// up since the last call, in order, and whether the user has asked to
+	// quit.
+	Poll() (events []KeyEvent, quit bool)
+}
+
+type nullHost struct {
+	nullRenderer
+}
+
+// NewNullHost returns a Host that draws nothing and never reports input. It
+// lets the cpu run headless, e.g. in tests.
+func NewNullHost() *nullHost {
+	return &nullHost{}
+}
+
+func (n *nullHost) Poll() ([]KeyEvent, bool) {
+	return nil, false
+}
+
+// rendererHost adapts a bare Renderer into a Host with no input.
+type rendererHost struct {
+	Renderer
+}
+
+func (r *rendererHost) Poll() ([]KeyEvent, bool) {
+	return nil, false
+}
@@ -1,11 +1,24 @@
 package chip8
 
-type keyboard struct {
-	buffer  []byte
-	mapping map[rune]byte
+// Keyboard is the 16 key hex keypad. It tracks which keys are held, fed
+// key-down and key-up events from the host.
+type Keyboard struct {
+	pressed [16]bool
+	mapping Keymap
+
+	waiting      bool     // FX0A is waiting for a key
+	waitPressed  [16]bool // keys pressed since FX0A started waiting
+	waitReleased int      // key pressed and released while waiting, or -1
 }
 
-const buffer_size int = 1
+// KeyEvent is a host key going down or up. Code is the host's key code and
+// Scancode, if the host knows it, the key's physical position as a USB HID
+// usage id; the keyboard's Keymap translates them to a keypad key.
+type KeyEvent struct {
+	Code     rune
+	Scancode int
+	Down     bool
+}
 
 var default_mapping = map[rune]byte{
 	'1': 0x1,
@@ -26,64 +39,72 @@ var default_mapping = map[rune]byte{
 	'v': 0xf,
 }
 
-func NewKeyboard() *keyboard {
-	return &keyboard{buffer: make([]byte, 0), mapping: default_mapping}
+func NewKeyboard() *Keyboard {
+	return &Keyboard{mapping: DefaultKeymap, waitReleased: -1}
 }
 
-func (k *keyboard) push(keys []byte) {
-	tmp := append(k.buffer, keys...)
-	if len(tmp) > buffer_size {
-		tmp = tmp[len(tmp)-buffer_size:]
-	}
-
-	k.buffer = tmp
+// SetKeymap replaces the mapping from host keys to keypad keys.
+func (k *Keyboard) SetKeymap(m Keymap) {
+	k.mapping = m
 }
 
-func (k *keyboard) popIfIs(key byte) (byte, bool) {
-	if len(k.buffer) < 1 {
-		return 0, false
+// Handle applies a host key event. Keys without a mapping are ignored.
+func (k *Keyboard) Handle(ev KeyEvent) {
+	key, ok := k.mapping.lookup(ev)
+	if !ok {
+		return
 	}
 
-	ch := rune(k.buffer[0])
-
-	keyPressed, ok := k.mapping[ch]
-	if !ok {
-		return 0, false
+	if ev.Down {
+		k.Press(key)
+	} else {
+		k.Release(key)
 	}
+}
 
-	if keyPressed == key {
-		if len(k.buffer) == 1 {
-			k.buffer = []byte{}
-		} else {
-			k.buffer = k.buffer[1:]
-		}
+// Press holds down keypad key, 0x0 to 0xF.
+func (k *Keyboard) Press(key byte) {
+	key &= 0xf
+	k.pressed[key] = true
+	if k.waiting {
+		k.waitPressed[key] = true
+	}
+}
 
-		if !ok {
-			return 0, false
-		}
-		return keyPressed, true
+func (k *Keyboard) Release(key byte) {
+	key &= 0xf
+	k.pressed[key] = false
+	if k.waiting && k.waitPressed[key] && k.waitReleased < 0 {
+		k.waitReleased = int(key)
 	}
+}
 
-	return 0, false
+func (k *Keyboard) IsPressed(key byte) bool {
+	return k.pressed[key&0xf]
 }
 
-func (k *keyboard) pop() (byte, bool) {
-	if len(k.buffer) < 1 {
-		return 0, false
+// awaitKey implements FX0A. As on the COSMAC VIP, a key counts once it has
+// been pressed and released again after the wait began. It returns false
+// until that has happened.
+func (k *Keyboard) awaitKey() (byte, bool) {
+	if !k.waiting {
+		k.waiting = true
+		k.waitPressed = [16]bool{}
+		k.waitReleased = -1
 	}
 
-	ch := rune(k.buffer[0])
-	if len(k.buffer) == 1 {
-		k.buffer = []byte{}
-	} else {
-		k.buffer = k.buffer[1:]
+	if k.waitReleased < 0 {
+		return 0, false
 	}
 
-	b, ok := k.mapping[ch]
-
-	if !ok {
-		return k.pop()
-	}
+	key := byte(k.waitReleased)
+	k.waiting = false
+	k.waitReleased = -1
+	return key, true
+}
 
-	return b, true
+func (k *Keyboard) reset() {
+	k.pressed = [16]bool{}
+	k.waiting = false
+	k.waitReleased = -1
 }
@@ -6,120 +6,86 @@ import (
 
 func TestReadKey(t *testing.T) {
 	tests := []struct {
-		input          string
+		input          rune
 		expectedOutput byte
 		expectedOk     bool
 	}{
-		{
-			"1",
-			1,
-			true,
-		},
-		{
-			"2",
-			2,
-			true,
-		},
-		{
-			"3",
-			3,
-			true,
-		},
-		{
-			"4",
-			0xc,
-			true,
-		},
-		{
-			"q",
-			4,
-			true,
-		},
-		{
-			"w",
-			5,
-			true,
-		},
-		{
-			"e",
-			6,
-			true,
-		},
-		{
-			"r",
-			0xd,
-			true,
-		},
-		{
-			"a",
-			7,
-			true,
-		},
-		{
-			"s",
-			8,
-			true,
-		},
-		{
-			"d",
-			9,
-			true,
-		},
-		{
-			"f",
-			0xe,
-			true,
-		},
-		{
-			"z",
-			0xa,
-			true,
-		},
-		{
-			"x",
-			0,
-			true,
-		},
-		{
-			"c",
-			0xb,
-			true,
-		},
-		{
-			"v",
-			0xf,
-			true,
-		},
-		{
-			"p1",
-			1,
-			true,
-		},
-		{
-			"p",
-			0,
-			false,
-		},
-		{
-			"",
-			0,
-			false,
-		},
+		{'1', 1, true},
+		{'2', 2, true},
+		{'3', 3, true},
+		{'4', 0xc, true},
+		{'q', 4, true},
+		{'w', 5, true},
+		{'e', 6, true},
+		{'r', 0xd, true},
+		{'a', 7, true},
+		{'s', 8, true},
+		{'d', 9, true},
+		{'f', 0xe, true},
+		{'z', 0xa, true},
+		{'x', 0, true},
+		{'c', 0xb, true},
+		{'v', 0xf, true},
+		{'p', 0, false},
 	}
 
 	for _, tt := range tests {
 		k := NewKeyboard()
-		k.push([]byte(tt.input))
+		k.Handle(KeyEvent{Code: tt.input, Down: true})
 
-		actualOutput, ok := k.pop()
+		held := -1
+		for key := byte(0); key < 16; key++ {
+			if k.IsPressed(key) {
+				held = int(key)
+			}
+		}
+
+		if ok := held >= 0; ok != tt.expectedOk {
+			t.Errorf("unexpected read result for %q, want=%t, got=%t", tt.input, tt.expectedOk, ok)
+		}
+		if tt.expectedOk && byte(held) != tt.expectedOutput {
+			t.Errorf("unexpected output for %q, want=%d, got=%d", tt.input, tt.expectedOutput, held)
+		}
 
-		if ok != tt.expectedOk {
-			t.Errorf("unexpected read result, want=%t, got=%t", tt.expectedOk, ok)
+		k.Handle(KeyEvent{Code: tt.input, Down: false})
+		if k.IsPressed(tt.expectedOutput) {
+			t.Errorf("key %q still held after release", tt.input)
 		}
+	}
+}
 
-		if actualOutput != tt.expectedOutput {
-			t.Errorf("unexpected output, want=%d, got=%d", tt.expectedOutput, actualOutput)
+func TestKeyHeldAcrossReads(t *testing.T) {
+	k := NewKeyboard()
+	k.Press(0x5)
+
+	for i := 0; i < 3; i++ {
+		if !k.IsPressed(0x5) {
+			t.Errorf("held key not reported on read %d", i)
 		}
+	}
+}
+
+func TestAwaitKey(t *testing.T) {
+	k := NewKeyboard()
+	k.Press(0x1) // held before the wait started
+
+	if _, ok := k.awaitKey(); ok {
+		t.Errorf("expected no key before any press")
+	}
+
+	k.Release(0x1)
+	if _, ok := k.awaitKey(); ok {
+		t.Errorf("expected a key held before the wait to be ignored")
+	}
+
+	k.Press(0x7)
+	if _, ok := k.awaitKey(); ok {
+		t.Errorf("expected no key until it is released")
+	}
 
+	k.Release(0x7)
+	key, ok := k.awaitKey()
+	if !ok || key != 0x7 {
+		t.Errorf("wrong key, want=%d, got=%d (ok=%t)", 0x7, key, ok)
 	}
 }
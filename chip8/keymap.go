@@ -0,0 +1,230 @@
+package chip8
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Keymap maps host keys to keypad keys. Any number of host keys may map to the
+// same keypad key. Scancodes identify physical key positions, so a scancode
+// map plays the same on any keyboard layout; where an event carries a
+// scancode with a mapping it wins over the key code.
+type Keymap struct {
+	Keys      map[rune]byte // by key code, which follows the layout
+	Scancodes map[int]byte  // by USB HID usage id, as SDL scancodes are
+}
+
+// DefaultKeymap is the usual QWERTY layout, the left four columns of keys 1
+// to V standing in for the hex keypad.
+var DefaultKeymap = Keymap{Keys: default_mapping}
+
+func (m Keymap) lookup(ev KeyEvent) (byte, bool) {
+	if ev.Scancode != 0 {
+		if key, ok := m.Scancodes[ev.Scancode]; ok {
+			return key, true
+		}
+	}
+	key, ok := m.Keys[ev.Code]
+	return key, ok
+}
+
+// KeymapConfig is a keymap file: a default map plus overrides for particular
+// ROMs, keyed by RomHash.
+//
+// On disk it is JSON. Host keys are single characters or one of space,
+// enter, tab, backspace and escape; scancodes are numbers or W3C code names
+// such as "KeyQ", "Digit1", "ArrowUp" and "Numpad5". Keypad keys are hex
+// digits.
+//
+//	{
+//	  "keys": {"1": "1", "2": "2", "q": "4"},
+//	  "scancodes": {"KeyA": "7", "ArrowUp": "5"},
+//	  "roms": {
+//	    "<sha1 of rom>": {"scancodes": {"ArrowLeft": "4", "ArrowRight": "6"}}
+//	  }
+//	}
+type KeymapConfig struct {
+	Default Keymap
+	Roms    map[string]Keymap
+}
+
+// For returns the keymap for the ROM with the given hash: the config's
+// default, with the keys and scancodes of the ROM's override, if it has one,
+// mapped over it.
+func (c *KeymapConfig) For(romHash string) Keymap {
+	override, ok := c.Roms[strings.ToLower(romHash)]
+	if !ok {
+		return c.Default
+	}
+
+	m := Keymap{Keys: map[rune]byte{}, Scancodes: map[int]byte{}}
+	for _, from := range []Keymap{c.Default, override} {
+		for code, key := range from.Keys {
+			m.Keys[code] = key
+		}
+		for scancode, key := range from.Scancodes {
+			m.Scancodes[scancode] = key
+		}
+	}
+	return m
+}
+
+type keymapJSON struct {
+	Keys      map[string]string `json:"keys"`
+	Scancodes map[string]string `json:"scancodes"`
+}
+
+type keymapConfigJSON struct {
+	keymapJSON
+	Roms map[string]keymapJSON `json:"roms"`
+}
+
+func LoadKeymapFile(path string) (*KeymapConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	config, err := ParseKeymapConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return config, nil
+}
+
+func ParseKeymapConfig(r io.Reader) (*KeymapConfig, error) {
+	var raw keymapConfigJSON
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	config := &KeymapConfig{Roms: map[string]Keymap{}}
+	var err error
+	if config.Default, err = raw.keymapJSON.keymap(); err != nil {
+		return nil, err
+	}
+	if len(config.Default.Keys) == 0 && len(config.Default.Scancodes) == 0 {
+		config.Default = DefaultKeymap
+	}
+
+	for hash, rom := range raw.Roms {
+		m, err := rom.keymap()
+		if err != nil {
+			return nil, fmt.Errorf("rom %s: %s", hash, err)
+		}
+		config.Roms[strings.ToLower(hash)] = m
+	}
+	return config, nil
+}
+
+func (j keymapJSON) keymap() (Keymap, error) {
+	m := Keymap{Keys: map[rune]byte{}, Scancodes: map[int]byte{}}
+
+	for name, value := range j.Keys {
+		code, err := parseKeyName(name)
+		if err != nil {
+			return m, err
+		}
+		if m.Keys[code], err = parseKeypadKey(value); err != nil {
+			return m, err
+		}
+	}
+
+	for name, value := range j.Scancodes {
+		scancode, err := parseScancode(name)
+		if err != nil {
+			return m, err
+		}
+		if m.Scancodes[scancode], err = parseKeypadKey(value); err != nil {
+			return m, err
+		}
+	}
+	return m, nil
+}
+
+var key_names = map[string]rune{
+	"space":     ' ',
+	"enter":     '\r',
+	"tab":       '\t',
+	"backspace": '\b',
+	"escape":    0x1b,
+}
+
+// parseKeyName reads a host key. Letters are taken in lower case, as key
+// codes are, so "Q" is the q key.
+func parseKeyName(name string) (rune, error) {
+	if utf8.RuneCountInString(name) == 1 {
+		r, _ := utf8.DecodeRuneInString(name)
+		return unicode.ToLower(r), nil
+	}
+	if r, ok := key_names[strings.ToLower(name)]; ok {
+		return r, nil
+	}
+	return 0, fmt.Errorf("unknown key %q", name)
+}
+
+var scancode_names = map[string]int{
+	"Enter":        40,
+	"Escape":       41,
+	"Backspace":    42,
+	"Tab":          43,
+	"Space":        44,
+	"Minus":        45,
+	"Equal":        46,
+	"BracketLeft":  47,
+	"BracketRight": 48,
+	"Semicolon":    51,
+	"Quote":        52,
+	"Comma":        54,
+	"Period":       55,
+	"Slash":        56,
+	"ArrowRight":   79,
+	"ArrowLeft":    80,
+	"ArrowDown":    81,
+	"ArrowUp":      82,
+}
+
+func init() {
+	for c := 'A'; c <= 'Z'; c++ {
+		scancode_names["Key"+string(c)] = 4 + int(c-'A')
+	}
+	for d := 1; d <= 9; d++ {
+		scancode_names["Digit"+strconv.Itoa(d)] = 29 + d
+		scancode_names["Numpad"+strconv.Itoa(d)] = 88 + d
+	}
+	scancode_names["Digit0"] = 39
+	scancode_names["Numpad0"] = 98
+}
+
+func parseScancode(name string) (int, error) {
+	if n, err := strconv.Atoi(name); err == nil {
+		return n, nil
+	}
+	if n, ok := scancode_names[name]; ok {
+		return n, nil
+	}
+	return 0, fmt.Errorf("unknown scancode %q", name)
+}
+
+func parseKeypadKey(value string) (byte, error) {
+	key, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(value), "0x"), 16, 8)
+	if err != nil || key > 0xf {
+		return 0, fmt.Errorf("keypad key %q is not a hex digit", value)
+	}
+	return byte(key), nil
+}
+
+// RomHash identifies a ROM by the SHA-1 of its contents, as a hex string.
+func RomHash(rom []byte) string {
+	sum := sha1.Sum(rom)
+	return hex.EncodeToString(sum[:])
+}
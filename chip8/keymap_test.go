@@ -0,0 +1,74 @@
+package chip8
+
+import (
+	"strings"
+	"testing"
+)
+
+const test_keymap = `{
+	"keys": {"1": "1", "j": "4", "K": "4", "space": "0xA"},
+	"scancodes": {"KeyQ": "5", "30": "c"},
+	"roms": {
+		"ABCDEF": {"keys": {"l": "6", "j": "7"}}
+	}
+}`
+
+func TestParseKeymapConfig(t *testing.T) {
+	config, err := ParseKeymapConfig(strings.NewReader(test_keymap))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	tests := []struct {
+		romHash     string
+		event       KeyEvent
+		expectedKey byte
+		expectedOk  bool
+	}{
+		{"", KeyEvent{Code: '1'}, 0x1, true},
+		{"", KeyEvent{Code: 'j'}, 0x4, true},
+		{"", KeyEvent{Code: 'k'}, 0x4, true},
+		{"", KeyEvent{Code: ' '}, 0xa, true},
+		{"", KeyEvent{Code: 'a', Scancode: 20}, 0x5, true},
+		{"", KeyEvent{Code: '1', Scancode: 30}, 0xc, true},
+		{"", KeyEvent{Code: 'j', Scancode: 99}, 0x4, true},
+		{"", KeyEvent{Code: 'l'}, 0, false},
+		{"abcdef", KeyEvent{Code: 'l'}, 0x6, true},
+		{"abcdef", KeyEvent{Code: 'j'}, 0x7, true},
+		{"abcdef", KeyEvent{Code: '1'}, 0x1, true},
+		{"abcdef", KeyEvent{Code: 'a', Scancode: 20}, 0x5, true},
+	}
+
+	for _, tt := range tests {
+		key, ok := config.For(tt.romHash).lookup(tt.event)
+		if ok != tt.expectedOk || key != tt.expectedKey {
+			t.Errorf("wrong mapping for %+v in rom %q, want=%d,%t, got=%d,%t", tt.event, tt.romHash, tt.expectedKey, tt.expectedOk, key, ok)
+		}
+	}
+}
+
+func TestParseKeymapConfigErrors(t *testing.T) {
+	tests := []string{
+		`{"keys": {"1": "10"}}`,
+		`{"keys": {"nope": "1"}}`,
+		`{"scancodes": {"KeyNope": "1"}}`,
+		`{"roms": {"ab": {"keys": {"1": "g"}}}}`,
+		`not json`,
+	}
+
+	for _, input := range tests {
+		if _, err := ParseKeymapConfig(strings.NewReader(input)); err == nil {
+			t.Errorf("expected error for %s", input)
+		}
+	}
+}
+
+func TestEmptyKeymapConfigUsesDefault(t *testing.T) {
+	config, err := ParseKeymapConfig(strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if key, ok := config.For("").lookup(KeyEvent{Code: 'v'}); !ok || key != 0xf {
+		t.Errorf("expected the default mapping, got=%d,%t", key, ok)
+	}
+}
@@ -0,0 +1,79 @@
+package chip8
+
+import "crypto/sha1"
+
+// Pause stops a running machine executing instructions and counting down
+// timers until Resume is called. Step and StepFrame still work while paused.
+func (c *Machine) Pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paused = true
+}
+
+func (c *Machine) Resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paused = false
+}
+
+func (c *Machine) Paused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.paused
+}
+
+// Step executes a single instruction, whether or not the machine is paused.
+func (c *Machine) Step() error {
+	return c.StepInstructions(1)
+}
+
+// StepFrame runs a single frame, whether or not the machine is paused.
+func (c *Machine) StepFrame() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.frame()
+}
+
+// Reset returns the cpu to its power-on state: registers, timers and stack
+// cleared, the display blanked and in low resolution, and the program counter
+// back at the start of the program. Memory is untouched, so the loaded ROM
+// runs again from the top. Rewinding stops and the history is dropped, but a
+// paused machine stays paused, so a debugger can reset and step from the top.
+func (c *Machine) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reset()
+}
+
+// HardReset is Reset plus wiping memory and reloading the font. The ROM has
+// to be loaded again before the machine is useful.
+func (c *Machine) HardReset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.reset()
+	c.memory = make([]byte, len(c.memory))
+	c.rom = [sha1.Size]byte{}
+	c.loadFont()
+}
+
+func (c *Machine) reset() {
+	c.registers = [16]byte{}
+	c.index = 0
+	c.delay = 0
+	c.sound = 0
+	c.stack = [16]uint16{}
+	c.sp = 0
+	c.pc = program_start_addr
+	c.cycles = 0
+	c.exited = false
+	c.vblankWait = false
+	c.rewinding = false
+	c.clearHistory()
+	c.audioPattern = [16]byte{}
+	c.hasPattern = false
+	c.pitch = default_pitch
+	c.d.SetHighRes(false)
+	c.d.SelectPlanes(1)
+	c.keyboard.reset()
+}
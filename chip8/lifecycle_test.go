@@ -0,0 +1,149 @@
+package chip8
+
+import (
+	"context"
+	"testing"
+)
+
+func TestStopTwice(t *testing.T) {
+	c := newTestCpu([]byte{0x12, 0x00})
+	c.Stop()
+	c.Stop()
+
+	if err := c.Run(context.Background()); err != nil {
+		t.Errorf("unexpected error from stopped machine: %s", err)
+	}
+}
+
+func TestRunCancelled(t *testing.T) {
+	c := newTestCpu([]byte{0x12, 0x00}, WithClock(NewManualClock()))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := c.Run(ctx); err != context.Canceled {
+		t.Errorf("wrong error, want=%s, got=%v", context.Canceled, err)
+	}
+}
+
+func TestPauseAndResume(t *testing.T) {
+	clock := NewManualClock()
+	c := newTestCpu([]byte{0x71, 0x01, 0x12, 0x00}, WithClock(clock), WithInstructionsPerFrame(2))
+	c.delay = 10
+
+	done := make(chan error)
+	go func() {
+		done <- c.Run(context.Background())
+	}()
+
+	clock.Advance(1)
+	c.Pause()
+	clock.Advance(5)
+
+	if v1 := c.Registers()[1]; v1 != 1 {
+		t.Errorf("paused machine kept running, want V1=%d, got=%d", 1, v1)
+	}
+	if d := c.Delay(); d != 9 {
+		t.Errorf("paused machine kept counting down, want delay=%d, got=%d", 9, d)
+	}
+
+	if err := c.Step(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := c.Step(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if v1 := c.Registers()[1]; v1 != 2 {
+		t.Errorf("wrong V1 after stepping, want=%d, got=%d", 2, v1)
+	}
+
+	if err := c.StepFrame(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if v1, d := c.Registers()[1], c.Delay(); v1 != 3 || d != 8 {
+		t.Errorf("wrong state after StepFrame, V1=%d, delay=%d", v1, d)
+	}
+
+	c.Resume()
+	clock.Advance(1)
+	c.Stop()
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if v1 := c.Registers()[1]; v1 != 4 {
+		t.Errorf("resumed machine did not run, want V1=%d, got=%d", 4, v1)
+	}
+}
+
+func TestReset(t *testing.T) {
+	program := []byte{0x61, 0x05, 0xa3, 0x00, 0xf1, 0x15, 0x22, 0x00}
+	c := newTestCpu(program)
+	if err := c.StepInstructions(4); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c.Reset()
+
+	if c.registers != [16]byte{} || c.index != 0 || c.delay != 0 || c.sp != 0 || c.pc != program_start_addr {
+		t.Errorf("cpu state survived reset")
+	}
+	if c.memory[program_start_addr] != program[0] {
+		t.Errorf("reset lost the ROM")
+	}
+
+	c.memory[0x300] = 0xff
+	c.HardReset()
+
+	if c.memory[program_start_addr] != 0 || c.memory[0x300] != 0 {
+		t.Errorf("hard reset did not clear memory")
+	}
+	if c.memory[font_start_addr] != fontset[0] {
+		t.Errorf("hard reset did not reload the font")
+	}
+}
+
+func TestResetAfterExit(t *testing.T) {
+	c := newTestCpu([]byte{0x60, 0x01, 0x00, 0xfd}, WithPlatform(PlatformSCHIP), WithRewind(DefaultRewindBudget))
+	if err := c.StepFrame(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	c.SetRewinding(true)
+
+	c.Reset()
+
+	if c.exited || c.rewinding || c.RewindFrames() != 0 {
+		t.Errorf("reset left the machine exited=%t, rewinding=%t with %d frames of history", c.exited, c.rewinding, c.RewindFrames())
+	}
+	if err := c.StepFrame(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if v0 := c.Registers()[0]; v0 != 1 {
+		t.Errorf("machine did not run after reset, want V0=%d, got=%d", 1, v0)
+	}
+}
+
+func TestResetWhilePaused(t *testing.T) {
+	c := newTestCpu([]byte{0x71, 0x01, 0x12, 0x00})
+	c.Pause()
+	if err := c.Step(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c.Reset()
+
+	if !c.Paused() {
+		t.Errorf("reset resumed a paused machine")
+	}
+	if err := c.Tick(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if v1 := c.Registers()[1]; v1 != 0 {
+		t.Errorf("paused machine ran after reset, want V1=%d, got=%d", 0, v1)
+	}
+	if err := c.Step(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if v1 := c.Registers()[1]; v1 != 1 {
+		t.Errorf("wrong V1 after stepping, want=%d, got=%d", 1, v1)
+	}
+}
@@ -0,0 +1,78 @@
+package chip8
+
+import (
+	"log"
+	"math/rand"
+)
+
+// Option configures a Machine at construction.
+type Option func(*Machine)
+
+// WithClock paces Run with clock rather than a DefaultClockSpeed real-time
+// clock. The caller owns clock and is responsible for stopping it.
+func WithClock(clock Clock) Option {
+	return func(c *Machine) {
+		c.clock = clock
+	}
+}
+
+// WithHost runs the machine inside h.
+func WithHost(h Host) Option {
+	return func(c *Machine) {
+		c.host = h
+	}
+}
+
+// WithRenderer draws the display with r. Input is ignored; use WithHost to
+// supply that as well.
+func WithRenderer(r Renderer) Option {
+	return func(c *Machine) {
+		c.host = &rendererHost{Renderer: r}
+	}
+}
+
+// WithAudio plays the sound timer through a.
+func WithAudio(a Audio) Option {
+	return func(c *Machine) {
+		c.audio = a
+	}
+}
+
+func WithKeyboard(k *Keyboard) Option {
+	return func(c *Machine) {
+		c.keyboard = k
+	}
+}
+
+func WithLogger(l *log.Logger) Option {
+	return func(c *Machine) {
+		c.logger = l
+	}
+}
+
+func WithQuirks(q Quirks) Option {
+	return func(c *Machine) {
+		c.quirks = q
+	}
+}
+
+// WithRand uses r for RND, making runs reproducible when r is seeded
+// deterministically.
+func WithRand(r *rand.Rand) Option {
+	return func(c *Machine) {
+		c.rng = r
+	}
+}
+
+// WithCPUHz runs hz instructions per second, spread across frames. Rates that
+// are not a multiple of FrameRate are honoured on average.
+func WithCPUHz(hz int) Option {
+	return func(c *Machine) {
+		c.hz = hz
+	}
+}
+
+// WithInstructionsPerFrame runs exactly n instructions per frame.
+func WithInstructionsPerFrame(n int) Option {
+	return WithCPUHz(n * FrameRate)
+}
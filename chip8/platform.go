@@ -0,0 +1,85 @@
+package chip8
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Platform is a CHIP-8 variant. It sets how much memory the machine has and
+// the quirks it starts with.
+type Platform int
+
+const (
+	PlatformCHIP8 Platform = iota
+	PlatformSCHIP
+	PlatformXOCHIP
+)
+
+var platformNames = map[Platform]string{
+	PlatformCHIP8:  "chip8",
+	PlatformSCHIP:  "schip",
+	PlatformXOCHIP: "xochip",
+}
+
+func (p Platform) String() string {
+	return platformNames[p]
+}
+
+func (p Platform) memorySize() int {
+	if p == PlatformXOCHIP {
+		return 0x10000
+	}
+	return 0x1000
+}
+
+func (p Platform) quirks() Quirks {
+	switch p {
+	case PlatformSCHIP:
+		return QuirksSCHIP11
+	case PlatformXOCHIP:
+		return QuirksXOCHIP
+	}
+	return Quirks{}
+}
+
+// schip_opcodes are the instructions SUPER-CHIP added, and xochip_opcodes
+// the ones XO-CHIP added on top of those.
+var (
+	schip_opcodes  = []Opcode{SCD, SCR, SCL, EXIT, LOW, HIGH, LDHF, LDRVx, LDVxR}
+	xochip_opcodes = []Opcode{SCU, LDIVxVy, LDVxVyI, LDIL, PLANE, AUDIO, PITCH}
+)
+
+// has reports whether op is one of p's instructions.
+func (p Platform) has(op Opcode) bool {
+	extensions := xochip_opcodes
+	switch p {
+	case PlatformXOCHIP:
+		return true
+	case PlatformCHIP8:
+		extensions = append(schip_opcodes, xochip_opcodes...)
+	}
+	for _, ext := range extensions {
+		if op == ext {
+			return false
+		}
+	}
+	return true
+}
+
+func PlatformByName(name string) (Platform, error) {
+	for p, n := range platformNames {
+		if n == strings.ToLower(name) {
+			return p, nil
+		}
+	}
+	return PlatformCHIP8, fmt.Errorf("unknown platform %q, want chip8, schip or xochip", name)
+}
+
+// WithPlatform sizes memory for p and selects its quirks. Put WithQuirks
+// after it to override them.
+func WithPlatform(p Platform) Option {
+	return func(c *Machine) {
+		c.platform = p
+		c.quirks = p.quirks()
+	}
+}
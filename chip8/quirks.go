@@ -0,0 +1,115 @@
+package chip8
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Quirks selects between the differing interpretations of ambiguous opcodes
+// found on the platforms CHIP-8 has run on. The zero value is modern CHIP-8:
+// shifts work on Vx in place, BNNN jumps from V0, sprites wrap and I is left
+// alone by FX55 and FX65.
+type Quirks struct {
+	// ShiftUsesVy makes 8XY6 and 8XYE shift Vy and store the result in Vx,
+	// as on the COSMAC VIP. Otherwise Vx is shifted in place and Y ignored.
+	ShiftUsesVy bool
+
+	// LoadStore sets what FX55 and FX65 leave in I.
+	LoadStore LoadStoreQuirk
+
+	// JumpUsesVx makes BXNN jump to XNN plus VX rather than plus V0.
+	JumpUsesVx bool
+
+	// ClipSprites cuts sprites off at the edge of the screen instead of
+	// wrapping them round to the other side. The starting coordinate always
+	// wraps.
+	ClipSprites bool
+
+	// LogicResetsVF zeroes VF after 8XY1, 8XY2 and 8XY3.
+	LogicResetsVF bool
+
+	// DisplayWait ends the frame after DXYN, as if the draw waited for
+	// vertical blank, so at most one sprite is drawn per frame.
+	DisplayWait bool
+}
+
+type LoadStoreQuirk int
+
+const (
+	LoadStoreKeepsI      LoadStoreQuirk = iota // I is left unchanged
+	LoadStoreIncrementsI                       // I is left at I+X+1
+	LoadStoreAddsX                             // I is left at I+X
+)
+
+func (q LoadStoreQuirk) increment(x uint8) uint16 {
+	switch q {
+	case LoadStoreIncrementsI:
+		return uint16(x) + 1
+	case LoadStoreAddsX:
+		return uint16(x)
+	}
+	return 0
+}
+
+var (
+	QuirksVIP = Quirks{
+		ShiftUsesVy:   true,
+		LoadStore:     LoadStoreIncrementsI,
+		ClipSprites:   true,
+		LogicResetsVF: true,
+		DisplayWait:   true,
+	}
+
+	QuirksCHIP48 = Quirks{
+		LoadStore:   LoadStoreAddsX,
+		JumpUsesVx:  true,
+		ClipSprites: true,
+	}
+
+	QuirksSCHIP10 = Quirks{
+		LoadStore:   LoadStoreAddsX,
+		JumpUsesVx:  true,
+		ClipSprites: true,
+	}
+
+	QuirksSCHIP11 = Quirks{
+		LoadStore:   LoadStoreKeepsI,
+		JumpUsesVx:  true,
+		ClipSprites: true,
+	}
+
+	QuirksXOCHIP = Quirks{
+		ShiftUsesVy: true,
+		LoadStore:   LoadStoreIncrementsI,
+	}
+)
+
+// QuirkProfiles names the preset Quirks, for selecting one from the command
+// line or a config file.
+var QuirkProfiles = map[string]Quirks{
+	"default":  {},
+	"vip":      QuirksVIP,
+	"chip48":   QuirksCHIP48,
+	"schip1.0": QuirksSCHIP10,
+	"schip1.1": QuirksSCHIP11,
+	"xochip":   QuirksXOCHIP,
+}
+
+func QuirksByName(name string) (Quirks, error) {
+	q, ok := QuirkProfiles[strings.ToLower(name)]
+	if !ok {
+		return Quirks{}, fmt.Errorf("unknown quirk profile %q, want one of %s", name, strings.Join(QuirkProfileNames(), ", "))
+	}
+	return q, nil
+}
+
+// QuirkProfileNames returns the keys of QuirkProfiles in sorted order.
+func QuirkProfileNames() []string {
+	names := make([]string, 0, len(QuirkProfiles))
+	for name := range QuirkProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,147 @@
+package chip8
+
+import "testing"
+
+func TestQuirks(t *testing.T) {
+	tests := []struct {
+		name              string
+		quirks            Quirks
+		program           []byte
+		steps             int
+		expectedRegisters map[int]byte
+		expectedI         uint16
+		expectedPc        uint16
+	}{
+		{
+			"shift in place",
+			Quirks{},
+			[]byte{0x61, 0x03, 0x62, 0x81, 0x81, 0x26},
+			3,
+			map[int]byte{1: 0x01, 0xf: 1},
+			0,
+			0x206,
+		},
+		{
+			"shift Vy",
+			Quirks{ShiftUsesVy: true},
+			[]byte{0x61, 0x03, 0x62, 0x81, 0x81, 0x2e},
+			3,
+			map[int]byte{1: 0x02, 2: 0x81, 0xf: 1},
+			0,
+			0x206,
+		},
+		{
+			"load keeps I",
+			Quirks{},
+			[]byte{0xa3, 0x00, 0xf2, 0x55},
+			2,
+			map[int]byte{},
+			0x300,
+			0x204,
+		},
+		{
+			"load increments I",
+			Quirks{LoadStore: LoadStoreIncrementsI},
+			[]byte{0xa3, 0x00, 0xf2, 0x65},
+			2,
+			map[int]byte{},
+			0x303,
+			0x204,
+		},
+		{
+			"load adds X to I",
+			Quirks{LoadStore: LoadStoreAddsX},
+			[]byte{0xa3, 0x00, 0xf2, 0x55},
+			2,
+			map[int]byte{},
+			0x302,
+			0x204,
+		},
+		{
+			"jump with V0",
+			Quirks{},
+			[]byte{0x60, 0x02, 0x63, 0x04, 0xb3, 0x00},
+			3,
+			map[int]byte{},
+			0,
+			0x302,
+		},
+		{
+			"jump with Vx",
+			Quirks{JumpUsesVx: true},
+			[]byte{0x60, 0x02, 0x63, 0x04, 0xb3, 0x00},
+			3,
+			map[int]byte{},
+			0,
+			0x304,
+		},
+		{
+			"logic keeps VF",
+			Quirks{},
+			[]byte{0x6f, 0x05, 0x81, 0x21},
+			2,
+			map[int]byte{0xf: 5},
+			0,
+			0x204,
+		},
+		{
+			"logic resets VF",
+			Quirks{LogicResetsVF: true},
+			[]byte{0x6f, 0x05, 0x81, 0x23},
+			2,
+			map[int]byte{0xf: 0},
+			0,
+			0x204,
+		},
+	}
+
+	for _, tt := range tests {
+		c := newTestCpu(tt.program, WithQuirks(tt.quirks))
+		if err := c.StepInstructions(tt.steps); err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.name, err)
+		}
+
+		for register, expected := range tt.expectedRegisters {
+			if c.registers[register] != expected {
+				t.Errorf("%s: wrong value in V%X, want=%#x, got=%#x", tt.name, register, expected, c.registers[register])
+			}
+		}
+		if c.index != tt.expectedI {
+			t.Errorf("%s: wrong I, want=%#x, got=%#x", tt.name, tt.expectedI, c.index)
+		}
+		if c.pc != tt.expectedPc {
+			t.Errorf("%s: wrong pc, want=%#x, got=%#x", tt.name, tt.expectedPc, c.pc)
+		}
+	}
+}
+
+func TestDisplayWait(t *testing.T) {
+	// Draw the font's 0 twice in a loop, counting draws in V1.
+	program := []byte{0xa0, 0x50, 0xd0, 0x05, 0x71, 0x01, 0x12, 0x02}
+
+	c := newTestCpu(program, WithInstructionsPerFrame(20))
+	c.RunFrames(1)
+	if c.registers[1] < 2 {
+		t.Errorf("expected several draws per frame without display wait, got %d", c.registers[1])
+	}
+
+	c = newTestCpu(program, WithInstructionsPerFrame(20), WithQuirks(Quirks{DisplayWait: true}))
+	c.RunFrames(3)
+	if c.registers[1] != 2 {
+		t.Errorf("expected one draw per frame with display wait, want=%d, got=%d", 2, c.registers[1])
+	}
+}
+
+func TestQuirksByName(t *testing.T) {
+	q, err := QuirksByName("VIP")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if q != QuirksVIP {
+		t.Errorf("wrong quirks for vip, got=%+v", q)
+	}
+
+	if _, err := QuirksByName("pdp-11"); err == nil {
+		t.Errorf("expected error for unknown profile")
+	}
+}
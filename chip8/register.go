@@ -0,0 +1,107 @@
+package chip8
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Register names one of the machine's registers for the debugger: V0 to VF,
+// then I, the two timers, the program counter and the stack pointer.
+type Register int
+
+const (
+	V0 Register = iota
+	V1
+	V2
+	V3
+	V4
+	V5
+	V6
+	V7
+	V8
+	V9
+	VA
+	VB
+	VC
+	VD
+	VE
+	VF
+	RegI
+	RegDT
+	RegST
+	RegPC
+	RegSP
+
+	register_count = int(RegSP) + 1
+)
+
+var register_names = [register_count]string{
+	"V0", "V1", "V2", "V3", "V4", "V5", "V6", "V7",
+	"V8", "V9", "VA", "VB", "VC", "VD", "VE", "VF",
+	"I", "DT", "ST", "PC", "SP",
+}
+
+func (r Register) String() string {
+	if r < 0 || int(r) >= register_count {
+		return fmt.Sprintf("Register(%d)", int(r))
+	}
+	return register_names[r]
+}
+
+// RegisterByName looks a register up by name, ignoring case.
+func RegisterByName(name string) (Register, error) {
+	for r, n := range register_names {
+		if strings.EqualFold(n, name) {
+			return Register(r), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown register %q", name)
+}
+
+// Register reads any register, widened to 16 bits.
+func (c *Machine) Register(r Register) uint16 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.register(r)
+}
+
+// SetRegister writes any register. Values too wide for it are truncated.
+func (c *Machine) SetRegister(r Register, value uint16) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case r >= V0 && r <= VF:
+		c.registers[r] = byte(value)
+	case r == RegI:
+		c.index = value
+	case r == RegDT:
+		c.delay = byte(value)
+	case r == RegST:
+		c.sound = byte(value)
+	case r == RegPC:
+		c.pc = value
+	case r == RegSP:
+		if int(value) <= len(c.stack) {
+			c.sp = uint8(value)
+		}
+	}
+}
+
+func (c *Machine) register(r Register) uint16 {
+	switch {
+	case r >= V0 && r <= VF:
+		return uint16(c.registers[r])
+	case r == RegI:
+		return c.index
+	case r == RegDT:
+		return uint16(c.delay)
+	case r == RegST:
+		return uint16(c.sound)
+	case r == RegPC:
+		return c.pc
+	case r == RegSP:
+		return uint16(c.sp)
+	}
+	return 0
+}
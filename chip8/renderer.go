@@ -1,10 +1,15 @@
 package chip8
 
-import "github.com/veandco/go-sdl2/sdl"
-
-type renderer interface {
+import (
+	"fmt"
+	"image/color"
+	"strconv"
+	"strings"
+)
+
+type Renderer interface {
 	Close()
-	Render(d *display) error
+	Render(d *Display) error
 }
 
 type nullRenderer struct{}
@@ -17,10 +22,41 @@ func (n *nullRenderer) Close() {
 
 }
 
-func (n *nullRenderer) Render(d *display) error {
+func (n *nullRenderer) Render(d *Display) error {
 	return nil
 }
 
+// Palette maps a pixel's Colour to the colour it is drawn in: background,
+// plane 1, plane 2, then both planes.
+type Palette [4]color.RGBA
+
+var DefaultPalette = Palette{
+	{0x00, 0x00, 0x00, 0xff},
+	{0xff, 0xff, 0xff, 0xff},
+	{0xaa, 0xaa, 0xaa, 0xff},
+	{0x55, 0x55, 0x55, 0xff},
+}
+
+// ParsePalette reads four comma separated RRGGBB hex colours, e.g.
+// "000000,ffffff,aaaaaa,555555".
+func ParsePalette(s string) (Palette, error) {
+	var p Palette
+	parts := strings.Split(s, ",")
+	if len(parts) != len(p) {
+		return p, fmt.Errorf("palette needs %d colours, got %d", len(p), len(parts))
+	}
+
+	for i, part := range parts {
+		part = strings.TrimPrefix(strings.TrimSpace(part), "#")
+		rgb, err := strconv.ParseUint(part, 16, 32)
+		if err != nil || len(part) != 6 {
+			return p, fmt.Errorf("bad palette colour %q", part)
+		}
+		p[i] = color.RGBA{byte(rgb >> 16), byte(rgb >> 8), byte(rgb), 0xff}
+	}
+	return p, nil
+}
+
 // type termboxRenderer struct {
 // 	fg, bg termbox.Attribute
 // }
@@ -49,11 +85,11 @@ func (n *nullRenderer) Render(d *display) error {
 // 	termbox.Close()
 // }
 
-// func (t *termboxRenderer) Render(d *display) error {
+// func (t *termboxRenderer) Render(d *Display) error {
 // 	d.EachPixel(func(x, y uint16, addr int) {
 // 		v := ' '
 
-// 		if d.pixels[addr] {
+// 		if d.Lit(addr) {
 // 			v = '█'
 // 		}
 
@@ -68,42 +104,3 @@ func (n *nullRenderer) Render(d *display) error {
 
 // 	return termbox.Flush()
 // }
-
-type sdlRenderer struct {
-	window        *sdl.Window
-	renderer      *sdl.Renderer
-	texture       *sdl.Texture
-	width, height int32
-}
-
-func NewSdlRenderer(width, height int32, w *sdl.Window, renderer *sdl.Renderer, texture *sdl.Texture) *sdlRenderer {
-	return &sdlRenderer{width: width, height: height, window: w, renderer: renderer, texture: texture}
-}
-
-func (s *sdlRenderer) Close() {
-
-}
-
-func (s *sdlRenderer) Render(d *display) error {
-	pixels := make([]byte, d.height*d.width*4)
-	d.EachPixel(func(x, y uint16, addr int) {
-		index := (int(y)*d.width + int(x)) * 4
-		if index < len(pixels)-4 && index >= 0 {
-			if d.pixels[addr] {
-
-				pixels[index] = 0xff
-				pixels[index+1] = 0xff
-				pixels[index+2] = 0xff
-
-			}
-		}
-	})
-	s.texture.Update(nil, pixels, int(d.width)*4)
-	src := sdl.Rect{0, 0, int32(d.width), int32(d.height)}
-	dst := sdl.Rect{0, 0, s.width, s.height}
-	s.renderer.Clear()
-	s.renderer.Copy(s.texture, &src, &dst)
-	s.renderer.Present()
-
-	return nil
-}
@@ -0,0 +1,193 @@
+package chip8
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+const (
+	// DefaultRewindBudget is enough for around half an hour of a typical
+	// CHIP-8 game.
+	DefaultRewindBudget = 16 << 20
+
+	keyframe_interval = 60 // frames between full snapshots
+)
+
+// WithRewind keeps up to budget bytes of per-frame history that the machine
+// can be rewound through. Zero or less turns rewinding off, which is the
+// default.
+func WithRewind(budget int) Option {
+	return func(c *Machine) {
+		if budget <= 0 {
+			c.history = nil
+			return
+		}
+		c.history = &rewindBuffer{budget: budget}
+	}
+}
+
+// SetRewinding makes each tick step back a frame rather than run one, until
+// it is called again with false. When the history runs out the machine waits
+// where it is. It does nothing unless the machine was made WithRewind.
+func (c *Machine) SetRewinding(on bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rewinding = on && c.history != nil
+}
+
+func (c *Machine) Rewinding() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rewinding
+}
+
+// StepBack restores the machine to how it was one frame earlier, reporting
+// false if there is no history left. Held keys are left as they are now.
+func (c *Machine) StepBack() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stepBack()
+}
+
+// RewindFrames is how many frames back the history reaches.
+func (c *Machine) RewindFrames() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.history == nil {
+		return 0
+	}
+	return c.history.frames()
+}
+
+func (c *Machine) stepBack() bool {
+	if c.history == nil {
+		return false
+	}
+	state := c.history.pop()
+	if state == nil {
+		return false
+	}
+
+	pressed := c.keyboard.pressed
+	if err := c.restore(state); err != nil {
+		// Only states this machine snapshotted are in the history.
+		panic(err)
+	}
+	c.keyboard.pressed = pressed
+	return true
+}
+
+// clearHistory forgets the history, which no longer applies once the ROM
+// changes.
+func (c *Machine) clearHistory() {
+	if c.history != nil {
+		c.history = &rewindBuffer{budget: c.history.budget}
+	}
+}
+
+// rewindBuffer holds the machine's recent history as segments, each a full
+// snapshot followed by the frames after it stored as differences from that
+// snapshot. Once the history is over budget the oldest segment is dropped.
+type rewindBuffer struct {
+	budget   int
+	size     int
+	segments []*rewindSegment
+}
+
+type rewindSegment struct {
+	key    []byte
+	deltas [][]byte
+	size   int
+}
+
+func (b *rewindBuffer) push(state []byte) {
+	var last *rewindSegment
+	if len(b.segments) > 0 {
+		last = b.segments[len(b.segments)-1]
+	}
+
+	var added int
+	if last == nil || len(last.deltas) >= keyframe_interval-1 || len(last.key) != len(state) {
+		b.segments = append(b.segments, &rewindSegment{key: state, size: len(state)})
+		added = len(state)
+	} else {
+		delta := diff(last.key, state)
+		last.deltas = append(last.deltas, delta)
+		last.size += len(delta)
+		added = len(delta)
+	}
+	b.size += added
+
+	for b.size > b.budget && len(b.segments) > 1 {
+		b.size -= b.segments[0].size
+		b.segments[0] = nil
+		b.segments = b.segments[1:]
+	}
+}
+
+// pop removes and returns the newest state, or nil if there are none.
+func (b *rewindBuffer) pop() []byte {
+	if len(b.segments) == 0 {
+		return nil
+	}
+	last := b.segments[len(b.segments)-1]
+
+	if n := len(last.deltas); n > 0 {
+		delta := last.deltas[n-1]
+		last.deltas = last.deltas[:n-1]
+		last.size -= len(delta)
+		b.size -= len(delta)
+		return patch(last.key, delta)
+	}
+
+	b.segments = b.segments[:len(b.segments)-1]
+	b.size -= last.size
+	return last.key
+}
+
+func (b *rewindBuffer) frames() int {
+	n := 0
+	for _, s := range b.segments {
+		n += 1 + len(s.deltas)
+	}
+	return n
+}
+
+// diff encodes state as the runs of bytes that differ from key, each run
+// written as the uvarint count of unchanged bytes before it, its uvarint
+// length and then its bytes. The two must be the same length.
+func diff(key, state []byte) []byte {
+	var buf bytes.Buffer
+	var scratch [binary.MaxVarintLen64]byte
+	last := 0
+	for i := 0; i < len(state); {
+		if state[i] == key[i] {
+			i++
+			continue
+		}
+		start := i
+		for i < len(state) && state[i] != key[i] {
+			i++
+		}
+		buf.Write(scratch[:binary.PutUvarint(scratch[:], uint64(start-last))])
+		buf.Write(scratch[:binary.PutUvarint(scratch[:], uint64(i-start))])
+		buf.Write(state[start:i])
+		last = i
+	}
+	return buf.Bytes()
+}
+
+// patch applies a diff to a copy of key.
+func patch(key, delta []byte) []byte {
+	state := append([]byte(nil), key...)
+	r := bytes.NewReader(delta)
+	at := 0
+	for r.Len() > 0 {
+		skip, _ := binary.ReadUvarint(r)
+		n, _ := binary.ReadUvarint(r)
+		at += int(skip)
+		r.Read(state[at : at+int(n)])
+		at += int(n)
+	}
+	return state
+}
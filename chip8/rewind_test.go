@@ -0,0 +1,115 @@
+package chip8
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRewind(t *testing.T) {
+	c := newTestCpu(counter, WithRewind(DefaultRewindBudget))
+	c.registers[5] = 200
+
+	var seen [][16]byte
+	for i := 0; i < 150; i++ {
+		seen = append(seen, c.Registers())
+		if err := c.RunFrames(1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := c.RewindFrames(); got != 150 {
+		t.Fatalf("history frames, want=%d, got=%d", 150, got)
+	}
+
+	c.SetRewinding(true)
+	if err := c.RunFrames(10); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Registers(); got != seen[140] {
+		t.Errorf("after rewinding 10 frames, want=%v, got=%v", seen[140], got)
+	}
+	c.SetRewinding(false)
+
+	for i := 139; i >= 0; i-- {
+		if !c.StepBack() {
+			t.Fatalf("history ran out at frame %d", i)
+		}
+		if got := c.Registers(); got != seen[i] {
+			t.Fatalf("frame %d, want=%v, got=%v", i, seen[i], got)
+		}
+	}
+	if c.StepBack() {
+		t.Errorf("stepped back past the start of the history")
+	}
+	if c.PC() != program_start_addr {
+		t.Errorf("pc, want=%d, got=%d", program_start_addr, c.PC())
+	}
+}
+
+func TestLoadStateDropsHistory(t *testing.T) {
+	c := newTestCpu(counter, WithRewind(DefaultRewindBudget))
+	var saved bytes.Buffer
+	if err := c.SaveState(&saved); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RunFrames(10); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.LoadState(&saved); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.RewindFrames(); got != 0 {
+		t.Errorf("history frames after loading a state, want=%d, got=%d", 0, got)
+	}
+	if c.StepBack() {
+		t.Errorf("stepped back past the loaded state")
+	}
+}
+
+func TestRewindStaysWithinBudget(t *testing.T) {
+	budget := 64 << 10
+	c := newTestCpu(counter, WithRewind(budget))
+	if err := c.RunFrames(2000); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.history.size > budget {
+		t.Errorf("history size, want<=%d, got=%d", budget, c.history.size)
+	}
+	frames := c.RewindFrames()
+	if frames == 0 || frames >= 2000 {
+		t.Errorf("history frames, want some but not all, got=%d", frames)
+	}
+	for i := 0; i < frames; i++ {
+		if !c.StepBack() {
+			t.Fatalf("history ran out after %d of %d frames", i, frames)
+		}
+	}
+}
+
+func TestRewindDisabled(t *testing.T) {
+	c := newTestCpu(counter)
+	if err := c.RunFrames(5); err != nil {
+		t.Fatal(err)
+	}
+	c.SetRewinding(true)
+	if c.Rewinding() || c.StepBack() {
+		t.Errorf("rewinding a machine without history")
+	}
+}
+
+func TestDiffAndPatch(t *testing.T) {
+	key := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	tests := [][]byte{
+		{1, 2, 3, 4, 5, 6, 7, 8},
+		{0, 2, 3, 4, 5, 6, 7, 0},
+		{1, 9, 9, 4, 5, 9, 7, 8},
+		{8, 7, 6, 5, 4, 3, 2, 1},
+	}
+
+	for _, state := range tests {
+		if got := patch(key, diff(key, state)); !bytes.Equal(got, state) {
+			t.Errorf("round trip, want=%v, got=%v", state, got)
+		}
+	}
+}
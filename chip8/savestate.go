@@ -0,0 +1,251 @@
+package chip8
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+)
+
+// A save state is, in big-endian order:
+//
+//	magic      "C8SS"
+//	version    uint16
+//	platform   uint8
+//	rom        [20]byte, SHA-1 of the loaded ROM
+//	cpu        machineState
+//	display    hires, planes, then the pixel bytes prefixed by a uint32 count
+//	keyboard   keyboardState
+//	memory     the address space prefixed by a uint32 count
+//	checksum   uint32, CRC-32 (IEEE) of everything before it
+//
+// The version goes up whenever the layout changes; LoadState refuses versions
+// it does not know.
+const (
+	state_magic        = "C8SS"
+	state_version      = 1
+	state_header_bytes = len(state_magic) + 2 + 1 + sha1.Size
+)
+
+var (
+	ErrNotSaveState   = errors.New("not a save state")
+	ErrStateVersion   = errors.New("unsupported save state version")
+	ErrStateChecksum  = errors.New("save state checksum mismatch")
+	ErrStateROM       = errors.New("save state is for a different ROM")
+	ErrStatePlatform  = errors.New("save state is for a different platform")
+	ErrStateMalformed = errors.New("malformed save state")
+)
+
+type stateHeader struct {
+	Magic    [4]byte
+	Version  uint16
+	Platform uint8
+	ROM      [sha1.Size]byte
+}
+
+type machineState struct {
+	Registers    [16]byte
+	Index        uint16
+	Delay        byte
+	Sound        byte
+	Stack        [16]uint16
+	PC           uint16
+	SP           uint8
+	Cycles       int32
+	VblankWait   bool
+	Exited       bool
+	RPL          [16]byte
+	AudioPattern [16]byte
+	HasPattern   bool
+	Pitch        byte
+}
+
+type displayState struct {
+	HighRes bool
+	Planes  byte
+}
+
+type keyboardState struct {
+	Pressed      [16]bool
+	Waiting      bool
+	WaitPressed  [16]bool
+	WaitReleased int8
+}
+
+// SaveState writes the whole machine - memory, registers, I, timers, stack,
+// display and keypad - to w. Configuration such as quirks and CPU speed is not
+// part of the state.
+func (c *Machine) SaveState(w io.Writer) error {
+	c.mu.Lock()
+	state := c.snapshot()
+	c.mu.Unlock()
+
+	_, err := w.Write(state)
+	return err
+}
+
+// LoadState restores a state written by SaveState. It is refused, leaving the
+// machine untouched, if it is corrupt, from another version, or was saved
+// with a different ROM or platform. The rewind history is dropped, as it
+// leads up to where the machine was, not to the loaded state.
+func (c *Machine) LoadState(r io.Reader) error {
+	state, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.restore(state); err != nil {
+		return err
+	}
+	c.clearHistory()
+	return nil
+}
+
+// snapshot encodes the machine state. The caller holds the lock.
+func (c *Machine) snapshot() []byte {
+	var buf bytes.Buffer
+	buf.Grow(state_header_bytes + len(c.memory) + len(c.d.pixels) + 128)
+
+	header := stateHeader{Version: state_version, Platform: uint8(c.platform), ROM: c.rom}
+	copy(header.Magic[:], state_magic)
+	write := func(v interface{}) {
+		// Writes to a bytes.Buffer cannot fail.
+		_ = binary.Write(&buf, binary.BigEndian, v)
+	}
+
+	write(header)
+	write(machineState{
+		Registers:    c.registers,
+		Index:        c.index,
+		Delay:        c.delay,
+		Sound:        c.sound,
+		Stack:        c.stack,
+		PC:           c.pc,
+		SP:           c.sp,
+		Cycles:       int32(c.cycles),
+		VblankWait:   c.vblankWait,
+		Exited:       c.exited,
+		RPL:          c.rpl,
+		AudioPattern: c.audioPattern,
+		HasPattern:   c.hasPattern,
+		Pitch:        c.pitch,
+	})
+	write(displayState{HighRes: c.d.hires, Planes: c.d.planes})
+	write(uint32(len(c.d.pixels)))
+	buf.Write(c.d.pixels)
+	k := c.keyboard
+	write(keyboardState{
+		Pressed:      k.pressed,
+		Waiting:      k.waiting,
+		WaitPressed:  k.waitPressed,
+		WaitReleased: int8(k.waitReleased),
+	})
+	write(uint32(len(c.memory)))
+	buf.Write(c.memory)
+	write(crc32.ChecksumIEEE(buf.Bytes()))
+
+	return buf.Bytes()
+}
+
+// restore applies an encoded state, checking all of it before changing
+// anything. The caller holds the lock.
+func (c *Machine) restore(state []byte) error {
+	if len(state) < state_header_bytes+4 || string(state[:len(state_magic)]) != state_magic {
+		return ErrNotSaveState
+	}
+	body, sum := state[:len(state)-4], binary.BigEndian.Uint32(state[len(state)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return ErrStateChecksum
+	}
+
+	r := bytes.NewReader(body)
+	var header stateHeader
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return ErrStateMalformed
+	}
+	if header.Version != state_version {
+		return fmt.Errorf("%w %d, want %d", ErrStateVersion, header.Version, state_version)
+	}
+	if Platform(header.Platform) != c.platform {
+		return fmt.Errorf("%w: saved on %s, running %s", ErrStatePlatform, Platform(header.Platform), c.platform)
+	}
+	if header.ROM != c.rom {
+		return ErrStateROM
+	}
+
+	var (
+		m machineState
+		d displayState
+		k keyboardState
+	)
+	if binary.Read(r, binary.BigEndian, &m) != nil ||
+		binary.Read(r, binary.BigEndian, &d) != nil {
+		return ErrStateMalformed
+	}
+	pixels, err := readCounted(r)
+	if err != nil {
+		return err
+	}
+	if binary.Read(r, binary.BigEndian, &k) != nil {
+		return ErrStateMalformed
+	}
+	memory, err := readCounted(r)
+	if err != nil {
+		return err
+	}
+	if r.Len() != 0 || len(memory) != len(c.memory) || int(m.SP) > len(m.Stack) {
+		return ErrStateMalformed
+	}
+	display_width, display_height := width, height
+	if d.HighRes {
+		display_width, display_height = hires_width, hires_height
+	}
+	if len(pixels) != display_width*display_height {
+		return ErrStateMalformed
+	}
+
+	c.registers = m.Registers
+	c.index = m.Index
+	c.delay = m.Delay
+	c.sound = m.Sound
+	c.stack = m.Stack
+	c.pc = m.PC
+	c.sp = m.SP
+	c.cycles = int(m.Cycles)
+	c.vblankWait = m.VblankWait
+	c.exited = m.Exited
+	c.rpl = m.RPL
+	c.audioPattern = m.AudioPattern
+	c.hasPattern = m.HasPattern
+	c.pitch = m.Pitch
+
+	c.d.SetHighRes(d.HighRes)
+	c.d.SelectPlanes(d.Planes)
+	copy(c.d.pixels, pixels)
+	c.d.isDirty = true
+
+	c.keyboard.pressed = k.Pressed
+	c.keyboard.waiting = k.Waiting
+	c.keyboard.waitPressed = k.WaitPressed
+	c.keyboard.waitReleased = int(k.WaitReleased)
+
+	copy(c.memory, memory)
+	return nil
+}
+
+// readCounted reads a uint32 length followed by that many bytes.
+func readCounted(r *bytes.Reader) ([]byte, error) {
+	var n uint32
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil || int64(n) > int64(r.Len()) {
+		return nil, ErrStateMalformed
+	}
+	b := make([]byte, n)
+	_, _ = r.Read(b)
+	return b, nil
+}
@@ -0,0 +1,118 @@
+package chip8
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"testing"
+)
+
+// counter draws a digit, sets the delay timer and then counts V0 up forever
+// through a subroutine.
+var counter = []byte{
+	0x60, 0x00, // 200: LD V0, 0
+	0xA0, 0x50, // 202: LD I, font
+	0xD1, 0x15, // 204: DRW V1, V1, 5
+	0xF5, 0x15, // 206: LD DT, V5
+	0x22, 0x0C, // 208: CALL 20C
+	0x12, 0x08, // 20A: JP 208
+	0x70, 0x01, // 20C: ADD V0, 1
+	0x00, 0xEE, // 20E: RET
+}
+
+func TestSaveAndLoadState(t *testing.T) {
+	c := newTestCpu(counter)
+	c.registers[5] = 30
+	c.keyboard.Press(0x7)
+	if err := c.RunFrames(3); err != nil {
+		t.Fatal(err)
+	}
+
+	var saved bytes.Buffer
+	if err := c.SaveState(&saved); err != nil {
+		t.Fatal(err)
+	}
+	registers, pc, sp, delay, memory := c.Registers(), c.PC(), c.SP(), c.Delay(), c.Memory()
+	pixels := append([]byte(nil), c.d.pixels...)
+
+	c.keyboard.Release(0x7)
+	c.d.SetHighRes(true)
+	c.write(0x300, 0xff)
+	if err := c.RunFrames(5); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.LoadState(bytes.NewReader(saved.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if c.Registers() != registers {
+		t.Errorf("registers, want=%v, got=%v", registers, c.Registers())
+	}
+	if c.PC() != pc || c.SP() != sp || c.Delay() != delay {
+		t.Errorf("pc/sp/delay, want=%d/%d/%d, got=%d/%d/%d", pc, sp, delay, c.PC(), c.SP(), c.Delay())
+	}
+	if !bytes.Equal(c.Memory(), memory) {
+		t.Errorf("memory was not restored")
+	}
+	if c.d.HighRes() || !bytes.Equal(c.d.pixels, pixels) {
+		t.Errorf("display was not restored")
+	}
+	if !c.keyboard.IsPressed(0x7) {
+		t.Errorf("key 7 should be held after restoring")
+	}
+
+	// A machine loaded with the same ROM accepts the state too.
+	other := newTestCpu(counter)
+	if err := other.LoadState(bytes.NewReader(saved.Bytes())); err != nil {
+		t.Errorf("loading into a fresh machine: %s", err)
+	}
+	if other.Registers() != registers {
+		t.Errorf("fresh machine registers, want=%v, got=%v", registers, other.Registers())
+	}
+}
+
+// resum fixes up the checksum of a state that has been edited.
+func resum(state []byte) []byte {
+	body := state[:len(state)-4]
+	binary.BigEndian.PutUint32(state[len(state)-4:], crc32.ChecksumIEEE(body))
+	return state
+}
+
+func TestLoadStateRefusesMismatches(t *testing.T) {
+	var saved bytes.Buffer
+	if err := newTestCpu(counter).SaveState(&saved); err != nil {
+		t.Fatal(err)
+	}
+	state := saved.Bytes()
+	edit := func(fn func(s []byte)) []byte {
+		s := append([]byte(nil), state...)
+		fn(s)
+		return s
+	}
+
+	tests := []struct {
+		name    string
+		machine *Machine
+		state   []byte
+		want    error
+	}{
+		{"garbage", newTestCpu(counter), []byte("hello"), ErrNotSaveState},
+		{"corrupt", newTestCpu(counter), edit(func(s []byte) { s[100] ^= 1 }), ErrStateChecksum},
+		{"truncated", newTestCpu(counter), resum(edit(func(s []byte) {})[:200]), ErrStateMalformed},
+		{"version", newTestCpu(counter), resum(edit(func(s []byte) { s[5] = 99 })), ErrStateVersion},
+		{"other rom", newTestCpu([]byte{0x12, 0x00}), state, ErrStateROM},
+		{"other platform", newTestCpu(counter, WithPlatform(PlatformXOCHIP)), state, ErrStatePlatform},
+	}
+
+	for _, test := range tests {
+		before := test.machine.Memory()
+		err := test.machine.LoadState(bytes.NewReader(test.state))
+		if !errors.Is(err, test.want) {
+			t.Errorf("%s: want=%v, got=%v", test.name, test.want, err)
+		}
+		if !bytes.Equal(test.machine.Memory(), before) {
+			t.Errorf("%s: refused state changed memory", test.name)
+		}
+	}
+}
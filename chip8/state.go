@@ -0,0 +1,131 @@
+package chip8
+
+// The accessors below take the machine's lock, so they are safe to call while
+// Run is going on another goroutine.
+
+// Registers returns a copy of V0 to VF.
+func (c *Machine) Registers() [16]byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.registers
+}
+
+// I returns the index register.
+func (c *Machine) I() uint16 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.index
+}
+
+func (c *Machine) PC() uint16 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pc
+}
+
+func (c *Machine) SP() uint8 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sp
+}
+
+// Stack returns a copy of the occupied part of the stack, oldest entry first.
+func (c *Machine) Stack() []uint16 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stack := make([]uint16, c.sp)
+	copy(stack, c.stack[:c.sp])
+	return stack
+}
+
+// Delay returns the delay timer.
+func (c *Machine) Delay() byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.delay
+}
+
+// Sound returns the sound timer.
+func (c *Machine) Sound() byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sound
+}
+
+// Memory returns a copy of the whole address space.
+func (c *Machine) Memory() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	memory := make([]byte, len(c.memory))
+	copy(memory, c.memory[:])
+	return memory
+}
+
+// WriteMemory stores data at addr, wrapping at the end of memory.
+func (c *Machine) WriteMemory(addr uint16, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, b := range data {
+		c.memory[int(addr+uint16(i))&(len(c.memory)-1)] = b
+	}
+}
+
+// Display returns the machine's display. It is updated in place by a running
+// machine, so reading it from another goroutine should happen while paused.
+func (c *Machine) Display() *Display {
+	return c.d
+}
+
+func (c *Machine) Keyboard() *Keyboard {
+	return c.keyboard
+}
+
+func (c *Machine) Quirks() Quirks {
+	return c.quirks
+}
+
+func (c *Machine) Platform() Platform {
+	return c.platform
+}
+
+// AudioPattern returns the XO-CHIP audio pattern buffer and pitch, and whether
+// the program has loaded a pattern at all. Without one the sound timer plays a
+// plain tone.
+func (c *Machine) AudioPattern() (pattern [16]byte, pitch byte, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.audioPattern, c.pitch, c.hasPattern
+}
+
+// MachineState is the cpu state and memory, as State reads them.
+type MachineState struct {
+	Registers [16]byte
+	I, PC     uint16
+	SP        uint8
+	Stack     []uint16 // the occupied part, oldest entry first
+	Delay     byte
+	Sound     byte
+	Memory    []byte
+	Paused    bool
+}
+
+// State reads the cpu state and a copy of memory under one lock, so they all
+// come from between the same two instructions of a running machine.
+func (c *Machine) State() MachineState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := MachineState{
+		Registers: c.registers,
+		I:         c.index,
+		PC:        c.pc,
+		SP:        c.sp,
+		Stack:     make([]uint16, c.sp),
+		Delay:     c.delay,
+		Sound:     c.sound,
+		Memory:    make([]byte, len(c.memory)),
+		Paused:    c.paused,
+	}
+	copy(s.Stack, c.stack[:c.sp])
+	copy(s.Memory, c.memory)
+	return s
+}
@@ -0,0 +1,219 @@
+package chip8
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A Tracer writes what a machine does, one event to a line, for reading or
+// for diffing against a trace of the same rom from another run or emulator.
+// Each line is the event's name and then space separated key=value fields,
+// values in hex. Anything after a semicolon is a comment:
+//
+//	ins pc=0204 op=2208 v0=05 v1=00 ... vf=00 i=0300 sp=0 dt=00 st=00 ; CALL draw
+//	call pc=0204 to=0208 sp=1
+//	draw pc=0208 x=05 y=00 n=5 i=0300 vf=0
+//	ret pc=020A to=0206 sp=0
+//	mem pc=0210 addr=0300 val=05
+//	timer dt=3B st=00
+//	key down=5
+//
+// An ins line is written before the instruction runs, so it holds the state
+// the instruction sees. call, ret, draw and mem are written after.
+type Tracer struct {
+	Events TraceEvent
+
+	// Ranges limits ins, call, ret, draw and mem events to instructions at
+	// these addresses. Empty means everywhere.
+	Ranges []AddrRange
+
+	// Describe, if set, writes the instruction at pc in an ins line's
+	// comment.
+	Describe func(pc uint16, ins []byte) string
+
+	w   io.Writer
+	err error
+	pc  uint16 // the instruction running
+}
+
+// TraceEvent is a set of events to trace.
+type TraceEvent uint8
+
+const (
+	TraceInstruction TraceEvent = 1 << iota // each instruction run
+	TraceDraw                               // sprites drawn
+	TraceKey                                // keypad keys pressed and let go
+	TraceTimer                              // each frame the timers count down
+	TraceStack                              // subroutine calls and returns
+	TraceMemory                             // bytes written to memory
+
+	TraceAll = TraceInstruction | TraceDraw | TraceKey | TraceTimer | TraceStack | TraceMemory
+)
+
+var traceEventNames = map[string]TraceEvent{
+	"ins":   TraceInstruction,
+	"draw":  TraceDraw,
+	"key":   TraceKey,
+	"timer": TraceTimer,
+	"stack": TraceStack,
+	"mem":   TraceMemory,
+	"all":   TraceAll,
+}
+
+// TraceEventNames returns the names ParseTraceEvents takes, sorted.
+func TraceEventNames() []string {
+	names := make([]string, 0, len(traceEventNames))
+	for name := range traceEventNames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseTraceEvents reads a comma separated list of event names, such as
+// "ins,stack".
+func ParseTraceEvents(s string) (TraceEvent, error) {
+	var events TraceEvent
+	for _, name := range strings.Split(s, ",") {
+		e, ok := traceEventNames[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return 0, fmt.Errorf("unknown trace event %q, want some of %s", name, strings.Join(TraceEventNames(), ", "))
+		}
+		events |= e
+	}
+	return events, nil
+}
+
+// AddrRange is the addresses from From to To, inclusive.
+type AddrRange struct {
+	From, To uint16
+}
+
+// ParseAddrRange reads a range of hex addresses, "200-2FF", or a single
+// address.
+func ParseAddrRange(s string) (AddrRange, error) {
+	from, to := s, s
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		from, to = s[:i], s[i+1:]
+	}
+	var r AddrRange
+	var err error
+	if r.From, err = parseHex16(from); err != nil {
+		return r, err
+	}
+	if r.To, err = parseHex16(to); err != nil {
+		return r, err
+	}
+	if r.To < r.From {
+		return r, fmt.Errorf("bad address range %q: it ends before it starts", s)
+	}
+	return r, nil
+}
+
+func parseHex16(s string) (uint16, error) {
+	s = strings.TrimSpace(s)
+	n, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(s), "0x"), 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("bad address %q", s)
+	}
+	return uint16(n), nil
+}
+
+// NewTracer returns a Tracer writing events to w.
+func NewTracer(w io.Writer, events TraceEvent) *Tracer {
+	return &Tracer{Events: events, w: w}
+}
+
+// WithTracer traces the machine with t. Machines are not traced by default.
+func WithTracer(t *Tracer) Option {
+	return func(c *Machine) {
+		c.tracer = t
+	}
+}
+
+// Err is the first error writing the trace. Tracing stops after one.
+func (t *Tracer) Err() error {
+	return t.err
+}
+
+// wants reports whether t traces event e at pc. A nil Tracer traces nothing.
+func (t *Tracer) wants(e TraceEvent, pc uint16) bool {
+	if t == nil || t.err != nil || t.Events&e == 0 {
+		return false
+	}
+	if len(t.Ranges) == 0 || e == TraceKey || e == TraceTimer {
+		return true
+	}
+	for _, r := range t.Ranges {
+		if r.From <= pc && pc <= r.To {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Tracer) printf(format string, args ...interface{}) {
+	if _, err := fmt.Fprintf(t.w, format, args...); err != nil {
+		t.err = err
+	}
+}
+
+func (t *Tracer) instruction(c *Machine, pc uint16, ins Instructions) {
+	if t != nil {
+		t.pc = pc
+	}
+	if !t.wants(TraceInstruction, pc) {
+		return
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "ins pc=%04X op=%X", pc, []byte(ins))
+	for i, v := range c.registers {
+		fmt.Fprintf(&b, " v%x=%02X", i, v)
+	}
+	fmt.Fprintf(&b, " i=%04X sp=%X dt=%02X st=%02X", c.index, c.sp, c.delay, c.sound)
+	if t.Describe != nil {
+		if text := t.Describe(pc, ins); text != "" {
+			b.WriteString(" ; " + text)
+		}
+	}
+	t.printf("%s\n", b.String())
+}
+
+func (t *Tracer) draw(pc uint16, x, y byte, n int, index uint16, collision bool) {
+	if t.wants(TraceDraw, pc) {
+		t.printf("draw pc=%04X x=%02X y=%02X n=%X i=%04X vf=%d\n", pc, x, y, n, index, flag(collision))
+	}
+}
+
+func (t *Tracer) memory(addr uint16, value byte) {
+	if t != nil && t.wants(TraceMemory, t.pc) {
+		t.printf("mem pc=%04X addr=%04X val=%02X\n", t.pc, addr, value)
+	}
+}
+
+// stack traces a call or ret at pc, which went to addr leaving the stack
+// sp deep.
+func (t *Tracer) stack(event string, pc, addr uint16, sp uint8) {
+	if t.wants(TraceStack, pc) {
+		t.printf("%s pc=%04X to=%04X sp=%X\n", event, pc, addr, sp)
+	}
+}
+
+func (t *Tracer) timer(delay, sound byte) {
+	if t.wants(TraceTimer, 0) {
+		t.printf("timer dt=%02X st=%02X\n", delay, sound)
+	}
+}
+
+func (t *Tracer) key(key byte, down bool) {
+	if t.wants(TraceKey, 0) {
+		state := "up"
+		if down {
+			state = "down"
+		}
+		t.printf("key %s=%X\n", state, key)
+	}
+}
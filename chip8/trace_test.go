@@ -0,0 +1,144 @@
+package chip8
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+var trace_program = []byte{
+	0x60, 0x05, // 200: LD V0, 5
+	0xA2, 0x0C, // 202: LD I, 20C
+	0x22, 0x08, // 204: CALL 208
+	0x12, 0x06, // 206: JP 206
+	0xD0, 0x01, // 208: DRW V0, V0, 1
+	0x00, 0xEE, // 20A: RET
+	0xF0, // 20C: sprite
+}
+
+func traceLines(t *testing.T, tracer *Tracer, out *bytes.Buffer, steps int) []string {
+	t.Helper()
+	c := newTestCpu(trace_program, WithTracer(tracer))
+	if err := c.StepInstructions(steps); err != nil {
+		t.Fatal(err)
+	}
+	if tracer.Err() != nil {
+		t.Fatal(tracer.Err())
+	}
+	return strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+}
+
+func TestTrace(t *testing.T) {
+	var out bytes.Buffer
+	tracer := NewTracer(&out, TraceAll)
+	tracer.Describe = func(pc uint16, ins []byte) string {
+		if pc == 0x204 {
+			return "CALL draw"
+		}
+		return ""
+	}
+	lines := traceLines(t, tracer, &out, 6)
+
+	want := []string{
+		"ins pc=0200 op=6005 v0=00 v1=00 v2=00 v3=00 v4=00 v5=00 v6=00 v7=00 v8=00 v9=00 va=00 vb=00 vc=00 vd=00 ve=00 vf=00 i=0000 sp=0 dt=00 st=00",
+		"ins pc=0202 op=A20C v0=05 v1=00 v2=00 v3=00 v4=00 v5=00 v6=00 v7=00 v8=00 v9=00 va=00 vb=00 vc=00 vd=00 ve=00 vf=00 i=0000 sp=0 dt=00 st=00",
+		"ins pc=0204 op=2208 v0=05 v1=00 v2=00 v3=00 v4=00 v5=00 v6=00 v7=00 v8=00 v9=00 va=00 vb=00 vc=00 vd=00 ve=00 vf=00 i=020C sp=0 dt=00 st=00 ; CALL draw",
+		"call pc=0204 to=0208 sp=1",
+		"ins pc=0208 op=D001 v0=05 v1=00 v2=00 v3=00 v4=00 v5=00 v6=00 v7=00 v8=00 v9=00 va=00 vb=00 vc=00 vd=00 ve=00 vf=00 i=020C sp=1 dt=00 st=00",
+		"draw pc=0208 x=05 y=05 n=1 i=020C vf=0",
+		"ins pc=020A op=00EE v0=05 v1=00 v2=00 v3=00 v4=00 v5=00 v6=00 v7=00 v8=00 v9=00 va=00 vb=00 vc=00 vd=00 ve=00 vf=00 i=020C sp=1 dt=00 st=00",
+		"ret pc=020A to=0206 sp=0",
+		"ins pc=0206 op=1206 v0=05 v1=00 v2=00 v3=00 v4=00 v5=00 v6=00 v7=00 v8=00 v9=00 va=00 vb=00 vc=00 vd=00 ve=00 vf=00 i=020C sp=0 dt=00 st=00",
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("want %d lines, got=%d:\n%s", len(want), len(lines), out.String())
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("line %d, want=%s\n got=%s", i, want[i], lines[i])
+		}
+	}
+}
+
+func TestTraceFilters(t *testing.T) {
+	tests := []struct {
+		events TraceEvent
+		ranges []AddrRange
+		want   []string // each line's event and pc
+	}{
+		{TraceStack, nil, []string{"call pc=0204", "ret pc=020A"}},
+		{TraceInstruction | TraceDraw, []AddrRange{{0x208, 0x20A}}, []string{"ins pc=0208", "draw pc=0208", "ins pc=020A"}},
+		{TraceAll, []AddrRange{{0x200, 0x200}, {0x206, 0x206}}, []string{"ins pc=0200", "ins pc=0206"}},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		tracer := NewTracer(&out, tt.events)
+		tracer.Ranges = tt.ranges
+		lines := traceLines(t, tracer, &out, 6)
+		if len(lines) != len(tt.want) {
+			t.Errorf("events %b in %v, want %d lines, got=%d:\n%s", tt.events, tt.ranges, len(tt.want), len(lines), out.String())
+			continue
+		}
+		for i, want := range tt.want {
+			if !strings.HasPrefix(lines[i], want) {
+				t.Errorf("events %b in %v, line %d, want=%s..., got=%s", tt.events, tt.ranges, i, want, lines[i])
+			}
+		}
+	}
+}
+
+func TestTraceTimers(t *testing.T) {
+	var out bytes.Buffer
+	c := newTestCpu([]byte{0x12, 0x00}, WithTracer(NewTracer(&out, TraceTimer)))
+	c.delay, c.sound = 2, 1
+	if err := c.RunFrames(3); err != nil {
+		t.Fatal(err)
+	}
+	if want := "timer dt=01 st=00\ntimer dt=00 st=00\n"; out.String() != want {
+		t.Errorf("timer trace, want=%q, got=%q", want, out.String())
+	}
+}
+
+func TestTraceMemory(t *testing.T) {
+	var out bytes.Buffer
+	tracer := NewTracer(&out, TraceMemory)
+	// V0 := 5; I := 300; BCD of V0
+	c := newTestCpu([]byte{0x60, 0x05, 0xA3, 0x00, 0xF0, 0x33}, WithTracer(tracer))
+	if err := c.StepInstructions(3); err != nil {
+		t.Fatal(err)
+	}
+	want := "mem pc=0204 addr=0300 val=00\nmem pc=0204 addr=0301 val=00\nmem pc=0204 addr=0302 val=05\n"
+	if out.String() != want {
+		t.Errorf("memory trace, want=%q, got=%q", want, out.String())
+	}
+}
+
+func TestParseTrace(t *testing.T) {
+	if e, err := ParseTraceEvents("ins, Stack"); err != nil || e != TraceInstruction|TraceStack {
+		t.Errorf("ParseTraceEvents, want=%b, got=%b, %v", TraceInstruction|TraceStack, e, err)
+	}
+	if e, err := ParseTraceEvents("all"); err != nil || e != TraceAll {
+		t.Errorf("ParseTraceEvents(all), want=%b, got=%b, %v", TraceAll, e, err)
+	}
+	if _, err := ParseTraceEvents("ins,sound"); err == nil {
+		t.Errorf("ParseTraceEvents(ins,sound), want error")
+	}
+
+	tests := []struct {
+		input string
+		want  AddrRange
+		ok    bool
+	}{
+		{"200-2FF", AddrRange{0x200, 0x2ff}, true},
+		{"0x300", AddrRange{0x300, 0x300}, true},
+		{"300-200", AddrRange{}, false},
+		{"2zz", AddrRange{}, false},
+		{"-200", AddrRange{}, false},
+	}
+	for _, tt := range tests {
+		r, err := ParseAddrRange(tt.input)
+		if (err == nil) != tt.ok || (tt.ok && r != tt.want) {
+			t.Errorf("ParseAddrRange(%q), want=%v ok=%v, got=%v, %v", tt.input, tt.want, tt.ok, r, err)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+
+	"github.com/gilmae/chip8/chip8"
+	"github.com/gilmae/chip8/dap"
+	"github.com/gilmae/chip8/sdlhost"
+)
+
+// serveDAP runs the Debug Adapter Protocol server, over stdio unless given an
+// address to listen on.
+func serveDAP(args []string) {
+	flags := flag.NewFlagSet("dap", flag.ExitOnError)
+	listen := flags.String("listen", "", "serve on this address, e.g. localhost:4711, rather than stdio")
+	window := flags.Bool("window", false, "show the display in a window rather than running headless")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s dap [flags]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	// stdout may be carrying the protocol.
+	logger := log.New(os.Stderr, "", 0)
+
+	var win *windowHost
+	if *window {
+		host, err := sdlhost.New("Chip-8", winWidth, winHeight)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			os.Exit(5)
+		}
+		defer host.Close()
+		win = &windowHost{host: host, calls: make(chan func())}
+	}
+
+	server := &dap.Server{
+		NewMachine: func(args dap.LaunchArguments) (*chip8.Machine, error) {
+			platform := args.Platform
+			if platform == "" {
+				platform = "chip8"
+			}
+			opts, err := machineOptions(platform, args.Quirks)
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, chip8.WithLogger(logger))
+			if args.Hz > 0 {
+				opts = append(opts, chip8.WithCPUHz(args.Hz))
+			}
+			if win != nil {
+				opts = append(opts, chip8.WithHost(win))
+			}
+			return chip8.NewMachine(opts...), nil
+		},
+	}
+
+	serve := func() error {
+		if *listen == "" {
+			return server.ServeConn(struct {
+				io.Reader
+				io.Writer
+			}{os.Stdin, os.Stdout})
+		}
+		l, err := net.Listen("tcp", *listen)
+		if err != nil {
+			return err
+		}
+		logger.Printf("serving the debug adapter protocol on %s", l.Addr())
+		return server.Serve(l)
+	}
+
+	var err error
+	if win != nil {
+		// The machines run on the server's goroutines, so the window is
+		// driven from this one, the main thread, on their behalf.
+		served := make(chan error, 1)
+		go func() {
+			served <- serve()
+		}()
+		err = win.serve(served)
+	} else {
+		err = serve()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(5)
+	}
+}
+
+// windowHost is the host for the debug adapter's machines. They run on the
+// server's goroutines, but SDL has to be called from the main thread, so
+// windowHost hands each call to the goroutine running serve and waits for it.
+// Sessions are served in turn, so one machine has the window at a time.
+type windowHost struct {
+	host  *sdlhost.Host
+	calls chan func()
+}
+
+// serve runs the machines' calls until served delivers the server's result.
+func (w *windowHost) serve(served <-chan error) error {
+	for {
+		select {
+		case call := <-w.calls:
+			call()
+		case err := <-served:
+			return err
+		}
+	}
+}
+
+func (w *windowHost) do(call func()) {
+	done := make(chan struct{})
+	w.calls <- func() {
+		call()
+		close(done)
+	}
+	<-done
+}
+
+func (w *windowHost) Render(d *chip8.Display) (err error) {
+	w.do(func() { err = w.host.Render(d) })
+	return err
+}
+
+func (w *windowHost) Poll() (events []chip8.KeyEvent, quit bool) {
+	w.do(func() { events, quit = w.host.Poll() })
+	return events, quit
+}
+
+// Close leaves the window open for the next session's machine; serveDAP
+// closes it on the way out.
+func (w *windowHost) Close() {}
@@ -0,0 +1,351 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var program = []byte{
+	0x60, 0x05, // 200: LD V0, 5
+	0xA3, 0x00, // 202: LD I, 300
+	0x22, 0x0C, // 204: CALL 20C
+	0x70, 0x01, // 206: ADD V0, 1
+	0x12, 0x04, // 208: JP 204
+	0x00, 0x00,
+	0xF0, 0x55, // 20C: LD [I], V0
+	0x00, 0xEE, // 20E: RET
+}
+
+// game_sym is what an assembler might write for program, built from
+// game.8o with a blank line before the subroutine.
+const game_sym = `0200 main
+020C draw
+0200 .line game.8o 1
+0202 .line game.8o 2
+0204 .line game.8o 3
+0206 .line game.8o 4
+0208 .line game.8o 5
+020A .line game.8o 6
+020C .line game.8o 8
+020E .line game.8o 9
+`
+
+type message map[string]interface{}
+
+func (m message) body() message {
+	b, _ := m["body"].(map[string]interface{})
+	return message(b)
+}
+
+// client is just enough of an editor to drive the adapter.
+type client struct {
+	t        *testing.T
+	conn     net.Conn
+	messages chan message
+	events   []message
+	seq      int
+}
+
+func newTestClient(t *testing.T) *client {
+	t.Helper()
+	serverConn, clientConn := net.Pipe()
+	go (&Server{}).ServeConn(serverConn)
+
+	c := &client{t: t, conn: clientConn, messages: make(chan message, 100)}
+	go func() {
+		r := bufio.NewReader(clientConn)
+		for {
+			data, err := readMessage(r)
+			if err != nil {
+				close(c.messages)
+				return
+			}
+			var msg message
+			if err := json.Unmarshal(data, &msg); err != nil {
+				t.Error(err)
+			}
+			c.messages <- msg
+		}
+	}()
+	t.Cleanup(func() {
+		clientConn.Close()
+	})
+	return c
+}
+
+func (c *client) read() message {
+	c.t.Helper()
+	select {
+	case msg, ok := <-c.messages:
+		if !ok {
+			c.t.Fatal("connection closed")
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("timed out")
+	}
+	return nil
+}
+
+// request sends a request and returns its response, keeping the events that
+// come first.
+func (c *client) request(command string, args interface{}) message {
+	c.t.Helper()
+	c.seq++
+	if err := writeMessage(c.conn, map[string]interface{}{"seq": c.seq, "type": "request", "command": command, "arguments": args}); err != nil {
+		c.t.Fatal(err)
+	}
+	for {
+		msg := c.read()
+		if msg["type"] == "event" {
+			c.events = append(c.events, msg)
+			continue
+		}
+		if msg["request_seq"] != float64(c.seq) {
+			c.t.Fatalf("%s, got a response to %v", command, msg["request_seq"])
+		}
+		return msg
+	}
+}
+
+// succeed is request for requests that should succeed.
+func (c *client) succeed(command string, args interface{}) message {
+	c.t.Helper()
+	resp := c.request(command, args)
+	if resp["success"] != true {
+		c.t.Fatalf("%s failed: %v", command, resp["message"])
+	}
+	return resp.body()
+}
+
+// event waits for the named event.
+func (c *client) event(name string) message {
+	c.t.Helper()
+	for {
+		if len(c.events) == 0 {
+			msg := c.read()
+			if msg["type"] != "event" {
+				c.t.Fatalf("want %s event, got=%v", name, msg)
+			}
+			c.events = append(c.events, msg)
+		}
+		msg := c.events[0]
+		c.events = c.events[1:]
+		if msg["event"] == name {
+			return msg.body()
+		}
+	}
+}
+
+func (c *client) frames() []message {
+	c.t.Helper()
+	body := c.succeed("stackTrace", map[string]int{"threadId": 1})
+	var frames []message
+	for _, f := range body["stackFrames"].([]interface{}) {
+		frames = append(frames, message(f.(map[string]interface{})))
+	}
+	return frames
+}
+
+func (c *client) variables(ref int) map[string]string {
+	c.t.Helper()
+	body := c.succeed("variables", map[string]int{"variablesReference": ref})
+	vars := make(map[string]string)
+	for _, v := range body["variables"].([]interface{}) {
+		v := v.(map[string]interface{})
+		vars[v["name"].(string)] = v["value"].(string)
+	}
+	return vars
+}
+
+func writeFiles(t *testing.T, withSymbols bool) (rom, sym string) {
+	dir := t.TempDir()
+	rom = filepath.Join(dir, "game.ch8")
+	if err := ioutil.WriteFile(rom, program, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if withSymbols {
+		sym = filepath.Join(dir, "game.sym")
+		if err := ioutil.WriteFile(sym, []byte(game_sym), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rom, sym
+}
+
+func TestSessionWithSymbols(t *testing.T) {
+	rom, sym := writeFiles(t, true)
+	source := filepath.Join(filepath.Dir(sym), "game.8o")
+	c := newTestClient(t)
+
+	if caps := c.succeed("initialize", map[string]string{"adapterID": "chip8"}); caps["supportsConfigurationDoneRequest"] != true {
+		t.Errorf("initialize, want supportsConfigurationDoneRequest, got=%v", caps)
+	}
+	c.succeed("launch", map[string]interface{}{"program": rom, "symbols": sym})
+	c.event("initialized")
+
+	body := c.succeed("setBreakpoints", map[string]interface{}{
+		"source":      map[string]string{"path": source},
+		"breakpoints": []map[string]int{{"line": 7}, {"line": 99}},
+	})
+	bps := body["breakpoints"].([]interface{})
+	if b := bps[0].(map[string]interface{}); b["verified"] != true || b["line"] != float64(8) {
+		t.Errorf("breakpoint at line 7, want verified at line 8, got=%v", b)
+	}
+	if b := bps[1].(map[string]interface{}); b["verified"] != false {
+		t.Errorf("breakpoint at line 99, want unverified, got=%v", b)
+	}
+
+	c.succeed("configurationDone", nil)
+	if e := c.event("stopped"); e["reason"] != "breakpoint" {
+		t.Errorf("stopped, want=breakpoint, got=%v", e["reason"])
+	}
+
+	frames := c.frames()
+	if len(frames) != 2 {
+		t.Fatalf("want 2 frames, got=%v", frames)
+	}
+	tests := []struct {
+		name string
+		line float64
+	}{
+		{"draw", 8},
+		{"main", 3},
+	}
+	for i, tt := range tests {
+		f := frames[i]
+		if f["name"] != tt.name || f["line"] != tt.line {
+			t.Errorf("frame %d, want=%s at %v, got=%v at %v", i, tt.name, tt.line, f["name"], f["line"])
+		}
+		if path := f["source"].(map[string]interface{})["path"]; path != source {
+			t.Errorf("frame %d, want source=%s, got=%v", i, source, path)
+		}
+	}
+
+	if regs := c.variables(registers_ref); regs["V0"] != "0x05" || regs["I"] != "0x300" || regs["PC"] != "0x20C" {
+		t.Errorf("registers, got=%v", regs)
+	}
+	if stack := c.variables(stack_ref); stack["0"] != "0x206" {
+		t.Errorf("stack, got=%v", stack)
+	}
+
+	c.succeed("stepIn", map[string]int{"threadId": 1})
+	if e := c.event("stopped"); e["reason"] != "step" {
+		t.Errorf("stopped, want=step, got=%v", e["reason"])
+	}
+	if f := c.frames()[0]; f["line"] != float64(9) {
+		t.Errorf("after stepIn, want line 9, got=%v", f["line"])
+	}
+
+	c.succeed("next", map[string]int{"threadId": 1})
+	c.event("stopped")
+	if frames := c.frames(); len(frames) != 1 || frames[0]["line"] != float64(4) {
+		t.Errorf("after next, want main at line 4, got=%v", frames)
+	}
+
+	if resp := c.request("stepOut", map[string]int{"threadId": 1}); resp["success"] != false {
+		t.Errorf("stepOut of main, want failure, got=%v", resp)
+	}
+
+	if r := c.succeed("evaluate", map[string]string{"expression": "draw"}); r["result"] != "0x20C" {
+		t.Errorf("evaluate draw, got=%v", r["result"])
+	}
+	if r := c.succeed("evaluate", map[string]string{"expression": "v0 + 1"}); r["result"] != "6 (0x6)" {
+		t.Errorf("evaluate v0 + 1, got=%v", r["result"])
+	}
+	if r := c.succeed("setVariable", map[string]interface{}{"variablesReference": registers_ref, "name": "V0", "value": "0x10"}); r["value"] != "0x10" {
+		t.Errorf("setVariable, got=%v", r["value"])
+	}
+
+	regions := c.variables(memory_ref)
+	if regions["program"] != "200-20F" {
+		t.Errorf("program region, got=%v", regions)
+	}
+	if rows := c.variables(region_ref + 1); rows["200"] != "60 05 A3 00 22 0C 70 01 12 04 00 00 F0 55 00 EE" {
+		t.Errorf("program memory, got=%v", rows)
+	}
+
+	c.succeed("disconnect", nil)
+}
+
+func TestSymbolsBesideRom(t *testing.T) {
+	rom, sym := writeFiles(t, true)
+	c := newTestClient(t)
+
+	c.succeed("initialize", nil)
+	c.succeed("launch", map[string]interface{}{"program": rom, "stopOnEntry": true})
+	c.event("initialized")
+	c.succeed("configurationDone", nil)
+	c.event("stopped")
+
+	f := c.frames()[0]
+	source := filepath.Join(filepath.Dir(sym), "game.8o")
+	if f["name"] != "main" || f["source"].(map[string]interface{})["path"] != source {
+		t.Errorf("entry frame, want main in %s, got=%v", source, f)
+	}
+	c.succeed("disconnect", nil)
+}
+
+func TestSessionWithDisassembly(t *testing.T) {
+	rom, _ := writeFiles(t, false)
+	c := newTestClient(t)
+
+	c.succeed("initialize", nil)
+	c.succeed("launch", map[string]interface{}{"program": rom, "stopOnEntry": true})
+	c.event("initialized")
+
+	body := c.succeed("setBreakpoints", map[string]interface{}{
+		"source":      map[string]int{"sourceReference": disassembly_ref},
+		"breakpoints": []map[string]interface{}{{"line": 4, "logMessage": "v0={v0}"}},
+	})
+	if b := body["breakpoints"].([]interface{})[0].(map[string]interface{}); b["verified"] != true {
+		t.Errorf("log point, want verified, got=%v", b)
+	}
+
+	c.succeed("configurationDone", nil)
+	if e := c.event("stopped"); e["reason"] != "entry" {
+		t.Errorf("stopped, want=entry, got=%v", e["reason"])
+	}
+	f := c.frames()[0]
+	if f["line"] != float64(1) || f["source"].(map[string]interface{})["sourceReference"] != float64(disassembly_ref) {
+		t.Errorf("entry frame, want disassembly line 1, got=%v", f)
+	}
+
+	content := c.succeed("source", map[string]int{"sourceReference": disassembly_ref})["content"].(string)
+	if !strings.Contains(content, "20C  F055") {
+		t.Errorf("disassembly lacks 20C, got=%s", content)
+	}
+
+	c.succeed("continue", map[string]int{"threadId": 1})
+	if out := c.event("output"); out["output"] != "v0=5\n" {
+		t.Errorf("log point, want=%q, got=%q", "v0=5\n", out["output"])
+	}
+	c.succeed("pause", map[string]int{"threadId": 1})
+	if e := c.event("stopped"); e["reason"] != "pause" {
+		t.Errorf("stopped, want=pause, got=%v", e["reason"])
+	}
+
+	c.succeed("terminate", nil)
+	c.event("terminated")
+}
+
+func TestRequestsBeforeLaunch(t *testing.T) {
+	c := newTestClient(t)
+	for _, command := range []string{"stackTrace", "variables", "continue", "configurationDone"} {
+		if resp := c.request(command, map[string]int{"variablesReference": 1}); resp["success"] != false {
+			t.Errorf("%s before launch, want failure, got=%v", command, resp)
+		}
+	}
+	if resp := c.request("launch", map[string]string{}); resp["success"] != false {
+		t.Errorf("launch without program, want failure, got=%v", resp)
+	}
+	if resp := c.request("frobnicate", nil); resp["success"] != false {
+		t.Errorf("unknown request, want failure, got=%v", resp)
+	}
+}
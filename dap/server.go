@@ -0,0 +1,720 @@
+// Package dap is a Debug Adapter Protocol server, so editors such as VS Code
+// can launch a rom and debug it.
+//
+// Source mapping uses the symbol file an assembler writes next to the rom,
+// named by the launch's "symbols" argument or else found beside the rom with
+// a .sym extension. Without one the rom is shown as a disassembly, line n
+// holding the two bytes at 200+2(n-1). Lines count from 1, the protocol's
+// default.
+package dap
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/gilmae/chip8/chip8"
+	"github.com/gilmae/chip8/disasm"
+	"github.com/gilmae/chip8/symbols"
+)
+
+const (
+	thread_id       = 1 // the machine is the only thread
+	disassembly_ref = 1 // the sourceReference of the rom's disassembly
+
+	program_start uint16 = 0x200
+
+	registers_ref = 1
+	timers_ref    = 2
+	stack_ref     = 3
+	memory_ref    = 4
+	region_ref    = 100 // the first memory region; the rest follow it
+	row_bytes     = 16
+)
+
+var errNotLaunched = errors.New("no rom launched")
+
+// Server runs debug sessions, one per connection.
+type Server struct {
+	// NewMachine makes the machine for a launch. By default it is headless
+	// and logs nowhere, as stdout may be carrying the protocol.
+	NewMachine func(args LaunchArguments) (*chip8.Machine, error)
+}
+
+// Serve accepts connections on l and serves them in turn.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		s.ServeConn(conn)
+		conn.Close()
+	}
+}
+
+// ServeConn serves one session until the client disconnects, stopping the
+// machine it launched. Pass a stdin and stdout pair to serve over stdio.
+func (s *Server) ServeConn(conn io.ReadWriter) error {
+	c := &session{
+		s:           s,
+		w:           conn,
+		breakpoints: make(map[string][]int),
+	}
+	defer c.shutdown()
+
+	r := bufio.NewReader(conn)
+	for !c.finished {
+		msg, err := readMessage(r)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		var req request
+		if err := json.Unmarshal(msg, &req); err != nil {
+			return fmt.Errorf("bad message: %w", err)
+		}
+		if req.Type != "request" {
+			continue
+		}
+		if err := c.handle(req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) newMachine(args LaunchArguments) (*chip8.Machine, error) {
+	if s.NewMachine != nil {
+		return s.NewMachine(args)
+	}
+	return chip8.NewMachine(chip8.WithLogger(log.New(ioutil.Discard, "", 0))), nil
+}
+
+// session is one client's debug session.
+type session struct {
+	s *Server
+
+	mu  sync.Mutex // guards w and seq, shared with the goroutines sending events
+	w   io.Writer
+	seq int
+
+	args      LaunchArguments
+	program   []byte
+	m         *chip8.Machine
+	d         *chip8.Debugger
+	table     *symbols.Table
+	symbolDir string // where the symbol file's source paths are relative to
+	cancel    context.CancelFunc
+	done      chan struct{} // closed when the machine stops running
+
+	breakpoints map[string][]int // the debugger's breakpoint ids, by source
+	next        func()           // run after the current response is sent
+	finished    bool
+}
+
+func (c *session) send(msg interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	switch m := msg.(type) {
+	case *response:
+		m.Seq = c.seq
+	case *event:
+		m.Seq = c.seq
+	}
+	return writeMessage(c.w, msg)
+}
+
+// event sends an event, ignoring failures: they also fail the next response,
+// which ends the session.
+func (c *session) event(name string, body interface{}) {
+	c.send(&event{Type: "event", Event: name, Body: body})
+}
+
+var handlers = map[string]func(c *session, args json.RawMessage) (interface{}, error){
+	"initialize":        (*session).initialize,
+	"launch":            (*session).launch,
+	"setBreakpoints":    (*session).setBreakpoints,
+	"configurationDone": (*session).configurationDone,
+	"threads":           (*session).threads,
+	"stackTrace":        (*session).stackTrace,
+	"scopes":            (*session).scopes,
+	"variables":         (*session).variables,
+	"setVariable":       (*session).setVariable,
+	"evaluate":          (*session).evaluate,
+	"source":            (*session).source,
+	"continue":          (*session).resume,
+	"next":              (*session).stepOver,
+	"stepIn":            (*session).stepIn,
+	"stepOut":           (*session).stepOut,
+	"pause":             (*session).pause,
+	"disconnect":        (*session).disconnect,
+	"terminate":         (*session).terminate,
+}
+
+// handle answers a request. Only failing to write ends the session.
+func (c *session) handle(req request) error {
+	resp := &response{Type: "response", RequestSeq: req.Seq, Command: req.Command, Success: true}
+	handler, ok := handlers[req.Command]
+	if !ok {
+		resp.Success = false
+		resp.Message = fmt.Sprintf("unsupported request %q", req.Command)
+	} else if body, err := handler(c, req.Arguments); err != nil {
+		resp.Success = false
+		resp.Message = err.Error()
+	} else {
+		resp.Body = body
+	}
+
+	if err := c.send(resp); err != nil {
+		return err
+	}
+	if next := c.next; next != nil {
+		c.next = nil
+		next()
+	}
+	return nil
+}
+
+func (c *session) initialize(json.RawMessage) (interface{}, error) {
+	return capabilities{
+		SupportsConfigurationDoneRequest: true,
+		SupportsConditionalBreakpoints:   true,
+		SupportsLogPoints:                true,
+		SupportsSetVariable:              true,
+		SupportsEvaluateForHovers:        true,
+		SupportsTerminateRequest:         true,
+	}, nil
+}
+
+// launch loads the rom and starts the machine paused. Breakpoints are set
+// once the client hears it is initialised, and configurationDone lets the
+// machine go.
+func (c *session) launch(raw json.RawMessage) (interface{}, error) {
+	if c.m != nil {
+		return nil, fmt.Errorf("already launched %s", c.args.Program)
+	}
+	if err := json.Unmarshal(raw, &c.args); err != nil {
+		return nil, err
+	}
+	if c.args.Program == "" {
+		return nil, fmt.Errorf("launch needs a program")
+	}
+
+	program, err := ioutil.ReadFile(c.args.Program)
+	if err != nil {
+		return nil, err
+	}
+	if c.args.Symbols == "" {
+		// Use the symbol file beside the rom, if there is one.
+		if _, err := os.Stat(symbols.PathFor(c.args.Program)); err == nil {
+			c.args.Symbols = symbols.PathFor(c.args.Program)
+		}
+	}
+	if c.args.Symbols != "" {
+		if c.table, err = symbols.Load(c.args.Symbols); err != nil {
+			return nil, err
+		}
+		if c.symbolDir, err = filepath.Abs(filepath.Dir(c.args.Symbols)); err != nil {
+			return nil, err
+		}
+	}
+
+	m, err := c.s.newMachine(c.args)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := m.LoadBytes(program); err != nil {
+		return nil, err
+	}
+	c.program = program
+	c.m = m
+	c.d = chip8.NewDebugger(m)
+	c.d.Logger = log.New(output{c}, "", 0)
+	c.d.Pause()
+	<-c.d.Stops()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	c.done = make(chan struct{})
+	go c.run(ctx)
+	go c.forwardStops(ctx)
+
+	c.next = func() { c.event("initialized", nil) }
+	return nil, nil
+}
+
+// run runs the machine, telling the client if it stops by itself.
+func (c *session) run(ctx context.Context) {
+	err := c.m.Run(ctx)
+	close(c.done)
+	if ctx.Err() != nil {
+		return
+	}
+	code := 0
+	if err != nil {
+		code = 1
+		c.event("output", outputEvent{Category: "stderr", Output: err.Error() + "\n"})
+	}
+	c.event("exited", map[string]int{"exitCode": code})
+	c.event("terminated", nil)
+}
+
+func (c *session) forwardStops(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case s := <-c.d.Stops():
+			c.event("stopped", stopped(s))
+		}
+	}
+}
+
+func stopped(s chip8.Stop) stoppedEvent {
+	e := stoppedEvent{ThreadID: thread_id, AllThreadsStopped: true}
+	switch s.Reason {
+	case chip8.StopBreakpoint:
+		e.Reason = "breakpoint"
+		e.HitBreakpointIds = []int{s.ID}
+	case chip8.StopWatchpoint:
+		e.Reason = "data breakpoint"
+		e.Description = fmt.Sprintf("%03X %s", s.Access.PC, s.Access.Kind)
+	case chip8.StopOpcode:
+		e.Reason = "instruction breakpoint"
+	case chip8.StopStep:
+		e.Reason = "step"
+	default:
+		e.Reason = "pause"
+	}
+	return e
+}
+
+// output sends what is written to it, log point messages, to the client's
+// debug console.
+type output struct {
+	c *session
+}
+
+func (o output) Write(p []byte) (int, error) {
+	o.c.event("output", outputEvent{Category: "console", Output: string(p)})
+	return len(p), nil
+}
+
+func (c *session) configurationDone(json.RawMessage) (interface{}, error) {
+	if c.m == nil {
+		return nil, errNotLaunched
+	}
+	if c.args.StopOnEntry {
+		c.next = func() {
+			c.event("stopped", stoppedEvent{Reason: "entry", ThreadID: thread_id, AllThreadsStopped: true})
+		}
+	} else {
+		c.next = c.d.Continue
+	}
+	return nil, nil
+}
+
+func (c *session) setBreakpoints(raw json.RawMessage) (interface{}, error) {
+	if c.m == nil {
+		return nil, errNotLaunched
+	}
+	var args setBreakpointsArguments
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	key := args.Source.Path
+	if key == "" {
+		key = fmt.Sprintf("#%d", args.Source.SourceReference)
+	}
+	for _, id := range c.breakpoints[key] {
+		c.d.Clear(id)
+	}
+	c.breakpoints[key] = nil
+
+	result := []breakpoint{}
+	for _, want := range args.Breakpoints {
+		addr, line, ok := c.resolve(args.Source, want.Line)
+		if !ok {
+			result = append(result, breakpoint{Line: want.Line, Message: "no code at this line"})
+			continue
+		}
+		id := c.d.SetBreakpoint(addr)
+		err := c.d.SetCondition(id, want.Condition)
+		if err == nil {
+			err = c.d.SetLog(id, want.LogMessage)
+		}
+		if err != nil {
+			c.d.Clear(id)
+			result = append(result, breakpoint{Line: want.Line, Message: err.Error()})
+			continue
+		}
+		c.breakpoints[key] = append(c.breakpoints[key], id)
+		src := args.Source
+		result = append(result, breakpoint{ID: id, Verified: true, Source: &src, Line: line})
+	}
+	return map[string]interface{}{"breakpoints": result}, nil
+}
+
+// resolve finds the address of the code at a line of a source, and the line
+// it is really on.
+func (c *session) resolve(src source, line int) (uint16, int, bool) {
+	if src.Path == "" {
+		if src.SourceReference != disassembly_ref || line < 1 || (line-1)*2 >= len(c.program) {
+			return 0, 0, false
+		}
+		return program_start + uint16(2*(line-1)), line, true
+	}
+	if c.table == nil {
+		return 0, 0, false
+	}
+	path := filepath.Clean(src.Path)
+	for _, file := range c.table.Files() {
+		if c.sourcePath(file) != path {
+			continue
+		}
+		if l, ok := c.table.Addr(file, line); ok {
+			return l.Addr, l.Line, true
+		}
+	}
+	return 0, 0, false
+}
+
+// sourcePath resolves a path from the symbol file.
+func (c *session) sourcePath(file string) string {
+	if filepath.IsAbs(file) {
+		return filepath.Clean(file)
+	}
+	return filepath.Join(c.symbolDir, file)
+}
+
+// location is the source and line of the code at addr.
+func (c *session) location(addr uint16) (*source, int) {
+	if c.table != nil {
+		if l, ok := c.table.Source(addr); ok {
+			path := c.sourcePath(l.File)
+			return &source{Name: filepath.Base(path), Path: path}, l.Line
+		}
+	}
+	if addr >= program_start && int(addr-program_start) < len(c.program) {
+		return c.disassemblySource(), int(addr-program_start)/2 + 1
+	}
+	return nil, 0
+}
+
+func (c *session) disassemblySource() *source {
+	return &source{Name: filepath.Base(c.args.Program) + " (disassembly)", SourceReference: disassembly_ref}
+}
+
+func (c *session) threads(json.RawMessage) (interface{}, error) {
+	return map[string]interface{}{"threads": []thread{{ID: thread_id, Name: "chip8"}}}, nil
+}
+
+func (c *session) stackTrace(json.RawMessage) (interface{}, error) {
+	if c.m == nil {
+		return nil, errNotLaunched
+	}
+	frames := c.d.CallStack()
+	result := make([]stackFrame, len(frames))
+	for i, f := range frames {
+		src, line := c.location(f.PC)
+		result[i] = stackFrame{
+			ID:                          i + 1,
+			Name:                        c.functionName(f.Function),
+			Source:                      src,
+			Line:                        line,
+			Column:                      1,
+			InstructionPointerReference: fmt.Sprintf("0x%03X", f.PC),
+		}
+	}
+	return map[string]interface{}{"stackFrames": result, "totalFrames": len(result)}, nil
+}
+
+func (c *session) functionName(addr uint16) string {
+	if c.table != nil {
+		if name, ok := c.table.Label(addr); ok {
+			return name
+		}
+	}
+	if addr == 0 {
+		return "???"
+	}
+	return fmt.Sprintf("sub_%03X", addr)
+}
+
+func (c *session) scopes(json.RawMessage) (interface{}, error) {
+	return map[string]interface{}{"scopes": []scope{
+		{Name: "Registers", VariablesReference: registers_ref},
+		{Name: "Timers", VariablesReference: timers_ref},
+		{Name: "Stack", VariablesReference: stack_ref},
+		{Name: "Memory", VariablesReference: memory_ref},
+	}}, nil
+}
+
+// region is a range of memory shown as its own variable.
+type region struct {
+	name       string
+	start, end uint16
+}
+
+func (c *session) regions() []region {
+	size := len(c.m.Memory())
+	end := program_start + uint16(len(c.program)) - 1
+	regions := []region{
+		{"interpreter", 0, program_start - 1},
+		{"program", program_start, end},
+	}
+	if int(end)+1 < size {
+		regions = append(regions, region{"free", end + 1, uint16(size - 1)})
+	}
+	i := c.m.I()
+	last := int(i) + row_bytes - 1
+	if last >= size {
+		last = size - 1
+	}
+	return append(regions, region{"at I", i, uint16(last)})
+}
+
+func (c *session) registerValue(r chip8.Register) string {
+	if r == chip8.RegI || r == chip8.RegPC {
+		return fmt.Sprintf("0x%03X", c.m.Register(r))
+	}
+	return fmt.Sprintf("0x%02X", c.m.Register(r))
+}
+
+func (c *session) registerVariables(regs ...chip8.Register) []variable {
+	vars := make([]variable, len(regs))
+	for i, r := range regs {
+		vars[i] = variable{Name: r.String(), Value: c.registerValue(r)}
+	}
+	return vars
+}
+
+func (c *session) variables(raw json.RawMessage) (interface{}, error) {
+	if c.m == nil {
+		return nil, errNotLaunched
+	}
+	var args variablesArguments
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	vars := []variable{}
+	switch ref := args.VariablesReference; {
+	case ref == registers_ref:
+		for r := chip8.V0; r <= chip8.VF; r++ {
+			vars = append(vars, c.registerVariables(r)...)
+		}
+		vars = append(vars, c.registerVariables(chip8.RegI, chip8.RegPC, chip8.RegSP)...)
+	case ref == timers_ref:
+		vars = c.registerVariables(chip8.RegDT, chip8.RegST)
+	case ref == stack_ref:
+		stack := c.m.Stack()
+		for i := len(stack) - 1; i >= 0; i-- {
+			value := fmt.Sprintf("0x%03X", stack[i])
+			if c.table != nil {
+				if name, ok := c.table.Label(stack[i]); ok {
+					value += " <" + name + ">"
+				}
+			}
+			vars = append(vars, variable{Name: fmt.Sprint(i), Value: value})
+		}
+	case ref == memory_ref:
+		for i, r := range c.regions() {
+			vars = append(vars, variable{
+				Name:               r.name,
+				Value:              fmt.Sprintf("%03X-%03X", r.start, r.end),
+				VariablesReference: region_ref + i,
+			})
+		}
+	case ref >= region_ref && ref < region_ref+len(c.regions()):
+		r := c.regions()[ref-region_ref]
+		mem := c.m.Memory()
+		for row := int(r.start); row <= int(r.end); row += row_bytes {
+			var b strings.Builder
+			for addr := row; addr < row+row_bytes && addr <= int(r.end); addr++ {
+				if addr > row {
+					b.WriteByte(' ')
+				}
+				fmt.Fprintf(&b, "%02X", mem[addr])
+			}
+			vars = append(vars, variable{Name: fmt.Sprintf("%03X", row), Value: b.String()})
+		}
+	default:
+		return nil, fmt.Errorf("no variables %d", args.VariablesReference)
+	}
+	return map[string]interface{}{"variables": vars}, nil
+}
+
+func (c *session) setVariable(raw json.RawMessage) (interface{}, error) {
+	if c.m == nil {
+		return nil, errNotLaunched
+	}
+	var args setVariableArguments
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	if args.VariablesReference != registers_ref && args.VariablesReference != timers_ref {
+		return nil, fmt.Errorf("only registers and timers can be set")
+	}
+	r, err := chip8.RegisterByName(args.Name)
+	if err != nil {
+		return nil, err
+	}
+	value, err := c.d.Evaluate(args.Value)
+	if err != nil {
+		return nil, err
+	}
+	c.m.SetRegister(r, uint16(value))
+	return map[string]string{"value": c.registerValue(r)}, nil
+}
+
+// evaluate works out an expression in the debugger's language or, when
+// there are symbols, the address of a label.
+func (c *session) evaluate(raw json.RawMessage) (interface{}, error) {
+	if c.m == nil {
+		return nil, errNotLaunched
+	}
+	var args evaluateArguments
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	expr := strings.TrimSpace(args.Expression)
+
+	var result string
+	if addr, ok := c.lookup(expr); ok {
+		result = fmt.Sprintf("0x%03X", addr)
+	} else {
+		value, err := c.d.Evaluate(expr)
+		if err != nil {
+			return nil, err
+		}
+		result = fmt.Sprintf("%d (0x%X)", value, value)
+	}
+	return map[string]interface{}{"result": result, "variablesReference": 0}, nil
+}
+
+func (c *session) lookup(name string) (uint16, bool) {
+	if c.table == nil {
+		return 0, false
+	}
+	return c.table.Lookup(name)
+}
+
+// source sends the disassembly, the one source the client cannot read for
+// itself.
+func (c *session) source(raw json.RawMessage) (interface{}, error) {
+	if c.m == nil {
+		return nil, errNotLaunched
+	}
+	var args sourceArguments
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	ref := args.SourceReference
+	if args.Source != nil && args.Source.SourceReference != 0 {
+		ref = args.Source.SourceReference
+	}
+	if ref != disassembly_ref {
+		return nil, fmt.Errorf("no source %d", ref)
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(c.program); i += 2 {
+		text, _, err := disasm.InstructionWithSymbols(c.program[i:], disasm.Cowgod, c.table)
+		if err != nil {
+			text = "???"
+		}
+		end := i + 2
+		if end > len(c.program) {
+			end = len(c.program)
+		}
+		fmt.Fprintf(&b, "%03X  %-4X  %s\n", int(program_start)+i, c.program[i:end], text)
+	}
+	return map[string]string{"content": b.String(), "mimeType": "text/x-chip8"}, nil
+}
+
+func (c *session) resume(json.RawMessage) (interface{}, error) {
+	if c.m == nil {
+		return nil, errNotLaunched
+	}
+	c.next = c.d.Continue
+	return map[string]bool{"allThreadsContinued": true}, nil
+}
+
+// step responds before stepping, so the client hears the step has started
+// before it hears it has stopped.
+func (c *session) step(step func() error) (interface{}, error) {
+	if c.m == nil {
+		return nil, errNotLaunched
+	}
+	c.next = func() {
+		if err := step(); err != nil {
+			c.event("output", outputEvent{Category: "stderr", Output: err.Error() + "\n"})
+		}
+	}
+	return nil, nil
+}
+
+func (c *session) stepIn(json.RawMessage) (interface{}, error) {
+	return c.step(c.d.StepIn)
+}
+
+func (c *session) stepOver(json.RawMessage) (interface{}, error) {
+	return c.step(c.d.StepOver)
+}
+
+func (c *session) stepOut(json.RawMessage) (interface{}, error) {
+	if c.m == nil {
+		return nil, errNotLaunched
+	}
+	if c.m.SP() == 0 {
+		return nil, fmt.Errorf("not in a subroutine")
+	}
+	return c.step(c.d.StepOut)
+}
+
+func (c *session) pause(json.RawMessage) (interface{}, error) {
+	if c.m == nil {
+		return nil, errNotLaunched
+	}
+	c.next = c.d.Pause
+	return nil, nil
+}
+
+func (c *session) disconnect(json.RawMessage) (interface{}, error) {
+	c.shutdown()
+	c.finished = true
+	return nil, nil
+}
+
+func (c *session) terminate(json.RawMessage) (interface{}, error) {
+	c.shutdown()
+	c.next = func() { c.event("terminated", nil) }
+	return nil, nil
+}
+
+// shutdown stops the machine, if it is running.
+func (c *session) shutdown() {
+	if c.cancel == nil {
+		return
+	}
+	c.cancel()
+	<-c.done
+	c.d.Detach()
+	c.cancel = nil
+}
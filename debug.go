@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+
+	"github.com/gilmae/chip8/chip8"
+	"github.com/gilmae/chip8/tui"
+)
+
+// debug runs a rom under the terminal debugger.
+func debug(args []string) {
+	flags := flag.NewFlagSet("debug", flag.ExitOnError)
+	hz := flags.Int("hz", chip8.DefaultCPUHz, "instructions executed per second")
+	platformName := flags.String("platform", "chip8", "platform: chip8, schip or xochip")
+	quirkProfile := flags.String("quirks", "", "quirk profile, overriding the platform's: "+strings.Join(chip8.QuirkProfileNames(), ", "))
+	keymapPath := flags.String("keymap", "", "JSON keymap file for keypad mode")
+	traceOpts := addTraceFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s debug [flags] rom\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() < 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	opts, err := machineOptions(*platformName, *quirkProfile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(2)
+	}
+
+	romPath := flags.Arg(0)
+	program, table, err := loadProgram(romPath)
+	if err != nil {
+		printError(err)
+		os.Exit(3)
+	}
+	// The terminal belongs to the UI, so a trace has to go to a file.
+	if *traceOpts.events != "" && *traceOpts.out == "" {
+		fmt.Fprintf(os.Stderr, "error: -trace needs -trace-out under the debugger\n")
+		os.Exit(2)
+	}
+	tracer, doneTracing, err := traceOpts.tracer(table)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(2)
+	}
+
+	keymap, err := loadKeymap(*keymapPath, program)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(3)
+	}
+	keyboard := chip8.NewKeyboard()
+	keyboard.SetKeymap(keymap)
+
+	ui := tui.New()
+	ui.Title = filepath.Base(romPath)
+	ui.Symbols = table
+	// The terminal belongs to the UI, so nothing else may log to it.
+	opts = append(opts,
+		chip8.WithHost(ui),
+		chip8.WithKeyboard(keyboard),
+		chip8.WithCPUHz(*hz),
+		chip8.WithLogger(log.New(ioutil.Discard, "", 0)),
+		chip8.WithTracer(tracer),
+	)
+	cpu := chip8.NewMachine(opts...)
+
+	if _, err := cpu.LoadBytes(program); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(4)
+	}
+	ui.Attach(chip8.NewDebugger(cpu))
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	err = ui.Run(ctx)
+	if terr := doneTracing(); terr != nil {
+		fmt.Fprintf(os.Stderr, "error: trace: %s\n", terr)
+	}
+	if err != nil && err != context.Canceled {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(5)
+	}
+}
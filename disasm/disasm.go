@@ -0,0 +1,270 @@
+// Package disasm turns CHIP-8 roms back into assembly listings.
+//
+// It follows the program's control flow from its entry point, so only bytes
+// the program can reach are shown as instructions; the rest, usually sprites,
+// are shown as data. Jump and call targets, and addresses loaded into I, get
+// labels, named from the rom's symbol table when there is one. The listing is
+// valid source for the assembler, which makes the same rom from it again.
+package disasm
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/gilmae/chip8/chip8"
+	"github.com/gilmae/chip8/symbols"
+)
+
+const (
+	// DefaultOrigin is where roms are loaded.
+	DefaultOrigin uint16 = 0x200
+
+	data_row   = 8  // bytes in a line of data
+	text_width = 28 // the column address comments start at
+)
+
+// Options control a disassembly.
+type Options struct {
+	Origin  uint16   // where the rom is loaded, and its entry point; DefaultOrigin if 0
+	Entries []uint16 // more entry points, for code reached in ways the disassembler cannot follow
+	Syntax  Syntax
+	Symbols *symbols.Table // names for labels, and more labels; may be nil
+}
+
+// Line is one line of a listing: an instruction or a run of data.
+type Line struct {
+	Addr  uint16
+	Bytes []byte
+	Code  bool
+	Label string // the label at Addr, if any
+	Text  string // the instruction or data, in the listing's syntax
+}
+
+// Listing is a disassembled rom.
+type Listing struct {
+	Syntax Syntax
+	Lines  []Line
+	Labels map[uint16]string
+}
+
+// label kinds, best first: a call target is named as a subroutine even if
+// it is also jumped to.
+const (
+	label_sub = iota
+	label_jump
+	label_data
+)
+
+var label_prefixes = map[int]string{
+	label_sub:  "sub",
+	label_jump: "label",
+	label_data: "data",
+}
+
+type disassembler struct {
+	program []byte
+	origin  uint16
+	starts  map[int]int // offsets of instructions to their sizes
+	covered []bool      // bytes that belong to an instruction
+	kinds   map[uint16]int
+}
+
+// Disassemble lists program.
+func Disassemble(program []byte, opts Options) *Listing {
+	if opts.Origin == 0 {
+		opts.Origin = DefaultOrigin
+	}
+	d := &disassembler{
+		program: program,
+		origin:  opts.Origin,
+		starts:  make(map[int]int),
+		covered: make([]bool, len(program)),
+		kinds:   make(map[uint16]int),
+	}
+	d.trace(append([]uint16{opts.Origin}, opts.Entries...))
+	return d.list(opts.Syntax, opts.Symbols)
+}
+
+// offset is where addr is in the program, or -1 if it is outside it.
+func (d *disassembler) offset(addr uint16) int {
+	off := int(addr) - int(d.origin)
+	if off < 0 || off >= len(d.program) {
+		return -1
+	}
+	return off
+}
+
+func (d *disassembler) mark(addr uint16, kind int) {
+	if old, ok := d.kinds[addr]; !ok || kind < old {
+		d.kinds[addr] = kind
+	}
+}
+
+// size is the size of the instruction at off, or 0 if there is none.
+func (d *disassembler) size(off int) int {
+	if off < 0 || off+2 > len(d.program) {
+		return 0
+	}
+	op := chip8.ParseOpcode(d.program[off:])
+	def, err := chip8.Lookup(byte(op))
+	if err != nil || off+def.Size() > len(d.program) {
+		return 0
+	}
+	// Some instructions, such as 9xy1, are decoded though their unused bits
+	// are set. Listing them as the instruction would lose those bits when
+	// the listing is assembled, so they are left as data.
+	ins := chip8.Instructions(d.program[off : off+def.Size()])
+	if canonical, err := chip8.Encode(op, chip8.ReadOperands(def, ins)...); err != nil || !bytes.Equal(canonical, ins) {
+		return 0
+	}
+	return def.Size()
+}
+
+// trace follows control flow from each entry, marking what it reaches as
+// code. A path ends at a return, an unconditional jump, an undefined
+// instruction or code already traced.
+func (d *disassembler) trace(entries []uint16) {
+	work := entries
+	for len(work) > 0 {
+		addr := work[len(work)-1]
+		work = work[:len(work)-1]
+
+		for {
+			off := d.offset(addr)
+			size := d.size(off)
+			if size == 0 || d.overlaps(off, size) {
+				break
+			}
+			d.starts[off] = size
+			for i := 0; i < size; i++ {
+				d.covered[off+i] = true
+			}
+
+			ins := chip8.Instructions(d.program[off : off+size])
+			next := addr + uint16(size)
+			op := chip8.ParseOpcode(ins)
+			switch op {
+			case chip8.JP:
+				target := chip8.ReadUint12(ins)
+				d.mark(target, label_jump)
+				work = append(work, target)
+			case chip8.CALL:
+				target := chip8.ReadUint12(ins)
+				d.mark(target, label_sub)
+				work = append(work, target)
+			case chip8.JPV0:
+				// Usually a table of jumps, so worth following.
+				target := chip8.ReadUint12(ins)
+				d.mark(target, label_jump)
+				work = append(work, target)
+			case chip8.SE, chip8.SNE, chip8.SRE, chip8.SRNE, chip8.SKP, chip8.SKNP:
+				// Skipping an XO-CHIP long load skips all four bytes.
+				skipped := 2
+				if s := d.size(d.offset(next)); s > 0 {
+					skipped = s
+				}
+				work = append(work, next+uint16(skipped))
+			case chip8.LDI:
+				d.mark(chip8.ReadUint12(ins), label_data)
+			case chip8.LDIL:
+				d.mark(chip8.ReadUint16(ins[2:]), label_data)
+			}
+			if op == chip8.JP || op == chip8.JPV0 || op == chip8.RET || op == chip8.EXIT {
+				break
+			}
+			addr = next
+		}
+	}
+}
+
+// overlaps reports whether the instruction at off would share bytes with one
+// already traced.
+func (d *disassembler) overlaps(off, size int) bool {
+	for i := 0; i < size; i++ {
+		if d.covered[off+i] {
+			return true
+		}
+	}
+	return false
+}
+
+// list lays out the traced program, naming the labels that fall at the start
+// of a line. A label in table takes the place of a made up name, and labels
+// it has that the trace did not find start lines of their own.
+func (d *disassembler) list(syntax Syntax, table *symbols.Table) *Listing {
+	l := &Listing{Syntax: syntax, Labels: make(map[uint16]string)}
+	for addr, kind := range d.kinds {
+		if d.inside(addr) {
+			continue
+		}
+		l.Labels[addr] = fmt.Sprintf("%s_%03X", label_prefixes[kind], addr)
+	}
+	if table != nil {
+		for _, sym := range table.Symbols {
+			if d.inside(sym.Addr) {
+				continue
+			}
+			if name, _ := table.Label(sym.Addr); name == sym.Name {
+				l.Labels[sym.Addr] = sym.Name // the first of several at an address
+			}
+		}
+	}
+
+	for off := 0; off < len(d.program); {
+		addr := d.origin + uint16(off)
+		line := Line{Addr: addr, Label: l.Labels[addr]}
+		if size, ok := d.starts[off]; ok {
+			line.Code = true
+			line.Bytes = d.program[off : off+size]
+			line.Text, _, _ = instruction(line.Bytes, syntax, l.name)
+		} else {
+			end := off + 1
+			for end < len(d.program) && end-off < data_row && !d.covered[end] && l.Labels[d.origin+uint16(end)] == "" {
+				end++
+			}
+			line.Bytes = d.program[off:end]
+			line.Text = syntax.data(line.Bytes)
+		}
+		l.Lines = append(l.Lines, line)
+		off += len(line.Bytes)
+	}
+	return l
+}
+
+// inside reports whether addr is outside the rom or inside an instruction,
+// where a label cannot go.
+func (d *disassembler) inside(addr uint16) bool {
+	off := d.offset(addr)
+	return off < 0 || (d.covered[off] && d.starts[off] == 0)
+}
+
+// name is the label for addr, or addr as a number.
+func (l *Listing) name(addr uint16) string {
+	if label, ok := l.Labels[addr]; ok {
+		return label
+	}
+	return fmt.Sprintf("0x%03X", addr)
+}
+
+// Write writes the listing as source, with each line's address and bytes in
+// a comment.
+func (l *Listing) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	comment := l.Syntax.comment()
+	for i, line := range l.Lines {
+		if line.Label != "" {
+			if i > 0 {
+				bw.WriteString("\n")
+			}
+			fmt.Fprintln(bw, l.Syntax.label(line.Label))
+		}
+		raw := ""
+		for _, b := range line.Bytes {
+			raw += fmt.Sprintf("%02X", b)
+		}
+		fmt.Fprintf(bw, "\t%-*s %s %03X  %s\n", text_width, line.Text, comment, line.Addr, raw)
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,229 @@
+package disasm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gilmae/chip8/symbols"
+)
+
+var program = []byte{
+	0x00, 0xE0, // 200: CLS
+	0xA2, 0x12, // 202: LD I, 212
+	0x22, 0x0C, // 204: CALL 20C
+	0x3F, 0x01, // 206: SE VF, 1
+	0x12, 0x04, // 208: JP 204
+	0x00, 0xFD, // 20A: EXIT
+	0xD0, 0x15, // 20C: DRW V0, V1, 5
+	0x00, 0xEE, // 20E: RET
+	0xFF, 0xFF, // 210: never reached
+	0xF0, 0x90, 0x90, 0x90, 0xF0, // 212: sprite
+}
+
+func TestDisassemble(t *testing.T) {
+	l := Disassemble(program, Options{})
+
+	tests := []struct {
+		addr  uint16
+		code  bool
+		label string
+		text  string
+	}{
+		{0x200, true, "", "CLS"},
+		{0x202, true, "", "LD I, data_212"},
+		{0x204, true, "label_204", "CALL sub_20C"},
+		{0x206, true, "", "SE VF, 0x01"},
+		{0x208, true, "", "JP label_204"},
+		{0x20A, true, "", "EXIT"},
+		{0x20C, true, "sub_20C", "DRW V0, V1, 5"},
+		{0x20E, true, "", "RET"},
+		{0x210, false, "", "DB 0xFF, 0xFF"},
+		{0x212, false, "data_212", "DB 0xF0, 0x90, 0x90, 0x90, 0xF0"},
+	}
+	if len(l.Lines) != len(tests) {
+		t.Fatalf("want %d lines, got=%d: %v", len(tests), len(l.Lines), l.Lines)
+	}
+	for i, tt := range tests {
+		line := l.Lines[i]
+		if line.Addr != tt.addr || line.Code != tt.code || line.Label != tt.label || line.Text != tt.text {
+			t.Errorf("line %d, want=%03X %v %q %q, got=%03X %v %q %q", i, tt.addr, tt.code, tt.label, tt.text, line.Addr, line.Code, line.Label, line.Text)
+		}
+	}
+}
+
+func TestSymbols(t *testing.T) {
+	table := &symbols.Table{Symbols: []symbols.Symbol{
+		{Name: "main", Addr: 0x200},
+		{Name: "draw_player", Addr: 0x20C},
+		{Name: "also_draw", Addr: 0x20C},
+		{Name: "unused", Addr: 0x210},
+		{Name: "mid_instruction", Addr: 0x203},
+	}}
+	l := Disassemble(program, Options{Symbols: table})
+
+	tests := []struct {
+		addr  uint16
+		label string
+		text  string
+	}{
+		{0x200, "main", "CLS"},
+		{0x204, "label_204", "CALL draw_player"},
+		{0x20C, "draw_player", "DRW V0, V1, 5"},
+		{0x210, "unused", "DB 0xFF, 0xFF"},
+	}
+	for _, tt := range tests {
+		found := false
+		for _, line := range l.Lines {
+			if line.Addr == tt.addr {
+				found = true
+				if line.Label != tt.label || line.Text != tt.text {
+					t.Errorf("line at %03X, want=%q %q, got=%q %q", tt.addr, tt.label, tt.text, line.Label, line.Text)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("no line at %03X", tt.addr)
+		}
+	}
+	if _, ok := l.Labels[0x203]; ok {
+		t.Errorf("want no label inside the instruction at 202")
+	}
+
+	if text, _, _ := InstructionWithSymbols([]byte{0x22, 0x0C}, Cowgod, table); text != "CALL draw_player" {
+		t.Errorf("InstructionWithSymbols, want=CALL draw_player, got=%q", text)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	var out strings.Builder
+	if err := Disassemble(program[:12], Options{Syntax: Octo}).Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	want := `	clear                        # 200  00E0
+	i := 0x212                   # 202  A212
+
+: label_204
+	:call 0x20C                  # 204  220C
+	if vf != 0x01 then           # 206  3F01
+	jump label_204               # 208  1204
+	exit                         # 20A  00FD
+`
+	if out.String() != want {
+		t.Errorf("listing, want=\n%s\ngot=\n%s", want, out.String())
+	}
+}
+
+func TestTracing(t *testing.T) {
+	tests := []struct {
+		name    string
+		program []byte
+		opts    Options
+		code    []uint16
+	}{
+		{
+			"skips over an XO-CHIP long load",
+			[]byte{0x30, 0x00, 0xF0, 0x00, 0x02, 0x00, 0x00, 0xFD},
+			Options{},
+			[]uint16{0x200, 0x202, 0x206},
+		},
+		{
+			"follows a jump table",
+			[]byte{0xB2, 0x04, 0xFF, 0xFF, 0x12, 0x08, 0x00, 0x00, 0x00, 0xFD},
+			Options{},
+			[]uint16{0x200, 0x204, 0x208},
+		},
+		{
+			"stops at undefined instructions",
+			[]byte{0x00, 0xE0, 0x51, 0x21, 0x00, 0xE0},
+			Options{},
+			[]uint16{0x200},
+		},
+		{
+			"leaves instructions with stray bits as data",
+			[]byte{0x00, 0xE0, 0x91, 0x21, 0x00, 0xE0},
+			Options{},
+			[]uint16{0x200},
+		},
+		{
+			"starts at extra entry points",
+			[]byte{0x00, 0xFD, 0xFF, 0xFF, 0x00, 0xE0},
+			Options{Entries: []uint16{0x204}},
+			[]uint16{0x200, 0x204},
+		},
+		{
+			"loads elsewhere",
+			[]byte{0x13, 0x04, 0xFF, 0xFF, 0x00, 0xFD},
+			Options{Origin: 0x300},
+			[]uint16{0x300, 0x304},
+		},
+	}
+
+	for _, tt := range tests {
+		var code []uint16
+		for _, line := range Disassemble(tt.program, tt.opts).Lines {
+			if line.Code {
+				code = append(code, line.Addr)
+			}
+		}
+		if len(code) != len(tt.code) {
+			t.Errorf("%s, want code at %03X, got=%03X", tt.name, tt.code, code)
+			continue
+		}
+		for i := range code {
+			if code[i] != tt.code[i] {
+				t.Errorf("%s, want code at %03X, got=%03X", tt.name, tt.code, code)
+				break
+			}
+		}
+	}
+}
+
+func TestInstruction(t *testing.T) {
+	tests := []struct {
+		code   []byte
+		cowgod string
+		octo   string
+	}{
+		{[]byte{0x01, 0x23}, "SYS 0x123", "0x01 0x23"},
+		{[]byte{0x51, 0x20}, "SE V1, V2", "if v1 != v2 then"},
+		{[]byte{0x91, 0x20}, "SNE V1, V2", "if v1 == v2 then"},
+		{[]byte{0x81, 0x27}, "SUBN V1, V2", "v1 =- v2"},
+		{[]byte{0x81, 0x2E}, "SHL V1, V2", "v1 <<= v2"},
+		{[]byte{0xB1, 0x23}, "JP V0, 0x123", "jump0 0x123"},
+		{[]byte{0xC1, 0x0F}, "RND V1, 0x0F", "v1 := random 0x0F"},
+		{[]byte{0xE1, 0x9E}, "SKP V1", "if v1 -key then"},
+		{[]byte{0xE1, 0xA1}, "SKNP V1", "if v1 key then"},
+		{[]byte{0xF1, 0x0A}, "LD V1, K", "v1 := key"},
+		{[]byte{0xF1, 0x18}, "LD ST, V1", "buzzer := v1"},
+		{[]byte{0xF1, 0x29}, "LD F, V1", "i := hex v1"},
+		{[]byte{0xF1, 0x55}, "LD [I], V1", "save v1"},
+		{[]byte{0xF1, 0x65}, "LD V1, [I]", "load v1"},
+		{[]byte{0x00, 0xC4}, "SCD 4", "scroll-down 4"},
+		{[]byte{0xF1, 0x30}, "LD HF, V1", "i := bighex v1"},
+		{[]byte{0xF1, 0x85}, "LD V1, R", "loadflags v1"},
+		{[]byte{0x51, 0x32}, "LD [I], V1-V3", "save v1 - v3"},
+		{[]byte{0x51, 0x33}, "LD V1-V3, [I]", "load v1 - v3"},
+		{[]byte{0xF0, 0x00, 0x12, 0x34}, "LD I, LONG 0x1234", "i := long 0x1234"},
+		{[]byte{0xF3, 0x01}, "PLANE 3", "plane 3"},
+		{[]byte{0xF1, 0x3A}, "PITCH V1", "pitch := v1"},
+	}
+
+	for _, tt := range tests {
+		for syntax, want := range map[Syntax]string{Cowgod: tt.cowgod, Octo: tt.octo} {
+			got, size, err := Instruction(tt.code, syntax)
+			if err != nil {
+				t.Errorf("%X in %s: %s", tt.code, syntax, err)
+				continue
+			}
+			if got != want || size != len(tt.code) {
+				t.Errorf("%X in %s, want=%q (%d bytes), got=%q (%d bytes)", tt.code, syntax, want, len(tt.code), got, size)
+			}
+		}
+	}
+
+	for _, code := range [][]byte{{0x51, 0x21}, {0x12}, {0xF0, 0x00, 0x12}} {
+		if _, _, err := Instruction(code, Cowgod); err == nil {
+			t.Errorf("%X, want error", code)
+		}
+	}
+}
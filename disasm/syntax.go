@@ -0,0 +1,336 @@
+package disasm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gilmae/chip8/chip8"
+	"github.com/gilmae/chip8/symbols"
+)
+
+// Syntax is the assembly language a listing is written in.
+type Syntax int
+
+const (
+	// Cowgod is the syntax of Cowgod's Chip-8 technical reference, extended
+	// for the SUPER-CHIP and XO-CHIP instructions, as the chip8 assembler
+	// reads it.
+	Cowgod Syntax = iota
+	// Octo is the syntax of the Octo assembler.
+	Octo
+)
+
+var syntax_names = map[string]Syntax{
+	"cowgod": Cowgod,
+	"octo":   Octo,
+}
+
+func (s Syntax) String() string {
+	for name, syntax := range syntax_names {
+		if syntax == s {
+			return name
+		}
+	}
+	return fmt.Sprintf("Syntax(%d)", int(s))
+}
+
+// SyntaxByName looks a syntax up by name, ignoring case.
+func SyntaxByName(name string) (Syntax, error) {
+	s, ok := syntax_names[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown syntax %q, want cowgod or octo", name)
+	}
+	return s, nil
+}
+
+func (s Syntax) comment() string {
+	if s == Octo {
+		return "#"
+	}
+	return ";"
+}
+
+func (s Syntax) label(name string) string {
+	if s == Octo {
+		return ": " + name
+	}
+	return name + ":"
+}
+
+func (s Syntax) data(bytes []byte) string {
+	parts := make([]string, len(bytes))
+	for i, b := range bytes {
+		parts[i] = fmt.Sprintf("0x%02X", b)
+	}
+	if s == Octo {
+		return strings.Join(parts, " ")
+	}
+	return "DB " + strings.Join(parts, ", ")
+}
+
+// Instruction describes the instruction at the start of code, returning it
+// and its size. Addresses are written as numbers.
+func Instruction(code []byte, syntax Syntax) (string, int, error) {
+	return instruction(code, syntax, func(addr uint16) string { return fmt.Sprintf("0x%03X", addr) })
+}
+
+// InstructionWithSymbols is Instruction, but writes the addresses that have
+// a label in table by name.
+func InstructionWithSymbols(code []byte, syntax Syntax, table *symbols.Table) (string, int, error) {
+	return instruction(code, syntax, func(addr uint16) string {
+		if name, ok := table.Label(addr); ok {
+			return name
+		}
+		return fmt.Sprintf("0x%03X", addr)
+	})
+}
+
+// instruction describes the instruction at the start of code, naming the
+// addresses it uses with name.
+func instruction(code []byte, syntax Syntax, name func(uint16) string) (string, int, error) {
+	if len(code) < 2 {
+		return "", 0, fmt.Errorf("instruction cut short")
+	}
+	ins := chip8.Instructions(code[:2])
+	op := chip8.ParseOpcode(ins)
+	if op == chip8.UNKNOWN {
+		return "", 0, fmt.Errorf("no instruction %02X%02X", code[0], code[1])
+	}
+	if op == chip8.LDIL {
+		if len(code) < 4 {
+			return "", 0, fmt.Errorf("instruction cut short")
+		}
+		ins = chip8.Instructions(code[:4])
+	}
+
+	x := fmt.Sprintf("V%X", chip8.ReadHighByteNibble(ins))
+	y := fmt.Sprintf("V%X", chip8.ReadLowByteHighNibble(ins))
+	n := chip8.ReadNibble(ins)
+	kk := fmt.Sprintf("0x%02X", chip8.ReadUint8(ins))
+	nnn := chip8.ReadUint12(ins)
+
+	if syntax == Octo {
+		x, y = strings.ToLower(x), strings.ToLower(y)
+		return octo(op, ins, x, y, n, kk, nnn, name), len(ins), nil
+	}
+	return cowgod(op, ins, x, y, n, kk, nnn, name), len(ins), nil
+}
+
+func cowgod(op chip8.Opcode, ins chip8.Instructions, x, y string, n uint8, kk string, nnn uint16, name func(uint16) string) string {
+	switch op {
+	case chip8.SYS:
+		return "SYS " + name(nnn)
+	case chip8.CLS:
+		return "CLS"
+	case chip8.RET:
+		return "RET"
+	case chip8.JP:
+		return "JP " + name(nnn)
+	case chip8.CALL:
+		return "CALL " + name(nnn)
+	case chip8.SE:
+		return fmt.Sprintf("SE %s, %s", x, kk)
+	case chip8.SNE:
+		return fmt.Sprintf("SNE %s, %s", x, kk)
+	case chip8.SRE:
+		return fmt.Sprintf("SE %s, %s", x, y)
+	case chip8.SRNE:
+		return fmt.Sprintf("SNE %s, %s", x, y)
+	case chip8.LD:
+		return fmt.Sprintf("LD %s, %s", x, kk)
+	case chip8.ADD:
+		return fmt.Sprintf("ADD %s, %s", x, kk)
+	case chip8.LDVxVy:
+		return fmt.Sprintf("LD %s, %s", x, y)
+	case chip8.OR:
+		return fmt.Sprintf("OR %s, %s", x, y)
+	case chip8.AND:
+		return fmt.Sprintf("AND %s, %s", x, y)
+	case chip8.XOR:
+		return fmt.Sprintf("XOR %s, %s", x, y)
+	case chip8.ADDVxVy:
+		return fmt.Sprintf("ADD %s, %s", x, y)
+	case chip8.SUB:
+		return fmt.Sprintf("SUB %s, %s", x, y)
+	case chip8.SHR:
+		return fmt.Sprintf("SHR %s, %s", x, y)
+	case chip8.SUBN:
+		return fmt.Sprintf("SUBN %s, %s", x, y)
+	case chip8.SHL:
+		return fmt.Sprintf("SHL %s, %s", x, y)
+	case chip8.LDI:
+		return "LD I, " + name(nnn)
+	case chip8.JPV0:
+		return "JP V0, " + name(nnn)
+	case chip8.RND:
+		return fmt.Sprintf("RND %s, %s", x, kk)
+	case chip8.DRW:
+		return fmt.Sprintf("DRW %s, %s, %d", x, y, n)
+	case chip8.SKP:
+		return "SKP " + x
+	case chip8.SKNP:
+		return "SKNP " + x
+	case chip8.LDVxDT:
+		return fmt.Sprintf("LD %s, DT", x)
+	case chip8.LDK:
+		return fmt.Sprintf("LD %s, K", x)
+	case chip8.LDDTVx:
+		return "LD DT, " + x
+	case chip8.LDSTVx:
+		return "LD ST, " + x
+	case chip8.ADDIVx:
+		return "ADD I, " + x
+	case chip8.LDF:
+		return "LD F, " + x
+	case chip8.LDB:
+		return "LD B, " + x
+	case chip8.LDIVx:
+		return "LD [I], " + x
+	case chip8.LDVxI:
+		return fmt.Sprintf("LD %s, [I]", x)
+	case chip8.SCD:
+		return fmt.Sprintf("SCD %d", n)
+	case chip8.SCR:
+		return "SCR"
+	case chip8.SCL:
+		return "SCL"
+	case chip8.EXIT:
+		return "EXIT"
+	case chip8.LOW:
+		return "LOW"
+	case chip8.HIGH:
+		return "HIGH"
+	case chip8.LDHF:
+		return "LD HF, " + x
+	case chip8.LDRVx:
+		return "LD R, " + x
+	case chip8.LDVxR:
+		return fmt.Sprintf("LD %s, R", x)
+	case chip8.SCU:
+		return fmt.Sprintf("SCU %d", n)
+	case chip8.LDIVxVy:
+		return fmt.Sprintf("LD [I], %s-%s", x, y)
+	case chip8.LDVxVyI:
+		return fmt.Sprintf("LD %s-%s, [I]", x, y)
+	case chip8.LDIL:
+		return "LD I, LONG " + name(chip8.ReadUint16(ins[2:]))
+	case chip8.PLANE:
+		return fmt.Sprintf("PLANE %d", chip8.ReadHighByteNibble(ins))
+	case chip8.AUDIO:
+		return "AUDIO"
+	case chip8.PITCH:
+		return "PITCH " + x
+	}
+	panic(fmt.Sprintf("disasm: no Cowgod syntax for %d", op))
+}
+
+func octo(op chip8.Opcode, ins chip8.Instructions, x, y string, n uint8, kk string, nnn uint16, name func(uint16) string) string {
+	switch op {
+	case chip8.SYS:
+		// Octo has no SYS, so it is written as the bytes it is.
+		return fmt.Sprintf("0x%02X 0x%02X", ins[0], ins[1])
+	case chip8.CLS:
+		return "clear"
+	case chip8.RET:
+		return "return"
+	case chip8.JP:
+		return "jump " + name(nnn)
+	case chip8.CALL:
+		return ":call " + name(nnn)
+	// Octo skips with if ... then, which runs the next instruction when the
+	// condition holds, so the comparisons are the other way round.
+	case chip8.SE:
+		return fmt.Sprintf("if %s != %s then", x, kk)
+	case chip8.SNE:
+		return fmt.Sprintf("if %s == %s then", x, kk)
+	case chip8.SRE:
+		return fmt.Sprintf("if %s != %s then", x, y)
+	case chip8.SRNE:
+		return fmt.Sprintf("if %s == %s then", x, y)
+	case chip8.SKP:
+		return fmt.Sprintf("if %s -key then", x)
+	case chip8.SKNP:
+		return fmt.Sprintf("if %s key then", x)
+	case chip8.LD:
+		return fmt.Sprintf("%s := %s", x, kk)
+	case chip8.ADD:
+		return fmt.Sprintf("%s += %s", x, kk)
+	case chip8.LDVxVy:
+		return fmt.Sprintf("%s := %s", x, y)
+	case chip8.OR:
+		return fmt.Sprintf("%s |= %s", x, y)
+	case chip8.AND:
+		return fmt.Sprintf("%s &= %s", x, y)
+	case chip8.XOR:
+		return fmt.Sprintf("%s ^= %s", x, y)
+	case chip8.ADDVxVy:
+		return fmt.Sprintf("%s += %s", x, y)
+	case chip8.SUB:
+		return fmt.Sprintf("%s -= %s", x, y)
+	case chip8.SHR:
+		return fmt.Sprintf("%s >>= %s", x, y)
+	case chip8.SUBN:
+		return fmt.Sprintf("%s =- %s", x, y)
+	case chip8.SHL:
+		return fmt.Sprintf("%s <<= %s", x, y)
+	case chip8.LDI:
+		return "i := " + name(nnn)
+	case chip8.JPV0:
+		return "jump0 " + name(nnn)
+	case chip8.RND:
+		return fmt.Sprintf("%s := random %s", x, kk)
+	case chip8.DRW:
+		return fmt.Sprintf("sprite %s %s %d", x, y, n)
+	case chip8.LDVxDT:
+		return x + " := delay"
+	case chip8.LDK:
+		return x + " := key"
+	case chip8.LDDTVx:
+		return "delay := " + x
+	case chip8.LDSTVx:
+		return "buzzer := " + x
+	case chip8.ADDIVx:
+		return "i += " + x
+	case chip8.LDF:
+		return "i := hex " + x
+	case chip8.LDB:
+		return "bcd " + x
+	case chip8.LDIVx:
+		return "save " + x
+	case chip8.LDVxI:
+		return "load " + x
+	case chip8.SCD:
+		return fmt.Sprintf("scroll-down %d", n)
+	case chip8.SCR:
+		return "scroll-right"
+	case chip8.SCL:
+		return "scroll-left"
+	case chip8.EXIT:
+		return "exit"
+	case chip8.LOW:
+		return "lores"
+	case chip8.HIGH:
+		return "hires"
+	case chip8.LDHF:
+		return "i := bighex " + x
+	case chip8.LDRVx:
+		return "saveflags " + x
+	case chip8.LDVxR:
+		return "loadflags " + x
+	case chip8.SCU:
+		return fmt.Sprintf("scroll-up %d", n)
+	case chip8.LDIVxVy:
+		return fmt.Sprintf("save %s - %s", x, y)
+	case chip8.LDVxVyI:
+		return fmt.Sprintf("load %s - %s", x, y)
+	case chip8.LDIL:
+		return "i := long " + name(chip8.ReadUint16(ins[2:]))
+	case chip8.PLANE:
+		return fmt.Sprintf("plane %d", chip8.ReadHighByteNibble(ins))
+	case chip8.AUDIO:
+		return "audio"
+	case chip8.PITCH:
+		return "pitch := " + x
+	}
+	panic(fmt.Sprintf("disasm: no Octo syntax for %d", op))
+}
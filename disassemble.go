@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gilmae/chip8/disasm"
+	"github.com/gilmae/chip8/symbols"
+)
+
+// disassemble writes a listing of a rom.
+func disassemble(args []string) {
+	flags := flag.NewFlagSet("disasm", flag.ExitOnError)
+	syntaxName := flags.String("syntax", "cowgod", "assembly syntax: cowgod or octo")
+	origin := flags.String("origin", "200", "hex address the rom is loaded at")
+	entries := flags.String("entry", "", "comma separated hex addresses of code the disassembler cannot find by itself")
+	outPath := flags.String("o", "", "write the listing here rather than to stdout")
+	symPath := flags.String("sym", "", "name labels from this symbol file (default: the rom's .sym file, if there is one)")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s disasm [flags] rom\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() < 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	syntax, err := disasm.SyntaxByName(*syntaxName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(2)
+	}
+	opts := disasm.Options{Syntax: syntax}
+	if opts.Origin, err = parseHexAddr(*origin); err != nil {
+		fmt.Fprintf(os.Stderr, "error: -origin: %s\n", err)
+		os.Exit(2)
+	}
+	if *entries != "" {
+		for _, e := range strings.Split(*entries, ",") {
+			addr, err := parseHexAddr(e)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: -entry: %s\n", err)
+				os.Exit(2)
+			}
+			opts.Entries = append(opts.Entries, addr)
+		}
+	}
+
+	program, err := ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(3)
+	}
+	if *symPath != "" {
+		opts.Symbols, err = symbols.Load(*symPath)
+	} else {
+		opts.Symbols, err = symbols.LoadFor(flags.Arg(0))
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(3)
+	}
+
+	var out io.Writer = os.Stdout
+	if *outPath != "" {
+		f, err := os.Create(*outPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			os.Exit(3)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	if err := disasm.Disassemble(program, opts).Write(out); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(3)
+	}
+}
+
+// parseHexAddr reads a hex address, with or without a 0x prefix.
+func parseHexAddr(s string) (uint16, error) {
+	s = strings.TrimSpace(s)
+	n, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(s), "0x"), 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("bad address %q", s)
+	}
+	return uint16(n), nil
+}
@@ -0,0 +1,349 @@
+package gdbstub
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gilmae/chip8/chip8"
+)
+
+const (
+	packet_size  = 0x1000
+	ok_reply     = "OK"
+	error_reply  = "E01"
+	thread_reply = "1" // the machine is the only thread
+)
+
+var errKilled = errors.New("killed")
+
+// target_xml describes the registers, in the order listed in the package
+// comment.
+var target_xml = func() string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0"?>
+<!DOCTYPE target SYSTEM "gdb-target.dtd">
+<target version="1.0">
+<feature name="org.gilmae.chip8.core">
+`)
+	for i, r := range registers {
+		typ := "uint8"
+		switch r.reg {
+		case chip8.RegI:
+			typ = "data_ptr"
+		case chip8.RegPC:
+			typ = "code_ptr"
+		}
+		fmt.Fprintf(&b, "<reg name=\"%s\" bitsize=\"%d\" type=\"%s\" regnum=\"%d\"/>\n", strings.ToLower(r.reg.String()), r.bytes*8, typ, i)
+	}
+	b.WriteString("</feature>\n</target>\n")
+	return b.String()
+}()
+
+// handle carries out a packet and returns the reply. Packets the stub does not
+// know get the empty reply, which tells the client so. Once the machine is
+// set running the reply is ignored, as the stop it later makes is the reply.
+func (c *session) handle(packet string) (string, error) {
+	cmd, args := packet[0], packet[1:]
+	switch cmd {
+	case '?':
+		return fmt.Sprintf("S%02x", sigtrap), nil
+	case 'g':
+		return c.readRegisters(), nil
+	case 'G':
+		return c.writeRegisters(args), nil
+	case 'p':
+		return c.readRegister(args), nil
+	case 'P':
+		return c.writeRegister(args), nil
+	case 'm':
+		return c.readMemory(args), nil
+	case 'M':
+		return c.writeMemory(args), nil
+	case 'Z', 'z':
+		return c.point(cmd == 'Z', args), nil
+	case 'c':
+		return c.resume(args)
+	case 's':
+		return c.step(args)
+	case 'H', 'T':
+		return ok_reply, nil
+	case 'D':
+		return ok_reply, errDetached
+	case 'k':
+		return "", errKilled
+	case 'q', 'Q':
+		return c.query(packet), nil
+	}
+	return "", nil
+}
+
+func (c *session) query(packet string) string {
+	name, args := packet, ""
+	if i := strings.IndexByte(packet, ':'); i >= 0 {
+		name, args = packet[:i], packet[i+1:]
+	}
+	switch name {
+	case "qSupported":
+		c.swbreak = strings.Contains(args, "swbreak+")
+		return fmt.Sprintf("PacketSize=%x;qXfer:features:read+;QStartNoAckMode+;swbreak+", packet_size)
+	case "QStartNoAckMode":
+		return ok_reply
+	case "qXfer":
+		return readTargetXML(args)
+	case "qAttached":
+		return "1"
+	case "qC":
+		return "QC" + thread_reply
+	case "qfThreadInfo":
+		return "m" + thread_reply
+	case "qsThreadInfo":
+		return "l"
+	}
+	return ""
+}
+
+// readTargetXML answers "features:read:target.xml:offset,length".
+func readTargetXML(args string) string {
+	const prefix = "features:read:target.xml:"
+	if !strings.HasPrefix(args, prefix) {
+		return ""
+	}
+	offset, length, ok := parsePair(args[len(prefix):], ',')
+	if !ok {
+		return error_reply
+	}
+	if offset >= len(target_xml) {
+		return "l"
+	}
+	end := offset + length
+	if end >= len(target_xml) {
+		return "l" + target_xml[offset:]
+	}
+	return "m" + target_xml[offset:end]
+}
+
+// parsePair reads two hex numbers separated by sep.
+func parsePair(s string, sep byte) (int, int, bool) {
+	i := strings.IndexByte(s, sep)
+	if i < 0 {
+		return 0, 0, false
+	}
+	a, err := strconv.ParseUint(s[:i], 16, 32)
+	if err != nil {
+		return 0, 0, false
+	}
+	b, err := strconv.ParseUint(s[i+1:], 16, 32)
+	if err != nil {
+		return 0, 0, false
+	}
+	return int(a), int(b), true
+}
+
+func encodeRegister(value uint16, bytes int) string {
+	if bytes == 1 {
+		return fmt.Sprintf("%02x", value)
+	}
+	return fmt.Sprintf("%04x", value)
+}
+
+func decodeRegister(s string) (uint16, bool) {
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) == 0 || len(b) > 2 {
+		return 0, false
+	}
+	var value uint16
+	for _, x := range b {
+		value = value<<8 | uint16(x)
+	}
+	return value, true
+}
+
+func (c *session) readRegisters() string {
+	m := c.d.Machine()
+	var b strings.Builder
+	for _, r := range registers {
+		b.WriteString(encodeRegister(m.Register(r.reg), r.bytes))
+	}
+	return b.String()
+}
+
+func (c *session) writeRegisters(args string) string {
+	m := c.d.Machine()
+	for _, r := range registers {
+		if len(args) < 2*r.bytes {
+			return error_reply
+		}
+		value, ok := decodeRegister(args[:2*r.bytes])
+		if !ok {
+			return error_reply
+		}
+		m.SetRegister(r.reg, value)
+		args = args[2*r.bytes:]
+	}
+	return ok_reply
+}
+
+func parseRegisterNumber(s string) (int, bool) {
+	n, err := strconv.ParseUint(s, 16, 8)
+	if err != nil || int(n) >= len(registers) {
+		return 0, false
+	}
+	return int(n), true
+}
+
+func (c *session) readRegister(args string) string {
+	n, ok := parseRegisterNumber(args)
+	if !ok {
+		return error_reply
+	}
+	r := registers[n]
+	return encodeRegister(c.d.Machine().Register(r.reg), r.bytes)
+}
+
+func (c *session) writeRegister(args string) string {
+	i := strings.IndexByte(args, '=')
+	if i < 0 {
+		return error_reply
+	}
+	n, ok := parseRegisterNumber(args[:i])
+	if !ok {
+		return error_reply
+	}
+	r := registers[n]
+	value, ok := decodeRegister(args[i+1:])
+	if !ok || len(args[i+1:]) != 2*r.bytes {
+		return error_reply
+	}
+	c.d.Machine().SetRegister(r.reg, value)
+	return ok_reply
+}
+
+// readMemory answers "addr,length". Addresses wrap around memory as they do
+// for the machine.
+func (c *session) readMemory(args string) string {
+	addr, length, ok := parsePair(args, ',')
+	if !ok || length > packet_size/2 {
+		return error_reply
+	}
+	mem := c.d.Machine().Memory()
+	data := make([]byte, length)
+	for i := range data {
+		data[i] = mem[(addr+i)&(len(mem)-1)]
+	}
+	return hex.EncodeToString(data)
+}
+
+// writeMemory carries out "addr,length:data".
+func (c *session) writeMemory(args string) string {
+	i := strings.IndexByte(args, ':')
+	if i < 0 {
+		return error_reply
+	}
+	addr, length, ok := parsePair(args[:i], ',')
+	if !ok {
+		return error_reply
+	}
+	data, err := hex.DecodeString(args[i+1:])
+	if err != nil || len(data) != length {
+		return error_reply
+	}
+	c.d.Machine().WriteMemory(uint16(addr), data)
+	return ok_reply
+}
+
+// point sets or clears a breakpoint or watchpoint from "type,addr,kind".
+// Types 0 and 1, software and hardware breakpoints, are the same thing here;
+// 2, 3 and 4 watch for writes, reads and either. kind is the length watched.
+func (c *session) point(set bool, args string) string {
+	fields := strings.Split(args, ",")
+	if len(fields) < 3 {
+		return error_reply
+	}
+	typ := fields[0]
+	addr, length, ok := parsePair(fields[1]+","+fields[2], ',')
+	if !ok {
+		return error_reply
+	}
+	key := strings.Join(fields[:3], ",")
+
+	if !set {
+		if id, ok := c.points[key]; ok {
+			c.d.Clear(id)
+			delete(c.points, key)
+		}
+		return ok_reply
+	}
+	if _, ok := c.points[key]; ok {
+		return ok_reply
+	}
+
+	var id int
+	switch typ {
+	case "0", "1":
+		id = c.d.SetBreakpoint(uint16(addr))
+	case "2", "3", "4":
+		kind := map[string]chip8.AccessKind{
+			"2": chip8.AccessWrite,
+			"3": chip8.AccessRead,
+			"4": chip8.AccessRead | chip8.AccessWrite,
+		}[typ]
+		if length < 1 {
+			length = 1
+		}
+		id = c.d.Watch(kind, uint16(addr), uint16(addr+length-1))
+	default:
+		return ""
+	}
+	c.points[key] = id
+	return ok_reply
+}
+
+// setPC handles the optional address continue and step take.
+func (c *session) setPC(args string) bool {
+	if args == "" {
+		return true
+	}
+	addr, err := strconv.ParseUint(args, 16, 16)
+	if err != nil {
+		return false
+	}
+	c.d.Machine().SetRegister(chip8.RegPC, uint16(addr))
+	return true
+}
+
+// drain discards a stop the client was never told of.
+func (c *session) drain() {
+	select {
+	case <-c.d.Stops():
+	default:
+	}
+}
+
+func (c *session) resume(args string) (string, error) {
+	if !c.setPC(args) {
+		return error_reply, nil
+	}
+	c.drain()
+	c.running = true
+	c.d.Continue()
+	return "", nil
+}
+
+func (c *session) step(args string) (string, error) {
+	if !c.setPC(args) {
+		return error_reply, nil
+	}
+	c.drain()
+	if err := c.d.StepIn(); err != nil {
+		return error_reply, nil
+	}
+	select {
+	case s := <-c.d.Stops():
+		return c.stopReply(s), nil
+	default:
+		return fmt.Sprintf("S%02x", sigtrap), nil
+	}
+}
@@ -0,0 +1,283 @@
+// Package gdbstub serves a chip8 machine over the GDB Remote Serial Protocol,
+// so gdb and the IDEs that drive it can attach to a running ROM.
+//
+// gdb has no CHIP-8 architecture, so the stub describes its registers with a
+// target description, sent on request as target.xml. They are numbered
+//
+//	0-15  V0 to VF, 8 bits
+//	16    I, 16 bits
+//	17    PC, 16 bits
+//	18    SP, 8 bits
+//	19    DT, 8 bits
+//	20    ST, 8 bits
+//
+// and, like memory, are sent big-endian, CHIP-8's byte order.
+package gdbstub
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/gilmae/chip8/chip8"
+)
+
+// registers lists the machine's registers in gdb's numbering.
+var registers = []struct {
+	reg   chip8.Register
+	bytes int
+}{
+	{chip8.V0, 1}, {chip8.V1, 1}, {chip8.V2, 1}, {chip8.V3, 1},
+	{chip8.V4, 1}, {chip8.V5, 1}, {chip8.V6, 1}, {chip8.V7, 1},
+	{chip8.V8, 1}, {chip8.V9, 1}, {chip8.VA, 1}, {chip8.VB, 1},
+	{chip8.VC, 1}, {chip8.VD, 1}, {chip8.VE, 1}, {chip8.VF, 1},
+	{chip8.RegI, 2},
+	{chip8.RegPC, 2},
+	{chip8.RegSP, 1},
+	{chip8.RegDT, 1},
+	{chip8.RegST, 1},
+}
+
+const (
+	interrupt = 0x03 // sent raw by gdb to stop a running target
+
+	sigint  = 2
+	sigtrap = 5
+)
+
+var errDetached = errors.New("detached")
+
+// Server serves a machine to gdb clients, one connection at a time. A
+// debugger is attached to the machine only while a client is connected, so
+// until one is the machine runs at full speed.
+type Server struct {
+	m *chip8.Machine
+}
+
+func NewServer(m *chip8.Machine) *Server {
+	return &Server{m: m}
+}
+
+// ListenAndServe listens on addr, which should be a loopback address as the
+// protocol has no authentication, and serves connections until the listener
+// fails.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l and serves them in turn.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		s.ServeConn(conn)
+	}
+}
+
+// ServeConn attaches a debugger, stops the machine and serves a client until
+// it detaches, kills the target or the connection drops. A kill stops the
+// machine for good; otherwise it is left running again without the
+// debugger.
+func (s *Server) ServeConn(conn io.ReadWriteCloser) (err error) {
+	defer conn.Close()
+
+	d := chip8.NewDebugger(s.m)
+	d.Pause()
+	<-d.Stops()
+
+	c := &session{
+		d:       d,
+		w:       conn,
+		packets: make(chan string),
+		done:    make(chan struct{}),
+		ack:     true,
+		points:  make(map[string]int),
+	}
+	go c.read(bufio.NewReader(conn))
+	defer close(c.done)
+	defer func() {
+		if err == errKilled {
+			s.m.Stop()
+			err = nil
+		} else {
+			d.Continue()
+		}
+		d.Detach()
+	}()
+
+	return c.serve()
+}
+
+// session is one client's connection.
+type session struct {
+	d       *chip8.Debugger
+	w       io.Writer
+	packets chan string // packets, or a lone interrupt byte, from the client
+	done    chan struct{}
+	err     error // why packets was closed
+
+	ack     bool // acknowledge packets; the reader turns it off for no-ack mode
+	swbreak bool // the client wants to be told of software breakpoints
+	running bool
+	points  map[string]int // "type,addr,kind" of each Z packet to the debugger's id
+}
+
+// read parses packets from the client until the connection fails.
+func (c *session) read(r *bufio.Reader) {
+	defer close(c.packets)
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			c.err = err
+			return
+		}
+		var packet string
+		switch b {
+		case '$':
+			if packet, err = c.readPacket(r); err != nil {
+				c.err = err
+				return
+			}
+			if packet == "QStartNoAckMode" {
+				c.ack = false // acknowledged already, and nothing after it is
+			}
+		case interrupt:
+			packet = string(rune(interrupt))
+		default:
+			continue // acks and noise
+		}
+		select {
+		case c.packets <- packet:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *session) readPacket(r *bufio.Reader) (string, error) {
+	data, err := r.ReadString('#')
+	if err != nil {
+		return "", err
+	}
+	data = data[:len(data)-1]
+	var sum [2]byte
+	if _, err := io.ReadFull(r, sum[:]); err != nil {
+		return "", err
+	}
+
+	if c.ack {
+		want, err := strconv.ParseUint(string(sum[:]), 16, 8)
+		if err != nil || byte(want) != checksum(data) {
+			_, err = c.w.Write([]byte("-"))
+			return "", err
+		}
+		if _, err := c.w.Write([]byte("+")); err != nil {
+			return "", err
+		}
+	}
+	return unescape(data), nil
+}
+
+func (c *session) serve() error {
+	for {
+		var stops <-chan chip8.Stop
+		if c.running {
+			stops = c.d.Stops()
+		}
+
+		select {
+		case packet, ok := <-c.packets:
+			if !ok {
+				return c.err
+			}
+			switch packet {
+			case "":
+				continue // a bad checksum, already nacked
+			case string(rune(interrupt)):
+				if c.running {
+					c.d.Pause() // the stop is reported below
+				}
+				continue
+			}
+			reply, err := c.handle(packet)
+			if err == errDetached {
+				return c.send(reply)
+			}
+			if err != nil {
+				return err
+			}
+			if !c.running {
+				if err := c.send(reply); err != nil {
+					return err
+				}
+			}
+		case stop := <-stops:
+			c.running = false
+			if err := c.send(c.stopReply(stop)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (c *session) send(data string) error {
+	_, err := fmt.Fprintf(c.w, "$%s#%02x", data, checksum(data))
+	return err
+}
+
+func checksum(data string) byte {
+	var sum byte
+	for i := 0; i < len(data); i++ {
+		sum += data[i]
+	}
+	return sum
+}
+
+// unescape undoes the protocol's escaping of $, # and } in binary data.
+func unescape(data string) string {
+	if !strings.Contains(data, "}") {
+		return data
+	}
+	var b strings.Builder
+	for i := 0; i < len(data); i++ {
+		if data[i] == '}' && i+1 < len(data) {
+			i++
+			b.WriteByte(data[i] ^ 0x20)
+		} else {
+			b.WriteByte(data[i])
+		}
+	}
+	return b.String()
+}
+
+// stopReply tells the client why the machine stopped.
+func (c *session) stopReply(s chip8.Stop) string {
+	switch s.Reason {
+	case chip8.StopPause:
+		return fmt.Sprintf("S%02x", sigint)
+	case chip8.StopBreakpoint:
+		if c.swbreak {
+			return fmt.Sprintf("T%02xswbreak:;", sigtrap)
+		}
+	case chip8.StopWatchpoint:
+		if s.Access.Memory {
+			kind := "watch"
+			if s.Access.Kind == chip8.AccessRead {
+				kind = "rwatch"
+			}
+			return fmt.Sprintf("T%02x%s:%x;", sigtrap, kind, s.Access.Addr)
+		}
+	}
+	return fmt.Sprintf("S%02x", sigtrap)
+}
@@ -0,0 +1,230 @@
+package gdbstub
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gilmae/chip8/chip8"
+)
+
+var program = []byte{
+	0x60, 0x05, // 200: LD V0, 5
+	0xA3, 0x00, // 202: LD I, 300
+	0x22, 0x0C, // 204: CALL 20C
+	0x70, 0x01, // 206: ADD V0, 1
+	0x12, 0x04, // 208: JP 204
+	0x00, 0x00,
+	0xF0, 0x55, // 20C: LD [I], V0
+	0x00, 0xEE, // 20E: RET
+}
+
+// client is just enough of gdb to drive the stub.
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+	ran  chan error // what the machine's Run returned
+}
+
+// newTestClient runs the program and connects to a stub serving it over
+// loopback.
+func newTestClient(t *testing.T) (*client, *chip8.Machine) {
+	t.Helper()
+	m := chip8.NewMachine(chip8.WithLogger(log.New(ioutil.Discard, "", 0)))
+	if _, err := m.LoadBytes(program); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ran := make(chan error, 1)
+	go func() {
+		ran <- m.Run(ctx)
+	}()
+	go NewServer(m).Serve(l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		l.Close()
+		cancel()
+	})
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &client{t: t, conn: conn, r: bufio.NewReader(conn), ran: ran}, m
+}
+
+func (c *client) send(packet string) {
+	c.t.Helper()
+	if _, err := fmt.Fprintf(c.conn, "$%s#%02x", packet, checksum(packet)); err != nil {
+		c.t.Fatal(err)
+	}
+	if b, err := c.r.ReadByte(); err != nil || b != '+' {
+		c.t.Fatalf("%s not acknowledged: %q, %v", packet, b, err)
+	}
+}
+
+func (c *client) reply() string {
+	c.t.Helper()
+	if _, err := c.r.ReadString('$'); err != nil {
+		c.t.Fatal(err)
+	}
+	data, err := c.r.ReadString('#')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	data = strings.TrimSuffix(data, "#")
+	var sum [2]byte
+	if _, err := io.ReadFull(c.r, sum[:]); err != nil {
+		c.t.Fatal(err)
+	}
+	if got := fmt.Sprintf("%02x", checksum(data)); got != string(sum[:]) {
+		c.t.Errorf("checksum of %q, want=%s, got=%s", data, got, sum[:])
+	}
+	c.conn.Write([]byte("+"))
+	return data
+}
+
+// expect sends a packet and checks the reply.
+func (c *client) expect(packet, want string) {
+	c.t.Helper()
+	c.send(packet)
+	if got := c.reply(); got != want {
+		c.t.Errorf("%s, want=%q, got=%q", packet, want, got)
+	}
+}
+
+func TestRegistersAndMemory(t *testing.T) {
+	c, m := newTestClient(t)
+
+	c.expect("?", "S05")
+	c.expect("g", "0000000000000000000000000000000000000200000000")
+	c.expect("P1=42", "OK")
+	c.expect("p1", "42")
+	c.expect("P10=0abc", "OK")
+	c.expect("p10", "0abc")
+	c.expect("P10=0a", "E01")
+	c.expect("p15", "E01")
+
+	c.expect("m200,4", "6005a300")
+	c.expect("M300,3:aabbcc", "OK")
+	c.expect("m300,3", "aabbcc")
+	c.expect("M300,2:aa", "E01")
+
+	c.expect("G"+strings.Repeat("01", 16)+"0123"+"0204"+"00"+"05"+"06", "OK")
+	if m.Register(chip8.VF) != 1 || m.I() != 0x123 || m.PC() != 0x204 || m.Delay() != 5 || m.Sound() != 6 {
+		t.Errorf("G, got V=%X I=%03X PC=%03X DT=%d ST=%d", m.Registers(), m.I(), m.PC(), m.Delay(), m.Sound())
+	}
+
+	c.expect("vMustReplyEmpty", "")
+}
+
+func TestTargetDescription(t *testing.T) {
+	c, _ := newTestClient(t)
+
+	c.send("qSupported:multiprocess+;swbreak+;xmlRegisters=i386")
+	if got := c.reply(); !strings.Contains(got, "qXfer:features:read+") {
+		t.Errorf("qSupported, want qXfer:features:read+, got=%q", got)
+	}
+
+	var xml strings.Builder
+	for {
+		c.send(fmt.Sprintf("qXfer:features:read:target.xml:%x,40", xml.Len()))
+		got := c.reply()
+		xml.WriteString(got[1:])
+		if got[0] == 'l' {
+			break
+		}
+	}
+	if xml.String() != target_xml {
+		t.Errorf("target.xml, want=%q, got=%q", target_xml, xml.String())
+	}
+	for _, reg := range []string{`name="v0" bitsize="8"`, `name="i" bitsize="16"`, `name="pc" bitsize="16" type="code_ptr" regnum="17"`, `name="st" bitsize="8" type="uint8" regnum="20"`} {
+		if !strings.Contains(target_xml, reg) {
+			t.Errorf("target.xml lacks %s", reg)
+		}
+	}
+}
+
+func TestBreakpointsAndStepping(t *testing.T) {
+	c, m := newTestClient(t)
+	c.send("qSupported:swbreak+")
+	c.reply()
+
+	c.expect("Z0,20c,2", "OK")
+	c.send("c")
+	if got := c.reply(); got != "T05swbreak:;" {
+		t.Errorf("c, want=%q, got=%q", "T05swbreak:;", got)
+	}
+	c.expect("p11", "020c")
+	c.expect("p12", "01")
+
+	c.expect("s", "S05")
+	c.expect("p11", "020e")
+	c.expect("m300,1", "05")
+
+	c.expect("z0,20c,2", "OK")
+	c.expect("Z2,300,1", "OK")
+	c.expect("M300,1:00", "OK")
+	c.send("c")
+	if got := c.reply(); got != "T05watch:300;" {
+		t.Errorf("c, want=%q, got=%q", "T05watch:300;", got)
+	}
+	c.expect("z2,300,1", "OK")
+
+	c.send("c")
+	c.conn.Write([]byte{interrupt})
+	if got := c.reply(); got != "S02" {
+		t.Errorf("interrupt, want=%q, got=%q", "S02", got)
+	}
+	if pc := m.PC(); pc < 0x204 || pc > 0x20e {
+		t.Errorf("pc after interrupt, got=%03X", pc)
+	}
+
+	c.expect("D", "OK")
+	time.Sleep(50 * time.Millisecond)
+	if m.Paused() {
+		t.Errorf("machine still paused after detach")
+	}
+}
+
+func TestKill(t *testing.T) {
+	c, m := newTestClient(t)
+	c.send("p11")
+	pc := c.reply()
+	c.send("k")
+
+	select {
+	case err := <-c.ran:
+		if err != nil {
+			t.Errorf("unexpected error from killed machine: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("machine still running after kill")
+	}
+	if got := fmt.Sprintf("%04x", m.PC()); got != pc {
+		t.Errorf("killed machine ran on, want pc=%s, got=%s", pc, got)
+	}
+}
+
+func TestBadChecksum(t *testing.T) {
+	c, _ := newTestClient(t)
+
+	fmt.Fprintf(c.conn, "$g#00")
+	if b, err := c.r.ReadByte(); err != nil || b != '-' {
+		t.Errorf("bad checksum, want='-', got=%q, %v", b, err)
+	}
+	c.expect("m200,2", "6005")
+}
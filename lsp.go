@@ -0,0 +1,29 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/gilmae/chip8/lsp"
+)
+
+// serveLSP runs the language server over stdio, for editors.
+func serveLSP(args []string) {
+	flags := flag.NewFlagSet("lsp", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s lsp\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	err := (&lsp.Server{}).ServeConn(struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(5)
+	}
+}
@@ -0,0 +1,321 @@
+package lsp
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/gilmae/chip8/asm"
+	"github.com/gilmae/chip8/chip8"
+	"github.com/gilmae/chip8/disasm"
+	"github.com/gilmae/chip8/octo"
+	"github.com/gilmae/chip8/symbols"
+)
+
+// word is a name, number or mnemonic in a document, at a line and a range of
+// bytes on it, all counted from 0.
+type word struct {
+	text       string
+	line       int
+	start, end int
+}
+
+// definition kinds
+const (
+	def_label = iota
+	def_constant
+	def_alias
+	def_macro
+)
+
+type definition struct {
+	word
+	kind int
+}
+
+// document is an open source file and what is known about it.
+type document struct {
+	uri        string
+	path       string
+	languageID string
+	octo       bool
+	lines      []string
+
+	words []word
+	defs  map[string]definition
+
+	// From the last compile, which failed if errs is set.
+	code  []byte
+	table *symbols.Table
+	errs  asm.ErrorList
+}
+
+func newDocument(uri, languageID, text string) *document {
+	d := &document{uri: uri, path: uriPath(uri), languageID: languageID, lines: strings.Split(text, "\n")}
+	d.octo = languageID == "octo" || strings.EqualFold(filepath.Ext(d.path), ".8o")
+	d.scan()
+	return d
+}
+
+// uriPath is the file a file: URI names, or the URI itself if it is not one.
+func uriPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+func pathURI(path string) string {
+	if strings.Contains(path, "://") {
+		return path
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}).String()
+}
+
+func (d *document) text() string {
+	return strings.Join(d.lines, "\n")
+}
+
+// scan finds the words and definitions in the document. It works without
+// compiling, so it keeps working while the source is broken.
+func (d *document) scan() {
+	d.words = nil
+	d.defs = make(map[string]definition)
+	for n, line := range d.lines {
+		var words []word
+		if d.octo {
+			words = octoWords(n, line)
+		} else {
+			words = asmWords(n, line)
+		}
+		d.words = append(d.words, words...)
+
+		for i, w := range words {
+			if d.octo {
+				if i == 0 {
+					continue
+				}
+				switch words[i-1].text {
+				case ":", ":next":
+					d.define(w, def_label)
+				case ":const", ":calc":
+					d.define(w, def_constant)
+				case ":alias":
+					d.define(w, def_alias)
+				case ":macro":
+					d.define(w, def_macro)
+				}
+				continue
+			}
+			// A label starts the line and ends in a colon; a constant is
+			// the first word, followed by = or EQU.
+			if i > 0 {
+				continue
+			}
+			rest := strings.TrimLeft(line[w.end:], " \t")
+			switch {
+			case strings.HasPrefix(line[w.end:], ":"):
+				d.define(w, def_label)
+			case strings.HasPrefix(rest, "="):
+				d.define(w, def_constant)
+			case len(words) > 1 && strings.EqualFold(words[1].text, "EQU"):
+				d.define(w, def_constant)
+			}
+		}
+	}
+}
+
+func (d *document) define(w word, kind int) {
+	if _, ok := d.defs[w.text]; !ok {
+		d.defs[w.text] = definition{word: w, kind: kind}
+	}
+}
+
+// asmWords splits an assembly line into words, leaving out its comment and
+// strings.
+func asmWords(n int, line string) []word {
+	var words []word
+	for i := 0; i < len(line); {
+		ch := line[i]
+		switch {
+		case ch == ';':
+			return words
+		case ch == '"' || ch == '\'':
+			i++
+			for i < len(line) && line[i] != ch {
+				if line[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			i++
+		case isWordByte(ch):
+			start := i
+			for i < len(line) && isWordByte(line[i]) {
+				i++
+			}
+			words = append(words, word{text: line[start:i], line: n, start: start, end: i})
+		default:
+			i++
+		}
+	}
+	return words
+}
+
+func isWordByte(ch byte) bool {
+	return ch == '_' || ch == '.' || ('a' <= ch && ch <= 'z') || ('A' <= ch && ch <= 'Z') || ('0' <= ch && ch <= '9')
+}
+
+// octoWords splits an Octo line into its white space separated tokens,
+// leaving out its comment.
+func octoWords(n int, line string) []word {
+	var words []word
+	for i := 0; i < len(line); {
+		ch := line[i]
+		switch {
+		case ch == '#':
+			return words
+		case strings.IndexByte(" \t\r{}()", ch) >= 0:
+			i++
+		default:
+			start := i
+			for i < len(line) && strings.IndexByte(" \t\r#{}()", line[i]) < 0 {
+				i++
+			}
+			words = append(words, word{text: line[start:i], line: n, start: start, end: i})
+		}
+	}
+	return words
+}
+
+// wordAt is the word at a position, if there is one.
+func (d *document) wordAt(p position) (word, bool) {
+	for _, w := range d.words {
+		if w.line == p.Line && w.start <= p.Character && p.Character <= w.end {
+			return w, true
+		}
+	}
+	return word{}, false
+}
+
+func (w word) span() span {
+	return span{Start: position{w.line, w.start}, End: position{w.line, w.end}}
+}
+
+// compile builds the document, reading files it includes with readFile.
+func (d *document) compile(readFile func(string) ([]byte, error)) {
+	d.code, d.table, d.errs = nil, nil, nil
+	var err error
+	if d.octo {
+		var p *octo.Program
+		if p, err = octo.Compile(d.path, []byte(d.text())); err == nil {
+			d.code, d.table = p.Code, p.Symbols
+		}
+	} else {
+		var p *asm.Program
+		if p, err = (&asm.Assembler{ReadFile: readFile}).Assemble(d.path, []byte(d.text())); err == nil {
+			d.code, d.table = p.Code, p.Symbols
+		}
+	}
+	switch err := err.(type) {
+	case nil:
+	case asm.ErrorList:
+		d.errs = err
+	default:
+		d.errs = asm.ErrorList{{File: d.path, Line: 1, Msg: err.Error()}}
+	}
+}
+
+// diagnostics turns the compile's errors into diagnostics, by file.
+func (d *document) diagnostics() map[string][]diagnostic {
+	out := map[string][]diagnostic{d.path: {}}
+	for _, e := range d.errs {
+		line, char := e.Line-1, e.Column-1
+		if char < 0 {
+			char = 0
+		}
+		end := char
+		if e.File == d.path && line >= 0 && line < len(d.lines) {
+			if w, ok := d.wordAt(position{line, char}); ok && w.start == char {
+				end = w.end
+			} else if char == 0 {
+				end = len(d.lines[line])
+			}
+		}
+		out[e.File] = append(out[e.File], diagnostic{
+			Range:    span{Start: position{line, char}, End: position{line, end}},
+			Severity: severity_error,
+			Source:   "chip8",
+			Message:  e.Msg,
+		})
+	}
+	return out
+}
+
+// isData reports whether line lays down data rather than instructions.
+func (d *document) isData(line int) bool {
+	var words []word
+	for _, w := range d.words {
+		if w.line == line {
+			words = append(words, w)
+		}
+	}
+	for i := 0; i < len(words); i++ {
+		w := words[i]
+		if def, ok := d.defs[w.text]; ok && def.word == w && def.kind == def_label {
+			continue // an assembly label
+		}
+		if w.text == ":" || w.text == ":next" {
+			i++ // an Octo label
+			continue
+		}
+		if '0' <= w.text[0] && w.text[0] <= '9' || w.text[0] == '-' {
+			return true
+		}
+		switch strings.ToUpper(w.text) {
+		case "DB", "DW", ":BYTE", ":POINTER":
+			return true
+		}
+		return false
+	}
+	return false
+}
+
+// instructions describes the code compiled from line, or returns "" if
+// there is none.
+func (d *document) instructions(line int) string {
+	if d.table == nil || d.isData(line) {
+		return ""
+	}
+	origin := int(asm.DefaultOrigin)
+	var b strings.Builder
+	for i, l := range d.table.Lines {
+		if l.File != d.path || l.Line != line+1 {
+			continue
+		}
+		start, end := int(l.Addr)-origin, len(d.code)
+		for _, next := range d.table.Lines[i+1:] {
+			if next.Addr > l.Addr {
+				end = int(next.Addr) - origin
+				break
+			}
+		}
+		for off := start; off >= 0 && off < end; {
+			text, size, err := disasm.Instruction(d.code[off:end], disasm.Cowgod)
+			if err != nil {
+				break
+			}
+			ins := chip8.Instructions(d.code[off : off+size])
+			def, _ := chip8.Lookup(byte(chip8.ParseOpcode(ins)))
+			fmt.Fprintf(&b, "```\n%s\n```\n`%X` at 0x%03X, `%s`: %s.\n\n", text, []byte(ins), origin+off, def.Pattern, def.Description)
+			off += size
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
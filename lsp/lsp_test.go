@@ -0,0 +1,229 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+// run serves a session of the given messages, numbering the requests from
+// 1, and returns the responses by id and the notifications in order.
+func run(t *testing.T, msgs ...map[string]interface{}) (map[int]json.RawMessage, []notification) {
+	t.Helper()
+	var in bytes.Buffer
+	id := 0
+	for _, msg := range msgs {
+		msg["jsonrpc"] = "2.0"
+		if _, ok := msg["id"]; ok {
+			id++
+			msg["id"] = id
+		}
+		if err := writeMessage(&in, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := (&Server{}).ServeConn(struct {
+		io.Reader
+		io.Writer
+	}{&in, &out}); err != nil {
+		t.Fatal(err)
+	}
+
+	results := make(map[int]json.RawMessage)
+	var notes []notification
+	r := bufio.NewReader(&out)
+	for {
+		body, err := readMessage(r)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		var msg struct {
+			ID     *int            `json:"id"`
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+			Result json.RawMessage `json:"result"`
+			Error  *responseError  `json:"error"`
+		}
+		if err := json.Unmarshal(body, &msg); err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case msg.ID == nil:
+			notes = append(notes, notification{Method: msg.Method, Params: msg.Params})
+		case msg.Error != nil:
+			t.Errorf("request %d: %s", *msg.ID, msg.Error.Message)
+		default:
+			results[*msg.ID] = msg.Result
+		}
+	}
+	return results, notes
+}
+
+func request(method string, params interface{}) map[string]interface{} {
+	return map[string]interface{}{"id": 0, "method": method, "params": params}
+}
+
+func notify(method string, params interface{}) map[string]interface{} {
+	return map[string]interface{}{"method": method, "params": params}
+}
+
+func open(uri, text string) map[string]interface{} {
+	return notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri, "languageId": "", "version": 1, "text": text},
+	})
+}
+
+func at(uri string, line, char int) map[string]interface{} {
+	return map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri},
+		"position":     map[string]interface{}{"line": line, "character": char},
+		"context":      map[string]interface{}{"includeDeclaration": true},
+	}
+}
+
+const game_asm = `SPEED = 3
+start:	LD V1, SPEED
+	CALL draw
+	JP start
+draw:	RET
+`
+
+func TestDiagnostics(t *testing.T) {
+	uri := "file:///src/game.asm"
+	_, notes := run(t,
+		request("initialize", map[string]interface{}{}),
+		open(uri, "CLS\n  JP nowhere\n"),
+		notify("textDocument/didChange", map[string]interface{}{
+			"textDocument":   map[string]interface{}{"uri": uri, "version": 2},
+			"contentChanges": []map[string]interface{}{{"text": "CLS\n"}},
+		}),
+	)
+	if len(notes) != 2 {
+		t.Fatalf("want 2 notifications, got=%d", len(notes))
+	}
+
+	var first, second publishDiagnosticsParams
+	json.Unmarshal(notes[0].Params.(json.RawMessage), &first)
+	json.Unmarshal(notes[1].Params.(json.RawMessage), &second)
+	if first.URI != uri || len(first.Diagnostics) != 1 {
+		t.Fatalf("first diagnostics, want one for %s, got=%+v", uri, first)
+	}
+	want := diagnostic{
+		Range:    span{Start: position{1, 5}, End: position{1, 12}},
+		Severity: severity_error,
+		Source:   "chip8",
+		Message:  "undefined: nowhere",
+	}
+	if first.Diagnostics[0] != want {
+		t.Errorf("diagnostic, want=%+v, got=%+v", want, first.Diagnostics[0])
+	}
+	if second.URI != uri || len(second.Diagnostics) != 0 {
+		t.Errorf("after the fix, want no diagnostics, got=%+v", second)
+	}
+}
+
+func TestNavigation(t *testing.T) {
+	uri := "file:///src/game.asm"
+	results, _ := run(t,
+		request("initialize", map[string]interface{}{}),
+		open(uri, game_asm),
+		request("textDocument/definition", at(uri, 2, 7)),
+		request("textDocument/references", at(uri, 0, 1)),
+		request("textDocument/hover", at(uri, 3, 5)),
+		request("textDocument/hover", at(uri, 1, 8)),
+		request("textDocument/completion", at(uri, 2, 1)),
+	)
+
+	var defs []location
+	json.Unmarshal(results[2], &defs)
+	if len(defs) != 1 || defs[0].URI != uri || defs[0].Range != (span{position{4, 0}, position{4, 4}}) {
+		t.Errorf("definition of draw, want line 4, got=%+v", defs)
+	}
+
+	var refs []location
+	json.Unmarshal(results[3], &refs)
+	if len(refs) != 2 || refs[0].Range.Start != (position{0, 0}) || refs[1].Range.Start != (position{1, 14}) {
+		t.Errorf("references to SPEED, want two, got=%+v", refs)
+	}
+
+	var label, ins hover
+	json.Unmarshal(results[4], &label)
+	json.Unmarshal(results[5], &ins)
+	if !strings.Contains(label.Contents.Value, "start:\tLD V1, SPEED") || !strings.Contains(label.Contents.Value, "Label at 0x200") {
+		t.Errorf("hover on start, got=%q", label.Contents.Value)
+	}
+	for _, want := range []string{"LD V1, 0x03", "`6103` at 0x200", "`6xkk`: Set Vx = kk."} {
+		if !strings.Contains(ins.Contents.Value, want) {
+			t.Errorf("hover on LD, want %q, got=%q", want, ins.Contents.Value)
+		}
+	}
+
+	var items []completionItem
+	json.Unmarshal(results[6], &items)
+	found := make(map[string]int)
+	for _, item := range items {
+		found[item.Label] = item.Kind
+	}
+	for label, kind := range map[string]int{"LD": kind_keyword, "DRW": kind_keyword, "VF": kind_variable, "draw": kind_function, "SPEED": kind_constant} {
+		if found[label] != kind {
+			t.Errorf("completion %s, want kind=%d, got=%d", label, kind, found[label])
+		}
+	}
+}
+
+func TestOcto(t *testing.T) {
+	uri := "file:///src/game.8o"
+	src := ": main\n\tloop\n\t\tdraw\n\tagain\n: draw\n\tif v0 > v1 then clear\n\treturn\n"
+	results, notes := run(t,
+		request("initialize", map[string]interface{}{}),
+		open(uri, src),
+		request("textDocument/definition", at(uri, 2, 3)),
+		request("textDocument/references", at(uri, 4, 3)),
+		request("textDocument/hover", at(uri, 5, 2)),
+	)
+
+	var diags publishDiagnosticsParams
+	json.Unmarshal(notes[0].Params.(json.RawMessage), &diags)
+	if len(diags.Diagnostics) != 0 {
+		t.Errorf("want no diagnostics, got=%+v", diags.Diagnostics)
+	}
+
+	var defs []location
+	json.Unmarshal(results[2], &defs)
+	if len(defs) != 1 || defs[0].Range.Start != (position{4, 2}) {
+		t.Errorf("definition of draw, want 4:2, got=%+v", defs)
+	}
+
+	var refs []location
+	json.Unmarshal(results[3], &refs)
+	if len(refs) != 2 {
+		t.Errorf("references to draw, want two, got=%+v", refs)
+	}
+
+	var h hover
+	json.Unmarshal(results[4], &h)
+	for _, want := range []string{"LD VF, V1", "SUB VF, V0", "SE VF, 0x01", "CLS"} {
+		if !strings.Contains(h.Contents.Value, want) {
+			t.Errorf("hover on the if, want %q, got=%q", want, h.Contents.Value)
+		}
+	}
+}
+
+func TestNotInitialized(t *testing.T) {
+	var in, out bytes.Buffer
+	writeMessage(&in, map[string]interface{}{"jsonrpc": "2.0", "id": 1, "method": "textDocument/hover", "params": at("file:///a.asm", 0, 0)})
+	(&Server{}).ServeConn(struct {
+		io.Reader
+		io.Writer
+	}{&in, &out})
+	if !strings.Contains(out.String(), `"code":-32002`) {
+		t.Errorf("want a not initialized error, got=%s", out.String())
+	}
+}
@@ -0,0 +1,398 @@
+// Package lsp is a Language Server Protocol server for CHIP-8 assembly and
+// Octo, so editors can check programs as they are written.
+//
+// It speaks JSON-RPC over a single connection, usually stdio, and offers
+// diagnostics from the assembler or Octo compiler, go to definition and find
+// references for labels, constants, aliases and macros, hover showing what
+// the code on a line assembles to and does, and completion of mnemonics,
+// registers and names. Files ending .8o, or opened as the octo language, are
+// Octo; everything else is assembly.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/gilmae/chip8/asm"
+	"github.com/gilmae/chip8/octo"
+)
+
+// Server serves language server sessions.
+type Server struct {
+	// ReadFile reads files included by a document that are not open in the
+	// editor. ioutil.ReadFile if nil.
+	ReadFile func(path string) ([]byte, error)
+}
+
+// ServeConn serves one session until the client sends exit or closes the
+// connection. Pass a stdin and stdout pair to serve over stdio.
+func (s *Server) ServeConn(conn io.ReadWriter) error {
+	c := &session{s: s, w: conn, docs: make(map[string]*document), published: make(map[string][]string)}
+	r := bufio.NewReader(conn)
+	for !c.exited {
+		body, err := readMessage(r)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		var msg message
+		if err := json.Unmarshal(body, &msg); err != nil {
+			if err := c.reply(nil, nil, &responseError{Code: code_parse_error, Message: err.Error()}); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := c.handle(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// session is one client's session.
+type session struct {
+	s           *Server
+	w           io.Writer
+	docs        map[string]*document
+	published   map[string][]string // the files each document last published diagnostics for
+	initialized bool
+	exited      bool
+}
+
+var handlers = map[string]func(c *session, params json.RawMessage) (interface{}, error){
+	"initialize":              (*session).initialize,
+	"shutdown":                (*session).shutdown,
+	"textDocument/hover":      (*session).hover,
+	"textDocument/definition": (*session).definition,
+	"textDocument/references": (*session).references,
+	"textDocument/completion": (*session).completion,
+}
+
+var notifications = map[string]func(c *session, params json.RawMessage) error{
+	"exit":                   (*session).exit,
+	"textDocument/didOpen":   (*session).didOpen,
+	"textDocument/didChange": (*session).didChange,
+	"textDocument/didClose":  (*session).didClose,
+}
+
+// handle answers a request or acts on a notification. Only failing to write
+// ends the session.
+func (c *session) handle(msg message) error {
+	if len(msg.ID) == 0 {
+		if n, ok := notifications[msg.Method]; ok {
+			return n(c, msg.Params)
+		}
+		return nil // others, such as initialized and $/cancelRequest, need nothing doing
+	}
+
+	handler, ok := handlers[msg.Method]
+	switch {
+	case !ok:
+		return c.reply(msg.ID, nil, &responseError{Code: code_method_not_found, Message: fmt.Sprintf("unsupported method %q", msg.Method)})
+	case !c.initialized && msg.Method != "initialize":
+		return c.reply(msg.ID, nil, &responseError{Code: code_server_not_inited, Message: "not initialized"})
+	}
+	result, err := handler(c, msg.Params)
+	if err != nil {
+		return c.reply(msg.ID, nil, &responseError{Code: code_request_failed, Message: err.Error()})
+	}
+	return c.reply(msg.ID, result, nil)
+}
+
+func (c *session) reply(id json.RawMessage, result interface{}, err *responseError) error {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	return writeMessage(c.w, &response{JSONRPC: "2.0", ID: id, Result: result, Error: err})
+}
+
+func (c *session) notify(method string, params interface{}) error {
+	return writeMessage(c.w, &notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+func decode(raw json.RawMessage, v interface{}) error {
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("bad params: %w", err)
+	}
+	return nil
+}
+
+func (c *session) initialize(json.RawMessage) (interface{}, error) {
+	c.initialized = true
+	var result initializeResult
+	result.Capabilities.TextDocumentSync = 1
+	result.Capabilities.HoverProvider = true
+	result.Capabilities.DefinitionProvider = true
+	result.Capabilities.ReferencesProvider = true
+	result.ServerInfo.Name = "chip8"
+	return result, nil
+}
+
+func (c *session) shutdown(json.RawMessage) (interface{}, error) {
+	return nil, nil
+}
+
+func (c *session) exit(json.RawMessage) error {
+	c.exited = true
+	return nil
+}
+
+func (c *session) didOpen(raw json.RawMessage) error {
+	var params didOpenParams
+	if err := decode(raw, &params); err != nil {
+		return nil
+	}
+	doc := params.TextDocument
+	c.docs[doc.URI] = newDocument(doc.URI, doc.LanguageID, doc.Text)
+	return c.check(doc.URI)
+}
+
+func (c *session) didChange(raw json.RawMessage) error {
+	var params didChangeParams
+	if err := decode(raw, &params); err != nil || len(params.ContentChanges) == 0 {
+		return nil
+	}
+	old, ok := c.docs[params.TextDocument.URI]
+	if !ok {
+		return nil
+	}
+	text := params.ContentChanges[len(params.ContentChanges)-1].Text
+	d := newDocument(old.uri, old.languageID, text)
+	c.docs[d.uri] = d
+	return c.check(d.uri)
+}
+
+func (c *session) didClose(raw json.RawMessage) error {
+	var params didCloseParams
+	if err := decode(raw, &params); err != nil {
+		return nil
+	}
+	uri := params.TextDocument.URI
+	d, ok := c.docs[uri]
+	if !ok {
+		return nil
+	}
+	delete(c.docs, uri)
+	for _, file := range c.published[uri] {
+		target := pathURI(file)
+		if file == d.path {
+			target = uri
+		}
+		if err := c.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{URI: target, Diagnostics: []diagnostic{}}); err != nil {
+			return err
+		}
+	}
+	delete(c.published, uri)
+	return nil
+}
+
+// check compiles a document and publishes its diagnostics, clearing those
+// it published before for files that are now fine.
+func (c *session) check(uri string) error {
+	d := c.docs[uri]
+	d.compile(c.readFile)
+	byFile := d.diagnostics()
+	for _, file := range c.published[uri] {
+		if _, ok := byFile[file]; !ok {
+			byFile[file] = []diagnostic{}
+		}
+	}
+
+	files := make([]string, 0, len(byFile))
+	for file := range byFile {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	c.published[uri] = nil
+	for _, file := range files {
+		target := pathURI(file)
+		if file == d.path {
+			target = uri
+		}
+		if err := c.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{URI: target, Diagnostics: byFile[file]}); err != nil {
+			return err
+		}
+		if len(byFile[file]) > 0 {
+			c.published[uri] = append(c.published[uri], file)
+		}
+	}
+	return nil
+}
+
+// readFile reads an included file from the editor if it is open there, else
+// from disk.
+func (c *session) readFile(path string) ([]byte, error) {
+	for _, d := range c.docs {
+		if d.path == path {
+			return []byte(d.text()), nil
+		}
+	}
+	if c.s.ReadFile != nil {
+		return c.s.ReadFile(path)
+	}
+	return ioutil.ReadFile(path)
+}
+
+// at finds the document and word a request is about.
+func (c *session) at(raw json.RawMessage, params interface{}, p *positionParams) (*document, word, bool, error) {
+	if err := decode(raw, params); err != nil {
+		return nil, word{}, false, err
+	}
+	d, ok := c.docs[p.TextDocument.URI]
+	if !ok {
+		return nil, word{}, false, fmt.Errorf("%s is not open", p.TextDocument.URI)
+	}
+	w, ok := d.wordAt(p.Position)
+	return d, w, ok, nil
+}
+
+// find looks for the definition of name, in d first and then in the other
+// open documents of its language.
+func (c *session) find(d *document, name string) (*document, definition, bool) {
+	if def, ok := d.defs[name]; ok {
+		return d, def, true
+	}
+	for _, uri := range c.uris() {
+		other := c.docs[uri]
+		if other.octo != d.octo {
+			continue
+		}
+		if def, ok := other.defs[name]; ok {
+			return other, def, true
+		}
+	}
+	return nil, definition{}, false
+}
+
+func (c *session) uris() []string {
+	uris := make([]string, 0, len(c.docs))
+	for uri := range c.docs {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+	return uris
+}
+
+func (c *session) hover(raw json.RawMessage) (interface{}, error) {
+	var params positionParams
+	d, w, ok, err := c.at(raw, &params, &params)
+	if err != nil {
+		return nil, err
+	}
+
+	var text string
+	var r *span
+	if ok {
+		if in, def, found := c.find(d, w.text); found {
+			text = fmt.Sprintf("```\n%s\n```", strings.TrimSpace(in.lines[def.line]))
+			if def.kind == def_label && in.table != nil {
+				if addr, ok := in.table.Lookup(w.text); ok {
+					text += fmt.Sprintf("\nLabel at 0x%03X.", addr)
+				}
+			}
+			s := w.span()
+			r = &s
+		}
+	}
+	if text == "" {
+		text = d.instructions(params.Position.Line)
+	}
+	if text == "" {
+		return nil, nil
+	}
+	return hover{Contents: markupContent{Kind: "markdown", Value: text}, Range: r}, nil
+}
+
+func (c *session) definition(raw json.RawMessage) (interface{}, error) {
+	var params positionParams
+	d, w, ok, err := c.at(raw, &params, &params)
+	if err != nil || !ok {
+		return nil, err
+	}
+	in, def, found := c.find(d, w.text)
+	if !found {
+		return nil, nil
+	}
+	return []location{{URI: in.uri, Range: def.span()}}, nil
+}
+
+func (c *session) references(raw json.RawMessage) (interface{}, error) {
+	var params referenceParams
+	d, w, ok, err := c.at(raw, &params, &params.positionParams)
+	if err != nil || !ok {
+		return nil, err
+	}
+	in, def, found := c.find(d, w.text)
+	if !found {
+		return nil, nil
+	}
+
+	locations := []location{}
+	for _, uri := range c.uris() {
+		other := c.docs[uri]
+		if other.octo != in.octo {
+			continue
+		}
+		for _, use := range other.words {
+			if use.text != w.text {
+				continue
+			}
+			if other == in && use == def.word && !params.Context.IncludeDeclaration {
+				continue
+			}
+			locations = append(locations, location{URI: other.uri, Range: use.span()})
+		}
+	}
+	return locations, nil
+}
+
+func (c *session) completion(raw json.RawMessage) (interface{}, error) {
+	var params positionParams
+	if err := decode(raw, &params); err != nil {
+		return nil, err
+	}
+	d, ok := c.docs[params.TextDocument.URI]
+	if !ok {
+		return nil, fmt.Errorf("%s is not open", params.TextDocument.URI)
+	}
+
+	var items []completionItem
+	keywords := append(asm.Mnemonics(), asm.Keywords()...)
+	register := "V%X"
+	if d.octo {
+		keywords, register = octo.Keywords(), "v%x"
+	}
+	for _, k := range keywords {
+		items = append(items, completionItem{Label: k, Kind: kind_keyword})
+	}
+	for r := 0; r < 16; r++ {
+		items = append(items, completionItem{Label: fmt.Sprintf(register, r), Kind: kind_variable, Detail: "register"})
+	}
+
+	names := make([]string, 0, len(d.defs))
+	for name := range d.defs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		def := d.defs[name]
+		item := completionItem{Label: name, Detail: strings.TrimSpace(d.lines[def.line])}
+		switch def.kind {
+		case def_label, def_macro:
+			item.Kind = kind_function
+		case def_constant:
+			item.Kind = kind_constant
+		case def_alias:
+			item.Kind = kind_variable
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
@@ -1,12 +1,20 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
-	"io/ioutil"
+	"log"
+	"net"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
 
 	"github.com/gilmae/chip8/chip8"
-	"github.com/veandco/go-sdl2/sdl"
+	"github.com/gilmae/chip8/gdbstub"
+	"github.com/gilmae/chip8/sdlhost"
 )
 
 var (
@@ -14,60 +22,199 @@ var (
 	winWidth  int32 = 64 * 10
 )
 
+// SDL has to be called from the thread that initialised it, so the main
+// goroutine, which makes every SDL call, keeps to the main thread.
+func init() {
+	runtime.LockOSThread()
+}
+
 func main() {
-	err := sdl.Init(sdl.INIT_EVERYTHING)
-	if err != nil {
-		panic(err)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "debug":
+			debug(os.Args[2:])
+			return
+		case "dap":
+			serveDAP(os.Args[2:])
+			return
+		case "disasm":
+			disassemble(os.Args[2:])
+			return
+		case "asm":
+			assemble(os.Args[2:])
+			return
+		case "lsp":
+			serveLSP(os.Args[2:])
+			return
+		case "tracediff":
+			traceDiff(os.Args[2:])
+			return
+		}
 	}
-	defer sdl.Quit()
 
-	sdl.SetHint(sdl.HINT_RENDER_SCALE_QUALITY, "0")
+	hz := flag.Int("hz", chip8.DefaultCPUHz, "instructions executed per second")
+	ipf := flag.Int("ipf", 0, "instructions executed per 60 Hz frame; overrides -hz")
+	platformName := flag.String("platform", "chip8", "platform: chip8, schip or xochip")
+	quirkProfile := flag.String("quirks", "", "quirk profile, overriding the platform's: "+strings.Join(chip8.QuirkProfileNames(), ", "))
+	toneHz := flag.Float64("tone", chip8.DefaultToneHz, "buzzer frequency in Hz")
+	waveformName := flag.String("waveform", "square", "buzzer waveform: square, sine, triangle or sawtooth")
+	volume := flag.Float64("volume", chip8.DefaultVolume, "buzzer volume, 0 to 1")
+	mute := flag.Bool("mute", false, "silence the buzzer")
+	keymapPath := flag.String("keymap", "", "JSON keymap file (default: chip8/keymap.json in the user config directory, if present)")
+	rewindMB := flag.Int("rewind", 16, "megabytes of history kept for rewinding; 0 turns rewind off")
+	gdbAddr := flag.String("gdb", "", "serve the GDB remote protocol on this address, e.g. localhost:1234")
+	paletteSpec := flag.String("palette", "", "four comma separated RRGGBB colours for background, plane 1, plane 2 and both")
+	traceOpts := addTraceFlags(flag.CommandLine)
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] rom|source.8o\n       %s debug [flags] rom\n       %s dap [flags]\n       %s disasm [flags] rom\n       %s asm [flags] source\n       %s lsp\n       %s tracediff [flags] trace1 trace2\n", os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nShift+F1 to Shift+F9 save the machine to a numbered slot next to the rom; F1 to F9 load it again. Hold Backspace to rewind.\n")
+	}
+	flag.Parse()
 
-	window, err := sdl.CreateWindow("Chip-8", sdl.WINDOWPOS_UNDEFINED, sdl.WINDOWPOS_UNDEFINED, winWidth, winHeight, sdl.WINDOW_SHOWN)
-	if err != nil {
-		panic(err)
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
 	}
-	defer window.Destroy()
 
-	r, err := sdl.CreateRenderer(window, -1, sdl.RENDERER_ACCELERATED)
+	opts, err := machineOptions(*platformName, *quirkProfile)
 	if err != nil {
-		panic(err)
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(2)
 	}
 
-	defer r.Destroy()
-
-	tex, err := r.CreateTexture(sdl.PIXELFORMAT_ABGR8888, sdl.TEXTUREACCESS_STATIC, winWidth, winHeight)
+	waveform, err := chip8.WaveformByName(*waveformName)
 	if err != nil {
-		panic(err)
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(2)
 	}
-	defer tex.Destroy()
-
-	renderer := chip8.NewSdlRenderer(winWidth, winHeight, window, r, tex)
-
-	defer renderer.Close()
 
-	keyboard := chip8.NewKeyboard()
-	cpu := chip8.NewCpu(keyboard, renderer)
+	palette := chip8.DefaultPalette
+	if *paletteSpec != "" {
+		palette, err = chip8.ParsePalette(*paletteSpec)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			os.Exit(2)
+		}
+	}
 
-	if len(os.Args) < 2 {
+	romPath := flag.Arg(0)
+	program, table, err := loadProgram(romPath)
+	if err != nil {
+		printError(err)
+		os.Exit(3)
+	}
+	tracer, doneTracing, err := traceOpts.tracer(table)
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "error: %s\n", err)
 		os.Exit(2)
 	}
 
-	filepath := os.Args[1]
-	program, err := ioutil.ReadFile(filepath)
-
+	keymap, err := loadKeymap(*keymapPath, program)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error: %s\n", err)
 		os.Exit(3)
 	}
 
+	host, err := sdlhost.New("Chip-8", winWidth, winHeight)
+	if err != nil {
+		panic(err)
+	}
+	defer host.Close()
+	host.Palette = palette
+
+	audio, err := sdlhost.NewAudio(chip8.DefaultSampleRate)
+	if err != nil {
+		panic(err)
+	}
+	defer audio.Close()
+	audio.Beeper.Frequency = *toneHz
+	audio.Beeper.Waveform = waveform
+	audio.Beeper.Volume = *volume
+	audio.Beeper.Muted = *mute
+	opts = append(opts, chip8.WithAudio(audio), chip8.WithRewind(*rewindMB<<20), chip8.WithTracer(tracer))
+
+	keyboard := chip8.NewKeyboard()
+	keyboard.SetKeymap(keymap)
+	speed := chip8.WithCPUHz(*hz)
+	if *ipf > 0 {
+		speed = chip8.WithInstructionsPerFrame(*ipf)
+	}
+	cpu := chip8.NewCpu(keyboard, host, append(opts, speed)...)
+
 	_, err = cpu.LoadBytes(program)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error: %s\n", err)
 		os.Exit(4)
 	}
 
-	cpu.Run()
+	slots := &sdlhost.SaveSlots{
+		Machine: cpu,
+		Base:    strings.TrimSuffix(romPath, filepath.Ext(romPath)),
+		Logger:  log.New(os.Stderr, "", 0),
+	}
+	host.Hotkey = sdlhost.Rewind(cpu, slots.Hotkey)
+
+	if *gdbAddr != "" {
+		l, err := net.Listen("tcp", *gdbAddr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			os.Exit(2)
+		}
+		defer l.Close()
+		go gdbstub.NewServer(cpu).Serve(l)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	err = cpu.Run(ctx)
+	if terr := doneTracing(); terr != nil {
+		fmt.Fprintf(os.Stderr, "error: trace: %s\n", terr)
+	}
+	if err != nil && err != context.Canceled {
+		printError(sourceError(err, cpu, table))
+		os.Exit(5)
+	}
+
+}
 
+// loadKeymap picks the keymap for program from the file at path or, if path
+// is empty, from the user's config directory when a keymap is saved there.
+func loadKeymap(path string, program []byte) (chip8.Keymap, error) {
+	if path == "" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return chip8.DefaultKeymap, nil
+		}
+		path = filepath.Join(dir, "chip8", "keymap.json")
+		if _, err := os.Stat(path); err != nil {
+			return chip8.DefaultKeymap, nil
+		}
+	}
+
+	config, err := chip8.LoadKeymapFile(path)
+	if err != nil {
+		return chip8.DefaultKeymap, err
+	}
+	return config.For(chip8.RomHash(program)), nil
+}
+
+// machineOptions configures the platform and, if a profile is named, the
+// quirks that override the platform's own.
+func machineOptions(platformName, quirkProfile string) ([]chip8.Option, error) {
+	platform, err := chip8.PlatformByName(platformName)
+	if err != nil {
+		return nil, err
+	}
+	opts := []chip8.Option{chip8.WithPlatform(platform)}
+
+	if quirkProfile != "" {
+		quirks, err := chip8.QuirksByName(quirkProfile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, chip8.WithQuirks(quirks))
+	}
+	return opts, nil
 }
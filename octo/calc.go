@@ -0,0 +1,124 @@
+package octo
+
+import (
+	"math"
+)
+
+// Octo's :calc expressions have no precedence: operators are worked out
+// right to left, and parentheses group.
+
+var calc_unary = map[string]func(float64) float64{
+	"-":     func(x float64) float64 { return -x },
+	"~":     func(x float64) float64 { return float64(^int64(x)) },
+	"!":     func(x float64) float64 { return truth(x == 0) },
+	"sin":   math.Sin,
+	"cos":   math.Cos,
+	"tan":   math.Tan,
+	"exp":   math.Exp,
+	"log":   math.Log,
+	"abs":   math.Abs,
+	"sqrt":  math.Sqrt,
+	"ceil":  math.Ceil,
+	"floor": math.Floor,
+	"sign": func(x float64) float64 {
+		switch {
+		case x < 0:
+			return -1
+		case x > 0:
+			return 1
+		}
+		return 0
+	},
+}
+
+var calc_binary = map[string]func(x, y float64) float64{
+	"-":   func(x, y float64) float64 { return x - y },
+	"+":   func(x, y float64) float64 { return x + y },
+	"*":   func(x, y float64) float64 { return x * y },
+	"/":   func(x, y float64) float64 { return x / y },
+	"%":   func(x, y float64) float64 { return math.Mod(x, y) },
+	"&":   func(x, y float64) float64 { return float64(int64(x) & int64(y)) },
+	"|":   func(x, y float64) float64 { return float64(int64(x) | int64(y)) },
+	"^":   func(x, y float64) float64 { return float64(int64(x) ^ int64(y)) },
+	"<<":  func(x, y float64) float64 { return float64(int64(x) << uint(y)) },
+	">>":  func(x, y float64) float64 { return float64(int64(x) >> uint(y)) },
+	"pow": math.Pow,
+	"min": math.Min,
+	"max": math.Max,
+	"<":   func(x, y float64) float64 { return truth(x < y) },
+	">":   func(x, y float64) float64 { return truth(x > y) },
+	"<=":  func(x, y float64) float64 { return truth(x <= y) },
+	">=":  func(x, y float64) float64 { return truth(x >= y) },
+	"==":  func(x, y float64) float64 { return truth(x == y) },
+	"!=":  func(x, y float64) float64 { return truth(x != y) },
+}
+
+func truth(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// calc reads a braced expression, the { already read, and works it out.
+func (c *compiler) calc() float64 {
+	x := c.calcExpr("}")
+	c.expect("}")
+	return x
+}
+
+// calcExpr works out an expression that ends at the token end.
+func (c *compiler) calcExpr(end string) float64 {
+	x := c.calcTerm()
+	if c.peek() == end {
+		return x
+	}
+	tok := c.next()
+	op, ok := calc_binary[tok.text]
+	if !ok {
+		c.fail(tok, "unknown operator %q in calculation", tok.text)
+	}
+	y := c.calcExpr(end)
+	if (tok.text == "/" || tok.text == "%") && y == 0 {
+		c.fail(tok, "division by zero")
+	}
+	return op(x, y)
+}
+
+func (c *compiler) calcTerm() float64 {
+	tok := c.next()
+	if n, ok := parseNumber(tok.text); ok {
+		return float64(n)
+	}
+	if op, ok := calc_unary[tok.text]; ok {
+		return op(c.calcTerm())
+	}
+	switch tok.text {
+	case "(":
+		x := c.calcExpr(")")
+		c.expect(")")
+		return x
+	case "@":
+		// The byte at an address of the rom so far.
+		tok = c.peekToken()
+		addr := int(c.calcTerm())
+		if addr < origin || addr >= origin+len(c.rom) {
+			c.fail(tok, "@ %d is outside the rom", addr)
+		}
+		return float64(c.rom[addr-origin])
+	case "HERE":
+		return float64(c.pc)
+	case "PI":
+		return math.Pi
+	case "E":
+		return math.E
+	}
+	if v, ok := c.constants[tok.text]; ok {
+		return v
+	}
+	if addr, ok := c.labels[tok.text]; ok {
+		return float64(addr)
+	}
+	c.fail(tok, "undefined name %q in calculation", tok.text)
+	return 0
+}
@@ -0,0 +1,57 @@
+package octo
+
+import (
+	"strings"
+)
+
+// token is a word of source, and where it was written.
+type token struct {
+	text   string
+	file   string
+	line   int
+	column int
+}
+
+// lex splits src into tokens. Tokens are separated by white space, except
+// that braces and parentheses are tokens of their own, and quoted strings
+// are one token with their quotes. Comments run from # to the end of the
+// line.
+func lex(file string, src string) []token {
+	var tokens []token
+	for n, line := range strings.Split(src, "\n") {
+		for i := 0; i < len(line); {
+			ch := line[i]
+			switch {
+			case ch == ' ' || ch == '\t' || ch == '\r':
+				i++
+				continue
+			case ch == '#':
+				i = len(line)
+				continue
+			}
+
+			start := i
+			switch {
+			case strings.IndexByte("{}()", ch) >= 0:
+				i++
+			case ch == '"':
+				i++
+				for i < len(line) && line[i] != '"' {
+					if line[i] == '\\' {
+						i++
+					}
+					i++
+				}
+				if i < len(line) {
+					i++
+				}
+			default:
+				for i < len(line) && strings.IndexByte(" \t\r#{}()", line[i]) < 0 {
+					i++
+				}
+			}
+			tokens = append(tokens, token{text: line[start:i], file: file, line: n + 1, column: start + 1})
+		}
+	}
+	return tokens
+}
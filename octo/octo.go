@@ -0,0 +1,931 @@
+// Package octo compiles programs written in Octo, the language most CHIP-8
+// programs are written in nowadays, to roms.
+//
+// It understands labels (: name and :next name), :alias, :const, :org,
+// :byte, :pointer, :call, :unpack, :macro, :calc and :assert, every
+// instruction in Octo's syntax, if ... then, if ... begin ... else ... end,
+// and loop ... while ... again. If a label called main is defined anywhere
+// but the start of the program, the rom starts with a jump to it.
+//
+// Errors are *asm.Error, so they say where in the source they are, and the
+// compiled program has a line table mapping its code back to the source.
+package octo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gilmae/chip8/asm"
+	"github.com/gilmae/chip8/chip8"
+	"github.com/gilmae/chip8/symbols"
+)
+
+// Origin is where Octo programs are loaded, and start.
+const Origin uint16 = 0x200
+
+const (
+	origin          = int(Origin)
+	max_expansions  = 10000 // macro expansions before giving up on a runaway macro
+	compare_temp    = "compare-temp"
+	default_compare = 0xF
+)
+
+// Program is a compiled rom.
+type Program struct {
+	Code    []byte
+	Symbols *symbols.Table // the labels, and the line each piece of code came from
+}
+
+type macro struct {
+	args []string
+	body []token
+}
+
+const (
+	fixup_address = iota // the low 12 bits of an instruction
+	fixup_pointer        // two bytes
+	fixup_unpack         // the bytes of the two loads :unpack makes
+)
+
+// fixup is a use of a label before it is defined, to fill in once it is.
+type fixup struct {
+	kind   int
+	addr   int
+	nibble int
+	tok    token
+}
+
+type loop struct {
+	start  int
+	whiles []int
+	tok    token
+}
+
+type branch struct {
+	addr int
+	tok  token
+}
+
+// bail carries a compile error up to Compile.
+type bail struct {
+	err *asm.Error
+}
+
+type compiler struct {
+	tokens     []token
+	pos        int
+	expansions int
+
+	rom     []byte
+	pc      int
+	written int // bytes emitted so far
+
+	labels    map[string]int
+	order     []string // labels, in the order they are defined
+	constants map[string]float64
+	aliases   map[string]int
+	macros    map[string]*macro
+	protos    map[string][]fixup
+
+	loops    []*loop
+	branches []branch
+	lines    []symbols.Line
+}
+
+var keywords = map[string]bool{
+	":": true, ":=": true, ";": true, "{": true, "}": true, "(": true, ")": true,
+	"again": true, "audio": true, "bcd": true, "begin": true, "bighex": true,
+	"buzzer": true, "clear": true, "delay": true, "else": true, "end": true,
+	"exit": true, "hex": true, "hires": true, "i": true, "if": true, "jump": true,
+	"jump0": true, "key": true, "-key": true, "load": true, "loadflags": true,
+	"long": true, "loop": true, "lores": true, "native": true, "pitch": true,
+	"plane": true, "random": true, "return": true, "save": true,
+	"saveflags": true, "scroll-down": true, "scroll-left": true,
+	"scroll-right": true, "scroll-up": true, "sprite": true, "then": true,
+	"while": true,
+}
+
+var directives = []string{
+	":alias", ":assert", ":breakpoint", ":byte", ":calc", ":call", ":const",
+	":macro", ":monitor", ":next", ":org", ":pointer", ":unpack",
+}
+
+// Keywords lists Octo's directives and reserved words, sorted.
+func Keywords() []string {
+	words := append([]string(nil), directives...)
+	for k := range keywords {
+		if k != ":" && strings.IndexAny(k, "{}()") < 0 {
+			words = append(words, k)
+		}
+	}
+	sort.Strings(words)
+	return words
+}
+
+// CompileFile compiles the Octo source file at path.
+func CompileFile(path string) (*Program, error) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Compile(path, src)
+}
+
+// Compile compiles src, naming it file in errors and the line table. A
+// mistake in the source is returned as an asm.ErrorList.
+func Compile(file string, src []byte) (p *Program, err error) {
+	c := &compiler{
+		tokens:    lex(file, string(src)),
+		pc:        origin,
+		labels:    make(map[string]int),
+		constants: make(map[string]float64),
+		aliases:   make(map[string]int),
+		macros:    make(map[string]*macro),
+		protos:    make(map[string][]fixup),
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			b, ok := r.(bail)
+			if !ok {
+				panic(r)
+			}
+			p, err = nil, asm.ErrorList{b.err}
+		}
+	}()
+	c.compile()
+	return &Program{Code: c.rom, Symbols: c.table()}, nil
+}
+
+func (c *compiler) compile() {
+	if c.definesMain() {
+		tok := c.tokens[0]
+		c.emitAddress(tok, chip8.JP, "main")
+	}
+	for c.pos < len(c.tokens) {
+		c.statement()
+	}
+
+	if len(c.branches) > 0 {
+		c.fail(c.branches[len(c.branches)-1].tok, "if ... begin without end")
+	}
+	if len(c.loops) > 0 {
+		c.fail(c.loops[len(c.loops)-1].tok, "loop without again")
+	}
+	var undefined []token
+	for _, fixups := range c.protos {
+		undefined = append(undefined, fixups[0].tok)
+	}
+	if len(undefined) > 0 {
+		sort.Slice(undefined, func(i, j int) bool {
+			a, b := undefined[i], undefined[j]
+			return a.line < b.line || (a.line == b.line && a.column < b.column)
+		})
+		c.fail(undefined[0], "undefined name %q", undefined[0].text)
+	}
+}
+
+// definesMain reports whether main is defined somewhere other than the
+// start, and so needs jumping to.
+func (c *compiler) definesMain() bool {
+	for i := 0; i+1 < len(c.tokens); i++ {
+		if c.tokens[i].text == ":" && c.tokens[i+1].text == "main" {
+			return i > 0
+		}
+	}
+	return false
+}
+
+func (c *compiler) table() *symbols.Table {
+	t := &symbols.Table{Lines: c.lines}
+	for _, name := range c.order {
+		t.Symbols = append(t.Symbols, symbols.Symbol{Name: name, Addr: uint16(c.labels[name])})
+	}
+	sort.SliceStable(t.Symbols, func(i, j int) bool { return t.Symbols[i].Addr < t.Symbols[j].Addr })
+	sort.SliceStable(t.Lines, func(i, j int) bool { return t.Lines[i].Addr < t.Lines[j].Addr })
+	return t
+}
+
+func (c *compiler) fail(tok token, format string, args ...interface{}) {
+	panic(bail{&asm.Error{File: tok.file, Line: tok.line, Column: tok.column, Msg: fmt.Sprintf(format, args...)}})
+}
+
+// peek is the text of the next token, or "" at the end.
+func (c *compiler) peek() string {
+	if c.pos >= len(c.tokens) {
+		return ""
+	}
+	return c.tokens[c.pos].text
+}
+
+// peekToken is the next token, or the last if there are no more.
+func (c *compiler) peekToken() token {
+	if c.pos >= len(c.tokens) {
+		return c.last()
+	}
+	return c.tokens[c.pos]
+}
+
+func (c *compiler) last() token {
+	if len(c.tokens) == 0 {
+		return token{line: 1, column: 1}
+	}
+	return c.tokens[len(c.tokens)-1]
+}
+
+func (c *compiler) next() token {
+	if c.pos >= len(c.tokens) {
+		c.fail(c.last(), "unexpected end of file")
+	}
+	c.pos++
+	return c.tokens[c.pos-1]
+}
+
+func (c *compiler) expect(text string) token {
+	tok := c.next()
+	if tok.text != text {
+		c.fail(tok, "want %q, got %q", text, tok.text)
+	}
+	return tok
+}
+
+// write puts b at addr, growing the rom to fit.
+func (c *compiler) write(tok token, addr int, b byte) {
+	if addr < origin || addr > 0xFFFF {
+		c.fail(tok, "address %X is outside the rom", addr)
+	}
+	for len(c.rom) <= addr-origin {
+		c.rom = append(c.rom, 0)
+	}
+	c.rom[addr-origin] = b
+}
+
+func (c *compiler) emit(tok token, bytes ...byte) {
+	for _, b := range bytes {
+		c.write(tok, c.pc, b)
+		c.pc++
+		c.written++
+	}
+}
+
+func (c *compiler) inst(tok token, op chip8.Opcode, operands ...int) {
+	ins, err := chip8.Encode(op, operands...)
+	if err != nil {
+		c.fail(tok, "%s", err)
+	}
+	c.emit(tok, ins...)
+}
+
+// statement compiles one statement, and notes where its code came from.
+func (c *compiler) statement() {
+	tok := c.peekToken()
+	start, written := c.pc, c.written
+	c.compileStatement()
+	if c.written > written {
+		c.lines = append(c.lines, symbols.Line{Addr: uint16(start), File: tok.file, Line: tok.line})
+	}
+}
+
+func (c *compiler) compileStatement() {
+	tok := c.next()
+	if m, ok := c.macros[tok.text]; ok {
+		c.expand(tok, m)
+		return
+	}
+	if _, ok := c.register(tok.text); ok {
+		c.assign(tok)
+		return
+	}
+	if n, ok := parseNumber(tok.text); ok {
+		c.emit(tok, c.byteValue(tok, n))
+		return
+	}
+
+	switch tok.text {
+	case ":":
+		c.defineLabel(c.next(), c.pc)
+	case ":next":
+		c.defineLabel(c.next(), c.pc+1)
+	case ":alias":
+		name := c.next()
+		if _, ok := c.aliases[name.text]; !ok {
+			c.name(name)
+		}
+		reg := c.next()
+		r, ok := c.register(reg.text)
+		if reg.text == "{" {
+			r, ok = int(c.calc()), true
+		}
+		if !ok || r < 0 || r > 0xF {
+			c.fail(reg, "%q is not a register", reg.text)
+		}
+		c.aliases[name.text] = r
+	case ":const":
+		name := c.name(c.next())
+		c.constants[name] = float64(c.value())
+	case ":calc":
+		name := c.next()
+		if _, ok := c.constants[name.text]; !ok {
+			c.name(name)
+		}
+		c.expect("{")
+		c.constants[name.text] = c.calc()
+	case ":org":
+		c.pc = c.value()
+	case ":byte":
+		vtok := c.peekToken()
+		c.emit(tok, c.byteValue(vtok, c.value()))
+	case ":pointer":
+		addr := c.pc
+		c.emit(tok, 0, 0)
+		v, ok := c.address(c.next(), fixup{kind: fixup_pointer, addr: addr})
+		if ok {
+			c.patch(fixup{kind: fixup_pointer, addr: addr, tok: tok}, v)
+		}
+	case ":call":
+		c.emitAddress(tok, chip8.CALL, "")
+	case ":unpack":
+		nibble := c.value()
+		if nibble < 0 || nibble > 0xF {
+			c.fail(tok, ":unpack nibble %d is not 0 to 15", nibble)
+		}
+		addr := c.pc
+		c.inst(tok, chip8.LD, 0, nibble<<4)
+		c.inst(tok, chip8.LD, 1, 0)
+		f := fixup{kind: fixup_unpack, addr: addr, nibble: nibble, tok: tok}
+		if v, ok := c.address(c.next(), f); ok {
+			c.patch(f, v)
+		}
+	case ":macro":
+		c.defineMacro()
+	case ":assert":
+		msg := "assertion failed"
+		if strings.HasPrefix(c.peek(), "\"") {
+			s, err := strconv.Unquote(c.peek())
+			if err != nil {
+				c.fail(c.peekToken(), "bad string %s", c.peek())
+			}
+			c.next()
+			msg += ": " + s
+		}
+		c.expect("{")
+		if c.calc() == 0 {
+			c.fail(tok, "%s", msg)
+		}
+	case ":breakpoint", ":proto":
+		c.next()
+	case ":monitor":
+		c.next()
+		c.next()
+
+	case "return", ";":
+		c.inst(tok, chip8.RET)
+	case "clear":
+		c.inst(tok, chip8.CLS)
+	case "exit":
+		c.inst(tok, chip8.EXIT)
+	case "hires":
+		c.inst(tok, chip8.HIGH)
+	case "lores":
+		c.inst(tok, chip8.LOW)
+	case "scroll-left":
+		c.inst(tok, chip8.SCL)
+	case "scroll-right":
+		c.inst(tok, chip8.SCR)
+	case "audio":
+		c.inst(tok, chip8.AUDIO)
+	case "scroll-down":
+		c.inst(tok, chip8.SCD, c.value())
+	case "scroll-up":
+		c.inst(tok, chip8.SCU, c.value())
+	case "plane":
+		c.inst(tok, chip8.PLANE, c.value())
+	case "bcd":
+		c.inst(tok, chip8.LDB, c.reg())
+	case "saveflags":
+		c.inst(tok, chip8.LDRVx, c.reg())
+	case "loadflags":
+		c.inst(tok, chip8.LDVxR, c.reg())
+	case "save", "load":
+		x := c.reg()
+		if c.peek() == "-" {
+			c.next()
+			op := chip8.LDIVxVy
+			if tok.text == "load" {
+				op = chip8.LDVxVyI
+			}
+			c.inst(tok, op, x, c.reg())
+		} else if tok.text == "save" {
+			c.inst(tok, chip8.LDIVx, x)
+		} else {
+			c.inst(tok, chip8.LDVxI, x)
+		}
+	case "sprite":
+		x := c.reg()
+		y := c.reg()
+		c.inst(tok, chip8.DRW, x, y, c.value())
+	case "jump":
+		c.emitAddress(tok, chip8.JP, "")
+	case "jump0":
+		c.emitAddress(tok, chip8.JPV0, "")
+	case "native":
+		c.emitAddress(tok, chip8.SYS, "")
+	case "delay", "buzzer", "pitch":
+		c.expect(":=")
+		op := map[string]chip8.Opcode{"delay": chip8.LDDTVx, "buzzer": chip8.LDSTVx, "pitch": chip8.PITCH}[tok.text]
+		c.inst(tok, op, c.reg())
+	case "i":
+		c.assignI(tok)
+
+	case "if":
+		c.compileIf(tok)
+	case "else":
+		if len(c.branches) == 0 {
+			c.fail(tok, "else without if ... begin")
+		}
+		b := c.branches[len(c.branches)-1]
+		c.branches[len(c.branches)-1] = branch{addr: c.pc, tok: tok}
+		c.inst(tok, chip8.JP, 0)
+		c.patchJump(b.addr, c.pc)
+	case "end":
+		if len(c.branches) == 0 {
+			c.fail(tok, "end without if ... begin")
+		}
+		b := c.branches[len(c.branches)-1]
+		c.branches = c.branches[:len(c.branches)-1]
+		c.patchJump(b.addr, c.pc)
+	case "loop":
+		c.loops = append(c.loops, &loop{start: c.pc, tok: tok})
+	case "while":
+		if len(c.loops) == 0 {
+			c.fail(tok, "while outside a loop")
+		}
+		c.conditional(true)
+		l := c.loops[len(c.loops)-1]
+		l.whiles = append(l.whiles, c.pc)
+		c.inst(tok, chip8.JP, 0)
+	case "again":
+		if len(c.loops) == 0 {
+			c.fail(tok, "again without loop")
+		}
+		l := c.loops[len(c.loops)-1]
+		c.loops = c.loops[:len(c.loops)-1]
+		c.inst(tok, chip8.JP, l.start)
+		for _, addr := range l.whiles {
+			c.patchJump(addr, c.pc)
+		}
+
+	default:
+		if strings.HasPrefix(tok.text, ":") || keywords[tok.text] {
+			c.fail(tok, "unexpected %q", tok.text)
+		}
+		if v, ok := c.constants[tok.text]; ok {
+			c.emit(tok, c.byteValue(tok, int(v)))
+			return
+		}
+		// A bare name calls the subroutine of that name.
+		c.pos--
+		c.emitAddress(tok, chip8.CALL, "")
+	}
+}
+
+// name checks tok can name something new.
+func (c *compiler) name(tok token) string {
+	if _, ok := parseNumber(tok.text); ok || keywords[tok.text] || strings.HasPrefix(tok.text, "\"") {
+		c.fail(tok, "%q cannot be a name", tok.text)
+	}
+	if isRegister(tok.text) {
+		c.fail(tok, "%q is a register, and cannot be a name", tok.text)
+	}
+	if _, ok := c.aliases[tok.text]; ok {
+		c.fail(tok, "%q is already an alias", tok.text)
+	}
+	if _, ok := c.labels[tok.text]; ok {
+		c.fail(tok, "%q is already a label", tok.text)
+	}
+	if _, ok := c.constants[tok.text]; ok {
+		c.fail(tok, "%q is already a constant", tok.text)
+	}
+	if _, ok := c.macros[tok.text]; ok {
+		c.fail(tok, "%q is already a macro", tok.text)
+	}
+	return tok.text
+}
+
+func (c *compiler) defineLabel(tok token, addr int) {
+	name := c.name(tok)
+	c.labels[name] = addr
+	c.order = append(c.order, name)
+	for _, f := range c.protos[name] {
+		c.patch(f, addr)
+	}
+	delete(c.protos, name)
+}
+
+func (c *compiler) defineMacro() {
+	name := c.name(c.next())
+	m := &macro{}
+	for c.peek() != "{" {
+		m.args = append(m.args, c.next().text)
+	}
+	open := c.next()
+	for depth := 1; ; {
+		if c.pos >= len(c.tokens) {
+			c.fail(open, "macro %s has no closing }", name)
+		}
+		tok := c.next()
+		switch tok.text {
+		case "{":
+			depth++
+		case "}":
+			depth--
+		}
+		if depth == 0 {
+			break
+		}
+		m.body = append(m.body, tok)
+	}
+	c.macros[name] = m
+}
+
+// expand replaces a use of m with its body, its arguments filled in.
+func (c *compiler) expand(tok token, m *macro) {
+	c.expansions++
+	if c.expansions > max_expansions {
+		c.fail(tok, "too many macro expansions; does %s use itself?", tok.text)
+	}
+	args := make(map[string]string)
+	for _, arg := range m.args {
+		args[arg] = c.next().text
+	}
+	body := make([]token, len(m.body))
+	for i, t := range m.body {
+		if v, ok := args[t.text]; ok {
+			t.text = v
+		}
+		body[i] = t
+	}
+	rest := append(body, c.tokens[c.pos:]...)
+	c.tokens = append(c.tokens[:c.pos], rest...)
+}
+
+// register is the number of the register called text, by name or alias.
+func (c *compiler) register(text string) (int, bool) {
+	if r, ok := c.aliases[text]; ok {
+		return r, true
+	}
+	if isRegister(text) {
+		r, _ := strconv.ParseUint(text[1:], 16, 8)
+		return int(r), true
+	}
+	return 0, false
+}
+
+// isRegister reports whether text is v0 to vf.
+func isRegister(text string) bool {
+	if len(text) != 2 || (text[0] != 'v' && text[0] != 'V') {
+		return false
+	}
+	_, err := strconv.ParseUint(text[1:], 16, 8)
+	return err == nil
+}
+
+// reg reads a register.
+func (c *compiler) reg() int {
+	tok := c.next()
+	r, ok := c.register(tok.text)
+	if !ok {
+		c.fail(tok, "want a register, got %q", tok.text)
+	}
+	return r
+}
+
+// parseNumber reads a decimal, 0x hex or 0b binary number, possibly
+// negative.
+func parseNumber(text string) (int, bool) {
+	digits := strings.TrimPrefix(text, "-")
+	lower := strings.ToLower(digits)
+	base := 10
+	switch {
+	case strings.HasPrefix(lower, "0x"):
+		base, digits = 16, digits[2:]
+	case strings.HasPrefix(lower, "0b"):
+		base, digits = 2, digits[2:]
+	}
+	n, err := strconv.ParseInt(digits, base, 32)
+	if err != nil {
+		return 0, false
+	}
+	if strings.HasPrefix(text, "-") {
+		n = -n
+	}
+	return int(n), true
+}
+
+// value reads a number, constant, label already defined or braced
+// calculation.
+func (c *compiler) value() int {
+	tok := c.next()
+	v, ok := c.known(tok)
+	if !ok {
+		c.fail(tok, "undefined name %q", tok.text)
+	}
+	return v
+}
+
+// known works out tok, if it can be worked out yet.
+func (c *compiler) known(tok token) (int, bool) {
+	if n, ok := parseNumber(tok.text); ok {
+		return n, true
+	}
+	if tok.text == "{" {
+		return int(c.calc()), true
+	}
+	if v, ok := c.constants[tok.text]; ok {
+		return int(v), true
+	}
+	if addr, ok := c.labels[tok.text]; ok {
+		return addr, true
+	}
+	return 0, false
+}
+
+func (c *compiler) byteValue(tok token, v int) byte {
+	if v < -0x80 || v > 0xFF {
+		c.fail(tok, "%d does not fit in a byte", v)
+	}
+	return byte(v)
+}
+
+// address works out tok as an address or, if it names a label not defined
+// yet, notes f to be filled in when it is.
+func (c *compiler) address(tok token, f fixup) (int, bool) {
+	if v, ok := c.known(tok); ok {
+		return v, true
+	}
+	name := c.name(tok)
+	f.tok = tok
+	c.protos[name] = append(c.protos[name], f)
+	return 0, false
+}
+
+// emitAddress compiles op, taking its address from the next token or, if
+// name is set, from the label of that name.
+func (c *compiler) emitAddress(tok token, op chip8.Opcode, name string) {
+	f := fixup{kind: fixup_address, addr: c.pc}
+	var v int
+	var ok bool
+	if name != "" {
+		f.tok = tok
+		c.protos[name] = append(c.protos[name], f)
+	} else {
+		v, ok = c.address(c.next(), f)
+	}
+	c.inst(tok, op, 0)
+	if ok {
+		c.patch(fixup{kind: fixup_address, addr: f.addr, tok: tok}, v)
+	}
+}
+
+// patch fills in a use of an address.
+func (c *compiler) patch(f fixup, v int) {
+	switch f.kind {
+	case fixup_address:
+		if v < 0 || v > 0xFFF {
+			c.fail(f.tok, "address %X does not fit in 12 bits", v)
+		}
+		off := f.addr - origin
+		c.rom[off] = c.rom[off]&0xF0 | byte(v>>8)
+		c.rom[off+1] = byte(v)
+	case fixup_pointer:
+		if v < 0 || v > 0xFFFF {
+			c.fail(f.tok, "address %X does not fit in 16 bits", v)
+		}
+		off := f.addr - origin
+		c.rom[off], c.rom[off+1] = byte(v>>8), byte(v)
+	case fixup_unpack:
+		if v < 0 || v > 0xFFF {
+			c.fail(f.tok, "address %X does not fit in 12 bits", v)
+		}
+		off := f.addr - origin
+		c.rom[off+1] = byte(f.nibble<<4 | v>>8)
+		c.rom[off+3] = byte(v)
+	}
+}
+
+func (c *compiler) patchJump(addr, target int) {
+	c.patch(fixup{kind: fixup_address, addr: addr, tok: c.last()}, target)
+}
+
+// assign compiles a statement starting with a register.
+func (c *compiler) assign(tok token) {
+	x, _ := c.register(tok.text)
+	optok := c.next()
+	switch optok.text {
+	case ":=":
+		src := c.peekToken()
+		if y, ok := c.register(src.text); ok {
+			c.next()
+			c.inst(tok, chip8.LDVxVy, x, y)
+			return
+		}
+		switch src.text {
+		case "key":
+			c.next()
+			c.inst(tok, chip8.LDK, x)
+		case "delay":
+			c.next()
+			c.inst(tok, chip8.LDVxDT, x)
+		case "random":
+			c.next()
+			vtok := c.peekToken()
+			c.inst(tok, chip8.RND, x, int(c.byteValue(vtok, c.value())))
+		default:
+			c.inst(tok, chip8.LD, x, int(c.byteValue(src, c.value())))
+		}
+	case "+=", "-=":
+		src := c.peekToken()
+		if y, ok := c.register(src.text); ok {
+			c.next()
+			op := chip8.ADDVxVy
+			if optok.text == "-=" {
+				op = chip8.SUB
+			}
+			c.inst(tok, op, x, y)
+			return
+		}
+		v := c.value()
+		if optok.text == "-=" {
+			v = -v
+		}
+		c.inst(tok, chip8.ADD, x, int(c.byteValue(src, v)))
+	default:
+		op, ok := map[string]chip8.Opcode{
+			"=-":  chip8.SUBN,
+			"|=":  chip8.OR,
+			"&=":  chip8.AND,
+			"^=":  chip8.XOR,
+			">>=": chip8.SHR,
+			"<<=": chip8.SHL,
+		}[optok.text]
+		if !ok {
+			c.fail(optok, "unknown operator %q", optok.text)
+		}
+		c.inst(tok, op, x, c.reg())
+	}
+}
+
+// assignI compiles a statement starting with i.
+func (c *compiler) assignI(tok token) {
+	optok := c.next()
+	switch optok.text {
+	case "+=":
+		c.inst(tok, chip8.ADDIVx, c.reg())
+	case ":=":
+		switch c.peek() {
+		case "hex":
+			c.next()
+			c.inst(tok, chip8.LDF, c.reg())
+		case "bighex":
+			c.next()
+			c.inst(tok, chip8.LDHF, c.reg())
+		case "long":
+			c.next()
+			addr := c.pc
+			c.inst(tok, chip8.LDIL, 0)
+			f := fixup{kind: fixup_pointer, addr: addr + 2}
+			if v, ok := c.address(c.next(), f); ok {
+				f.tok = tok
+				c.patch(f, v)
+			}
+		default:
+			c.emitAddress(tok, chip8.LDI, "")
+		}
+	default:
+		c.fail(optok, "want := or += after i, got %q", optok.text)
+	}
+}
+
+// compileIf compiles if ... then, which runs the next statement only if the
+// condition holds, or if ... begin, which runs the block up to else or end.
+func (c *compiler) compileIf(tok token) {
+	switch c.conditionEnd() {
+	case "then":
+		c.conditional(false)
+		c.expect("then")
+	case "begin":
+		c.conditional(true)
+		c.expect("begin")
+		c.branches = append(c.branches, branch{addr: c.pc, tok: tok})
+		c.inst(tok, chip8.JP, 0)
+	default:
+		c.conditional(false)
+		c.fail(c.peekToken(), "want then or begin, got %q", c.peek())
+	}
+}
+
+// conditionEnd looks past the condition at the next token for the word
+// after it.
+func (c *compiler) conditionEnd() string {
+	i := c.pos + 2
+	if i-1 < len(c.tokens) && c.tokens[i-1].text != "key" && c.tokens[i-1].text != "-key" {
+		if i < len(c.tokens) && c.tokens[i].text == "{" {
+			for depth := 0; i < len(c.tokens); i++ {
+				if c.tokens[i].text == "{" {
+					depth++
+				} else if c.tokens[i].text == "}" {
+					depth--
+					if depth == 0 {
+						break
+					}
+				}
+			}
+		}
+		i++
+	}
+	if i < len(c.tokens) {
+		return c.tokens[i].text
+	}
+	return ""
+}
+
+var negations = map[string]string{
+	"==": "!=", "!=": "==", "key": "-key", "-key": "key",
+	"<": ">=", ">": "<=", ">=": "<", "<=": ">",
+}
+
+// conditional compiles a test that skips the next instruction unless the
+// condition holds or, if negated, when it does. The comparisons CHIP-8 has no
+// skip for are worked out in the compare-temp register, vf unless aliased.
+func (c *compiler) conditional(negated bool) {
+	tok := c.peekToken()
+	x := c.reg()
+	optok := c.next()
+	op := optok.text
+	if _, ok := negations[op]; !ok {
+		c.fail(optok, "unknown comparison %q", op)
+	}
+	if negated {
+		op = negations[op]
+	}
+	temp := default_compare
+	if r, ok := c.aliases[compare_temp]; ok {
+		temp = r
+	}
+
+	switch op {
+	case "key":
+		c.inst(tok, chip8.SKNP, x)
+		return
+	case "-key":
+		c.inst(tok, chip8.SKP, x)
+		return
+	}
+
+	src := c.peekToken()
+	y, isReg := c.register(src.text)
+	var v int
+	if isReg {
+		c.next()
+	} else {
+		v = int(c.byteValue(src, c.value()))
+	}
+	switch op {
+	case "==":
+		if isReg {
+			c.inst(tok, chip8.SRNE, x, y)
+		} else {
+			c.inst(tok, chip8.SNE, x, v)
+		}
+		return
+	case "!=":
+		if isReg {
+			c.inst(tok, chip8.SRE, x, y)
+		} else {
+			c.inst(tok, chip8.SE, x, v)
+		}
+		return
+	}
+
+	if isReg {
+		c.inst(tok, chip8.LDVxVy, temp, y)
+	} else {
+		c.inst(tok, chip8.LD, temp, v)
+	}
+	switch op {
+	case ">":
+		c.inst(tok, chip8.SUB, temp, x)
+		c.inst(tok, chip8.SE, 0xF, 1)
+	case "<":
+		c.inst(tok, chip8.SUBN, temp, x)
+		c.inst(tok, chip8.SE, 0xF, 1)
+	case ">=":
+		c.inst(tok, chip8.SUBN, temp, x)
+		c.inst(tok, chip8.SNE, 0xF, 1)
+	case "<=":
+		c.inst(tok, chip8.SUB, temp, x)
+		c.inst(tok, chip8.SNE, 0xF, 1)
+	}
+}
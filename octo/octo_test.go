@@ -0,0 +1,139 @@
+package octo
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/gilmae/chip8/asm"
+	"github.com/gilmae/chip8/disasm"
+)
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []byte
+	}{
+		{"clear return ;", []byte{0x00, 0xE0, 0x00, 0xEE, 0x00, 0xEE}},
+		{"v1 := 0x2A v2 := v1 v3 := -1", []byte{0x61, 0x2A, 0x82, 0x10, 0x63, 0xFF}},
+		{"v1 += 2 v1 -= 1 v1 += v2 v1 -= v2 v1 =- v2", []byte{0x71, 0x02, 0x71, 0xFF, 0x81, 0x24, 0x81, 0x25, 0x81, 0x27}},
+		{"v1 |= v2 v1 &= v2 v1 ^= v2 v1 >>= v2 v1 <<= v2", []byte{0x81, 0x21, 0x81, 0x22, 0x81, 0x23, 0x81, 0x26, 0x81, 0x2E}},
+		{"v0 := key v0 := delay v0 := random 0x0F", []byte{0xF0, 0x0A, 0xF0, 0x07, 0xC0, 0x0F}},
+		{"delay := v1 buzzer := v1 pitch := v1", []byte{0xF1, 0x15, 0xF1, 0x18, 0xF1, 0x3A}},
+		{"i := 0x300 i += v1 i := hex v1 i := bighex v1", []byte{0xA3, 0x00, 0xF1, 0x1E, 0xF1, 0x29, 0xF1, 0x30}},
+		{"i := long 0x1234", []byte{0xF0, 0x00, 0x12, 0x34}},
+		{"save v3 load v3 save v1 - v3 load v1 - v3", []byte{0xF3, 0x55, 0xF3, 0x65, 0x51, 0x32, 0x51, 0x33}},
+		{"bcd v1 saveflags v1 loadflags v1", []byte{0xF1, 0x33, 0xF1, 0x75, 0xF1, 0x85}},
+		{"sprite v0 v1 5 scroll-down 4 scroll-up 2 plane 3", []byte{0xD0, 0x15, 0x00, 0xC4, 0x00, 0xD2, 0xF3, 0x01}},
+		{"hires lores scroll-left scroll-right exit audio", []byte{0x00, 0xFF, 0x00, 0xFE, 0x00, 0xFC, 0x00, 0xFB, 0x00, 0xFD, 0xF0, 0x02}},
+		{"jump 0x234 jump0 0x300 native 0x123 :call 0x456", []byte{0x12, 0x34, 0xB3, 0x00, 0x01, 0x23, 0x24, 0x56}},
+		{": start jump start", []byte{0x12, 0x00}},
+		{"jump done : done exit", []byte{0x12, 0x02, 0x00, 0xFD}},
+		{"draw : draw return", []byte{0x22, 0x02, 0x00, 0xEE}},
+		{"i := face : face 0x24 0x00 0x42 0x3C", []byte{0xA2, 0x02, 0x24, 0x00, 0x42, 0x3C}},
+		{": data 1 2 : main jump main", []byte{0x12, 0x04, 0x01, 0x02, 0x12, 0x04}},
+		{":alias px v5 px := 3", []byte{0x65, 0x03}},
+		{":const SPEED 3 v0 := SPEED", []byte{0x60, 0x03}},
+		{":calc half { 10 / 2 } :calc x { 2 * 3 + 1 } v0 := half v1 := x", []byte{0x60, 0x05, 0x61, 0x08}},
+		{":byte 7 :byte { 1 << 3 } :pointer far : far", []byte{0x07, 0x08, 0x02, 0x04}},
+		{":org 0x204 exit", []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0xFD}},
+		{":unpack 0xA face : face", []byte{0x60, 0xA2, 0x61, 0x04}},
+		{":next target v0 := 5", []byte{0x60, 0x05}},
+		{":macro twice op { op op } twice clear", []byte{0x00, 0xE0, 0x00, 0xE0}},
+		{"if v0 == 1 then clear if v0 != v1 then clear", []byte{0x40, 0x01, 0x00, 0xE0, 0x50, 0x10, 0x00, 0xE0}},
+		{"if v0 key then clear if v0 -key then clear", []byte{0xE0, 0xA1, 0x00, 0xE0, 0xE0, 0x9E, 0x00, 0xE0}},
+		{"if v0 > v1 then clear", []byte{0x8F, 0x10, 0x8F, 0x05, 0x3F, 0x01, 0x00, 0xE0}},
+		{"if v0 <= 4 then clear", []byte{0x6F, 0x04, 0x8F, 0x05, 0x4F, 0x01, 0x00, 0xE0}},
+		{":alias compare-temp ve if v0 < v1 then clear", []byte{0x8E, 0x10, 0x8E, 0x07, 0x3F, 0x01, 0x00, 0xE0}},
+		{"if v0 == 1 begin clear end", []byte{0x30, 0x01, 0x12, 0x06, 0x00, 0xE0}},
+		{"if v0 == 1 begin clear else exit end", []byte{0x30, 0x01, 0x12, 0x08, 0x00, 0xE0, 0x12, 0x0A, 0x00, 0xFD}},
+		{"loop v0 += 1 while v0 != 10 again", []byte{0x70, 0x01, 0x40, 0x0A, 0x12, 0x08, 0x12, 0x00}},
+		{"if v0 == { 2 + 1 } then clear # comment", []byte{0x40, 0x03, 0x00, 0xE0}},
+		{":assert \"fits\" { 1 < 2 }", nil},
+	}
+
+	for _, tt := range tests {
+		p, err := Compile("test.8o", []byte(tt.src))
+		if err != nil {
+			t.Errorf("%q: %s", tt.src, err)
+			continue
+		}
+		if !bytes.Equal(p.Code, tt.want) {
+			t.Errorf("%q, want=% X, got=% X", tt.src, tt.want, p.Code)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"clear\n  jump nowhere", "test.8o:2:8: undefined name \"nowhere\""},
+		{"v0 := 256", "test.8o:1:7: 256 does not fit in a byte"},
+		{"v0 ** v1", "test.8o:1:4: unknown operator \"**\""},
+		{"if v0 == 1 begin clear", "test.8o:1:1: if ... begin without end"},
+		{"loop clear", "test.8o:1:1: loop without again"},
+		{"again", "test.8o:1:1: again without loop"},
+		{": a : a", "test.8o:1:7: \"a\" is already a label"},
+		{": v3", "test.8o:1:3: \"v3\" is a register, and cannot be a name"},
+		{"sprite v0 v1", "test.8o:1:11: unexpected end of file"},
+		{":assert \"too big\" { 300 < 255 }", "test.8o:1:1: assertion failed: too big"},
+		{":macro m { m } m", "test.8o:1:12: too many macro expansions; does m use itself?"},
+		{"if v0 == 1 clear", "test.8o:1:12: want then or begin, got \"clear\""},
+	}
+
+	for _, tt := range tests {
+		_, err := Compile("test.8o", []byte(tt.src))
+		list, ok := err.(asm.ErrorList)
+		if !ok {
+			t.Errorf("%q, want an asm.ErrorList, got=%v", tt.src, err)
+			continue
+		}
+		if list[0].Error() != tt.want {
+			t.Errorf("%q, want=%q, got=%q", tt.src, tt.want, list[0])
+		}
+	}
+}
+
+func TestLineTable(t *testing.T) {
+	src := ": main\n\tv0 := 1\n\n\tdraw\n\tjump main\n: draw\n\treturn\n"
+	p, err := Compile("game.8o", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		addr uint16
+		line int
+	}{{0x200, 2}, {0x202, 4}, {0x204, 5}, {0x206, 7}} {
+		line, ok := p.Symbols.Source(tt.addr)
+		if !ok || line.File != "game.8o" || line.Line != tt.line {
+			t.Errorf("source of %03X, want=game.8o:%d, got=%s:%d", tt.addr, tt.line, line.File, line.Line)
+		}
+	}
+	if name, ok := p.Symbols.Label(0x206); !ok || name != "draw" {
+		t.Errorf("label at 206, want=draw, got=%q", name)
+	}
+}
+
+// Roms disassembled as Octo must compile back to themselves.
+func TestRoundTrip(t *testing.T) {
+	random := rand.New(rand.NewSource(21))
+	for i := 0; i < 200; i++ {
+		rom := make([]byte, 2+random.Intn(200))
+		random.Read(rom)
+		rom[0], rom[1] = 0x00, 0xE0
+
+		var listing bytes.Buffer
+		if err := disasm.Disassemble(rom, disasm.Options{Syntax: disasm.Octo}).Write(&listing); err != nil {
+			t.Fatal(err)
+		}
+		p, err := Compile("rom.8o", listing.Bytes())
+		if err != nil {
+			t.Fatalf("rom %d: %s\n%s", i, err, listing.String())
+		}
+		if !bytes.Equal(p.Code, rom) {
+			t.Fatalf("rom %d, want=% X\ngot=% X\n%s", i, rom, p.Code, listing.String())
+		}
+	}
+}
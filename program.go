@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gilmae/chip8/asm"
+	"github.com/gilmae/chip8/chip8"
+	"github.com/gilmae/chip8/octo"
+	"github.com/gilmae/chip8/symbols"
+)
+
+// loadProgram reads a rom or, for .8o files, compiles Octo source. Compiled
+// programs come with a symbol table, and roms with the symbol file beside
+// them if there is one.
+func loadProgram(path string) ([]byte, *symbols.Table, error) {
+	if strings.EqualFold(filepath.Ext(path), ".8o") {
+		p, err := octo.CompileFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		return p.Code, p.Symbols, nil
+	}
+	program, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	table, err := symbols.LoadFor(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return program, table, nil
+}
+
+// printError prints err to stderr, each source error on a line of its own.
+func printError(err error) {
+	if list, ok := err.(asm.ErrorList); ok {
+		for _, e := range list {
+			fmt.Fprintln(os.Stderr, e)
+		}
+		return
+	}
+	fmt.Fprintf(os.Stderr, "error: %s\n", err)
+}
+
+// sourceError adds the source line the machine stopped in to err, when the
+// program came with a line table.
+func sourceError(err error, cpu *chip8.Machine, table *symbols.Table) error {
+	if table == nil {
+		return err
+	}
+	// The machine has moved past the instruction that failed.
+	if line, ok := table.Source(cpu.PC() - 2); ok {
+		return fmt.Errorf("%s:%d: %s", line.File, line.Line, err)
+	}
+	return err
+}
@@ -0,0 +1,67 @@
+package sdlhost
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/gilmae/chip8/chip8"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// queue_frames is how many frames of sound may be waiting in SDL's queue
+// before further frames are dropped, bounding latency if the machine runs
+// ahead of the device.
+const queue_frames = 4
+
+// Audio is a chip8.Audio that queues the Beeper's samples to the default SDL
+// audio device. SDL must already be initialised, e.g. by New.
+type Audio struct {
+	Beeper *chip8.Beeper
+
+	dev sdl.AudioDeviceID
+	buf []float32
+	out []byte
+}
+
+func NewAudio(sampleRate int) (*Audio, error) {
+	want := sdl.AudioSpec{
+		Freq:     int32(sampleRate),
+		Format:   sdl.AUDIO_S16LSB,
+		Channels: 1,
+		Samples:  512,
+	}
+	var got sdl.AudioSpec
+
+	dev, err := sdl.OpenAudioDevice("", false, &want, &got, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	samples := sampleRate / chip8.FrameRate
+	a := &Audio{
+		Beeper: chip8.NewBeeper(sampleRate),
+		dev:    dev,
+		buf:    make([]float32, samples),
+		out:    make([]byte, samples*2),
+	}
+	sdl.PauseAudioDevice(dev, false)
+
+	return a, nil
+}
+
+func (a *Audio) Play(t chip8.Tone) {
+	a.Beeper.Generate(t, a.buf)
+
+	if sdl.GetQueuedAudioSize(a.dev) > uint32(len(a.out)*queue_frames) {
+		return
+	}
+
+	for i, sample := range a.buf {
+		binary.LittleEndian.PutUint16(a.out[i*2:], uint16(int16(sample*math.MaxInt16)))
+	}
+	sdl.QueueAudio(a.dev, a.out)
+}
+
+func (a *Audio) Close() {
+	sdl.CloseAudioDevice(a.dev)
+}
@@ -0,0 +1,142 @@
+// Package sdlhost runs the chip8 interpreter in an SDL window.
+package sdlhost
+
+import (
+	"github.com/gilmae/chip8/chip8"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// Host is a chip8.Host that draws to an SDL window and reads the SDL event
+// queue for keypresses and quit requests.
+type Host struct {
+	// Palette is the colour of each pixel value; XO-CHIP programs use all
+	// four.
+	Palette chip8.Palette
+
+	// Hotkey, if set, sees every key press and release before the keypad
+	// does. Returning true swallows the key. It runs outside the machine's
+	// lock, so it may call back into the machine.
+	Hotkey Hotkey
+
+	window        *sdl.Window
+	renderer      *sdl.Renderer
+	texture       *sdl.Texture
+	width, height int32
+}
+
+// Hotkey handles a key going down or up, reporting whether it was one of its
+// keys.
+type Hotkey func(sym sdl.Keycode, mod uint16, down bool) bool
+
+// New initialises SDL and opens a window of the given size. Close must be
+// called to release it.
+func New(title string, width, height int32) (*Host, error) {
+	if err := sdl.Init(sdl.INIT_EVERYTHING); err != nil {
+		return nil, err
+	}
+
+	sdl.SetHint(sdl.HINT_RENDER_SCALE_QUALITY, "0")
+
+	h := &Host{Palette: chip8.DefaultPalette, width: width, height: height}
+
+	var err error
+	h.window, err = sdl.CreateWindow(title, sdl.WINDOWPOS_UNDEFINED, sdl.WINDOWPOS_UNDEFINED, width, height, sdl.WINDOW_SHOWN)
+	if err != nil {
+		h.Close()
+		return nil, err
+	}
+
+	h.renderer, err = sdl.CreateRenderer(h.window, -1, sdl.RENDERER_ACCELERATED)
+	if err != nil {
+		h.Close()
+		return nil, err
+	}
+
+	return h, nil
+}
+
+func (h *Host) Close() {
+	if h.texture != nil {
+		h.texture.Destroy()
+	}
+	if h.renderer != nil {
+		h.renderer.Destroy()
+	}
+	if h.window != nil {
+		h.window.Destroy()
+	}
+	sdl.Quit()
+}
+
+func (h *Host) Render(d *chip8.Display) error {
+	if err := h.ensureTexture(int32(d.Width()), int32(d.Height())); err != nil {
+		return err
+	}
+
+	pixels := make([]byte, d.Height()*d.Width()*4)
+	d.EachPixel(func(x, y uint16, addr int) {
+		index := (int(y)*d.Width() + int(x)) * 4
+		colour := h.Palette[d.Colour(addr)]
+		pixels[index] = colour.R
+		pixels[index+1] = colour.G
+		pixels[index+2] = colour.B
+		pixels[index+3] = colour.A
+	})
+
+	if err := h.texture.Update(nil, pixels, d.Width()*4); err != nil {
+		return err
+	}
+	dst := sdl.Rect{X: 0, Y: 0, W: h.width, H: h.height}
+	h.renderer.Clear()
+	h.renderer.Copy(h.texture, nil, &dst)
+	h.renderer.Present()
+
+	return nil
+}
+
+// ensureTexture (re)creates the static texture whenever the display
+// resolution changes.
+func (h *Host) ensureTexture(width, height int32) error {
+	if h.texture != nil {
+		_, _, w, th, err := h.texture.Query()
+		if err == nil && w == width && th == height {
+			return nil
+		}
+		h.texture.Destroy()
+		h.texture = nil
+	}
+
+	tex, err := h.renderer.CreateTexture(sdl.PIXELFORMAT_ABGR8888, sdl.TEXTUREACCESS_STATIC, width, height)
+	if err != nil {
+		return err
+	}
+	h.texture = tex
+	return nil
+}
+
+func (h *Host) Poll() ([]chip8.KeyEvent, bool) {
+	var events []chip8.KeyEvent
+	quit := false
+
+	for event := sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
+		switch t := event.(type) {
+		case *sdl.QuitEvent:
+			quit = true
+		case *sdl.KeyboardEvent:
+			if t.Repeat != 0 {
+				continue
+			}
+			down := t.Type == sdl.KEYDOWN
+			if h.Hotkey != nil && h.Hotkey(t.Keysym.Sym, t.Keysym.Mod, down) {
+				continue
+			}
+			events = append(events, chip8.KeyEvent{
+				Code:     rune(t.Keysym.Sym),
+				Scancode: int(t.Keysym.Scancode),
+				Down:     down,
+			})
+		}
+	}
+
+	return events, quit
+}
@@ -0,0 +1,18 @@
+package sdlhost
+
+import (
+	"github.com/gilmae/chip8/chip8"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// Rewind returns a Hotkey that rewinds m for as long as Backspace is held,
+// handing every other key to next, which may be nil.
+func Rewind(m *chip8.Machine, next Hotkey) Hotkey {
+	return func(sym sdl.Keycode, mod uint16, down bool) bool {
+		if sym == sdl.K_BACKSPACE {
+			m.SetRewinding(down)
+			return true
+		}
+		return next != nil && next(sym, mod, down)
+	}
+}
@@ -0,0 +1,76 @@
+package sdlhost
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/gilmae/chip8/chip8"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+var slot_keys = []sdl.Keycode{
+	sdl.K_F1, sdl.K_F2, sdl.K_F3, sdl.K_F4, sdl.K_F5,
+	sdl.K_F6, sdl.K_F7, sdl.K_F8, sdl.K_F9,
+}
+
+// SaveSlots binds F1 to F9 to numbered save-state slots: Shift+Fn saves the
+// machine to slot n and Fn loads it back. Use its Hotkey method as the Host's
+// Hotkey.
+type SaveSlots struct {
+	Machine *chip8.Machine
+	// Base is the path the slot files are named after; slot n is Base.sn.
+	Base   string
+	Logger *log.Logger
+}
+
+// Path is the file holding slot n.
+func (s *SaveSlots) Path(n int) string {
+	return fmt.Sprintf("%s.s%d", s.Base, n)
+}
+
+func (s *SaveSlots) Hotkey(sym sdl.Keycode, mod uint16, down bool) bool {
+	for i, key := range slot_keys {
+		if sym != key {
+			continue
+		}
+		if !down {
+			return true
+		}
+		n := i + 1
+		var err error
+		if mod&sdl.KMOD_SHIFT != 0 {
+			err = s.Save(n)
+		} else {
+			err = s.Load(n)
+		}
+		if err != nil && s.Logger != nil {
+			s.Logger.Printf("slot %d: %s", n, err)
+		}
+		return true
+	}
+	return false
+}
+
+// Save writes the machine's state to slot n.
+func (s *SaveSlots) Save(n int) error {
+	f, err := os.Create(s.Path(n))
+	if err != nil {
+		return err
+	}
+	if err := s.Machine.SaveState(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Load restores the machine from slot n.
+func (s *SaveSlots) Load(n int) error {
+	f, err := os.Open(s.Path(n))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return s.Machine.LoadState(f)
+}
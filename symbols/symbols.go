@@ -0,0 +1,255 @@
+// Package symbols maps a rom's addresses back to the labels and source lines
+// an assembler built it from, for the debuggers to show.
+//
+// A symbol file is text, one entry to a line:
+//
+//	; comments start with a semicolon
+//	0200 main
+//	0200 .line main.8o 12
+//
+// The first is a label, the second says the code at 0200 came from line 12
+// of main.8o. Addresses are hex.
+//
+// The same table can be written as JSON, with decimal addresses:
+//
+//	{"symbols": [{"name": "main", "addr": 512}],
+//	 "lines": [{"addr": 512, "file": "main.8o", "line": 12}]}
+//
+// Read takes either. A rom's symbol file sits beside it with a .sym
+// extension, game.sym for game.ch8, and LoadFor finds it there.
+package symbols
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Symbol is a label and the address it names.
+type Symbol struct {
+	Name string `json:"name"`
+	Addr uint16 `json:"addr"`
+}
+
+// Line says the code at Addr came from line Line of File.
+type Line struct {
+	Addr uint16 `json:"addr"`
+	File string `json:"file"`
+	Line int    `json:"line"`
+}
+
+// Table is a rom's symbols and line table.
+type Table struct {
+	Symbols []Symbol `json:"symbols"`
+	Lines   []Line   `json:"lines"`
+}
+
+// Load reads the symbol file at path.
+func Load(path string) (*Table, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	t, err := Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return t, nil
+}
+
+// PathFor is where the symbol file for the rom at path goes.
+func PathFor(rom string) string {
+	return strings.TrimSuffix(rom, filepath.Ext(rom)) + ".sym"
+}
+
+// LoadFor loads the symbol file beside the rom at path. A rom without one
+// has no table, and no error.
+func LoadFor(rom string) (*Table, error) {
+	t, err := Load(PathFor(rom))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return t, err
+}
+
+// Read parses a symbol file, in either format.
+func Read(r io.Reader) (*Table, error) {
+	br := bufio.NewReader(r)
+	for {
+		ch, _, err := br.ReadRune()
+		if err == io.EOF {
+			return &Table{}, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if !unicode.IsSpace(ch) {
+			br.UnreadRune()
+			if ch == '{' {
+				return readJSON(br)
+			}
+			return readText(br)
+		}
+	}
+}
+
+func readJSON(r io.Reader) (*Table, error) {
+	t := &Table{}
+	if err := json.NewDecoder(r).Decode(t); err != nil {
+		return nil, err
+	}
+	for _, l := range t.Lines {
+		if l.File == "" || l.Line < 1 {
+			return nil, fmt.Errorf("bad line table entry %s:%d at %04X", l.File, l.Line, l.Addr)
+		}
+	}
+	for _, s := range t.Symbols {
+		if s.Name == "" {
+			return nil, fmt.Errorf("unnamed symbol at %04X", s.Addr)
+		}
+	}
+	t.sort()
+	return t, nil
+}
+
+func readText(r io.Reader) (*Table, error) {
+	t := &Table{}
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, ';'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		addr, err := strconv.ParseUint(fields[0], 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: bad address %q", n, fields[0])
+		}
+		switch {
+		case len(fields) == 2 && !strings.HasPrefix(fields[1], "."):
+			t.Symbols = append(t.Symbols, Symbol{Name: fields[1], Addr: uint16(addr)})
+		case len(fields) == 4 && fields[1] == ".line":
+			line, err := strconv.Atoi(fields[3])
+			if err != nil || line < 1 {
+				return nil, fmt.Errorf("line %d: bad line number %q", n, fields[3])
+			}
+			t.Lines = append(t.Lines, Line{Addr: uint16(addr), File: fields[2], Line: line})
+		default:
+			return nil, fmt.Errorf("line %d: want \"addr label\" or \"addr .line file line\"", n)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	t.sort()
+	return t, nil
+}
+
+// Write writes the table as a symbol file.
+func (t *Table) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, s := range t.Symbols {
+		fmt.Fprintf(bw, "%04X %s\n", s.Addr, s.Name)
+	}
+	for _, l := range t.Lines {
+		fmt.Fprintf(bw, "%04X .line %s %d\n", l.Addr, l.File, l.Line)
+	}
+	return bw.Flush()
+}
+
+// WriteJSON writes the table as a JSON symbol file.
+func (t *Table) WriteJSON(w io.Writer) error {
+	out := *t
+	// Empty lists, not null, for readers in other languages.
+	if out.Symbols == nil {
+		out.Symbols = []Symbol{}
+	}
+	if out.Lines == nil {
+		out.Lines = []Line{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(&out)
+}
+
+func (t *Table) sort() {
+	sort.SliceStable(t.Symbols, func(i, j int) bool { return t.Symbols[i].Addr < t.Symbols[j].Addr })
+	sort.SliceStable(t.Lines, func(i, j int) bool { return t.Lines[i].Addr < t.Lines[j].Addr })
+}
+
+// Label returns the first label at addr. A nil table has none.
+func (t *Table) Label(addr uint16) (string, bool) {
+	if t == nil {
+		return "", false
+	}
+	// Tables straight from a compiler are not sorted by address, so search
+	// them all.
+	for _, s := range t.Symbols {
+		if s.Addr == addr {
+			return s.Name, true
+		}
+	}
+	return "", false
+}
+
+// Lookup returns the address of a label.
+func (t *Table) Lookup(name string) (uint16, bool) {
+	for _, s := range t.Symbols {
+		if s.Name == name {
+			return s.Addr, true
+		}
+	}
+	return 0, false
+}
+
+// Source returns the line the code at addr came from: the last line table
+// entry at or before it.
+func (t *Table) Source(addr uint16) (Line, bool) {
+	i := sort.Search(len(t.Lines), func(i int) bool { return t.Lines[i].Addr > addr })
+	if i == 0 {
+		return Line{}, false
+	}
+	return t.Lines[i-1], true
+}
+
+// Addr returns the first address of the code from line of file or, if that
+// line made no code, of the next line of file that did. The line returned is
+// the one found.
+func (t *Table) Addr(file string, line int) (Line, bool) {
+	var best Line
+	found := false
+	for _, l := range t.Lines {
+		if l.File != file || l.Line < line {
+			continue
+		}
+		if !found || l.Line < best.Line || (l.Line == best.Line && l.Addr < best.Addr) {
+			best, found = l, true
+		}
+	}
+	return best, found
+}
+
+// Files lists the source files in the line table.
+func (t *Table) Files() []string {
+	seen := make(map[string]bool)
+	var files []string
+	for _, l := range t.Lines {
+		if !seen[l.File] {
+			seen[l.File] = true
+			files = append(files, l.File)
+		}
+	}
+	sort.Strings(files)
+	return files
+}
@@ -0,0 +1,134 @@
+package symbols
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const example = `; built by hand
+0200 main
+020C draw ; the player
+0200 .line game.8o 3
+0204 .line game.8o 4
+020C .line game.8o 9
+0300 .line sprites.8o 1
+`
+
+func TestReadAndWrite(t *testing.T) {
+	table, err := Read(strings.NewReader(example))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(table.Symbols) != 2 || len(table.Lines) != 4 {
+		t.Fatalf("want 2 symbols and 4 lines, got=%v", table)
+	}
+
+	var out strings.Builder
+	if err := table.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	again, err := Read(strings.NewReader(out.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again.Symbols) != 2 || len(again.Lines) != 4 || again.Lines[3] != table.Lines[3] {
+		t.Errorf("round trip, want=%v, got=%v", table, again)
+	}
+}
+
+func TestLookups(t *testing.T) {
+	table, err := Read(strings.NewReader(example))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name, ok := table.Label(0x20c); !ok || name != "draw" {
+		t.Errorf("Label(20C), want=draw, got=%q", name)
+	}
+	if _, ok := table.Label(0x20e); ok {
+		t.Errorf("Label(20E), want none")
+	}
+	if addr, ok := table.Lookup("draw"); !ok || addr != 0x20c {
+		t.Errorf("Lookup(draw), want=0x20c, got=%#x", addr)
+	}
+
+	tests := []struct {
+		addr uint16
+		file string
+		line int
+	}{
+		{0x200, "game.8o", 3},
+		{0x206, "game.8o", 4},
+		{0x20e, "game.8o", 9},
+		{0x310, "sprites.8o", 1},
+	}
+	for _, tt := range tests {
+		l, ok := table.Source(tt.addr)
+		if !ok || l.File != tt.file || l.Line != tt.line {
+			t.Errorf("Source(%03X), want=%s:%d, got=%s:%d", tt.addr, tt.file, tt.line, l.File, l.Line)
+		}
+	}
+	if _, ok := table.Source(0x100); ok {
+		t.Errorf("Source(100), want none")
+	}
+
+	if l, ok := table.Addr("game.8o", 5); !ok || l.Addr != 0x20c || l.Line != 9 {
+		t.Errorf("Addr(game.8o, 5), want=20C at line 9, got=%03X at line %d", l.Addr, l.Line)
+	}
+	if _, ok := table.Addr("game.8o", 10); ok {
+		t.Errorf("Addr(game.8o, 10), want none")
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	for _, src := range []string{"zz main", "0200", "0200 .line game.8o", "0200 .line game.8o x", "0200 .size 4"} {
+		if _, err := Read(strings.NewReader(src)); err == nil {
+			t.Errorf("Read(%q), want error", src)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	table, err := Read(strings.NewReader(example))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	if err := table.WriteJSON(&out); err != nil {
+		t.Fatal(err)
+	}
+	again, err := Read(strings.NewReader(out.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again.Symbols) != 2 || len(again.Lines) != 4 || again.Symbols[1] != table.Symbols[1] || again.Lines[3] != table.Lines[3] {
+		t.Errorf("round trip, want=%v, got=%v", table, again)
+	}
+
+	for _, src := range []string{`{"symbols": [{"addr": 512}]}`, `{"lines": [{"addr": 512, "file": "a.8o"}]}`, `{"symbols": `} {
+		if _, err := Read(strings.NewReader(src)); err == nil {
+			t.Errorf("Read(%q), want error", src)
+		}
+	}
+}
+
+func TestLoadFor(t *testing.T) {
+	dir := t.TempDir()
+	rom := filepath.Join(dir, "game.ch8")
+	if table, err := LoadFor(rom); err != nil || table != nil {
+		t.Errorf("LoadFor without a symbol file, want nothing, got=%v, %v", table, err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "game.sym"), []byte(example), 0644); err != nil {
+		t.Fatal(err)
+	}
+	table, err := LoadFor(rom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, ok := table.Label(0x200); !ok || name != "main" {
+		t.Errorf("Label(200), want=main, got=%q", name)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/gilmae/chip8/chip8"
+	"github.com/gilmae/chip8/disasm"
+	"github.com/gilmae/chip8/symbols"
+)
+
+// traceFlags are the flags that turn on tracing.
+type traceFlags struct {
+	events *string
+	ranges *string
+	out    *string
+}
+
+func addTraceFlags(flags *flag.FlagSet) *traceFlags {
+	return &traceFlags{
+		events: flags.String("trace", "", "trace these comma separated events: "+strings.Join(chip8.TraceEventNames(), ", ")),
+		ranges: flags.String("trace-range", "", "only trace instructions in these comma separated hex address ranges, e.g. 200-2FF,310"),
+		out:    flags.String("trace-out", "", "write the trace here rather than to stderr"),
+	}
+}
+
+// tracer makes the tracer the flags ask for, describing instructions with
+// table's labels, and a func to call when the machine is done with it. It
+// returns a nil tracer if tracing is off.
+func (f *traceFlags) tracer(table *symbols.Table) (*chip8.Tracer, func() error, error) {
+	if *f.events == "" {
+		return nil, func() error { return nil }, nil
+	}
+	events, err := chip8.ParseTraceEvents(*f.events)
+	if err != nil {
+		return nil, nil, err
+	}
+	var ranges []chip8.AddrRange
+	if *f.ranges != "" {
+		for _, s := range strings.Split(*f.ranges, ",") {
+			r, err := chip8.ParseAddrRange(s)
+			if err != nil {
+				return nil, nil, fmt.Errorf("-trace-range: %s", err)
+			}
+			ranges = append(ranges, r)
+		}
+	}
+
+	var w io.Writer = os.Stderr
+	var file *os.File
+	if *f.out != "" {
+		if file, err = os.Create(*f.out); err != nil {
+			return nil, nil, err
+		}
+		w = file
+	}
+	bw := bufio.NewWriter(w)
+
+	t := chip8.NewTracer(bw, events)
+	t.Ranges = ranges
+	t.Describe = func(pc uint16, ins []byte) string {
+		text, _, err := disasm.InstructionWithSymbols(ins, disasm.Cowgod, table)
+		if err != nil {
+			return ""
+		}
+		return text
+	}
+	done := func() error {
+		err := t.Err()
+		if ferr := bw.Flush(); err == nil {
+			err = ferr
+		}
+		if file != nil {
+			if cerr := file.Close(); err == nil {
+				err = cerr
+			}
+		}
+		return err
+	}
+	return t, done, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gilmae/chip8/tracediff"
+)
+
+// traceDiff reports where two traces first differ. It exits 1 if they do,
+// like diff.
+func traceDiff(args []string) {
+	flags := flag.NewFlagSet("tracediff", flag.ExitOnError)
+	context := flags.Int("context", 5, "lines of each trace to show either side of the divergence")
+	events := flags.String("events", "", "comma separated events to compare (default: those both traces have)")
+	ignore := flags.String("ignore", "", "comma separated fields to leave out, e.g. dt,st")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s tracediff [flags] trace1 trace2\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	var opts tracediff.Options
+	if *events != "" {
+		opts.Events = strings.Split(*events, ",")
+	}
+	if *ignore != "" {
+		opts.Ignore = strings.Split(*ignore, ",")
+	}
+
+	var traces [2]*tracediff.Trace
+	for i, path := range flags.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			os.Exit(3)
+		}
+		traces[i], err = tracediff.Read(path, f)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			os.Exit(3)
+		}
+	}
+
+	a, b := traces[0], traces[1]
+	d, err := tracediff.Compare(a, b, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(2)
+	}
+	if d == nil {
+		steps := a.Steps()
+		if b.Steps() < steps {
+			steps = b.Steps()
+		}
+		fmt.Printf("traces agree for %d steps\n", steps)
+		switch {
+		case a.Steps() > steps:
+			fmt.Printf("%s goes on for %d more\n", a.Name, a.Steps()-steps)
+		case b.Steps() > steps:
+			fmt.Printf("%s goes on for %d more\n", b.Name, b.Steps()-steps)
+		}
+		return
+	}
+	if err := d.Write(os.Stdout, a, b, *context); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(3)
+	}
+	os.Exit(1)
+}
@@ -0,0 +1,280 @@
+// Package tracediff finds the first place two execution traces of a rom part
+// ways, such as runs under different quirks, or this interpreter's run and
+// another emulator's.
+//
+// Traces are in the format chip8.Tracer writes: one event to a line, its name
+// and then space separated key=value fields with hex values, and anything
+// after a semicolon a comment. Blank lines and lines starting with # are
+// skipped. A line that starts with a field rather than a name is an
+// instruction, so the least another emulator has to write is
+//
+//	pc=0200 op=6005 v0=00 v1=00 ... vf=00 i=0000
+//
+// before each instruction runs, and
+//
+//	mem pc=0204 addr=0300 val=05
+//
+// for each byte written to memory, if memory is to be compared.
+package tracediff
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// instruction is the name of the event traced before each instruction.
+const instruction = "ins"
+
+// Event is one line of a trace.
+type Event struct {
+	Line   int // in the trace, from 1
+	Name   string
+	Fields []Field
+	Text   string // the line as it was written
+}
+
+// Field is a key=value pair in an event.
+type Field struct {
+	Key, Value string
+}
+
+// Get returns the value of the field named key.
+func (e *Event) Get(key string) (string, bool) {
+	for _, f := range e.Fields {
+		if f.Key == key {
+			return f.Value, true
+		}
+	}
+	return "", false
+}
+
+// Trace is a trace file's events.
+type Trace struct {
+	Name   string
+	Events []Event
+}
+
+// Read reads a trace, called name in reports.
+func Read(name string, r io.Reader) (*Trace, error) {
+	t := &Trace{Name: name}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for n := 1; scanner.Scan(); n++ {
+		text := scanner.Text()
+		line := text
+		if i := strings.IndexByte(line, ';'); i >= 0 {
+			line = line[:i]
+		}
+		words := strings.Fields(line)
+		if len(words) == 0 || strings.HasPrefix(words[0], "#") {
+			continue
+		}
+
+		e := Event{Line: n, Name: instruction, Text: strings.TrimRight(text, " \t\r")}
+		if !strings.Contains(words[0], "=") {
+			e.Name, words = strings.ToLower(words[0]), words[1:]
+		}
+		for _, w := range words {
+			i := strings.IndexByte(w, '=')
+			if i <= 0 {
+				return nil, fmt.Errorf("%s:%d: want key=value, got %q", name, n, w)
+			}
+			e.Fields = append(e.Fields, Field{Key: strings.ToLower(w[:i]), Value: w[i+1:]})
+		}
+		t.Events = append(t.Events, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return t, nil
+}
+
+// Steps counts the instructions in t.
+func (t *Trace) Steps() int {
+	n := 0
+	for _, e := range t.Events {
+		if e.Name == instruction {
+			n++
+		}
+	}
+	return n
+}
+
+// names lists the events in t.
+func (t *Trace) names() map[string]bool {
+	names := make(map[string]bool)
+	for _, e := range t.Events {
+		names[e.Name] = true
+	}
+	return names
+}
+
+// Options control a comparison.
+type Options struct {
+	// Events are the events to compare; the others are skipped. Names are
+	// matched ignoring case and surrounding space, and each has to be in
+	// one trace or the other. If empty, the events both traces have are
+	// compared.
+	Events []string
+
+	// Ignore are fields to leave out of the comparison, such as dt and st
+	// when the two runs' timers tick at different times. Fields only one of
+	// the events has are always left out.
+	Ignore []string
+}
+
+// Divergence is where two traces part ways.
+type Divergence struct {
+	Step  int      // the instruction they part at, counting from 1
+	A, B  int      // the events that differ, as indexes into each trace's Events
+	Diffs []string // how they differ
+}
+
+// Compare returns the first place a and b differ, or nil if they agree as
+// far as the shorter goes: one run going on longer than the other is not a
+// divergence.
+func Compare(a, b *Trace, opts Options) (*Divergence, error) {
+	compared := make(map[string]bool)
+	inA, inB := a.names(), b.names()
+	if len(opts.Events) > 0 {
+		for _, name := range opts.Events {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if !inA[name] && !inB[name] {
+				return nil, fmt.Errorf("neither trace has %q events, want some of %s", name, strings.Join(union(inA, inB), ", "))
+			}
+			compared[name] = true
+		}
+	} else {
+		for name := range inA {
+			if inB[name] {
+				compared[name] = true
+			}
+		}
+	}
+	ignored := make(map[string]bool)
+	for _, key := range opts.Ignore {
+		ignored[strings.ToLower(key)] = true
+	}
+
+	// next skips from i to the next compared event.
+	next := func(t *Trace, i int) int {
+		for i < len(t.Events) && !compared[t.Events[i].Name] {
+			i++
+		}
+		return i
+	}
+
+	// Events after an instruction, such as its memory writes, belong to
+	// its step, so one trace writing where the other goes on to the next
+	// instruction parts at the step that wrote.
+	steps := 0
+	i, j := next(a, 0), next(b, 0)
+	for ; i < len(a.Events) && j < len(b.Events); i, j = next(a, i+1), next(b, j+1) {
+		ea, eb := &a.Events[i], &b.Events[j]
+		diffs := diff(ea, eb, a.Name, b.Name, ignored)
+		if len(diffs) == 0 {
+			if ea.Name == instruction {
+				steps++
+			}
+			continue
+		}
+		step := steps
+		if ea.Name == instruction && eb.Name == instruction || step == 0 {
+			step++
+		}
+		return &Divergence{Step: step, A: i, B: j, Diffs: diffs}, nil
+	}
+	return nil, nil
+}
+
+// union lists the names in either set, sorted.
+func union(a, b map[string]bool) []string {
+	var names []string
+	for name := range a {
+		names = append(names, name)
+	}
+	for name := range b {
+		if !a[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// diff describes how events ea and eb, from traces called a and b, differ.
+func diff(ea, eb *Event, a, b string, ignored map[string]bool) []string {
+	if ea.Name != eb.Name {
+		return []string{fmt.Sprintf("%s has %s, %s has %s", a, ea.Name, b, eb.Name)}
+	}
+	var diffs []string
+	for _, f := range ea.Fields {
+		if ignored[f.Key] {
+			continue
+		}
+		other, ok := eb.Get(f.Key)
+		if ok && !same(f.Value, other) {
+			diffs = append(diffs, fmt.Sprintf("%s: %s in %s, %s in %s", f.Key, f.Value, a, other, b))
+		}
+	}
+	return diffs
+}
+
+// same reports whether two values are equal, as hex numbers if they both
+// are, so 5 and 05 agree.
+func same(x, y string) bool {
+	nx, errx := strconv.ParseUint(x, 16, 64)
+	ny, erry := strconv.ParseUint(y, 16, 64)
+	if errx == nil && erry == nil {
+		return nx == ny
+	}
+	return strings.EqualFold(x, y)
+}
+
+// Write reports the divergence between a and b, with up to context lines of
+// each trace either side of it.
+func (d *Divergence) Write(w io.Writer, a, b *Trace, context int) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "traces part at step %d, %s:%d and %s:%d\n", d.Step, a.Name, a.Events[d.A].Line, b.Name, b.Events[d.B].Line)
+	for _, diff := range d.Diffs {
+		fmt.Fprintf(bw, "\t%s\n", diff)
+	}
+	// An instruction's line holds the state it found, so a difference there
+	// was most likely left by the instruction before.
+	if a.Events[d.A].Name == instruction && b.Events[d.B].Name == instruction {
+		for i := d.A - 1; i >= 0; i-- {
+			if prev := &a.Events[i]; prev.Name == instruction {
+				pc, _ := prev.Get("pc")
+				op, _ := prev.Get("op")
+				fmt.Fprintf(bw, "\tafter the instruction at pc=%s op=%s, %s:%d\n", pc, op, a.Name, prev.Line)
+				break
+			}
+		}
+	}
+	for _, side := range []struct {
+		t *Trace
+		i int
+	}{{a, d.A}, {b, d.B}} {
+		fmt.Fprintf(bw, "\n%s:\n", side.t.Name)
+		from, to := side.i-context, side.i+context
+		if from < 0 {
+			from = 0
+		}
+		if to >= len(side.t.Events) {
+			to = len(side.t.Events) - 1
+		}
+		for i := from; i <= to; i++ {
+			e := side.t.Events[i]
+			marker := " "
+			if i == side.i {
+				marker = ">"
+			}
+			fmt.Fprintf(bw, "%s %6d  %s\n", marker, e.Line, e.Text)
+		}
+	}
+	return bw.Flush()
+}
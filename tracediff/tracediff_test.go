@@ -0,0 +1,179 @@
+package tracediff
+
+import (
+	"strings"
+	"testing"
+)
+
+const ours = `ins pc=0200 op=6005 v0=00 v1=00 i=0000 dt=00 ; LD V0, 0x05
+ins pc=0202 op=A300 v0=05 v1=00 i=0000 dt=00
+ins pc=0204 op=F055 v0=05 v1=00 i=0300 dt=00
+mem pc=0204 addr=0300 val=05
+ins pc=0206 op=2208 v0=05 v1=00 i=0300 dt=00
+call pc=0206 to=0208 sp=1
+ins pc=0208 op=7101 v0=05 v1=00 i=0300 dt=00
+`
+
+func read(t *testing.T, name, src string) *Trace {
+	t.Helper()
+	trace, err := Read(name, strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return trace
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		name   string
+		theirs string
+		opts   Options
+		step   int
+		line   int // of the divergent event in theirs
+		diffs  []string
+	}{
+		{
+			name:   "the same",
+			theirs: ours,
+		},
+		{
+			name: "another emulator's minimal trace",
+			theirs: `# pc, opcode, registers and I
+pc=200 op=6005 v0=0 v1=0 i=0
+pc=202 op=A300 v0=5 v1=0 i=0
+pc=204 op=F055 v0=5 v1=0 i=300
+pc=206 op=2208 v0=5 v1=0 i=300
+pc=208 op=7101 v0=5 v1=0 i=300
+pc=20A op=00EE v0=5 v1=1 i=300
+`,
+		},
+		{
+			name:   "a register",
+			theirs: strings.Replace(ours, "ins pc=0206 op=2208 v0=05 v1=00", "ins pc=0206 op=2208 v0=05 v1=07", 1),
+			step:   4,
+			line:   5,
+			diffs:  []string{"v1: 00 in ours, 07 in theirs"},
+		},
+		{
+			name:   "a memory write",
+			theirs: strings.Replace(ours, "val=05", "val=00", 1),
+			step:   3,
+			line:   4,
+			diffs:  []string{"val: 05 in ours, 00 in theirs"},
+		},
+		{
+			name:   "a missing memory write",
+			theirs: strings.Replace(ours, "mem pc=0204 addr=0300 val=05\n", "", 1),
+			opts:   Options{Events: []string{"ins", "mem"}},
+			step:   3,
+			line:   4,
+			diffs:  []string{"ours has mem, theirs has ins"},
+		},
+		{
+			name:   "event names as typed",
+			theirs: strings.Replace(ours, "mem pc=0204 addr=0300 val=05\n", "", 1),
+			opts:   Options{Events: []string{" INS", "Mem "}},
+			step:   3,
+			line:   4,
+			diffs:  []string{"ours has mem, theirs has ins"},
+		},
+		{
+			name:   "an ignored field",
+			theirs: strings.Replace(ours, "ins pc=0208 op=7101 v0=05 v1=00 i=0300 dt=00", "ins pc=0208 op=7101 v0=05 v1=00 i=0300 dt=3C", 1),
+			opts:   Options{Ignore: []string{"dt"}},
+		},
+		{
+			name:   "events left out",
+			theirs: strings.Replace(ours, "to=0208", "to=0300", 1),
+			opts:   Options{Events: []string{"ins"}},
+		},
+	}
+
+	a := read(t, "ours", ours)
+	for _, tt := range tests {
+		b := read(t, "theirs", tt.theirs)
+		d, err := Compare(a, b, tt.opts)
+		if err != nil {
+			t.Errorf("%s, unexpected error: %s", tt.name, err)
+			continue
+		}
+		if tt.step == 0 {
+			if d != nil {
+				t.Errorf("%s, want no divergence, got=%+v", tt.name, d)
+			}
+			continue
+		}
+		if d == nil {
+			t.Errorf("%s, want a divergence at step %d, got none", tt.name, tt.step)
+			continue
+		}
+		if d.Step != tt.step || b.Events[d.B].Line != tt.line || strings.Join(d.Diffs, "\n") != strings.Join(tt.diffs, "\n") {
+			t.Errorf("%s, want=step %d at line %d %q, got=step %d at line %d %q", tt.name, tt.step, tt.line, tt.diffs, d.Step, b.Events[d.B].Line, d.Diffs)
+		}
+	}
+}
+
+func TestCompareUnknownEvent(t *testing.T) {
+	a := read(t, "ours", ours)
+	b := read(t, "theirs", strings.Replace(ours, "mem pc=0204 addr=0300 val=05\n", "", 1))
+
+	if _, err := Compare(a, b, Options{Events: []string{"ins", "mem"}}); err != nil {
+		t.Errorf("an event in one trace was rejected: %s", err)
+	}
+	if _, err := Compare(a, b, Options{Events: []string{"ins", "stack"}}); err == nil {
+		t.Errorf("an event in neither trace was accepted")
+	}
+}
+
+func TestWrite(t *testing.T) {
+	a := read(t, "a.log", ours)
+	b := read(t, "b.log", strings.Replace(ours, "op=2208 v0=05", "op=2208 v0=06", 1))
+	d, err := Compare(a, b, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d == nil {
+		t.Fatal("want a divergence")
+	}
+
+	var out strings.Builder
+	if err := d.Write(&out, a, b, 1); err != nil {
+		t.Fatal(err)
+	}
+	want := `traces part at step 4, a.log:5 and b.log:5
+	v0: 05 in a.log, 06 in b.log
+	after the instruction at pc=0204 op=F055, a.log:3
+
+a.log:
+       4  mem pc=0204 addr=0300 val=05
+>      5  ins pc=0206 op=2208 v0=05 v1=00 i=0300 dt=00
+       6  call pc=0206 to=0208 sp=1
+
+b.log:
+       4  mem pc=0204 addr=0300 val=05
+>      5  ins pc=0206 op=2208 v0=06 v1=00 i=0300 dt=00
+       6  call pc=0206 to=0208 sp=1
+`
+	if out.String() != want {
+		t.Errorf("report, want=\n%s\ngot=\n%s", want, out.String())
+	}
+}
+
+func TestRead(t *testing.T) {
+	trace := read(t, "t", "\n# a comment\nins pc=0200 op=00E0 ; CLS\npc=0202 op=1202\ntimer dt=01 st=00\n")
+	if len(trace.Events) != 3 || trace.Steps() != 2 {
+		t.Fatalf("want 3 events and 2 steps, got=%d and %d", len(trace.Events), trace.Steps())
+	}
+	if e := trace.Events[1]; e.Name != "ins" || e.Line != 4 {
+		t.Errorf("unnamed event, want ins on line 4, got=%s on line %d", e.Name, e.Line)
+	}
+	if v, ok := trace.Events[2].Get("dt"); !ok || v != "01" {
+		t.Errorf("timer dt, want=01, got=%q", v)
+	}
+
+	for _, src := range []string{"ins pc", "ins =0200", "pc=0200 op"} {
+		if _, err := Read("t", strings.NewReader(src)); err == nil {
+			t.Errorf("Read(%q), want error", src)
+		}
+	}
+}
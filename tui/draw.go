@@ -0,0 +1,277 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gilmae/chip8/chip8"
+	"github.com/gilmae/chip8/disasm"
+	"github.com/gilmae/chip8/symbols"
+	"github.com/nsf/termbox-go"
+)
+
+const (
+	disasm_x    = 0
+	registers_x = 32
+	display_x   = 52
+	memory_rows = 8
+
+	program_start uint16 = 0x200
+
+	help = "c continue  p pause  s step  n over  o out  b break  w watch  x clear  e edit  r reg  g goto  tab keypad  q quit"
+)
+
+var (
+	plain  = termbox.ColorDefault
+	title  = termbox.ColorCyan | termbox.AttrBold
+	marked = termbox.ColorRed | termbox.AttrBold
+
+	// pixel_colours are the display's colours 0 to 3, after DefaultPalette.
+	pixel_colours = [4]termbox.Attribute{termbox.ColorBlack, termbox.ColorWhite, termbox.ColorRed, termbox.ColorYellow}
+)
+
+// view is everything a redraw needs. The machine's part is read under one
+// lock, so it is all from the same moment; the breakpoints and watchpoints
+// are the debugger's, read after.
+type view struct {
+	mem         []byte
+	registers   [16]byte
+	index, pc   uint16
+	delay       byte
+	sound       byte
+	sp          uint8
+	stack       []uint16
+	breakpoints []chip8.Breakpoint
+	watchpoints []chip8.Watchpoint
+	paused      bool
+}
+
+func (ui *UI) read() view {
+	state := ui.m.State()
+	return view{
+		mem:         state.Memory,
+		registers:   state.Registers,
+		index:       state.I,
+		pc:          state.PC,
+		delay:       state.Delay,
+		sound:       state.Sound,
+		sp:          state.SP,
+		stack:       state.Stack,
+		breakpoints: ui.d.Breakpoints(),
+		watchpoints: ui.d.Watchpoints(),
+		paused:      state.Paused,
+	}
+}
+
+func (ui *UI) draw(s screen) {
+	w, h := s.Size()
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			s.SetCell(x, y, ' ', plain, plain)
+		}
+	}
+
+	v := ui.read()
+	memTop := h - memory_rows - 3
+
+	state := "running"
+	if v.paused {
+		state = "paused"
+	}
+	if ui.mode == mode_keypad {
+		state += ", keys to keypad (tab to leave)"
+	}
+	text(s, 0, 0, fmt.Sprintf("chip8 debug %s [%s]", ui.Title, state), title)
+
+	ui.drawDisassembly(s, v, 1, memTop-1)
+	ui.drawRegisters(s, v, 1)
+	bottom := ui.drawDisplay(s, w, 1)
+	ui.drawLog(s, bottom+1, memTop-1)
+	ui.drawMemory(s, v, memTop)
+
+	text(s, 0, h-2, help, plain)
+	if ui.mode == mode_prompt {
+		line := ui.prompt + string(ui.input)
+		text(s, 0, h-1, line, plain)
+		s.SetCell(len([]rune(line)), h-1, '_', plain, plain)
+	} else {
+		text(s, 0, h-1, ui.status, marked)
+	}
+}
+
+// drawDisassembly lists instructions from a little before pc, marking pc and
+// breakpoints, in rows from top up to but not including bottom.
+func (ui *UI) drawDisassembly(s screen, v view, top, bottom int) {
+	text(s, disasm_x, top, "Disassembly", title)
+	rows := bottom - top - 1
+	if rows <= 0 {
+		return
+	}
+
+	breaks := make(map[uint16]bool)
+	for _, b := range v.breakpoints {
+		breaks[b.Addr] = true
+	}
+
+	addr := v.pc
+	if back := uint16(2 * (rows / 3)); v.pc >= program_start+back {
+		addr = v.pc - back
+	} else if v.pc >= program_start {
+		addr = program_start
+	}
+	for y := top + 1; y < bottom; y++ {
+		mnemonic, size := disassemble(v.mem, addr, ui.Symbols)
+		colour := plain
+		marker := "  "
+		if breaks[addr] {
+			marker = "* "
+			colour = marked
+		}
+		if addr == v.pc {
+			marker = marker[:1] + ">"
+			colour |= termbox.AttrReverse
+		}
+		raw := ""
+		for i := 0; i < size; i++ {
+			raw += fmt.Sprintf("%02X", peek(v.mem, addr+uint16(i)))
+		}
+		text(s, disasm_x, y, fmt.Sprintf("%s%03X %-8s %s", marker, addr, raw, mnemonic), colour)
+		addr += uint16(size)
+	}
+}
+
+// disassemble describes the instruction at addr, naming the addresses table
+// has labels for, and returns it and its size.
+func disassemble(mem []byte, addr uint16, table *symbols.Table) (string, int) {
+	code := []byte{peek(mem, addr), peek(mem, addr+1), peek(mem, addr+2), peek(mem, addr+3)}
+	text, size, err := disasm.InstructionWithSymbols(code, disasm.Cowgod, table)
+	if err != nil {
+		return "???", 2
+	}
+	return text, size
+}
+
+func peek(mem []byte, addr uint16) byte {
+	return mem[int(addr)&(len(mem)-1)]
+}
+
+func (ui *UI) drawRegisters(s screen, v view, top int) {
+	text(s, registers_x, top, "Registers", title)
+	for i := 0; i < 8; i++ {
+		text(s, registers_x, top+1+i, fmt.Sprintf("V%X %02X   V%X %02X", i, v.registers[i], i+8, v.registers[i+8]), plain)
+	}
+	text(s, registers_x, top+9, fmt.Sprintf("I  %04X", v.index), plain)
+	text(s, registers_x, top+10, fmt.Sprintf("DT %02X    ST %02X", v.delay, v.sound), plain)
+	text(s, registers_x, top+11, fmt.Sprintf("PC %04X  SP %X", v.pc, v.sp), plain)
+
+	y := top + 13
+	text(s, registers_x, y, "Stack", title)
+	if len(v.stack) == 0 {
+		text(s, registers_x, y+1, "(empty)", plain)
+	}
+	for i := len(v.stack) - 1; i >= 0; i-- {
+		y++
+		text(s, registers_x, y, fmt.Sprintf("%X  %03X", i, v.stack[i]), plain)
+	}
+
+	y += 2
+	text(s, registers_x, y, "Breakpoints", title)
+	for _, b := range v.breakpoints {
+		y++
+		line := fmt.Sprintf("%d  %03X", b.ID, b.Addr)
+		if b.Condition != "" {
+			line += " if " + b.Condition
+		}
+		text(s, registers_x, y, clip(line, display_x-registers_x-1), plain)
+	}
+	for _, w := range v.watchpoints {
+		y++
+		what := w.Register.String()
+		if w.Memory {
+			what = fmt.Sprintf("%03X-%03X", w.Start, w.End)
+		}
+		text(s, registers_x, y, fmt.Sprintf("%d  %s %s", w.ID, w.Kind, what), plain)
+	}
+}
+
+// drawDisplay draws two pixel rows per character with half blocks, halving
+// the high resolution display again if the terminal is too narrow for it. It
+// returns the row below the display.
+func (ui *UI) drawDisplay(s screen, w int, top int) int {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+
+	scale := 1
+	if display_x+ui.width > w && ui.width > 64 {
+		scale = 2
+	}
+	text(s, display_x, top, fmt.Sprintf("Display %dx%d", ui.width, ui.height), title)
+	for y := 0; y+scale < ui.height; y += 2 * scale {
+		for x := 0; x < ui.width; x += scale {
+			upper := pixel_colours[ui.pixels[y*ui.width+x]&3]
+			lower := pixel_colours[ui.pixels[(y+scale)*ui.width+x]&3]
+			s.SetCell(display_x+x/scale, top+1+y/(2*scale), '▀', upper, lower)
+		}
+	}
+	return top + 1 + ui.height/(2*scale)
+}
+
+// drawLog shows the latest log point messages in rows from top up to but not
+// including bottom.
+func (ui *UI) drawLog(s screen, top, bottom int) {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+
+	text(s, display_x, top, "Log", title)
+	rows := bottom - top - 1
+	lines := ui.logged
+	if rows < 0 {
+		rows = 0
+	}
+	if len(lines) > rows {
+		lines = lines[len(lines)-rows:]
+	}
+	for i, line := range lines {
+		text(s, display_x, top+1+i, line, plain)
+	}
+}
+
+func (ui *UI) drawMemory(s screen, v view, top int) {
+	text(s, 0, top, "Memory", title)
+	for row := 0; row < memory_rows; row++ {
+		addr := ui.memAddr + uint16(row*16)
+		x := 0
+		text(s, x, top+1+row, fmt.Sprintf("%04X ", addr), plain)
+		x += 5
+		var ascii strings.Builder
+		for i := uint16(0); i < 16; i++ {
+			b := peek(v.mem, addr+i)
+			colour := plain
+			if addr+i == v.index {
+				colour = marked
+			}
+			text(s, x, top+1+row, fmt.Sprintf(" %02X", b), colour)
+			x += 3
+			if b >= 0x20 && b < 0x7f {
+				ascii.WriteByte(b)
+			} else {
+				ascii.WriteByte('.')
+			}
+		}
+		text(s, x+2, top+1+row, ascii.String(), plain)
+	}
+}
+
+func clip(str string, n int) string {
+	if runes := []rune(str); len(runes) > n {
+		return string(runes[:n-1]) + "…"
+	}
+	return str
+}
+
+func text(s screen, x, y int, str string, fg termbox.Attribute) {
+	for _, ch := range str {
+		s.SetCell(x, y, ch, fg, plain)
+		x++
+	}
+}
@@ -0,0 +1,265 @@
+package tui
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gilmae/chip8/chip8"
+	"github.com/nsf/termbox-go"
+)
+
+// handleKey acts on a key according to the mode the UI is in.
+func (ui *UI) handleKey(ev termbox.Event) {
+	if ev.Key == termbox.KeyCtrlC {
+		ui.quit = true
+		return
+	}
+
+	switch ui.mode {
+	case mode_prompt:
+		ui.handlePromptKey(ev)
+	case mode_keypad:
+		if ev.Key == termbox.KeyTab || ev.Key == termbox.KeyEsc {
+			ui.mode = mode_command
+			return
+		}
+		if ev.Ch != 0 {
+			ui.press(ev.Ch)
+		} else if ev.Key == termbox.KeySpace {
+			ui.press(' ')
+		}
+	default:
+		ui.handleCommandKey(ev)
+	}
+}
+
+func (ui *UI) handleCommandKey(ev termbox.Event) {
+	var err error
+	switch {
+	case ev.Ch == 'q':
+		ui.quit = true
+	case ev.Ch == 'c' || ev.Key == termbox.KeyF5:
+		ui.d.Continue()
+		ui.status = "running"
+	case ev.Ch == 'p' || ev.Key == termbox.KeyF6:
+		ui.d.Pause()
+	case ev.Ch == 's' || ev.Key == termbox.KeyF11:
+		err = ui.d.StepIn()
+	case ev.Ch == 'n' || ev.Key == termbox.KeyF10:
+		err = ui.d.StepOver()
+	case ev.Ch == 'o' || ev.Key == termbox.KeyF12:
+		err = ui.d.StepOut()
+	case ev.Key == termbox.KeyTab:
+		ui.mode = mode_keypad
+	case ev.Key == termbox.KeyPgup || ev.Key == termbox.KeyArrowUp:
+		ui.memAddr -= scroll(ev.Key)
+	case ev.Key == termbox.KeyPgdn || ev.Key == termbox.KeyArrowDown:
+		ui.memAddr += scroll(ev.Key)
+	case ev.Ch == 'b':
+		ui.ask("break at (addr [if cond], empty for pc, existing to clear): ", ui.toggleBreakpoint)
+	case ev.Ch == 'w':
+		ui.ask("watch (addr[-end] or register, then r, w or rw): ", ui.watch)
+	case ev.Ch == 'e':
+		ui.ask("edit memory (addr byte...): ", ui.editMemory)
+	case ev.Ch == 'r':
+		ui.ask("set register (name expression): ", ui.setRegister)
+	case ev.Ch == 'g':
+		ui.ask("show memory at (addr or expression): ", ui.gotoMemory)
+	case ev.Ch == 'x':
+		ui.ask("clear breakpoint or watchpoint: ", ui.clear)
+	case ev.Ch == '?':
+		ui.status = help
+	}
+	if err != nil {
+		ui.status = err.Error()
+	}
+}
+
+func scroll(key termbox.Key) uint16 {
+	if key == termbox.KeyPgup || key == termbox.KeyPgdn {
+		return memory_rows * 16
+	}
+	return 16
+}
+
+// ask switches to the prompt, calling submit with what is typed.
+func (ui *UI) ask(prompt string, submit func(string) error) {
+	ui.mode = mode_prompt
+	ui.prompt = prompt
+	ui.input = nil
+	ui.submit = submit
+}
+
+func (ui *UI) handlePromptKey(ev termbox.Event) {
+	switch {
+	case ev.Key == termbox.KeyEsc:
+		ui.mode = mode_command
+		ui.status = ""
+	case ev.Key == termbox.KeyEnter:
+		ui.mode = mode_command
+		ui.status = ""
+		if err := ui.submit(strings.TrimSpace(string(ui.input))); err != nil {
+			ui.status = err.Error()
+		}
+	case ev.Key == termbox.KeyBackspace || ev.Key == termbox.KeyBackspace2:
+		if len(ui.input) > 0 {
+			ui.input = ui.input[:len(ui.input)-1]
+		}
+	case ev.Key == termbox.KeySpace:
+		ui.input = append(ui.input, ' ')
+	case ev.Ch != 0:
+		ui.input = append(ui.input, ev.Ch)
+	}
+}
+
+// parseAddr reads a label, or a hex address with or without a 0x prefix.
+func (ui *UI) parseAddr(s string) (uint16, error) {
+	if ui.Symbols != nil {
+		if addr, ok := ui.Symbols.Lookup(s); ok {
+			return addr, nil
+		}
+	}
+	n, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(s), "0x"), 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("bad address %q", s)
+	}
+	return uint16(n), nil
+}
+
+func (ui *UI) toggleBreakpoint(input string) error {
+	where, cond := input, ""
+	if i := strings.Index(input, " if "); i >= 0 {
+		where, cond = strings.TrimSpace(input[:i]), strings.TrimSpace(input[i+4:])
+	}
+
+	addr := ui.m.PC()
+	if where != "" {
+		var err error
+		if addr, err = ui.parseAddr(where); err != nil {
+			return err
+		}
+	}
+
+	for _, b := range ui.d.Breakpoints() {
+		if b.Addr == addr && cond == "" {
+			ui.d.Clear(b.ID)
+			ui.status = fmt.Sprintf("cleared breakpoint %d at %03X", b.ID, addr)
+			return nil
+		}
+	}
+	id := ui.d.SetBreakpoint(addr)
+	if err := ui.d.SetCondition(id, cond); err != nil {
+		ui.d.Clear(id)
+		return err
+	}
+	ui.status = fmt.Sprintf("breakpoint %d at %03X", id, addr)
+	return nil
+}
+
+func (ui *UI) watch(input string) error {
+	fields := strings.Fields(input)
+	if len(fields) == 0 {
+		return fmt.Errorf("watch what?")
+	}
+	kind := chip8.AccessWrite
+	if len(fields) > 1 {
+		switch fields[1] {
+		case "r":
+			kind = chip8.AccessRead
+		case "w":
+			kind = chip8.AccessWrite
+		case "rw":
+			kind = chip8.AccessRead | chip8.AccessWrite
+		default:
+			return fmt.Errorf("want r, w or rw, got %q", fields[1])
+		}
+	}
+
+	if r, err := chip8.RegisterByName(fields[0]); err == nil {
+		id, err := ui.d.WatchRegister(kind, r)
+		if err != nil {
+			return err
+		}
+		ui.status = fmt.Sprintf("watchpoint %d on %s", id, r)
+		return nil
+	}
+
+	start, end := fields[0], fields[0]
+	if i := strings.IndexByte(fields[0], '-'); i >= 0 {
+		start, end = fields[0][:i], fields[0][i+1:]
+	}
+	from, err := ui.parseAddr(start)
+	if err != nil {
+		return err
+	}
+	to, err := ui.parseAddr(end)
+	if err != nil {
+		return err
+	}
+	id := ui.d.Watch(kind, from, to)
+	ui.status = fmt.Sprintf("watchpoint %d on %03X-%03X", id, from, to)
+	return nil
+}
+
+func (ui *UI) editMemory(input string) error {
+	fields := strings.Fields(input)
+	if len(fields) < 2 {
+		return fmt.Errorf("want an address and at least one byte")
+	}
+	addr, err := ui.parseAddr(fields[0])
+	if err != nil {
+		return err
+	}
+	var data []byte
+	for _, f := range fields[1:] {
+		b, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(f), "0x"), 16, 8)
+		if err != nil {
+			return fmt.Errorf("bad byte %q", f)
+		}
+		data = append(data, byte(b))
+	}
+	ui.m.WriteMemory(addr, data)
+	ui.status = fmt.Sprintf("wrote %d bytes at %03X", len(data), addr)
+	return nil
+}
+
+func (ui *UI) setRegister(input string) error {
+	input = strings.Replace(input, "=", " ", 1)
+	i := strings.IndexByte(input, ' ')
+	if i < 0 {
+		return fmt.Errorf("want a register and a value")
+	}
+	r, err := chip8.RegisterByName(input[:i])
+	if err != nil {
+		return err
+	}
+	value, err := ui.d.Evaluate(input[i+1:])
+	if err != nil {
+		return err
+	}
+	ui.m.SetRegister(r, uint16(value))
+	return nil
+}
+
+func (ui *UI) gotoMemory(input string) error {
+	addr, err := ui.parseAddr(input)
+	if err != nil {
+		value, err := ui.d.Evaluate(input)
+		if err != nil {
+			return err
+		}
+		addr = uint16(value)
+	}
+	ui.memAddr = addr &^ 0xf
+	return nil
+}
+
+func (ui *UI) clear(input string) error {
+	id, err := strconv.Atoi(input)
+	if err != nil {
+		return fmt.Errorf("bad id %q", input)
+	}
+	ui.d.Clear(id)
+	return nil
+}
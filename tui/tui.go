@@ -0,0 +1,247 @@
+// Package tui is a full-screen terminal debugger for the chip8 interpreter,
+// for when there is no window to run it in.
+package tui
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gilmae/chip8/chip8"
+	"github.com/gilmae/chip8/symbols"
+	"github.com/nsf/termbox-go"
+)
+
+const (
+	redraw_interval = time.Second / 30
+	key_hold_frames = 8 // terminals send no key up, so keypad presses are let go after this long
+	log_lines       = 64
+)
+
+type mode int
+
+const (
+	mode_command mode = iota // keys drive the debugger
+	mode_keypad              // keys go to the CHIP-8 keypad
+	mode_prompt              // keys are typed into the prompt
+)
+
+// UI is the debugger's terminal interface. It is the Host of the machine it
+// debugs: it shows the display and feeds the keypad.
+type UI struct {
+	Title   string
+	Symbols *symbols.Table // names addresses in the disassembly and prompts; may be nil
+
+	m *chip8.Machine
+	d *chip8.Debugger
+
+	mode    mode
+	memAddr uint16 // first address in the memory pane
+	status  string
+	quit    bool
+
+	prompt string
+	input  []rune
+	submit func(input string) error
+
+	mu     sync.Mutex // guards the fields below, shared with the machine
+	pixels []byte
+	width  int
+	height int
+	keys   []chip8.KeyEvent
+	held   map[rune]int // frames left before a keypad key is let go
+	logged []string     // the latest log point messages
+}
+
+// New returns a UI with no machine yet. The machine has to be made with the
+// UI as its Host, so make the UI first and attach its debugger afterwards:
+//
+//	ui := tui.New()
+//	m := chip8.NewMachine(chip8.WithHost(ui))
+//	ui.Attach(chip8.NewDebugger(m))
+func New() *UI {
+	return &UI{
+		memAddr: 0x200,
+		width:   64,
+		height:  32,
+		pixels:  make([]byte, 64*32),
+		held:    make(map[rune]int),
+	}
+}
+
+// Attach points the UI at the debugger driving its machine, and shows the
+// debugger's log point messages in the log pane.
+func (ui *UI) Attach(d *chip8.Debugger) {
+	ui.d = d
+	ui.m = d.Machine()
+	d.Logger = log.New(ui, "", 0)
+}
+
+// Write adds lines to the log pane.
+func (ui *UI) Write(p []byte) (int, error) {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		ui.logged = append(ui.logged, line)
+	}
+	if len(ui.logged) > log_lines {
+		ui.logged = ui.logged[len(ui.logged)-log_lines:]
+	}
+	return len(p), nil
+}
+
+// Render copies the display for the next redraw. It is called by the machine
+// with its lock held, so it must not call back into it.
+func (ui *UI) Render(d *chip8.Display) error {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+
+	ui.width, ui.height = d.Width(), d.Height()
+	if len(ui.pixels) != ui.width*ui.height {
+		ui.pixels = make([]byte, ui.width*ui.height)
+	}
+	d.EachPixel(func(x, y uint16, addr int) {
+		ui.pixels[int(y)*ui.width+int(x)] = d.Colour(addr)
+	})
+	return nil
+}
+
+// Poll hands the machine the keypad presses typed since the last frame, and
+// lets go of keys held long enough.
+func (ui *UI) Poll() ([]chip8.KeyEvent, bool) {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+
+	events := ui.keys
+	ui.keys = nil
+	for code, frames := range ui.held {
+		if frames == 0 {
+			delete(ui.held, code)
+			events = append(events, chip8.KeyEvent{Code: code})
+		} else {
+			ui.held[code] = frames - 1
+		}
+	}
+	return events, false
+}
+
+func (ui *UI) Close() {}
+
+// press holds a keypad key down for key_hold_frames. Terminals repeat held
+// keys, which keeps extending it.
+func (ui *UI) press(code rune) {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	if _, ok := ui.held[code]; !ok {
+		ui.keys = append(ui.keys, chip8.KeyEvent{Code: code, Down: true})
+	}
+	ui.held[code] = key_hold_frames
+}
+
+// Run takes over the terminal and runs the machine under the debugger until
+// the user quits or ctx is cancelled. The machine starts paused at its first
+// instruction.
+func (ui *UI) Run(ctx context.Context) error {
+	if err := termbox.Init(); err != nil {
+		return err
+	}
+	defer termbox.Close()
+	termbox.SetInputMode(termbox.InputEsc)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ui.d.Pause()
+	<-ui.d.Stops()
+	ui.status = fmt.Sprintf("paused at %03X; c to continue, ? for help", ui.m.PC())
+
+	machine := make(chan error, 1)
+	go func() {
+		machine <- ui.m.Run(ctx)
+	}()
+
+	events := make(chan termbox.Event)
+	go func() {
+		for {
+			ev := termbox.PollEvent()
+			if ev.Type == termbox.EventInterrupt {
+				return
+			}
+			select {
+			case events <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	defer termbox.Interrupt()
+
+	redraw := time.NewTicker(redraw_interval)
+	defer redraw.Stop()
+
+	screen := termboxScreen{}
+	for !ui.quit {
+		ui.draw(screen)
+		if err := termbox.Flush(); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-machine:
+			if err != nil {
+				return err
+			}
+			return nil
+		case ev := <-events:
+			if ev.Type == termbox.EventError {
+				return ev.Err
+			}
+			if ev.Type == termbox.EventKey {
+				ui.handleKey(ev)
+			}
+		case s := <-ui.d.Stops():
+			ui.status = describeStop(s)
+		case <-redraw.C:
+		}
+	}
+
+	return nil
+}
+
+func describeStop(s chip8.Stop) string {
+	switch s.Reason {
+	case chip8.StopBreakpoint:
+		return fmt.Sprintf("breakpoint %d at %03X", s.ID, s.PC)
+	case chip8.StopWatchpoint:
+		what := s.Access.Register.String()
+		if s.Access.Memory {
+			what = fmt.Sprintf("[%03X]", s.Access.Addr)
+		}
+		return fmt.Sprintf("watchpoint %d: %03X %s %s = %02X", s.ID, s.Access.PC, s.Access.Kind, what, s.Access.Value)
+	case chip8.StopOpcode:
+		return fmt.Sprintf("opcode break at %03X", s.PC)
+	}
+	return fmt.Sprintf("paused at %03X", s.PC)
+}
+
+// screen is the part of termbox the UI draws with, so tests can draw into
+// something else.
+type screen interface {
+	SetCell(x, y int, ch rune, fg, bg termbox.Attribute)
+	Size() (width, height int)
+}
+
+type termboxScreen struct{}
+
+func (termboxScreen) SetCell(x, y int, ch rune, fg, bg termbox.Attribute) {
+	termbox.SetCell(x, y, ch, fg, bg)
+}
+
+func (termboxScreen) Size() (int, int) {
+	return termbox.Size()
+}
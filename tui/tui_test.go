@@ -0,0 +1,226 @@
+package tui
+
+import (
+	"io/ioutil"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/gilmae/chip8/chip8"
+	"github.com/gilmae/chip8/symbols"
+	"github.com/nsf/termbox-go"
+)
+
+// fakeScreen records what is drawn, row by row.
+type fakeScreen struct {
+	width, height int
+	cells         [][]rune
+}
+
+func newFakeScreen(width, height int) *fakeScreen {
+	s := &fakeScreen{width: width, height: height}
+	for y := 0; y < height; y++ {
+		s.cells = append(s.cells, []rune(strings.Repeat(" ", width)))
+	}
+	return s
+}
+
+func (s *fakeScreen) SetCell(x, y int, ch rune, fg, bg termbox.Attribute) {
+	if x >= 0 && x < s.width && y >= 0 && y < s.height {
+		s.cells[y][x] = ch
+	}
+}
+
+func (s *fakeScreen) Size() (int, int) {
+	return s.width, s.height
+}
+
+func (s *fakeScreen) String() string {
+	var rows []string
+	for _, row := range s.cells {
+		rows = append(rows, string(row))
+	}
+	return strings.Join(rows, "\n")
+}
+
+var program = []byte{
+	0x60, 0x05, // 200: LD V0, 5
+	0xA3, 0x00, // 202: LD I, 300
+	0x22, 0x08, // 204: CALL 208
+	0x12, 0x06, // 206: JP 206
+	0xD0, 0x05, // 208: DRW V0, V0, 5
+	0x00, 0xEE, // 20A: RET
+}
+
+func newTestUI(t *testing.T) *UI {
+	ui := New()
+	m := chip8.NewMachine(chip8.WithHost(ui), chip8.WithLogger(log.New(ioutil.Discard, "", 0)))
+	if _, err := m.LoadBytes(program); err != nil {
+		t.Fatal(err)
+	}
+	ui.Attach(chip8.NewDebugger(m))
+	return ui
+}
+
+func keys(ui *UI, typed string) {
+	for _, ch := range typed {
+		switch ch {
+		case '\n':
+			ui.handleKey(termbox.Event{Type: termbox.EventKey, Key: termbox.KeyEnter})
+		case ' ':
+			ui.handleKey(termbox.Event{Type: termbox.EventKey, Key: termbox.KeySpace})
+		default:
+			ui.handleKey(termbox.Event{Type: termbox.EventKey, Ch: ch})
+		}
+	}
+}
+
+func TestDraw(t *testing.T) {
+	ui := newTestUI(t)
+	ui.d.Pause()
+	if err := ui.d.StepIn(); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newFakeScreen(120, 40)
+	ui.draw(s)
+	out := s.String()
+	for _, want := range []string{
+		"Disassembly",
+		" 200 6005     LD V0, 0x05",
+		" >202 A300     LD I, 0x300",
+		"V0 05   V8 00",
+		"PC 0202  SP 0",
+		"(empty)",
+		"0200  60 05 A3 00 22 08",
+		"Display 64x32",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("screen is missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestSymbols(t *testing.T) {
+	ui := newTestUI(t)
+	ui.Symbols = &symbols.Table{Symbols: []symbols.Symbol{{Name: "draw_player", Addr: 0x208}}}
+	ui.d.Pause()
+
+	s := newFakeScreen(120, 40)
+	ui.draw(s)
+	if !strings.Contains(s.String(), " 204 2208     CALL draw_player") {
+		t.Errorf("disassembly does not name the subroutine:\n%s", s)
+	}
+
+	keys(ui, "bdraw_player\n")
+	if bps := ui.d.Breakpoints(); len(bps) != 1 || bps[0].Addr != 0x208 {
+		t.Errorf("breakpoint on a label, want at 208, got=%v", bps)
+	}
+}
+
+func TestStepAndCallStack(t *testing.T) {
+	ui := newTestUI(t)
+	ui.d.Pause()
+	keys(ui, "sss")
+	if pc := ui.m.PC(); pc != 0x208 {
+		t.Fatalf("pc after three steps, want=%#x, got=%#x", 0x208, pc)
+	}
+
+	s := newFakeScreen(120, 40)
+	ui.draw(s)
+	if !strings.Contains(s.String(), "0  206") {
+		t.Errorf("stack pane does not show the return address:\n%s", s)
+	}
+
+	keys(ui, "s")
+	s = newFakeScreen(120, 40)
+	ui.draw(s)
+	if !strings.Contains(s.String(), "▀") {
+		t.Errorf("display pane does not show the drawn sprite")
+	}
+}
+
+func TestPromptCommands(t *testing.T) {
+	ui := newTestUI(t)
+
+	keys(ui, "b208 if V0 == 5\n")
+	bps := ui.d.Breakpoints()
+	if len(bps) != 1 || bps[0].Addr != 0x208 || bps[0].Condition != "V0 == 5" {
+		t.Fatalf("breakpoints, want one at 0x208 if V0 == 5, got=%+v (%s)", bps, ui.status)
+	}
+	keys(ui, "b208\n")
+	if bps := ui.d.Breakpoints(); len(bps) != 0 {
+		t.Errorf("breakpoint was not toggled off: %+v", bps)
+	}
+
+	keys(ui, "e300 aa BB\n")
+	if mem := ui.m.Memory(); mem[0x300] != 0xaa || mem[0x301] != 0xbb {
+		t.Errorf("memory edit, want=aa bb, got=%02x %02x (%s)", mem[0x300], mem[0x301], ui.status)
+	}
+
+	keys(ui, "rV3 = 0x10 + 1\n")
+	if v := ui.m.Registers()[3]; v != 0x11 {
+		t.Errorf("register edit, want=%#x, got=%#x (%s)", 0x11, v, ui.status)
+	}
+
+	keys(ui, "w300-301 rw\n")
+	ws := ui.d.Watchpoints()
+	if len(ws) != 1 || ws[0].Start != 0x300 || ws[0].End != 0x301 || ws[0].Kind != chip8.AccessRead|chip8.AccessWrite {
+		t.Errorf("watchpoints, want=300-301 rw, got=%+v (%s)", ws, ui.status)
+	}
+
+	keys(ui, "g3F5\n")
+	if ui.memAddr != 0x3F0 {
+		t.Errorf("memory pane, want=%#x, got=%#x", 0x3F0, ui.memAddr)
+	}
+
+	keys(ui, "bzz\n")
+	if !strings.Contains(ui.status, "bad address") {
+		t.Errorf("status after a bad address, got=%q", ui.status)
+	}
+}
+
+func TestKeypadMode(t *testing.T) {
+	ui := newTestUI(t)
+	ui.handleKey(termbox.Event{Type: termbox.EventKey, Key: termbox.KeyTab})
+	keys(ui, "q")
+	if ui.quit {
+		t.Fatalf("q quit in keypad mode")
+	}
+
+	events, _ := ui.Poll()
+	if len(events) != 1 || events[0].Code != 'q' || !events[0].Down {
+		t.Fatalf("keypad events, want=q down, got=%+v", events)
+	}
+	for i := 1; i < key_hold_frames; i++ {
+		if events, _ := ui.Poll(); len(events) != 0 {
+			t.Fatalf("key let go after %d frames", i)
+		}
+	}
+	if events, _ := ui.Poll(); len(events) != 1 || events[0].Down {
+		t.Errorf("keypad events, want=q up, got=%+v", events)
+	}
+
+	ui.handleKey(termbox.Event{Type: termbox.EventKey, Key: termbox.KeyTab})
+	keys(ui, "q")
+	if !ui.quit {
+		t.Errorf("q did not quit in command mode")
+	}
+}
+
+func TestLogPane(t *testing.T) {
+	ui := newTestUI(t)
+	id := ui.d.SetBreakpoint(0x204)
+	if err := ui.d.SetLog(id, "calling with V0={V0}"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ui.m.StepFrame(); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newFakeScreen(120, 40)
+	ui.draw(s)
+	if !strings.Contains(s.String(), "calling with V0=5") {
+		t.Errorf("log pane is missing the log point message:\n%s", s)
+	}
+}